@@ -7,7 +7,9 @@ import (
 	"os"
 	"os/signal"
 	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/soulteary/owlmail/internal/api"
 	"github.com/soulteary/owlmail/internal/common"
@@ -19,9 +21,28 @@ import (
 // Config holds all application configuration
 type Config struct {
 	// SMTP server configuration
-	SMTPPort int
-	SMTPHost string
-	MailDir  string
+	SMTPPort   int
+	SMTPHost   string
+	MailDir    string
+	LMTPAddr   string
+	SMTPListen string // Comma-separated listener URLs, replacing the default listeners
+	SMTPSPort  int
+
+	// Optional ESMTP extensions advertised in EHLO
+	SMTPExtensions string
+
+	// SMTP personality profile
+	SMTPProfile     string // Built-in profile name
+	SMTPProfileFile string // JSON file with a custom profile, overriding SMTPProfile
+	SMTPHostname    string // Hostname in the banner and EHLO reply, overriding the profile's
+
+	// Message size limit of listeners that do not set their own, overriding the profile's
+	MaxMessageBytes   int64
+	OversizeCaptureKB int // Body kilobytes kept of messages over the limit; 0 keeps none
+
+	// PROXY protocol on SMTP listeners
+	ProxyProtocol        bool
+	ProxyProtocolTrusted string // Comma-separated CIDRs allowed to send PROXY headers
 
 	// Web API configuration
 	WebPort     int
@@ -34,6 +55,10 @@ type Config struct {
 	HTTPSCertFile string
 	HTTPSKeyFile  string
 
+	// Local CA mode for SMTP TLS and HTTPS
+	LocalCA      bool
+	LocalCAHosts string // Comma-separated DNS names and IPs of the issued certificate
+
 	// Outgoing mail configuration
 	OutgoingHost   string
 	OutgoingPort   int
@@ -45,14 +70,52 @@ type Config struct {
 	AutoRelayRules string
 
 	// SMTP authentication
-	SMTPUser     string
-	SMTPPassword string
+	SMTPUser      string
+	SMTPPassword  string
+	SMTPUsersFile string
+
+	// SMTP fault injection rules file
+	FaultRules string
+
+	// Greylisting simulation
+	Greylist      bool
+	GreylistDelay string
+
+	// SMTP rate limiting
+	MaxConnections           int
+	MaxConnectionsPerIP      int
+	MessagesPerMinute        int
+	MessagesPerMinutePerIP   int
+	MessagesPerMinutePerUser int
+
+	// Asynchronous ingest pipeline
+	IngestWorkers   int // Parse and store workers; 0 processes messages within the SMTP transaction
+	IngestQueueSize int // Spooled messages waiting for a worker before clients get 451
+
+	// Storage mode for load testing
+	StorageMode        string // all, blackhole or sample
+	StorageSampleEvery int    // Sample mode: store every Nth matching message
+	StorageMatchFrom   string // Sample mode: sender pattern
+	StorageMatchTo     string // Sample mode: recipient pattern
+
+	// Local zone file with DKIM keys and SPF/DMARC policies
+	DNSZoneFile string
+
+	// Ingest access policy
+	AllowNetworks   string // Comma-separated client CIDRs allowed to connect
+	DenyNetworks    string // Comma-separated client CIDRs refused
+	AcceptedDomains string // Comma-separated recipient domains accepted
 
 	// TLS configuration for SMTP
 	TLSEnabled  bool
 	TLSCertFile string
 	TLSKeyFile  string
 
+	// Client certificate (mutual TLS) authentication
+	TLSClientCA      string
+	TLSClientAuth    string
+	TLSClientCertMap string
+
 	// Logging configuration
 	LogLevel string
 
@@ -106,9 +169,28 @@ func getLogLevelFromEnv() common.LogLevel {
 func parseConfig() *Config {
 	var (
 		// SMTP server configuration
-		smtpPort = flag.Int("smtp", maildev.GetMailDevEnvInt("OWLMAIL_SMTP_PORT", 1025), "SMTP port to catch emails")
-		smtpHost = flag.String("ip", maildev.GetMailDevEnvString("OWLMAIL_SMTP_HOST", "localhost"), "IP address to bind SMTP service to")
-		mailDir  = flag.String("mail-directory", maildev.GetMailDevEnvString("OWLMAIL_MAIL_DIR", ""), "Directory for persisting mails")
+		smtpPort   = flag.Int("smtp", maildev.GetMailDevEnvInt("OWLMAIL_SMTP_PORT", 1025), "SMTP port to catch emails")
+		smtpHost   = flag.String("ip", maildev.GetMailDevEnvString("OWLMAIL_SMTP_HOST", "localhost"), "IP address to bind SMTP service to")
+		mailDir    = flag.String("mail-directory", maildev.GetMailDevEnvString("OWLMAIL_MAIL_DIR", ""), "Directory for persisting mails")
+		lmtpAddr   = flag.String("lmtp", maildev.GetMailDevEnvString("OWLMAIL_LMTP_ADDR", ""), "LMTP listen address (host:port or unix:/path/to/socket)")
+		smtpListen = flag.String("smtp-listen", maildev.GetMailDevEnvString("OWLMAIL_SMTP_LISTEN", ""), "Comma-separated SMTP listeners, e.g. smtp://0.0.0.0:1025,smtp://[::]:1025,smtps://:1465 (overrides -smtp and -ip)")
+		smtpsPort  = flag.Int("smtps-port", maildev.GetMailDevEnvInt("OWLMAIL_SMTPS_PORT", 465), "Implicit TLS (SMTPS) port used when TLS is enabled")
+
+		// Optional ESMTP extensions
+		smtpExtensions = flag.String("smtp-extensions", maildev.GetMailDevEnvString("OWLMAIL_SMTP_EXTENSIONS", ""), "Comma-separated optional ESMTP extensions to advertise: SMTPUTF8, BINARYMIME, REQUIRETLS, DSN or none (default: all)")
+
+		// SMTP personality profile
+		smtpProfile     = flag.String("smtp-profile", maildev.GetMailDevEnvString("OWLMAIL_SMTP_PROFILE", ""), "SMTP personality profile: owlmail, postfix, exchange or gmail (default: owlmail)")
+		smtpProfileFile = flag.String("smtp-profile-file", maildev.GetMailDevEnvString("OWLMAIL_SMTP_PROFILE_FILE", ""), "JSON file with a custom SMTP profile (overrides -smtp-profile)")
+		smtpHostname    = flag.String("smtp-hostname", maildev.GetMailDevEnvString("OWLMAIL_SMTP_HOSTNAME", ""), "Hostname announced in the SMTP banner and EHLO reply (default: from the profile)")
+
+		// Message size limit
+		maxMessageBytes   = flag.Int64("max-message-bytes", int64(maildev.GetMailDevEnvInt("OWLMAIL_MAX_MESSAGE_BYTES", 0)), "Maximum message size in bytes for listeners without a maxMessageBytes parameter (default: from the profile, 1 MB for owlmail)")
+		oversizeCaptureKB = flag.Int("oversize-capture-kb", maildev.GetMailDevEnvInt("OWLMAIL_OVERSIZE_CAPTURE_KB", 0), "Keep the header and the first N KB of the body of messages over the size limit as rejected emails (0 = keep nothing)")
+
+		// PROXY protocol on SMTP listeners
+		proxyProtocol        = flag.Bool("proxy-protocol", maildev.GetMailDevEnvBool("OWLMAIL_PROXY_PROTOCOL", false), "Expect a HAProxy PROXY protocol (v1/v2) header on all SMTP listeners")
+		proxyProtocolTrusted = flag.String("proxy-protocol-trusted", maildev.GetMailDevEnvString("OWLMAIL_PROXY_PROTOCOL_TRUSTED", ""), "Comma-separated CIDRs allowed to send PROXY headers (required with the PROXY protocol)")
 
 		// Web API configuration
 		webPort     = flag.Int("web", maildev.GetMailDevEnvInt("OWLMAIL_WEB_PORT", 1080), "Web API port")
@@ -121,6 +203,10 @@ func parseConfig() *Config {
 		httpsCertFile = flag.String("https-cert", maildev.GetMailDevEnvString("OWLMAIL_HTTPS_CERT", ""), "HTTPS certificate file path")
 		httpsKeyFile  = flag.String("https-key", maildev.GetMailDevEnvString("OWLMAIL_HTTPS_KEY", ""), "HTTPS private key file path")
 
+		// Local CA mode for SMTP TLS and HTTPS
+		localCA      = flag.Bool("local-ca", maildev.GetMailDevEnvBool("OWLMAIL_LOCAL_CA", false), "Issue SMTP and HTTPS certificates from a CA persisted in the mail directory")
+		localCAHosts = flag.String("local-ca-hosts", maildev.GetMailDevEnvString("OWLMAIL_LOCAL_CA_HOSTS", ""), "Comma-separated hostnames and IPs of the local CA certificate (default: localhost,127.0.0.1,::1)")
+
 		// Outgoing mail configuration
 		outgoingHost   = flag.String("outgoing-host", maildev.GetMailDevEnvString("OWLMAIL_OUTGOING_HOST", ""), "Outgoing SMTP server host")
 		outgoingPort   = flag.Int("outgoing-port", maildev.GetMailDevEnvInt("OWLMAIL_OUTGOING_PORT", 587), "Outgoing SMTP server port")
@@ -132,14 +218,52 @@ func parseConfig() *Config {
 		autoRelayRules = flag.String("auto-relay-rules", maildev.GetMailDevEnvString("OWLMAIL_AUTO_RELAY_RULES", ""), "JSON file path for auto relay rules")
 
 		// SMTP authentication
-		smtpUser     = flag.String("smtp-user", maildev.GetMailDevEnvString("OWLMAIL_SMTP_USER", ""), "SMTP server username for authentication")
-		smtpPassword = flag.String("smtp-password", maildev.GetMailDevEnvString("OWLMAIL_SMTP_PASSWORD", ""), "SMTP server password for authentication")
+		smtpUser      = flag.String("smtp-user", maildev.GetMailDevEnvString("OWLMAIL_SMTP_USER", ""), "SMTP server username for authentication")
+		smtpPassword  = flag.String("smtp-password", maildev.GetMailDevEnvString("OWLMAIL_SMTP_PASSWORD", ""), "SMTP server password for authentication")
+		smtpUsersFile = flag.String("smtp-users-file", maildev.GetMailDevEnvString("OWLMAIL_SMTP_USERS_FILE", ""), "htpasswd-style file with SMTP credentials (bcrypt or plaintext)")
+
+		// SMTP fault injection
+		faultRules = flag.String("fault-rules", maildev.GetMailDevEnvString("OWLMAIL_FAULT_RULES", ""), "JSON file path for SMTP fault injection rules")
+
+		// Greylisting simulation
+		greylist      = flag.Bool("greylist", maildev.GetMailDevEnvBool("OWLMAIL_GREYLIST", false), "Temporarily reject the first delivery attempt of each (client IP, sender, recipient) triplet")
+		greylistDelay = flag.String("greylist-delay", maildev.GetMailDevEnvString("OWLMAIL_GREYLIST_DELAY", "5m"), "Minimum time before a greylisted retry is accepted")
+
+		// SMTP rate limiting
+		maxConnections           = flag.Int("max-connections", maildev.GetMailDevEnvInt("OWLMAIL_MAX_CONNECTIONS", 0), "Maximum concurrent SMTP connections (0 = unlimited)")
+		maxConnectionsPerIP      = flag.Int("max-connections-per-ip", maildev.GetMailDevEnvInt("OWLMAIL_MAX_CONNECTIONS_PER_IP", 0), "Maximum concurrent SMTP connections per client IP (0 = unlimited)")
+		messagesPerMinute        = flag.Int("rate-limit", maildev.GetMailDevEnvInt("OWLMAIL_RATE_LIMIT", 0), "Maximum messages per minute in total (0 = unlimited)")
+		messagesPerMinutePerIP   = flag.Int("rate-limit-per-ip", maildev.GetMailDevEnvInt("OWLMAIL_RATE_LIMIT_PER_IP", 0), "Maximum messages per minute per client IP (0 = unlimited)")
+		messagesPerMinutePerUser = flag.Int("rate-limit-per-user", maildev.GetMailDevEnvInt("OWLMAIL_RATE_LIMIT_PER_USER", 0), "Maximum messages per minute per authenticated SMTP user (0 = unlimited)")
+
+		// Asynchronous ingest pipeline
+		ingestWorkers   = flag.Int("ingest-workers", maildev.GetMailDevEnvInt("OWLMAIL_INGEST_WORKERS", 0), "Workers that parse and store spooled messages after they are acknowledged (0 = process within the SMTP transaction)")
+		ingestQueueSize = flag.Int("ingest-queue", maildev.GetMailDevEnvInt("OWLMAIL_INGEST_QUEUE", mailserver.DefaultIngestQueueSize), "Spooled messages waiting for an ingest worker before clients are told to retry with 451")
+
+		// Storage mode for load testing
+		storageMode        = flag.String("storage-mode", maildev.GetMailDevEnvString("OWLMAIL_STORAGE_MODE", mailserver.StorageModeAll), "Which accepted messages are stored: all, blackhole (none) or sample")
+		storageSampleEvery = flag.Int("storage-sample-every", maildev.GetMailDevEnvInt("OWLMAIL_STORAGE_SAMPLE_EVERY", 0), "Sample mode: store every Nth matching message, starting with the first")
+		storageMatchFrom   = flag.String("storage-match-from", maildev.GetMailDevEnvString("OWLMAIL_STORAGE_MATCH_FROM", ""), "Sample mode: only store messages from senders matching this pattern, e.g. *@example.com")
+		storageMatchTo     = flag.String("storage-match-to", maildev.GetMailDevEnvString("OWLMAIL_STORAGE_MATCH_TO", ""), "Sample mode: only store messages with a recipient matching this pattern")
+
+		// Local zone file with DKIM keys and SPF/DMARC policies
+		dnsZoneFile = flag.String("dns-zone-file", maildev.GetMailDevEnvString("OWLMAIL_DNS_ZONE_FILE", ""), "Zone file with the DNS records (DKIM keys, SPF and DMARC policies) used to authenticate received mail")
+
+		// Ingest access policy
+		allowNetworks   = flag.String("allow-networks", maildev.GetMailDevEnvString("OWLMAIL_ALLOW_NETWORKS", ""), "Comma-separated client CIDRs allowed to connect to SMTP (default: any)")
+		denyNetworks    = flag.String("deny-networks", maildev.GetMailDevEnvString("OWLMAIL_DENY_NETWORKS", ""), "Comma-separated client CIDRs refused by SMTP")
+		acceptedDomains = flag.String("accepted-domains", maildev.GetMailDevEnvString("OWLMAIL_ACCEPTED_DOMAINS", ""), "Comma-separated recipient domains accepted, *.example.com for subdomains (default: any)")
 
 		// TLS configuration for SMTP
 		tlsEnabled  = flag.Bool("tls", maildev.GetMailDevEnvBool("OWLMAIL_TLS_ENABLED", false), "Enable TLS/STARTTLS for SMTP server")
 		tlsCertFile = flag.String("tls-cert", maildev.GetMailDevEnvString("OWLMAIL_TLS_CERT", ""), "TLS certificate file path")
 		tlsKeyFile  = flag.String("tls-key", maildev.GetMailDevEnvString("OWLMAIL_TLS_KEY", ""), "TLS private key file path")
 
+		// Client certificate (mutual TLS) authentication
+		tlsClientCA      = flag.String("tls-client-ca", maildev.GetMailDevEnvString("OWLMAIL_TLS_CLIENT_CA", ""), "PEM file with the CAs that issue SMTP client certificates")
+		tlsClientAuth    = flag.String("tls-client-auth", maildev.GetMailDevEnvString("OWLMAIL_TLS_CLIENT_AUTH", ""), "Client certificate mode: request or require (default: request when -tls-client-ca is set)")
+		tlsClientCertMap = flag.String("tls-client-cert-map", maildev.GetMailDevEnvString("OWLMAIL_TLS_CLIENT_CERT_MAP", ""), "JSON file mapping client certificate subjects to mailboxes")
+
 		// Logging configuration
 		logLevel = flag.String("log-level", maildev.GetMailDevLogLevel("normal"), "Log level: silent, normal, or verbose")
 
@@ -149,31 +273,66 @@ func parseConfig() *Config {
 	flag.Parse()
 
 	return &Config{
-		SMTPPort:          *smtpPort,
-		SMTPHost:          *smtpHost,
-		MailDir:           *mailDir,
-		WebPort:           *webPort,
-		WebHost:           *webHost,
-		WebUser:           *webUser,
-		WebPassword:       *webPassword,
-		HTTPSEnabled:      *httpsEnabled,
-		HTTPSCertFile:     *httpsCertFile,
-		HTTPSKeyFile:      *httpsKeyFile,
-		OutgoingHost:      *outgoingHost,
-		OutgoingPort:      *outgoingPort,
-		OutgoingUser:      *outgoingUser,
-		OutgoingPass:      *outgoingPass,
-		OutgoingSecure:    *outgoingSecure,
-		AutoRelay:         *autoRelay,
-		AutoRelayAddr:     *autoRelayAddr,
-		AutoRelayRules:    *autoRelayRules,
-		SMTPUser:          *smtpUser,
-		SMTPPassword:      *smtpPassword,
-		TLSEnabled:        *tlsEnabled,
-		TLSCertFile:       *tlsCertFile,
-		TLSKeyFile:        *tlsKeyFile,
-		LogLevel:          *logLevel,
-		UseUUIDForEmailID: *useUUIDForEmailID,
+		SMTPPort:                 *smtpPort,
+		SMTPHost:                 *smtpHost,
+		MailDir:                  *mailDir,
+		LMTPAddr:                 *lmtpAddr,
+		SMTPListen:               *smtpListen,
+		SMTPSPort:                *smtpsPort,
+		SMTPExtensions:           *smtpExtensions,
+		SMTPProfile:              *smtpProfile,
+		SMTPProfileFile:          *smtpProfileFile,
+		SMTPHostname:             *smtpHostname,
+		MaxMessageBytes:          *maxMessageBytes,
+		OversizeCaptureKB:        *oversizeCaptureKB,
+		ProxyProtocol:            *proxyProtocol,
+		ProxyProtocolTrusted:     *proxyProtocolTrusted,
+		WebPort:                  *webPort,
+		WebHost:                  *webHost,
+		WebUser:                  *webUser,
+		WebPassword:              *webPassword,
+		HTTPSEnabled:             *httpsEnabled,
+		HTTPSCertFile:            *httpsCertFile,
+		HTTPSKeyFile:             *httpsKeyFile,
+		LocalCA:                  *localCA,
+		LocalCAHosts:             *localCAHosts,
+		OutgoingHost:             *outgoingHost,
+		OutgoingPort:             *outgoingPort,
+		OutgoingUser:             *outgoingUser,
+		OutgoingPass:             *outgoingPass,
+		OutgoingSecure:           *outgoingSecure,
+		AutoRelay:                *autoRelay,
+		AutoRelayAddr:            *autoRelayAddr,
+		AutoRelayRules:           *autoRelayRules,
+		SMTPUser:                 *smtpUser,
+		SMTPPassword:             *smtpPassword,
+		SMTPUsersFile:            *smtpUsersFile,
+		FaultRules:               *faultRules,
+		Greylist:                 *greylist,
+		GreylistDelay:            *greylistDelay,
+		MaxConnections:           *maxConnections,
+		MaxConnectionsPerIP:      *maxConnectionsPerIP,
+		MessagesPerMinute:        *messagesPerMinute,
+		MessagesPerMinutePerIP:   *messagesPerMinutePerIP,
+		MessagesPerMinutePerUser: *messagesPerMinutePerUser,
+		IngestWorkers:            *ingestWorkers,
+		IngestQueueSize:          *ingestQueueSize,
+		StorageMode:              *storageMode,
+		StorageSampleEvery:       *storageSampleEvery,
+		StorageMatchFrom:         *storageMatchFrom,
+		StorageMatchTo:           *storageMatchTo,
+		DNSZoneFile:              *dnsZoneFile,
+		AllowNetworks:            *allowNetworks,
+		DenyNetworks:             *denyNetworks,
+		AcceptedDomains:          *acceptedDomains,
+		TLSEnabled:               *tlsEnabled,
+		TLSCertFile:              *tlsCertFile,
+		TLSKeyFile:               *tlsKeyFile,
+		TLSClientCA:              *tlsClientCA,
+		TLSClientAuth:            *tlsClientAuth,
+		TLSClientCertMap:         *tlsClientCertMap,
+		LogLevel:                 *logLevel,
+		UseUUIDForEmailID:        *useUUIDForEmailID,
 	}
 }
 
@@ -223,25 +382,58 @@ func setupOutgoingConfig(cfg *Config) (*outgoing.OutgoingConfig, error) {
 
 // setupAuthConfig creates SMTP authentication configuration from config
 func setupAuthConfig(cfg *Config) *mailserver.SMTPAuthConfig {
-	if cfg.SMTPUser == "" || cfg.SMTPPassword == "" {
+	hasUser := cfg.SMTPUser != "" && cfg.SMTPPassword != ""
+	if !hasUser && cfg.SMTPUsersFile == "" {
 		return nil
 	}
-	return &mailserver.SMTPAuthConfig{
-		Username: cfg.SMTPUser,
-		Password: cfg.SMTPPassword,
-		Enabled:  true,
+	authConfig := &mailserver.SMTPAuthConfig{
+		UsersFile: cfg.SMTPUsersFile,
+		Enabled:   true,
+	}
+	if hasUser {
+		authConfig.Username = cfg.SMTPUser
+		authConfig.Password = cfg.SMTPPassword
 	}
+	return authConfig
 }
 
 // setupTLSConfig creates TLS configuration from config
 func setupTLSConfig(cfg *Config) *mailserver.TLSConfig {
+	var localCAHosts []string
+	if cfg.LocalCAHosts != "" {
+		localCAHosts = strings.Split(cfg.LocalCAHosts, ",")
+	}
 	if !cfg.TLSEnabled {
+		// The local CA may still issue the HTTPS certificate
+		if cfg.LocalCA {
+			return &mailserver.TLSConfig{LocalCA: true, LocalCAHosts: localCAHosts}
+		}
 		return nil
 	}
 	return &mailserver.TLSConfig{
-		CertFile: cfg.TLSCertFile,
-		KeyFile:  cfg.TLSKeyFile,
-		Enabled:  true,
+		CertFile:  cfg.TLSCertFile,
+		KeyFile:   cfg.TLSKeyFile,
+		Enabled:   true,
+		SMTPSPort: cfg.SMTPSPort,
+
+		ClientCAFile:      cfg.TLSClientCA,
+		ClientAuth:        cfg.TLSClientAuth,
+		ClientCertMapFile: cfg.TLSClientCertMap,
+
+		LocalCA:      cfg.LocalCA,
+		LocalCAHosts: localCAHosts,
+	}
+}
+
+// setupAccessPolicy builds the client and recipient access policy from the configuration
+func setupAccessPolicy(cfg *Config) mailserver.AccessPolicy {
+	splitList := func(list string) []string {
+		return strings.FieldsFunc(list, func(r rune) bool { return r == ',' })
+	}
+	return mailserver.AccessPolicy{
+		AllowNetworks:   splitList(cfg.AllowNetworks),
+		DenyNetworks:    splitList(cfg.DenyNetworks),
+		AcceptedDomains: splitList(cfg.AcceptedDomains),
 	}
 }
 
@@ -304,6 +496,8 @@ func startAPIServer(server *mailserver.MailServer, cfg *Config) (*api.API, error
 	if cfg.HTTPSEnabled {
 		if cfg.HTTPSCertFile != "" {
 			common.Log("HTTPS enabled with certificate: %s", cfg.HTTPSCertFile)
+		} else if server.GetLocalCA() != nil {
+			common.Log("HTTPS enabled with a certificate issued by the local CA")
 		} else {
 			common.Log("HTTPS enabled (no certificate file specified)")
 		}
@@ -346,6 +540,30 @@ func initializeApplication(cfg *Config) error {
 	return nil
 }
 
+// setupSMTPProfile returns the SMTP profile selected by the configuration
+func setupSMTPProfile(cfg *Config) (*mailserver.SMTPProfile, error) {
+	var profile *mailserver.SMTPProfile
+	var err error
+	switch {
+	case cfg.SMTPProfileFile != "":
+		profile, err = mailserver.LoadSMTPProfileFile(cfg.SMTPProfileFile)
+	case cfg.SMTPProfile != "":
+		profile, err = mailserver.GetBuiltinSMTPProfile(cfg.SMTPProfile)
+	default:
+		profile, err = mailserver.GetBuiltinSMTPProfile(mailserver.ProfileOwlMail)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if cfg.SMTPHostname != "" {
+		profile.Hostname = cfg.SMTPHostname
+	}
+	if cfg.MaxMessageBytes != 0 {
+		profile.MaxMessageBytes = cfg.MaxMessageBytes
+	}
+	return profile, nil
+}
+
 // createMailServer creates and configures the mail server
 func createMailServer(cfg *Config) (*mailserver.MailServer, error) {
 	if cfg == nil {
@@ -370,6 +588,172 @@ func createMailServer(cfg *Config) (*mailserver.MailServer, error) {
 		return nil, fmt.Errorf("failed to create mail server: %w", err)
 	}
 
+	if ca := server.GetLocalCA(); ca != nil {
+		common.Log("Local CA certificate: %s (served at /api/v1/ca.pem)", ca.CertFile())
+	}
+
+	// Apply an SMTP personality profile first, so that explicit listener
+	// limits and extensions take precedence over it
+	if cfg.SMTPProfile != "" || cfg.SMTPProfileFile != "" || cfg.SMTPHostname != "" || cfg.MaxMessageBytes != 0 {
+		profile, err := setupSMTPProfile(cfg)
+		if err == nil {
+			err = server.SetSMTPProfile(profile)
+		}
+		if err != nil {
+			_ = server.Close()
+			return nil, fmt.Errorf("invalid SMTP profile: %w", err)
+		}
+		common.Log("Using SMTP profile %s (%s)", profile.Name, profile.Hostname)
+	}
+
+	// Keep the start of messages over the size limit if configured
+	if cfg.OversizeCaptureKB != 0 {
+		if err := server.SetOversizeCapture(cfg.OversizeCaptureKB); err != nil {
+			_ = server.Close()
+			return nil, fmt.Errorf("invalid oversize capture: %w", err)
+		}
+	}
+
+	// Replace the default SMTP listeners if configured
+	if cfg.SMTPListen != "" || cfg.ProxyProtocol {
+		listeners := server.GetListeners()
+		var err error
+		if cfg.SMTPListen != "" {
+			listeners, err = mailserver.ParseListenerConfigs(cfg.SMTPListen)
+		}
+		if cfg.ProxyProtocol {
+			for i := range listeners {
+				listeners[i].ProxyProtocol = true
+			}
+		}
+		if err == nil {
+			err = server.SetListeners(listeners)
+		}
+		if err != nil {
+			_ = server.Close()
+			return nil, fmt.Errorf("invalid SMTP listeners: %w", err)
+		}
+	}
+
+	// Restrict the optional ESMTP extensions if configured
+	if cfg.SMTPExtensions != "" {
+		extensions, err := mailserver.ParseESMTPExtensions(cfg.SMTPExtensions)
+		if err != nil {
+			_ = server.Close()
+			return nil, fmt.Errorf("invalid SMTP extensions: %w", err)
+		}
+		server.SetESMTPExtensions(extensions)
+	}
+
+	// PROXY protocol headers are only accepted from trusted networks, so a
+	// listener expecting them without any would never see a proxied client
+	if cfg.ProxyProtocolTrusted == "" {
+		for _, l := range server.GetListeners() {
+			if l.ProxyProtocol {
+				_ = server.Close()
+				return nil, fmt.Errorf("PROXY protocol on %s requires trusted networks (-proxy-protocol-trusted)", l.Addr)
+			}
+		}
+	}
+
+	// Restrict which sources may send PROXY protocol headers
+	if cfg.ProxyProtocolTrusted != "" {
+		if err := server.SetProxyProtocolTrusted(strings.Split(cfg.ProxyProtocolTrusted, ",")); err != nil {
+			_ = server.Close()
+			return nil, fmt.Errorf("invalid PROXY protocol trusted networks: %w", err)
+		}
+	}
+
+	// Load SMTP fault injection rules if configured
+	if cfg.FaultRules != "" {
+		rules, err := mailserver.LoadFaultRulesFile(cfg.FaultRules)
+		if err == nil {
+			err = server.SetFaultRules(rules)
+		}
+		if err != nil {
+			_ = server.Close()
+			return nil, fmt.Errorf("failed to load fault rules: %w", err)
+		}
+		common.Log("Loaded %d SMTP fault injection rules", len(rules))
+	}
+
+	// Enable greylisting simulation if configured
+	if cfg.Greylist {
+		delay, err := time.ParseDuration(cfg.GreylistDelay)
+		if err == nil {
+			err = server.SetGreylistConfig(mailserver.GreylistConfig{
+				Enabled: true,
+				Delay:   delay,
+				Expiry:  mailserver.DefaultGreylistExpiry,
+			})
+		}
+		if err != nil {
+			_ = server.Close()
+			return nil, fmt.Errorf("invalid greylist configuration: %w", err)
+		}
+		common.Log("Greylisting enabled with a %s retry delay", delay)
+	}
+
+	// Apply SMTP rate limits
+	rateLimits := mailserver.RateLimitConfig{
+		MaxConnections:           cfg.MaxConnections,
+		MaxConnectionsPerIP:      cfg.MaxConnectionsPerIP,
+		MessagesPerMinute:        cfg.MessagesPerMinute,
+		MessagesPerMinutePerIP:   cfg.MessagesPerMinutePerIP,
+		MessagesPerMinutePerUser: cfg.MessagesPerMinutePerUser,
+	}
+	if err := server.SetRateLimitConfig(rateLimits); err != nil {
+		_ = server.Close()
+		return nil, fmt.Errorf("invalid rate limits: %w", err)
+	}
+
+	// Acknowledge spooled messages and parse them in the background if configured
+	if cfg.IngestWorkers > 0 {
+		ingest := mailserver.IngestConfig{Workers: cfg.IngestWorkers, QueueSize: cfg.IngestQueueSize}
+		if err := server.SetIngestConfig(ingest); err != nil {
+			_ = server.Close()
+			return nil, fmt.Errorf("invalid ingest configuration: %w", err)
+		}
+		common.Log("Asynchronous ingest enabled with %d workers", cfg.IngestWorkers)
+	}
+
+	// Accept messages without storing all of them if configured
+	if cfg.StorageMode != "" && cfg.StorageMode != mailserver.StorageModeAll {
+		storage := mailserver.StorageConfig{
+			Mode:        cfg.StorageMode,
+			SampleEvery: cfg.StorageSampleEvery,
+			MatchFrom:   cfg.StorageMatchFrom,
+			MatchTo:     cfg.StorageMatchTo,
+		}
+		if err := server.SetStorageConfig(storage); err != nil {
+			_ = server.Close()
+			return nil, fmt.Errorf("invalid storage configuration: %w", err)
+		}
+		common.Log("Storage mode: %s", cfg.StorageMode)
+	}
+
+	// Restrict which clients may connect and which recipient domains are accepted
+	if err := server.SetAccessPolicy(setupAccessPolicy(cfg)); err != nil {
+		_ = server.Close()
+		return nil, fmt.Errorf("invalid access policy: %w", err)
+	}
+
+	// Authenticate received mail against the records of a local zone file
+	if cfg.DNSZoneFile != "" {
+		zone, err := mailserver.LoadZoneFile(cfg.DNSZoneFile)
+		if err != nil {
+			_ = server.Close()
+			return nil, fmt.Errorf("failed to load DNS zone file: %w", err)
+		}
+		server.SetDNSResolver(zone)
+		common.Log("Loaded DNS records for %d names from zone file %s", zone.Len(), cfg.DNSZoneFile)
+	}
+
+	// Enable LMTP listener if configured
+	if cfg.LMTPAddr != "" {
+		server.SetLMTPAddr(cfg.LMTPAddr)
+	}
+
 	// Register event handlers
 	registerEventHandlers(server)
 
@@ -554,6 +554,21 @@ func TestSetupAuthConfig(t *testing.T) {
 	if result.Enabled != true {
 		t.Errorf("setupAuthConfig().Enabled = %v, want %v", result.Enabled, true)
 	}
+
+	// Test with only a users file
+	cfg = &Config{
+		SMTPUsersFile: "/etc/owlmail/users.htpasswd",
+	}
+	result = setupAuthConfig(cfg)
+	if result == nil {
+		t.Fatal("setupAuthConfig() = nil, want non-nil")
+	}
+	if result.UsersFile != cfg.SMTPUsersFile {
+		t.Errorf("setupAuthConfig().UsersFile = %q, want %q", result.UsersFile, cfg.SMTPUsersFile)
+	}
+	if result.Username != "" {
+		t.Errorf("setupAuthConfig().Username = %q, want empty", result.Username)
+	}
 }
 
 func TestSetupTLSConfig(t *testing.T) {
@@ -585,6 +600,15 @@ func TestSetupTLSConfig(t *testing.T) {
 	if result.Enabled != true {
 		t.Errorf("setupTLSConfig().Enabled = %v, want %v", result.Enabled, true)
 	}
+
+	// Test with client certificate authentication
+	cfg.TLSClientCA = "/path/to/ca.pem"
+	cfg.TLSClientAuth = "require"
+	cfg.TLSClientCertMap = "/path/to/map.json"
+	result = setupTLSConfig(cfg)
+	if result.ClientCAFile != "/path/to/ca.pem" || result.ClientAuth != "require" || result.ClientCertMapFile != "/path/to/map.json" {
+		t.Errorf("Unexpected client certificate config: %+v", result)
+	}
 }
 
 func TestRegisterEventHandlers(t *testing.T) {
@@ -724,6 +748,17 @@ func TestParseConfig(t *testing.T) {
 			"OWLMAIL_SMTP_PORT", "MAILDEV_SMTP_PORT",
 			"OWLMAIL_SMTP_HOST", "MAILDEV_IP",
 			"OWLMAIL_MAIL_DIR", "MAILDEV_MAIL_DIRECTORY",
+			"OWLMAIL_LMTP_ADDR",
+			"OWLMAIL_SMTP_LISTEN",
+			"OWLMAIL_SMTPS_PORT",
+			"OWLMAIL_SMTP_EXTENSIONS",
+			"OWLMAIL_SMTP_PROFILE",
+			"OWLMAIL_SMTP_PROFILE_FILE",
+			"OWLMAIL_SMTP_HOSTNAME",
+			"OWLMAIL_MAX_MESSAGE_BYTES",
+			"OWLMAIL_OVERSIZE_CAPTURE_KB",
+			"OWLMAIL_PROXY_PROTOCOL",
+			"OWLMAIL_PROXY_PROTOCOL_TRUSTED",
 			"OWLMAIL_WEB_PORT", "MAILDEV_WEB_PORT",
 			"OWLMAIL_WEB_HOST", "MAILDEV_WEB_IP",
 			"OWLMAIL_WEB_USER", "MAILDEV_WEB_USER",
@@ -741,9 +776,33 @@ func TestParseConfig(t *testing.T) {
 			"OWLMAIL_AUTO_RELAY_RULES", "MAILDEV_AUTO_RELAY_RULES",
 			"OWLMAIL_SMTP_USER", "MAILDEV_INCOMING_USER",
 			"OWLMAIL_SMTP_PASSWORD", "MAILDEV_INCOMING_PASS",
+			"OWLMAIL_SMTP_USERS_FILE",
+			"OWLMAIL_FAULT_RULES",
+			"OWLMAIL_GREYLIST",
+			"OWLMAIL_GREYLIST_DELAY",
+			"OWLMAIL_MAX_CONNECTIONS",
+			"OWLMAIL_MAX_CONNECTIONS_PER_IP",
+			"OWLMAIL_RATE_LIMIT",
+			"OWLMAIL_RATE_LIMIT_PER_IP",
+			"OWLMAIL_RATE_LIMIT_PER_USER",
+			"OWLMAIL_DNS_ZONE_FILE",
+			"OWLMAIL_INGEST_WORKERS",
+			"OWLMAIL_INGEST_QUEUE",
+			"OWLMAIL_STORAGE_MODE",
+			"OWLMAIL_STORAGE_SAMPLE_EVERY",
+			"OWLMAIL_STORAGE_MATCH_FROM",
+			"OWLMAIL_STORAGE_MATCH_TO",
+			"OWLMAIL_ALLOW_NETWORKS",
+			"OWLMAIL_DENY_NETWORKS",
+			"OWLMAIL_ACCEPTED_DOMAINS",
 			"OWLMAIL_TLS_ENABLED", "MAILDEV_INCOMING_SECURE",
 			"OWLMAIL_TLS_CERT", "MAILDEV_INCOMING_CERT",
 			"OWLMAIL_TLS_KEY", "MAILDEV_INCOMING_KEY",
+			"OWLMAIL_TLS_CLIENT_CA",
+			"OWLMAIL_TLS_CLIENT_AUTH",
+			"OWLMAIL_TLS_CLIENT_CERT_MAP",
+			"OWLMAIL_LOCAL_CA",
+			"OWLMAIL_LOCAL_CA_HOSTS",
 			"OWLMAIL_LOG_LEVEL", "MAILDEV_VERBOSE", "MAILDEV_SILENT",
 		}
 		for _, envVar := range envVars {
@@ -1914,3 +1973,379 @@ func TestStartServers(t *testing.T) {
 	// So we only test the validation logic here (nil server and nil config cases above).
 	// The actual server startup is tested in integration tests or through the main() function.
 }
+
+func TestCreateMailServerWithGreylist(t *testing.T) {
+	cfg := &Config{
+		SMTPPort:      1025,
+		SMTPHost:      "localhost",
+		MailDir:       t.TempDir(),
+		Greylist:      true,
+		GreylistDelay: "30s",
+	}
+	server, err := createMailServer(cfg)
+	if err != nil {
+		t.Fatalf("createMailServer() error = %v, want nil", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+	config := server.GetGreylistConfig()
+	if !config.Enabled || config.Delay != 30*time.Second {
+		t.Errorf("Unexpected greylist config: %+v", config)
+	}
+
+	cfg.MailDir = t.TempDir()
+	cfg.GreylistDelay = "soon"
+	if _, err := createMailServer(cfg); err == nil {
+		t.Error("Expected error for invalid greylist delay")
+	}
+}
+
+func TestCreateMailServerWithListeners(t *testing.T) {
+	cfg := &Config{
+		SMTPPort:   1025,
+		SMTPHost:   "localhost",
+		MailDir:    t.TempDir(),
+		SMTPListen: "smtp://127.0.0.1:2525, smtp://[::1]:2525?maxRecipients=10",
+	}
+	server, err := createMailServer(cfg)
+	if err != nil {
+		t.Fatalf("createMailServer() error = %v, want nil", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+	listeners := server.GetListeners()
+	if len(listeners) != 2 || listeners[1].Addr != "[::1]:2525" || listeners[1].MaxRecipients != 10 {
+		t.Errorf("Unexpected listeners: %+v", listeners)
+	}
+
+	cfg.MailDir = t.TempDir()
+	cfg.SMTPListen = "smtps://127.0.0.1:2465"
+	if _, err := createMailServer(cfg); err == nil {
+		t.Error("Expected error for SMTPS listener without TLS")
+	}
+}
+
+func TestCreateMailServerWithProxyProtocol(t *testing.T) {
+	cfg := &Config{
+		SMTPPort:             1025,
+		SMTPHost:             "localhost",
+		MailDir:              t.TempDir(),
+		ProxyProtocol:        true,
+		ProxyProtocolTrusted: "10.0.0.0/8,192.0.2.1",
+	}
+	server, err := createMailServer(cfg)
+	if err != nil {
+		t.Fatalf("createMailServer() error = %v, want nil", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+	for _, l := range server.GetListeners() {
+		if !l.ProxyProtocol {
+			t.Errorf("Expected PROXY protocol on listener %s", l.Addr)
+		}
+	}
+	if trusted := server.GetProxyProtocolTrusted(); len(trusted) != 2 || trusted[1] != "192.0.2.1/32" {
+		t.Errorf("Unexpected trusted networks: %v", trusted)
+	}
+
+	cfg.MailDir = t.TempDir()
+	cfg.ProxyProtocolTrusted = "balancer.internal"
+	if _, err := createMailServer(cfg); err == nil {
+		t.Error("Expected error for invalid trusted network")
+	}
+
+	cfg.MailDir = t.TempDir()
+	cfg.ProxyProtocolTrusted = ""
+	if _, err := createMailServer(cfg); err == nil {
+		t.Error("Expected error for PROXY protocol without trusted networks")
+	}
+	cfg.ProxyProtocol = false
+	cfg.SMTPListen = "smtp://127.0.0.1:2525?proxyProtocol=true"
+	if _, err := createMailServer(cfg); err == nil {
+		t.Error("Expected error for a PROXY protocol listener without trusted networks")
+	}
+}
+
+func TestCreateMailServerWithSMTPExtensions(t *testing.T) {
+	cfg := &Config{
+		SMTPPort: 1025,
+		SMTPHost: "localhost",
+		MailDir:  t.TempDir(),
+	}
+	server, err := createMailServer(cfg)
+	if err != nil {
+		t.Fatalf("createMailServer() error = %v, want nil", err)
+	}
+	if ext := server.GetESMTPExtensions(); ext != mailserver.DefaultESMTPExtensions() {
+		t.Errorf("Expected all extensions by default, got %+v", ext)
+	}
+	_ = server.Close()
+
+	cfg.MailDir = t.TempDir()
+	cfg.SMTPExtensions = "smtputf8, DSN"
+	server, err = createMailServer(cfg)
+	if err != nil {
+		t.Fatalf("createMailServer() error = %v, want nil", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+	if ext := server.GetESMTPExtensions(); !ext.SMTPUTF8 || !ext.DSN || ext.BinaryMIME || ext.RequireTLS {
+		t.Errorf("Unexpected extensions: %+v", ext)
+	}
+
+	cfg.MailDir = t.TempDir()
+	cfg.SMTPExtensions = "PIPELINING"
+	if _, err := createMailServer(cfg); err == nil {
+		t.Error("Expected error for unknown extension")
+	}
+}
+
+func TestCreateMailServerWithRateLimits(t *testing.T) {
+	cfg := &Config{
+		SMTPPort:               1025,
+		SMTPHost:               "localhost",
+		MailDir:                t.TempDir(),
+		MaxConnectionsPerIP:    5,
+		MessagesPerMinutePerIP: 100,
+	}
+	server, err := createMailServer(cfg)
+	if err != nil {
+		t.Fatalf("createMailServer() error = %v, want nil", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+	config := server.GetRateLimitConfig()
+	if config.MaxConnectionsPerIP != 5 || config.MessagesPerMinutePerIP != 100 || config.MaxConnections != 0 {
+		t.Errorf("Unexpected rate limits: %+v", config)
+	}
+
+	cfg.MailDir = t.TempDir()
+	cfg.MessagesPerMinute = -1
+	if _, err := createMailServer(cfg); err == nil {
+		t.Error("Expected error for negative rate limit")
+	}
+}
+
+func TestCreateMailServerWithAccessPolicy(t *testing.T) {
+	cfg := &Config{
+		SMTPPort:        1025,
+		SMTPHost:        "localhost",
+		MailDir:         t.TempDir(),
+		AllowNetworks:   "10.0.0.0/8, 192.168.1.10",
+		AcceptedDomains: "example.com,*.test.example.com,",
+	}
+	server, err := createMailServer(cfg)
+	if err != nil {
+		t.Fatalf("createMailServer() error = %v, want nil", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+	policy := server.GetAccessPolicy()
+	if len(policy.AllowNetworks) != 2 || policy.AllowNetworks[1] != "192.168.1.10/32" || len(policy.DenyNetworks) != 0 {
+		t.Errorf("Unexpected networks: %+v", policy)
+	}
+	if len(policy.AcceptedDomains) != 2 || policy.AcceptedDomains[1] != "*.test.example.com" {
+		t.Errorf("Unexpected accepted domains: %v", policy.AcceptedDomains)
+	}
+
+	cfg.MailDir = t.TempDir()
+	cfg.DenyNetworks = "10.0.0.0/99"
+	if _, err := createMailServer(cfg); err == nil {
+		t.Error("Expected error for invalid network")
+	}
+}
+
+func TestCreateMailServerWithLocalCA(t *testing.T) {
+	cfg := &Config{
+		SMTPPort:     1025,
+		SMTPHost:     "localhost",
+		MailDir:      t.TempDir(),
+		LocalCA:      true,
+		LocalCAHosts: "mail.test,10.0.0.5",
+	}
+
+	// The local CA is available for HTTPS even when SMTP TLS is disabled
+	server, err := createMailServer(cfg)
+	if err != nil {
+		t.Fatalf("createMailServer() error = %v, want nil", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+	ca := server.GetLocalCA()
+	if ca == nil {
+		t.Fatal("Expected local CA to be loaded")
+	}
+	if hosts := ca.Hosts(); len(hosts) != 2 || hosts[0] != "mail.test" || hosts[1] != "10.0.0.5" {
+		t.Errorf("Unexpected local CA hosts: %v", hosts)
+	}
+	if tlsConfig := server.GetTLSConfig(); tlsConfig == nil || tlsConfig.Enabled {
+		t.Errorf("Expected SMTP TLS to stay disabled, got %+v", tlsConfig)
+	}
+}
+
+func TestCreateMailServerWithDNSZoneFile(t *testing.T) {
+	zoneFile := filepath.Join(t.TempDir(), "keys.zone")
+	if err := os.WriteFile(zoneFile, []byte("sel._domainkey.example.com. IN TXT \"v=DKIM1; p=\"\n"), 0644); err != nil {
+		t.Fatalf("Failed to write zone file: %v", err)
+	}
+	cfg := &Config{
+		SMTPPort:    1025,
+		SMTPHost:    "localhost",
+		MailDir:     t.TempDir(),
+		DNSZoneFile: zoneFile,
+	}
+	server, err := createMailServer(cfg)
+	if err != nil {
+		t.Fatalf("createMailServer() error = %v, want nil", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+	records, err := server.GetDNSResolver().LookupTXT("sel._domainkey.example.com")
+	if err != nil || len(records) != 1 {
+		t.Errorf("Expected the zone file records, got %v, %v", records, err)
+	}
+
+	cfg.MailDir = t.TempDir()
+	cfg.DNSZoneFile = filepath.Join(t.TempDir(), "missing.zone")
+	if _, err := createMailServer(cfg); err == nil {
+		t.Error("Expected error for a missing zone file")
+	}
+}
+
+func TestCreateMailServerWithSMTPProfile(t *testing.T) {
+	cfg := &Config{
+		SMTPPort:       1025,
+		SMTPHost:       "localhost",
+		MailDir:        t.TempDir(),
+		SMTPProfile:    "exchange",
+		SMTPHostname:   "mx1.test.local",
+		SMTPExtensions: "dsn",
+	}
+	server, err := createMailServer(cfg)
+	if err != nil {
+		t.Fatalf("createMailServer() error = %v, want nil", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+	profile := server.GetSMTPProfile()
+	if profile.Name != "exchange" || profile.Hostname != "mx1.test.local" {
+		t.Errorf("Unexpected profile: %+v", profile)
+	}
+	// Explicit extensions take precedence over the profile's
+	if names := server.GetESMTPExtensions().Names(); len(names) != 1 || names[0] != mailserver.ExtensionDSN {
+		t.Errorf("Expected only DSN, got %v", names)
+	}
+
+	profileFile := filepath.Join(t.TempDir(), "profile.json")
+	if err := os.WriteFile(profileFile, []byte(`{"name": "relay", "base": "postfix", "maxRecipients": 3}`), 0644); err != nil {
+		t.Fatalf("Failed to write profile: %v", err)
+	}
+	server2, err := createMailServer(&Config{SMTPPort: 1025, SMTPHost: "localhost", MailDir: t.TempDir(), SMTPProfileFile: profileFile})
+	if err != nil {
+		t.Fatalf("createMailServer() with profile file error = %v, want nil", err)
+	}
+	defer func() {
+		_ = server2.Close()
+	}()
+	if listeners := server2.GetListeners(); listeners[0].MaxRecipients != 3 {
+		t.Errorf("Expected the profile's recipient limit, got %d", listeners[0].MaxRecipients)
+	}
+
+	if _, err := createMailServer(&Config{SMTPPort: 1025, SMTPHost: "localhost", MailDir: t.TempDir(), SMTPProfile: "sendmail"}); err == nil {
+		t.Error("Expected error for an unknown profile")
+	}
+}
+
+func TestCreateMailServerWithIngestWorkers(t *testing.T) {
+	cfg := &Config{
+		SMTPPort:        1025,
+		SMTPHost:        "localhost",
+		MailDir:         t.TempDir(),
+		IngestWorkers:   4,
+		IngestQueueSize: 50,
+	}
+	server, err := createMailServer(cfg)
+	if err != nil {
+		t.Fatalf("createMailServer() error = %v, want nil", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+	if got := server.GetIngestConfig(); got.Workers != 4 || got.QueueSize != 50 {
+		t.Errorf("Expected 4 ingest workers with a queue of 50, got %+v", got)
+	}
+
+	cfg.MailDir = t.TempDir()
+	cfg.IngestQueueSize = -1
+	if _, err := createMailServer(cfg); err == nil {
+		t.Error("Expected error for a negative ingest queue size")
+	}
+}
+
+func TestCreateMailServerWithStorageMode(t *testing.T) {
+	cfg := &Config{
+		SMTPPort:           1025,
+		SMTPHost:           "localhost",
+		MailDir:            t.TempDir(),
+		StorageMode:        "sample",
+		StorageSampleEvery: 10,
+		StorageMatchTo:     "*@example.com",
+	}
+	server, err := createMailServer(cfg)
+	if err != nil {
+		t.Fatalf("createMailServer() error = %v, want nil", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+	want := mailserver.StorageConfig{Mode: "sample", SampleEvery: 10, MatchTo: "*@example.com"}
+	if got := server.GetStorageConfig(); got != want {
+		t.Errorf("Expected storage config %+v, got %+v", want, got)
+	}
+
+	cfg.MailDir = t.TempDir()
+	cfg.StorageMode = "nowhere"
+	if _, err := createMailServer(cfg); err == nil {
+		t.Error("Expected error for an unknown storage mode")
+	}
+}
+
+func TestCreateMailServerWithMaxMessageBytes(t *testing.T) {
+	cfg := &Config{
+		SMTPPort:          1025,
+		SMTPHost:          "localhost",
+		MailDir:           t.TempDir(),
+		SMTPListen:        "smtp://127.0.0.1:0,smtp://127.0.0.1:0?maxMessageBytes=2048",
+		MaxMessageBytes:   25 * 1024 * 1024,
+		OversizeCaptureKB: 64,
+	}
+	server, err := createMailServer(cfg)
+	if err != nil {
+		t.Fatalf("createMailServer() error = %v, want nil", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+	listeners := server.GetListeners()
+	if len(listeners) != 2 || listeners[0].MaxMessageBytes != 25*1024*1024 || listeners[1].MaxMessageBytes != 2048 {
+		t.Errorf("Expected the listener limit to override the default, got %+v", listeners)
+	}
+	if got := server.GetOversizeCapture(); got != 64 {
+		t.Errorf("Expected an oversize capture of 64 KB, got %d", got)
+	}
+
+	cfg.MailDir = t.TempDir()
+	cfg.MaxMessageBytes = -1
+	if _, err := createMailServer(cfg); err == nil {
+		t.Error("Expected error for a negative message size limit")
+	}
+}
@@ -21,7 +21,7 @@ type API struct {
 	port          int
 	host          string
 	wsUpgrader    websocket.Upgrader
-	wsClients     map[*websocket.Conn]*sync.Mutex
+	wsClients     map[*websocket.Conn]*wsClient
 	wsClientsLock sync.RWMutex
 	authUser      string
 	authPassword  string
@@ -46,7 +46,7 @@ func NewAPIWithHTTPS(mailServer *mailserver.MailServer, port int, host, user, pa
 		mailServer:    mailServer,
 		port:          port,
 		host:          host,
-		wsClients:     make(map[*websocket.Conn]*sync.Mutex),
+		wsClients:     make(map[*websocket.Conn]*wsClient),
 		authUser:      user,
 		authPassword:  password,
 		httpsEnabled:  httpsEnabled,
@@ -74,7 +74,7 @@ func (api *API) setupRoutes() {
 
 	// HTTP Basic Auth middleware if configured
 	if api.authUser != "" && api.authPassword != "" {
-		router.Use(basicAuthMiddleware(api.authUser, api.authPassword, "/healthz", "/api/v1/health"))
+		router.Use(basicAuthMiddleware(api.authUser, api.authPassword, "/healthz", "/api/v1/health", "/api/v1/ca.pem"))
 	}
 
 	// Static files (web UI)
@@ -154,23 +154,39 @@ func (api *API) setupImprovedAPIRoutes(router *gin.Engine) {
 			emailsGroup.POST("/reload", api.reloadMailsFromDirectory)
 
 			// Individual email routes
-			emailsGroup.GET("/:id", api.getEmailByID)
-			emailsGroup.DELETE("/:id", api.deleteEmail)
-			emailsGroup.PATCH("/:id/read", api.readEmail)
+			emailsGroup.GET("/:id", api.mailboxScope, api.getEmailByID)
+			emailsGroup.DELETE("/:id", api.mailboxScope, api.deleteEmail)
+			emailsGroup.PATCH("/:id/read", api.mailboxScope, api.readEmail)
 
 			// Email content routes
-			emailsGroup.GET("/:id/html", api.getEmailHTML)
-			emailsGroup.GET("/:id/source", api.getEmailSource)
-			emailsGroup.GET("/:id/raw", api.downloadEmail) // More semantic than /download
+			emailsGroup.GET("/:id/html", api.mailboxScope, api.getEmailHTML)
+			emailsGroup.GET("/:id/source", api.mailboxScope, api.getEmailSource)
+			emailsGroup.GET("/:id/raw", api.mailboxScope, api.downloadEmail) // More semantic than /download
+			emailsGroup.GET("/:id/transcript", api.mailboxScope, api.getEmailTranscript)
+			emailsGroup.GET("/:id/parts", api.mailboxScope, api.getEmailParts)
+			emailsGroup.GET("/:id/parts/:path", api.mailboxScope, api.getEmailPart)
 
 			// Email attachments (plural, more RESTful)
-			emailsGroup.GET("/:id/attachments/:filename", api.getAttachment)
+			emailsGroup.GET("/:id/attachments/:filename", api.mailboxScope, api.getAttachment)
 
 			// Email actions
-			emailsGroup.POST("/:id/actions/relay", api.relayEmail)
-			emailsGroup.POST("/:id/actions/relay/:relayTo", api.relayEmailWithParam)
+			emailsGroup.POST("/:id/actions/relay", api.mailboxScope, api.relayEmail)
+			emailsGroup.POST("/:id/actions/relay/:relayTo", api.mailboxScope, api.relayEmailWithParam)
 		}
 
+		// GET /api/v1/mailboxes - List mailboxes with email counts
+		v1.GET("/mailboxes", api.getMailboxes)
+
+		// Greylisting triplet table
+		v1.GET("/greylist", api.getGreylist)
+		v1.DELETE("/greylist", api.clearGreylist)
+
+		// Rate limiting counters
+		v1.GET("/ratelimits", api.getRateLimits)
+
+		// Asynchronous ingest queue depth and counters
+		v1.GET("/ingest", api.getIngest)
+
 		// Settings resource (more semantic than /config)
 		settingsGroup := v1.Group("/settings")
 		{
@@ -181,11 +197,32 @@ func (api *API) setupImprovedAPIRoutes(router *gin.Engine) {
 			settingsGroup.GET("/outgoing", api.getOutgoingConfig)
 			settingsGroup.PUT("/outgoing", api.updateOutgoingConfig)
 			settingsGroup.PATCH("/outgoing", api.patchOutgoingConfig)
+
+			// SMTP fault injection rules
+			settingsGroup.GET("/faults", api.getFaultRules)
+			settingsGroup.PUT("/faults", api.updateFaultRules)
+
+			// Greylisting simulation
+			settingsGroup.PUT("/greylist", api.updateGreylistConfig)
+
+			// SMTP rate limits and connection caps
+			settingsGroup.PUT("/ratelimits", api.updateRateLimits)
+
+			// Client networks and accepted recipient domains
+			settingsGroup.GET("/policy", api.getAccessPolicy)
+			settingsGroup.PUT("/policy", api.updateAccessPolicy)
+
+			// Blackhole and sampling storage modes
+			settingsGroup.GET("/storage", api.getStorageConfig)
+			settingsGroup.PUT("/storage", api.updateStorageConfig)
 		}
 
 		// Health check (more standard than /healthz)
 		v1.GET("/health", api.healthCheck)
 
+		// Local CA certificate for clients to trust
+		v1.GET("/ca.pem", api.getLocalCACert)
+
 		// WebSocket (clearer path)
 		v1.GET("/ws", api.handleWebSocket)
 	}
@@ -196,38 +233,59 @@ func (api *API) Start() error {
 	addr := fmt.Sprintf("%s:%d", api.host, api.port)
 
 	if api.httpsEnabled {
-		if api.httpsCertFile == "" || api.httpsKeyFile == "" {
-			return fmt.Errorf("HTTPS enabled but certificate or key file not provided")
+		tlsConfig, err := api.httpsTLSConfig()
+		if err != nil {
+			return err
 		}
 
 		// Create HTTP server with TLS config
 		srv := &http.Server{
-			Addr:    addr,
-			Handler: api.router,
-			TLSConfig: &tls.Config{
-				MinVersion: tls.VersionTLS12,
-			},
+			Addr:      addr,
+			Handler:   api.router,
+			TLSConfig: tlsConfig,
 		}
 
 		// Logging is handled in main.go
-		return srv.ListenAndServeTLS(api.httpsCertFile, api.httpsKeyFile)
+		return srv.ListenAndServeTLS("", "")
 	}
 
 	// Logging is handled in main.go
 	return api.router.Run(addr)
 }
 
+// httpsTLSConfig returns the TLS configuration of the HTTPS server, using the
+// configured certificate or one issued by the local CA
+func (api *API) httpsTLSConfig() (*tls.Config, error) {
+	var cert tls.Certificate
+	var err error
+	switch {
+	case api.httpsCertFile != "" && api.httpsKeyFile != "":
+		cert, err = tls.LoadX509KeyPair(api.httpsCertFile, api.httpsKeyFile)
+	case api.mailServer.GetLocalCA() != nil:
+		cert, err = api.mailServer.GetLocalCA().ServerCertificate()
+	default:
+		return nil, fmt.Errorf("HTTPS enabled but certificate or key file not provided")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load HTTPS certificate: %w", err)
+	}
+	return &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}, nil
+}
+
 // setupEventListeners sets up event listeners for WebSocket broadcasting
 func (api *API) setupEventListeners() {
 	api.mailServer.On("new", func(email *types.Email) {
-		api.broadcastMessage(gin.H{
+		api.broadcastMailboxMessage(email.Mailbox, gin.H{
 			"type":  "new",
 			"email": email,
 		})
 	})
 
 	api.mailServer.On("delete", func(email *types.Email) {
-		api.broadcastMessage(gin.H{
+		api.broadcastMailboxMessage(email.Mailbox, gin.H{
 			"type": "delete",
 			"id":   email.ID,
 		})
@@ -244,22 +302,22 @@ func (api *API) setupMailDevCompatibleRoutes(router *gin.Engine) {
 		emailGroup.GET("", api.getAllEmails)
 
 		// GET /email/:id - Get single email by ID
-		emailGroup.GET("/:id", api.getEmailByID)
+		emailGroup.GET("/:id", api.mailboxScope, api.getEmailByID)
 
 		// GET /email/:id/html - Get email HTML content
-		emailGroup.GET("/:id/html", api.getEmailHTML)
+		emailGroup.GET("/:id/html", api.mailboxScope, api.getEmailHTML)
 
 		// GET /email/:id/attachment/:filename - Download attachment
-		emailGroup.GET("/:id/attachment/:filename", api.getAttachment)
+		emailGroup.GET("/:id/attachment/:filename", api.mailboxScope, api.getAttachment)
 
 		// GET /email/:id/download - Download raw .eml file
-		emailGroup.GET("/:id/download", api.downloadEmail)
+		emailGroup.GET("/:id/download", api.mailboxScope, api.downloadEmail)
 
 		// GET /email/:id/source - Get email raw source
-		emailGroup.GET("/:id/source", api.getEmailSource)
+		emailGroup.GET("/:id/source", api.mailboxScope, api.getEmailSource)
 
 		// DELETE /email/:id - Delete single email
-		emailGroup.DELETE("/:id", api.deleteEmail)
+		emailGroup.DELETE("/:id", api.mailboxScope, api.deleteEmail)
 
 		// DELETE /email/all - Delete all emails
 		emailGroup.DELETE("/all", api.deleteAllEmails)
@@ -268,13 +326,13 @@ func (api *API) setupMailDevCompatibleRoutes(router *gin.Engine) {
 		emailGroup.PATCH("/read-all", api.readAllEmails)
 
 		// PATCH /email/:id/read - Mark single email as read
-		emailGroup.PATCH("/:id/read", api.readEmail)
+		emailGroup.PATCH("/:id/read", api.mailboxScope, api.readEmail)
 
 		// POST /email/:id/relay - Relay email to SMTP server
-		emailGroup.POST("/:id/relay", api.relayEmail)
+		emailGroup.POST("/:id/relay", api.mailboxScope, api.relayEmail)
 
 		// POST /email/:id/relay/:relayTo - Relay email to SMTP server with specific recipient
-		emailGroup.POST("/:id/relay/:relayTo", api.relayEmailWithParam)
+		emailGroup.POST("/:id/relay/:relayTo", api.mailboxScope, api.relayEmailWithParam)
 
 		// GET /email/stats - Get email statistics
 		emailGroup.GET("/stats", api.getEmailStats)
@@ -4,6 +4,7 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/soulteary/owlmail/internal/mailserver"
 	"github.com/soulteary/owlmail/internal/outgoing"
 )
 
@@ -15,11 +16,39 @@ func (api *API) getConfig(c *gin.Context) {
 			"host": api.mailServer.GetHost(),
 			"port": api.mailServer.GetPort(),
 		},
+		"listeners": listenersResponse(api.mailServer.GetListeners()),
+		"esmtp": gin.H{
+			"extensions": api.mailServer.GetESMTPExtensions().Names(),
+		},
+		"smtpProfile": gin.H{
+			"profile":   api.mailServer.GetSMTPProfile(),
+			"available": mailserver.SMTPProfileNames(),
+		},
+		"proxyProtocol": gin.H{
+			"trusted": api.mailServer.GetProxyProtocolTrusted(),
+		},
+		"oversizeCapture": gin.H{
+			"enabled": api.mailServer.GetOversizeCapture() > 0,
+			"kb":      api.mailServer.GetOversizeCapture(),
+		},
+		"lmtp": gin.H{
+			"enabled": api.mailServer.GetLMTPAddr() != "",
+			"addr":    api.mailServer.GetLMTPAddr(),
+		},
 		"web": gin.H{
 			"host": api.host,
 			"port": api.port,
 		},
-		"mailDir": api.mailServer.GetMailDir(),
+		"mailDir":    api.mailServer.GetMailDir(),
+		"greylist":   greylistConfigResponse(api.mailServer.GetGreylistConfig()),
+		"rateLimits": api.mailServer.GetRateLimitConfig(),
+		"ingest":     api.mailServer.GetIngestConfig(),
+		"storage":    api.mailServer.GetStorageConfig(),
+		"policy":     api.mailServer.GetAccessPolicy(),
+		"authentication": gin.H{
+			"resolver": api.mailServer.GetDNSResolver() != nil,
+			"checks":   []string{"dkim", "spf", "dmarc"},
+		},
 	}
 
 	// Add outgoing mail configuration if available
@@ -43,8 +72,11 @@ func (api *API) getConfig(c *gin.Context) {
 	authConfig := api.mailServer.GetAuthConfig()
 	if authConfig != nil {
 		config["smtpAuth"] = gin.H{
-			"enabled":  authConfig.Enabled,
-			"username": authConfig.Username,
+			"enabled":    authConfig.Enabled,
+			"username":   authConfig.Username,
+			"usersFile":  authConfig.UsersFile,
+			"userCount":  authConfig.UserCount(),
+			"mechanisms": []string{"PLAIN", "LOGIN", "CRAM-MD5"},
 		}
 	} else {
 		config["smtpAuth"] = nil
@@ -54,17 +86,48 @@ func (api *API) getConfig(c *gin.Context) {
 	tlsConfig := api.mailServer.GetTLSConfig()
 	if tlsConfig != nil {
 		config["tls"] = gin.H{
-			"enabled":  tlsConfig.Enabled,
-			"certFile": tlsConfig.CertFile,
-			"keyFile":  tlsConfig.KeyFile,
+			"enabled":           tlsConfig.Enabled,
+			"certFile":          tlsConfig.CertFile,
+			"keyFile":           tlsConfig.KeyFile,
+			"clientCA":          tlsConfig.ClientCAFile,
+			"clientAuth":        tlsConfig.ClientAuth,
+			"clientCertMapFile": tlsConfig.ClientCertMapFile,
 		}
 	} else {
 		config["tls"] = nil
 	}
 
+	// Add local CA mode if enabled
+	if ca := api.mailServer.GetLocalCA(); ca != nil {
+		config["localCA"] = gin.H{
+			"enabled": true,
+			"hosts":   ca.Hosts(),
+			"certURL": "/api/v1/ca.pem",
+		}
+	} else {
+		config["localCA"] = gin.H{"enabled": false}
+	}
+
 	c.JSON(http.StatusOK, config)
 }
 
+// listenersResponse converts SMTP listener configurations to their JSON form
+func listenersResponse(configs []mailserver.ListenerConfig) []gin.H {
+	listeners := make([]gin.H, 0, len(configs))
+	for _, config := range configs {
+		listeners = append(listeners, gin.H{
+			"addr":            config.Addr,
+			"mode":            config.Mode,
+			"maxMessageBytes": config.MaxMessageBytes,
+			"maxRecipients":   config.MaxRecipients,
+			"readTimeout":     config.ReadTimeout.String(),
+			"writeTimeout":    config.WriteTimeout.String(),
+			"proxyProtocol":   config.ProxyProtocol,
+		})
+	}
+	return listeners
+}
+
 // getOutgoingConfig handles GET /api/v1/settings/outgoing
 func (api *API) getOutgoingConfig(c *gin.Context) {
 	outgoingConfig := api.mailServer.GetOutgoingConfig()
@@ -214,9 +277,49 @@ func (api *API) patchOutgoingConfig(c *gin.Context) {
 	})
 }
 
+// getFaultRules handles GET /api/v1/settings/faults
+func (api *API) getFaultRules(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"rules": api.mailServer.GetFaultRules(),
+	})
+}
+
+// updateFaultRules handles PUT /api/v1/settings/faults
+func (api *API) updateFaultRules(c *gin.Context) {
+	var request struct {
+		Rules []mailserver.FaultRule `json:"rules"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse(ErrorCodeInvalidRequest, "Invalid request: "+err.Error()))
+		return
+	}
+
+	if err := api.mailServer.SetFaultRules(request.Rules); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse(ErrorCodeInvalidFaultRule, err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    SuccessCodeConfigUpdated,
+		"message": "Fault injection rules updated",
+		"rules":   api.mailServer.GetFaultRules(),
+	})
+}
+
 // healthCheck handles GET /api/v1/health
 func (api *API) healthCheck(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"status": "ok",
 	})
 }
+
+// getLocalCACert handles GET /api/v1/ca.pem
+func (api *API) getLocalCACert(c *gin.Context) {
+	ca := api.mailServer.GetLocalCA()
+	if ca == nil {
+		c.JSON(http.StatusNotFound, ErrorResponse(ErrorCodeLocalCADisabled, "Local CA mode is not enabled"))
+		return
+	}
+	c.Header("Content-Disposition", `attachment; filename="owlmail-ca.pem"`)
+	c.Data(http.StatusOK, "application/x-pem-file", ca.CertPEM())
+}
@@ -36,6 +36,23 @@ func TestAPIGetConfig(t *testing.T) {
 	if response["version"] == nil {
 		t.Error("Response should have version field")
 	}
+	listeners, ok := response["listeners"].([]interface{})
+	if !ok || len(listeners) != 1 {
+		t.Fatalf("Expected one SMTP listener, got %v", response["listeners"])
+	}
+	if mode := listeners[0].(map[string]interface{})["mode"]; mode != "plain" {
+		t.Errorf("Expected plain listener, got %v", mode)
+	}
+	smtpProfile, ok := response["smtpProfile"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("Expected smtpProfile field, got %v", response["smtpProfile"])
+	}
+	if name := smtpProfile["profile"].(map[string]interface{})["name"]; name != "owlmail" {
+		t.Errorf("Expected the owlmail profile, got %v", name)
+	}
+	if available := smtpProfile["available"].([]interface{}); len(available) != 4 {
+		t.Errorf("Expected 4 built-in profiles, got %v", available)
+	}
 }
 
 func TestAPIGetOutgoingConfig(t *testing.T) {
@@ -652,3 +669,57 @@ func TestAPIPatchOutgoingConfigWithNonStringRules(t *testing.T) {
 		t.Errorf("Expected 1 deny rule (non-string filtered), got %d", len(denyRules))
 	}
 }
+
+func TestAPIFaultRules(t *testing.T) {
+	api, server, _ := setupTestAPI(t)
+	defer func() {
+		if err := server.Close(); err != nil {
+			t.Errorf("Failed to close server: %v", err)
+		}
+	}()
+
+	gin.SetMode(gin.TestMode)
+
+	body := map[string]interface{}{
+		"rules": []mailserver.FaultRule{
+			{Name: "bounce", Stage: mailserver.FaultStageRcpt, Match: "bounce-*@example.com", Code: 550, EnhancedCode: "5.1.1"},
+		},
+	}
+	jsonBody, _ := json.Marshal(body)
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("PUT", "/api/v1/settings/faults", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	api.router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/v1/settings/faults", nil)
+	api.router.ServeHTTP(w, req)
+	var response struct {
+		Rules []mailserver.FaultRule `json:"rules"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if len(response.Rules) != 1 || response.Rules[0].Name != "bounce" {
+		t.Errorf("Unexpected rules: %+v", response.Rules)
+	}
+
+	// Invalid rules are rejected and leave the current rules in place
+	invalid := []byte(`{"rules":[{"stage":"rcpt","code":250}]}`)
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("PUT", "/api/v1/settings/faults", bytes.NewBuffer(invalid))
+	req.Header.Set("Content-Type", "application/json")
+	api.router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", w.Code)
+	}
+	if !bytes.Contains(w.Body.Bytes(), []byte(ErrorCodeInvalidFaultRule)) {
+		t.Errorf("Expected %s error code, got %s", ErrorCodeInvalidFaultRule, w.Body.String())
+	}
+	if len(server.GetFaultRules()) != 1 {
+		t.Error("Invalid update should not replace existing rules")
+	}
+}
@@ -35,12 +35,7 @@ func (api *API) getAllEmails(c *gin.Context) {
 	// Get query parameters
 	limitStr := c.DefaultQuery("limit", "50")
 	offsetStr := c.DefaultQuery("offset", "0")
-	query := c.Query("q")                            // Full text search query
-	from := c.Query("from")                          // Filter by sender
-	to := c.Query("to")                              // Filter by recipient
-	dateFrom := c.Query("dateFrom")                  // Filter by date from (YYYY-MM-DD)
-	dateTo := c.Query("dateTo")                      // Filter by date to (YYYY-MM-DD)
-	read := c.Query("read")                          // Filter by read status (true/false)
+	filter := parseEmailFilter(c)
 	sortBy := c.DefaultQuery("sortBy", "")           // Sort by: time, subject
 	sortOrder := c.DefaultQuery("sortOrder", "desc") // Sort order: asc, desc
 
@@ -57,11 +52,11 @@ func (api *API) getAllEmails(c *gin.Context) {
 		offset = 0
 	}
 
-	// Get all emails
-	emails := api.mailServer.GetAllEmail()
+	// Get all emails in the selected mailbox
+	emails := api.mailServer.GetAllEmailInMailbox(filter.Mailbox)
 
 	// Apply filters
-	filtered := applyEmailFilters(emails, query, from, to, dateFrom, dateTo, read)
+	filtered := applyEmailFilters(emails, filter)
 
 	// Apply sorting
 	if sortBy != "" {
@@ -99,6 +94,32 @@ func (api *API) getAllEmails(c *gin.Context) {
 	})
 }
 
+// mailboxScope answers 404 for an email outside the mailbox selected with the
+// optional "mailbox" query parameter, so that the routes of a single email
+// are scoped like the list
+func (api *API) mailboxScope(c *gin.Context) {
+	if !api.inMailboxScope(c)(c.Param("id")) {
+		c.AbortWithStatusJSON(http.StatusNotFound, ErrorResponse(ErrorCodeEmailNotFound, "Email not found"))
+		return
+	}
+	c.Next()
+}
+
+// inMailboxScope returns a function reporting whether an email ID belongs to
+// the mailbox selected with the optional "mailbox" query parameter. Without
+// the parameter every ID does.
+func (api *API) inMailboxScope(c *gin.Context) func(id string) bool {
+	mailbox := c.Query("mailbox")
+	if mailbox == "" {
+		return func(string) bool { return true }
+	}
+	ids := make(map[string]bool)
+	for _, email := range api.mailServer.GetAllEmailInMailbox(mailbox) {
+		ids[email.ID] = true
+	}
+	return func(id string) bool { return ids[id] }
+}
+
 // getEmailByID handles GET /api/v1/emails/:id
 func (api *API) getEmailByID(c *gin.Context) {
 	id := c.Param("id")
@@ -111,9 +132,14 @@ func (api *API) getEmailByID(c *gin.Context) {
 }
 
 // getEmailHTML handles GET /api/v1/emails/:id/html
+// Use ?embed=true for a self-contained document with inline images as data URIs.
 func (api *API) getEmailHTML(c *gin.Context) {
 	id := c.Param("id")
-	html, err := api.mailServer.GetEmailHTML(id)
+	getHTML := api.mailServer.GetEmailHTML
+	if c.Query("embed") == "true" {
+		getHTML = api.mailServer.GetEmailHTMLEmbedded
+	}
+	html, err := getHTML(id)
 	if err != nil {
 		c.JSON(http.StatusNotFound, ErrorResponse(ErrorCodeEmailNotFound, "Email not found"))
 		return
@@ -176,6 +202,47 @@ func (api *API) getEmailSource(c *gin.Context) {
 	c.Data(http.StatusOK, "text/plain; charset=utf-8", content)
 }
 
+// getEmailTranscript handles GET /api/v1/emails/:id/transcript
+// Use ?format=text for a plain text rendering of the conversation.
+func (api *API) getEmailTranscript(c *gin.Context) {
+	id := c.Param("id")
+
+	entries, err := api.mailServer.GetEmailTranscript(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse(ErrorCodeEmailNotFound, err.Error()))
+		return
+	}
+
+	if c.Query("format") == "text" {
+		var sb strings.Builder
+		for _, entry := range entries {
+			prefix := "*"
+			switch entry.Direction {
+			case types.TranscriptClient:
+				prefix = "C:"
+			case types.TranscriptServer:
+				prefix = "S:"
+			}
+			fmt.Fprintf(&sb, "%s %s %s", entry.Time.Format("15:04:05.000"), prefix, entry.Line)
+			if entry.DurationMs > 0 {
+				fmt.Fprintf(&sb, " (%.1fms)", entry.DurationMs)
+			}
+			sb.WriteString("\n")
+		}
+		c.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(sb.String()))
+		return
+	}
+
+	if entries == nil {
+		entries = []types.TranscriptEntry{}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"id":      id,
+		"count":   len(entries),
+		"entries": entries,
+	})
+}
+
 // deleteEmail handles DELETE /api/v1/emails/:id
 func (api *API) deleteEmail(c *gin.Context) {
 	id := c.Param("id")
@@ -187,8 +254,9 @@ func (api *API) deleteEmail(c *gin.Context) {
 }
 
 // deleteAllEmails handles DELETE /api/v1/emails
+// With ?mailbox=<name>, only the emails of that mailbox are deleted.
 func (api *API) deleteAllEmails(c *gin.Context) {
-	if err := api.mailServer.DeleteAllEmail(); err != nil {
+	if err := api.mailServer.DeleteAllEmailInMailbox(c.Query("mailbox")); err != nil {
 		c.JSON(http.StatusInternalServerError, ErrorResponse(ErrorCodeInvalidRequest, err.Error()))
 		return
 	}
@@ -196,8 +264,9 @@ func (api *API) deleteAllEmails(c *gin.Context) {
 }
 
 // readAllEmails handles PATCH /api/v1/emails/read
+// With ?mailbox=<name>, only the emails of that mailbox are marked as read.
 func (api *API) readAllEmails(c *gin.Context) {
-	count := api.mailServer.ReadAllEmail()
+	count := api.mailServer.ReadAllEmailInMailbox(c.Query("mailbox"))
 	c.JSON(http.StatusOK, SuccessResponse(SuccessCodeAllEmailsMarkedRead, "All emails marked as read", gin.H{"count": count}))
 }
 
@@ -212,11 +281,38 @@ func (api *API) readEmail(c *gin.Context) {
 }
 
 // getEmailStats handles GET /api/v1/emails/stats
+// With ?mailbox=<name>, only the emails of that mailbox are counted and the
+// server-wide "received" counters are left out.
 func (api *API) getEmailStats(c *gin.Context) {
+	if mailbox := c.Query("mailbox"); mailbox != "" {
+		c.JSON(http.StatusOK, api.mailServer.GetEmailStatsInMailbox(mailbox))
+		return
+	}
 	stats := api.mailServer.GetEmailStats()
 	c.JSON(http.StatusOK, stats)
 }
 
+// getMailboxes handles GET /api/v1/mailboxes
+func (api *API) getMailboxes(c *gin.Context) {
+	counts := api.mailServer.GetMailboxes()
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	mailboxes := make([]gin.H, 0, len(names))
+	for _, name := range names {
+		mailboxes = append(mailboxes, gin.H{
+			"name":  name,
+			"total": counts[name],
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"mailboxes": mailboxes,
+	})
+}
+
 // reloadMailsFromDirectory handles POST /api/v1/emails/reload
 func (api *API) reloadMailsFromDirectory(c *gin.Context) {
 	if err := api.mailServer.LoadMailsFromDirectory(); err != nil {
@@ -232,12 +328,7 @@ func (api *API) getEmailPreviews(c *gin.Context) {
 	// Get query parameters (same as getAllEmails but return previews)
 	limitStr := c.DefaultQuery("limit", "50")
 	offsetStr := c.DefaultQuery("offset", "0")
-	query := c.Query("q")
-	from := c.Query("from")
-	to := c.Query("to")
-	dateFrom := c.Query("dateFrom")
-	dateTo := c.Query("dateTo")
-	read := c.Query("read")
+	filter := parseEmailFilter(c)
 	sortBy := c.DefaultQuery("sortBy", "")
 	sortOrder := c.DefaultQuery("sortOrder", "desc")
 
@@ -254,11 +345,11 @@ func (api *API) getEmailPreviews(c *gin.Context) {
 		offset = 0
 	}
 
-	// Get all emails
-	emails := api.mailServer.GetAllEmail()
+	// Get all emails in the selected mailbox
+	emails := api.mailServer.GetAllEmailInMailbox(filter.Mailbox)
 
 	// Apply filters (same logic as getAllEmails)
-	filtered := applyEmailFilters(emails, query, from, to, dateFrom, dateTo, read)
+	filtered := applyEmailFilters(emails, filter)
 
 	// Apply sorting (same as getAllEmails)
 	if sortBy != "" {
@@ -362,7 +453,14 @@ func (api *API) batchDeleteEmails(c *gin.Context) {
 	failedCount := 0
 	failedIDs := make([]string, 0)
 
+	// Emails outside the selected mailbox fail like missing ones
+	inScope := api.inMailboxScope(c)
 	for _, id := range request.IDs {
+		if !inScope(id) {
+			failedCount++
+			failedIDs = append(failedIDs, id)
+			continue
+		}
 		if err := api.mailServer.DeleteEmail(id); err != nil {
 			failedCount++
 			failedIDs = append(failedIDs, id)
@@ -402,7 +500,13 @@ func (api *API) batchReadEmails(c *gin.Context) {
 	failedCount := 0
 	failedIDs := make([]string, 0)
 
+	inScope := api.inMailboxScope(c)
 	for _, id := range request.IDs {
+		if !inScope(id) {
+			failedCount++
+			failedIDs = append(failedIDs, id)
+			continue
+		}
 		email, err := api.mailServer.GetEmail(id)
 		if err != nil {
 			failedCount++
@@ -431,15 +535,10 @@ func (api *API) batchReadEmails(c *gin.Context) {
 func (api *API) exportEmails(c *gin.Context) {
 	// Get query parameters for filtering
 	idsParam := c.Query("ids") // Comma-separated list of IDs
-	query := c.Query("q")
-	from := c.Query("from")
-	to := c.Query("to")
-	dateFrom := c.Query("dateFrom")
-	dateTo := c.Query("dateTo")
-	read := c.Query("read")
+	filter := parseEmailFilter(c)
 
-	// Get all emails
-	emails := api.mailServer.GetAllEmail()
+	// Get all emails in the selected mailbox
+	emails := api.mailServer.GetAllEmailInMailbox(filter.Mailbox)
 
 	// Filter emails
 	var filtered []*types.Email
@@ -458,7 +557,7 @@ func (api *API) exportEmails(c *gin.Context) {
 		}
 	} else {
 		// Apply filters (same logic as getAllEmails)
-		filtered = applyEmailFilters(emails, query, from, to, dateFrom, dateTo, read)
+		filtered = applyEmailFilters(emails, filter)
 	}
 
 	if len(filtered) == 0 {
@@ -514,10 +613,96 @@ func (api *API) exportEmails(c *gin.Context) {
 	c.Writer.Flush()
 }
 
+// emailFilter holds the filter criteria accepted by the email list endpoints
+type emailFilter struct {
+	Query    string // Full text search query
+	From     string // Filter by sender
+	To       string // Filter by recipient
+	DateFrom string // Filter by date from (YYYY-MM-DD)
+	DateTo   string // Filter by date to (YYYY-MM-DD)
+	Read     string // Filter by read status (true/false)
+	Mailbox  string // Filter by mailbox (authenticated SMTP user)
+	Rejected string // Filter by rejection (true/false): oversize messages of which only the start was kept
+
+	// Parse warnings
+	Problems string // Filter by whether parse warnings were found (true/false)
+	Warning  string // Filter by parse warning code, e.g. invalid_address
+
+	// Header fields, all of which must match: Name for a field that is present,
+	// or Name:value for a field whose decoded value contains value
+	Headers []string
+
+	// ESMTP parameters of the envelope
+	SMTPUTF8   string // Filter by SMTPUTF8 (true/false)
+	RequireTLS string // Filter by REQUIRETLS (true/false)
+	Body       string // Filter by BODY type (7BIT, 8BITMIME, BINARYMIME)
+	Ret        string // Filter by DSN RET (FULL, HDRS)
+	Notify     string // Filter by a DSN NOTIFY value of any recipient
+
+	// Connection the message was received over
+	Helo       string // Filter by EHLO/HELO name (substring)
+	User       string // Filter by authenticated SMTP username, or none for unauthenticated
+	TLS        string // Filter by encryption (true/false)
+	TLSMode    string // Filter by TLS mode (starttls, implicit), or none for plaintext
+	TLSVersion string // Filter by TLS version, e.g. TLS1.3
+	Cipher     string // Filter by TLS cipher suite
+	SNI        string // Filter by TLS server name
+
+	// Authentication results
+	DKIM       string // Filter by the result of any signature (pass, fail, temperror, permerror), or none for unsigned
+	DKIMDomain string // Filter by the signing domain of any signature
+	SPF        string // Filter by the SPF result of the envelope sender
+	DMARC      string // Filter by the DMARC result of the From header domain
+}
+
+// parseEmailFilter reads email filter criteria from query parameters
+func parseEmailFilter(c *gin.Context) emailFilter {
+	return emailFilter{
+		Query:    c.Query("q"),
+		From:     c.Query("from"),
+		To:       c.Query("to"),
+		DateFrom: c.Query("dateFrom"),
+		DateTo:   c.Query("dateTo"),
+		Read:     c.Query("read"),
+		Mailbox:  c.Query("mailbox"),
+		Rejected: c.Query("rejected"),
+		Headers:  c.QueryArray("header"),
+		Problems: c.Query("problems"),
+		Warning:  c.Query("warning"),
+
+		SMTPUTF8:   c.Query("smtputf8"),
+		RequireTLS: c.Query("requireTLS"),
+		Body:       c.Query("body"),
+		Ret:        c.Query("ret"),
+		Notify:     c.Query("notify"),
+
+		Helo:       c.Query("helo"),
+		User:       c.Query("user"),
+		TLS:        c.Query("tls"),
+		TLSMode:    c.Query("tlsMode"),
+		TLSVersion: c.Query("tlsVersion"),
+		Cipher:     c.Query("cipher"),
+		SNI:        c.Query("sni"),
+
+		DKIM:       c.Query("dkim"),
+		DKIMDomain: c.Query("dkimDomain"),
+		SPF:        c.Query("spf"),
+		DMARC:      c.Query("dmarc"),
+	}
+}
+
 // applyEmailFilters applies filters to email list
-func applyEmailFilters(emails []*types.Email, query, from, to, dateFrom, dateTo, read string) []*types.Email {
+func applyEmailFilters(emails []*types.Email, filter emailFilter) []*types.Email {
+	query, from, to := filter.Query, filter.From, filter.To
+	dateFrom, dateTo, read := filter.DateFrom, filter.DateTo, filter.Read
+
 	filtered := make([]*types.Email, 0)
 	for _, email := range emails {
+		// Filter by mailbox
+		if filter.Mailbox != "" && email.Mailbox != filter.Mailbox {
+			continue
+		}
+
 		// Full text search
 		if query != "" {
 			queryLower := strings.ToLower(query)
@@ -609,11 +794,195 @@ func applyEmailFilters(emails []*types.Email, query, from, to, dateFrom, dateTo,
 			}
 		}
 
+		// Filter by rejection
+		if filter.Rejected != "" {
+			rejected := email.Envelope != nil && email.Envelope.Rejected != nil
+			if rejected != (filter.Rejected == "true") {
+				continue
+			}
+		}
+
+		// Filter by parse warnings
+		if filter.Problems != "" && (len(email.Warnings) > 0) != (filter.Problems == "true") {
+			continue
+		}
+		if filter.Warning != "" && !hasWarning(email.Warnings, filter.Warning) {
+			continue
+		}
+
+		if !matchesHeaderFilter(email.HeaderFields, filter.Headers) {
+			continue
+		}
+		if !matchesESMTPFilter(email.Envelope, filter) {
+			continue
+		}
+		if !matchesSessionFilter(email.Envelope, filter) {
+			continue
+		}
+		if !matchesDKIMFilter(email.DKIM, filter) {
+			continue
+		}
+		if filter.SPF != "" && !matchesSPFFilter(email.SPF, filter.SPF) {
+			continue
+		}
+		if filter.DMARC != "" && !matchesDMARCFilter(email.DMARC, filter.DMARC) {
+			continue
+		}
+
 		filtered = append(filtered, email)
 	}
 	return filtered
 }
 
+// hasWarning reports whether an email has a parse warning with the given code
+func hasWarning(warnings []types.ParseWarning, code string) bool {
+	for _, warning := range warnings {
+		if strings.EqualFold(warning.Code, code) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesHeaderFilter reports whether the header fields of an email match every header filter
+func matchesHeaderFilter(fields []types.HeaderField, filters []string) bool {
+	for _, filter := range filters {
+		name, value, hasValue := strings.Cut(filter, ":")
+		name = strings.TrimSpace(name)
+		value = strings.ToLower(strings.TrimSpace(value))
+		matched := false
+		for _, field := range fields {
+			if strings.EqualFold(field.Name, name) && (!hasValue || strings.Contains(strings.ToLower(field.Decoded), value)) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesESMTPFilter reports whether the ESMTP parameters of an envelope match the filter
+func matchesESMTPFilter(envelope *types.Envelope, filter emailFilter) bool {
+	if filter.SMTPUTF8 == "" && filter.RequireTLS == "" && filter.Body == "" && filter.Ret == "" && filter.Notify == "" {
+		return true
+	}
+	if envelope == nil {
+		envelope = &types.Envelope{}
+	}
+
+	if filter.SMTPUTF8 != "" && envelope.SMTPUTF8 != (filter.SMTPUTF8 == "true") {
+		return false
+	}
+	if filter.RequireTLS != "" && envelope.RequireTLS != (filter.RequireTLS == "true") {
+		return false
+	}
+	if filter.Body != "" && !strings.EqualFold(envelope.Body, filter.Body) {
+		return false
+	}
+	if filter.Ret != "" && !strings.EqualFold(envelope.Return, filter.Ret) {
+		return false
+	}
+	if filter.Notify != "" {
+		for _, rcpt := range envelope.Recipients {
+			for _, notify := range rcpt.Notify {
+				if strings.EqualFold(notify, filter.Notify) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// matchesSessionFilter reports whether the connection details of an envelope match the filter
+func matchesSessionFilter(envelope *types.Envelope, filter emailFilter) bool {
+	if filter.Helo == "" && filter.User == "" && filter.TLS == "" && filter.TLSMode == "" &&
+		filter.TLSVersion == "" && filter.Cipher == "" && filter.SNI == "" {
+		return true
+	}
+	if envelope == nil {
+		envelope = &types.Envelope{}
+	}
+
+	if filter.Helo != "" && !strings.Contains(strings.ToLower(envelope.Host), strings.ToLower(filter.Helo)) {
+		return false
+	}
+	if filter.User != "" {
+		if strings.EqualFold(filter.User, "none") {
+			if envelope.User != "" {
+				return false
+			}
+		} else if envelope.User != filter.User {
+			return false
+		}
+	}
+	if filter.TLS != "" && (envelope.TLS != nil) != (filter.TLS == "true") {
+		return false
+	}
+
+	// Emails received in plaintext have no TLS details and match tlsMode=none only
+	tlsInfo := envelope.TLS
+	if tlsInfo == nil {
+		if filter.TLSVersion != "" || filter.Cipher != "" || filter.SNI != "" {
+			return false
+		}
+		return filter.TLSMode == "" || strings.EqualFold(filter.TLSMode, "none")
+	}
+	if filter.TLSMode != "" && !strings.EqualFold(tlsInfo.Mode, filter.TLSMode) {
+		return false
+	}
+	// TLS versions match with or without the space, e.g. TLS1.3 or "TLS 1.3"
+	if filter.TLSVersion != "" && !strings.EqualFold(strings.ReplaceAll(tlsInfo.Version, " ", ""), strings.ReplaceAll(filter.TLSVersion, " ", "")) {
+		return false
+	}
+	if filter.Cipher != "" && !strings.EqualFold(tlsInfo.CipherSuite, filter.Cipher) {
+		return false
+	}
+	if filter.SNI != "" && !strings.EqualFold(tlsInfo.ServerName, filter.SNI) {
+		return false
+	}
+	return true
+}
+
+// matchesDKIMFilter reports whether the DKIM results of an email match the filter
+func matchesDKIMFilter(results []types.DKIMResult, filter emailFilter) bool {
+	if filter.DKIM == "" && filter.DKIMDomain == "" {
+		return true
+	}
+	if strings.EqualFold(filter.DKIM, "none") {
+		return len(results) == 0 && filter.DKIMDomain == ""
+	}
+	for _, result := range results {
+		if (filter.DKIM == "" || strings.EqualFold(result.Result, filter.DKIM)) &&
+			(filter.DKIMDomain == "" || strings.EqualFold(result.Domain, filter.DKIMDomain)) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesSPFFilter reports whether the SPF result of an email matches the
+// filter; emails that were not evaluated match none
+func matchesSPFFilter(result *types.SPFResult, filter string) bool {
+	if result == nil {
+		return strings.EqualFold(filter, "none")
+	}
+	return strings.EqualFold(result.Result, filter)
+}
+
+// matchesDMARCFilter reports whether the DMARC result of an email matches the
+// filter; emails without a single From domain have no result and match none
+func matchesDMARCFilter(result *types.DMARCResult, filter string) bool {
+	if result == nil {
+		return strings.EqualFold(filter, "none")
+	}
+	return strings.EqualFold(result.Result, filter)
+}
+
 // applyEmailSorting applies sorting to email list
 func applyEmailSorting(emails []*types.Email, sortBy, sortOrder string) {
 	switch sortBy {
@@ -2513,79 +2513,79 @@ func TestApplyEmailFilters(t *testing.T) {
 	}
 
 	// Test with query filter
-	filtered := applyEmailFilters(emails, "Test", "", "", "", "", "")
+	filtered := applyEmailFilters(emails, emailFilter{Query: "Test"})
 	if len(filtered) != 1 {
 		t.Errorf("Expected 1 email, got %d", len(filtered))
 	}
 
 	// Test with from filter
-	filtered = applyEmailFilters(emails, "", "from1", "", "", "", "")
+	filtered = applyEmailFilters(emails, emailFilter{From: "from1"})
 	if len(filtered) != 1 {
 		t.Errorf("Expected 1 email, got %d", len(filtered))
 	}
 
 	// Test with from filter by name
-	filtered = applyEmailFilters(emails, "", "From One", "", "", "", "")
+	filtered = applyEmailFilters(emails, emailFilter{From: "From One"})
 	if len(filtered) != 1 {
 		t.Errorf("Expected 1 email, got %d", len(filtered))
 	}
 
 	// Test with to filter
-	filtered = applyEmailFilters(emails, "", "", "to1", "", "", "")
+	filtered = applyEmailFilters(emails, emailFilter{To: "to1"})
 	if len(filtered) != 1 {
 		t.Errorf("Expected 1 email, got %d", len(filtered))
 	}
 
 	// Test with to filter by CC
-	filtered = applyEmailFilters(emails, "", "", "cc1", "", "", "")
+	filtered = applyEmailFilters(emails, emailFilter{To: "cc1"})
 	if len(filtered) != 1 {
 		t.Errorf("Expected 1 email, got %d", len(filtered))
 	}
 
 	// Test with to filter by BCC
-	filtered = applyEmailFilters(emails, "", "", "bcc1", "", "", "")
+	filtered = applyEmailFilters(emails, emailFilter{To: "bcc1"})
 	if len(filtered) != 1 {
 		t.Errorf("Expected 1 email, got %d", len(filtered))
 	}
 
 	// Test with dateFrom filter
-	filtered = applyEmailFilters(emails, "", "", "", now.Add(-48*time.Hour).Format("2006-01-02"), "", "")
+	filtered = applyEmailFilters(emails, emailFilter{DateFrom: now.Add(-48 * time.Hour).Format("2006-01-02")})
 	if len(filtered) != 2 {
 		t.Errorf("Expected 2 emails, got %d", len(filtered))
 	}
 
 	// Test with dateTo filter
-	filtered = applyEmailFilters(emails, "", "", "", "", now.Format("2006-01-02"), "")
+	filtered = applyEmailFilters(emails, emailFilter{DateTo: now.Format("2006-01-02")})
 	if len(filtered) != 2 {
 		t.Errorf("Expected 2 emails, got %d", len(filtered))
 	}
 
 	// Test with read filter (false)
-	filtered = applyEmailFilters(emails, "", "", "", "", "", "false")
+	filtered = applyEmailFilters(emails, emailFilter{Read: "false"})
 	if len(filtered) != 1 {
 		t.Errorf("Expected 1 email, got %d", len(filtered))
 	}
 
 	// Test with read filter (true)
-	filtered = applyEmailFilters(emails, "", "", "", "", "", "true")
+	filtered = applyEmailFilters(emails, emailFilter{Read: "true"})
 	if len(filtered) != 1 {
 		t.Errorf("Expected 1 email, got %d", len(filtered))
 	}
 
 	// Test with invalid dateFrom
-	filtered = applyEmailFilters(emails, "", "", "", "invalid-date", "", "")
+	filtered = applyEmailFilters(emails, emailFilter{DateFrom: "invalid-date"})
 	if len(filtered) != 2 {
 		t.Errorf("Expected 2 emails (no filter applied), got %d", len(filtered))
 	}
 
 	// Test with invalid dateTo
-	filtered = applyEmailFilters(emails, "", "", "", "", "invalid-date", "")
+	filtered = applyEmailFilters(emails, emailFilter{DateTo: "invalid-date"})
 	if len(filtered) != 2 {
 		t.Errorf("Expected 2 emails (no filter applied), got %d", len(filtered))
 	}
 
 	// Test with no filters
-	filtered = applyEmailFilters(emails, "", "", "", "", "", "")
+	filtered = applyEmailFilters(emails, emailFilter{})
 	if len(filtered) != 2 {
 		t.Errorf("Expected 2 emails, got %d", len(filtered))
 	}
@@ -2604,31 +2604,31 @@ func TestApplyEmailFilters(t *testing.T) {
 	}
 
 	// Test query filter with empty email
-	filtered = applyEmailFilters(emails3, "Content", "", "", "", "", "")
+	filtered = applyEmailFilters(emails3, emailFilter{Query: "Content"})
 	if len(filtered) != 1 {
 		t.Errorf("Expected 1 email, got %d", len(filtered))
 	}
 
 	// Test from filter with empty From
-	filtered = applyEmailFilters(emails3, "", "test", "", "", "", "")
+	filtered = applyEmailFilters(emails3, emailFilter{From: "test"})
 	if len(filtered) != 0 {
 		t.Errorf("Expected 0 emails (no match), got %d", len(filtered))
 	}
 
 	// Test to filter with empty To
-	filtered = applyEmailFilters(emails3, "", "", "test", "", "", "")
+	filtered = applyEmailFilters(emails3, emailFilter{To: "test"})
 	if len(filtered) != 0 {
 		t.Errorf("Expected 0 emails (no match), got %d", len(filtered))
 	}
 
 	// Test dateFrom filter with email before date
-	filtered = applyEmailFilters(emails3, "", "", "", now.Add(24*time.Hour).Format("2006-01-02"), "", "")
+	filtered = applyEmailFilters(emails3, emailFilter{DateFrom: now.Add(24 * time.Hour).Format("2006-01-02")})
 	if len(filtered) != 0 {
 		t.Errorf("Expected 0 emails (before date), got %d", len(filtered))
 	}
 
 	// Test dateTo filter with email after date
-	filtered = applyEmailFilters(emails3, "", "", "", "", now.Add(-48*time.Hour).Format("2006-01-02"), "")
+	filtered = applyEmailFilters(emails3, emailFilter{DateTo: now.Add(-48 * time.Hour).Format("2006-01-02")})
 	if len(filtered) != 0 {
 		t.Errorf("Expected 0 emails (after date), got %d", len(filtered))
 	}
@@ -2816,3 +2816,721 @@ func TestAPIGetEmailPreviewsStartEqualsEnd(t *testing.T) {
 		t.Errorf("Expected 0 previews (start == end), got %d", len(previews))
 	}
 }
+
+func TestAPIEmailsMailboxSelector(t *testing.T) {
+	api, server, _ := setupTestAPI(t)
+	defer func() {
+		if err := server.Close(); err != nil {
+			t.Errorf("Failed to close server: %v", err)
+		}
+	}()
+
+	if err := server.SaveEmailToStore("id1", false, &types.Envelope{User: "alice"}, &types.Email{Subject: "For Alice"}); err != nil {
+		t.Fatalf("Failed to save email 1: %v", err)
+	}
+	if err := server.SaveEmailToStore("id2", false, &types.Envelope{User: "bob"}, &types.Email{Subject: "For Bob"}); err != nil {
+		t.Fatalf("Failed to save email 2: %v", err)
+	}
+	if err := server.SaveEmailToStore("id3", false, &types.Envelope{}, &types.Email{Subject: "Anonymous"}); err != nil {
+		t.Fatalf("Failed to save email 3: %v", err)
+	}
+
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		mailbox string
+		want    int
+	}{
+		{"", 3},
+		{"alice", 1},
+		{"bob", 1},
+		{mailserver.DefaultMailbox, 1},
+		{"carol", 0},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/v1/emails?mailbox="+tt.mailbox, nil)
+		api.router.ServeHTTP(w, req)
+
+		var response struct {
+			Total  int            `json:"total"`
+			Emails []*types.Email `json:"emails"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to parse response: %v", err)
+		}
+		if response.Total != tt.want {
+			t.Errorf("mailbox=%q: expected %d emails, got %d", tt.mailbox, tt.want, response.Total)
+		}
+		for _, email := range response.Emails {
+			if tt.mailbox != "" && email.Mailbox != tt.mailbox {
+				t.Errorf("mailbox=%q: got email from mailbox %q", tt.mailbox, email.Mailbox)
+			}
+		}
+	}
+
+	// Mailbox listing
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v1/mailboxes", nil)
+	api.router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	var response struct {
+		Mailboxes []struct {
+			Name  string `json:"name"`
+			Total int    `json:"total"`
+		} `json:"mailboxes"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if len(response.Mailboxes) != 3 {
+		t.Fatalf("Expected 3 mailboxes, got %d", len(response.Mailboxes))
+	}
+	if response.Mailboxes[0].Name != "alice" || response.Mailboxes[0].Total != 1 {
+		t.Errorf("Unexpected first mailbox: %+v", response.Mailboxes[0])
+	}
+
+	// applyEmailFilters honours the mailbox selector on its own
+	filtered := applyEmailFilters(server.GetAllEmail(), emailFilter{Mailbox: "bob"})
+	if len(filtered) != 1 || filtered[0].Subject != "For Bob" {
+		t.Errorf("Expected only Bob's email, got %d emails", len(filtered))
+	}
+
+	// Single emails are scoped like the list
+	for _, tt := range []struct {
+		path string
+		code int
+	}{
+		{"/api/v1/emails/id1", http.StatusOK},
+		{"/api/v1/emails/id1?mailbox=alice", http.StatusOK},
+		{"/api/v1/emails/id1?mailbox=bob", http.StatusNotFound},
+		{"/api/v1/emails/id1/source?mailbox=bob", http.StatusNotFound},
+		{"/email/id3?mailbox=alice", http.StatusNotFound},
+		{"/email/id3?mailbox=" + mailserver.DefaultMailbox, http.StatusOK},
+	} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", tt.path, nil)
+		api.router.ServeHTTP(w, req)
+		if w.Code != tt.code {
+			t.Errorf("GET %s: expected status %d, got %d", tt.path, tt.code, w.Code)
+		}
+	}
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("DELETE", "/api/v1/emails/id2?mailbox=alice", nil)
+	api.router.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected deleting Bob's email from Alice's mailbox to fail, got %d", w.Code)
+	}
+
+	// So are the statistics
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/v1/emails/stats?mailbox=alice", nil)
+	api.router.ServeHTTP(w, req)
+	var stats map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil {
+		t.Fatalf("Failed to parse stats: %v", err)
+	}
+	if stats["total"] != float64(1) {
+		t.Errorf("Expected 1 email in Alice's stats, got %v", stats["total"])
+	}
+	if _, ok := stats["received"]; ok {
+		t.Error("Expected no server-wide counters in mailbox stats")
+	}
+}
+
+func TestAPIGetEmailTranscript(t *testing.T) {
+	api, server, _ := setupTestAPI(t)
+	defer func() {
+		if err := server.Close(); err != nil {
+			t.Errorf("Failed to close server: %v", err)
+		}
+	}()
+
+	now := time.Now()
+	email := &types.Email{
+		ID:      "transcript-id",
+		Subject: "Transcript",
+		Time:    now,
+		Transcript: []types.TranscriptEntry{
+			{Time: now, Direction: types.TranscriptServer, Line: "220 localhost ESMTP"},
+			{Time: now, Direction: types.TranscriptClient, Line: "EHLO client"},
+			{Time: now, Direction: types.TranscriptServer, Line: "250 Hello client", DurationMs: 1.5},
+		},
+	}
+	envelope := &types.Envelope{From: "from@example.com", To: []string{"to@example.com"}}
+	if err := server.SaveEmailToStore("transcript-id", false, envelope, email); err != nil {
+		t.Fatalf("Failed to save email: %v", err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v1/emails/transcript-id/transcript", nil)
+	api.router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	var response struct {
+		Count   int                     `json:"count"`
+		Entries []types.TranscriptEntry `json:"entries"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if response.Count != 3 || response.Entries[1].Line != "EHLO client" {
+		t.Errorf("Unexpected transcript: %+v", response)
+	}
+
+	// The transcript is not part of the email itself
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/v1/emails/transcript-id", nil)
+	api.router.ServeHTTP(w, req)
+	if strings.Contains(w.Body.String(), "EHLO client") {
+		t.Error("Email JSON should not embed the transcript")
+	}
+
+	// Plain text rendering
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/v1/emails/transcript-id/transcript?format=text", nil)
+	api.router.ServeHTTP(w, req)
+	if !strings.Contains(w.Body.String(), "C: EHLO client") || !strings.Contains(w.Body.String(), "S: 250 Hello client (1.5ms)") {
+		t.Errorf("Unexpected text transcript:\n%s", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/v1/emails/nonexistent/transcript", nil)
+	api.router.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", w.Code)
+	}
+}
+
+func TestAPIGetAllEmailsFilterByESMTPParameters(t *testing.T) {
+	api, server, _ := setupTestAPI(t)
+	defer func() {
+		if err := server.Close(); err != nil {
+			t.Errorf("Failed to close server: %v", err)
+		}
+	}()
+
+	envelopes := map[string]*types.Envelope{
+		"dsn": {
+			From:     "from@example.com",
+			To:       []string{"to@example.com"},
+			Body:     "8BITMIME",
+			Return:   "HDRS",
+			SMTPUTF8: true,
+			Recipients: []types.RecipientOptions{
+				{Address: "to@example.com", Notify: []string{"SUCCESS", "FAILURE"}},
+			},
+		},
+		"plain": {From: "from@example.com", To: []string{"to@example.com"}, Body: "7BIT"},
+		"tls":   {From: "from@example.com", To: []string{"to@example.com"}, RequireTLS: true},
+	}
+	for id, envelope := range envelopes {
+		email := &types.Email{ID: id, Subject: id, Time: time.Now()}
+		if err := server.SaveEmailToStore(id, false, envelope, email); err != nil {
+			t.Fatalf("Failed to save email: %v", err)
+		}
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{query: "smtputf8=true", want: []string{"dsn"}},
+		{query: "smtputf8=false", want: []string{"plain", "tls"}},
+		{query: "requireTLS=true", want: []string{"tls"}},
+		{query: "body=8bitmime", want: []string{"dsn"}},
+		{query: "ret=HDRS", want: []string{"dsn"}},
+		{query: "notify=success", want: []string{"dsn"}},
+		{query: "notify=DELAY", want: nil},
+	}
+	gin.SetMode(gin.TestMode)
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/v1/emails?sortBy=subject&sortOrder=asc&"+tt.query, nil)
+		api.router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected status 200, got %d", tt.query, w.Code)
+		}
+		var response struct {
+			Emails []*types.Email `json:"emails"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to parse response: %v", err)
+		}
+		var got []string
+		for _, email := range response.Emails {
+			got = append(got, email.ID)
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%s: got %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestAPIGetAllEmailsFilterByDKIM(t *testing.T) {
+	api, server, _ := setupTestAPI(t)
+	defer func() {
+		if err := server.Close(); err != nil {
+			t.Errorf("Failed to close server: %v", err)
+		}
+	}()
+
+	results := map[string][]types.DKIMResult{
+		"broken": {{Domain: "example.com", Selector: "s1", Result: "fail", Reason: "body hash did not verify"}},
+		"signed": {
+			{Domain: "example.com", Selector: "s1", Result: "pass", BodyHashMatch: true},
+			{Domain: "esp.example.net", Selector: "s2", Result: "permerror", Reason: "no key"},
+		},
+		"unsigned": nil,
+	}
+	for id, dkim := range results {
+		email := &types.Email{ID: id, Subject: id, Time: time.Now(), DKIM: dkim}
+		if err := server.SaveEmailToStore(id, false, &types.Envelope{}, email); err != nil {
+			t.Fatalf("Failed to save email: %v", err)
+		}
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{query: "dkim=pass", want: []string{"signed"}},
+		{query: "dkim=FAIL", want: []string{"broken"}},
+		{query: "dkim=none", want: []string{"unsigned"}},
+		{query: "dkimDomain=example.com", want: []string{"broken", "signed"}},
+		{query: "dkimDomain=esp.example.net&dkim=pass", want: nil},
+		{query: "dkimDomain=esp.example.net&dkim=permerror", want: []string{"signed"}},
+	}
+	gin.SetMode(gin.TestMode)
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/v1/emails?sortBy=subject&sortOrder=asc&"+tt.query, nil)
+		api.router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected status 200, got %d", tt.query, w.Code)
+		}
+		var response struct {
+			Emails []*types.Email `json:"emails"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to parse response: %v", err)
+		}
+		var got []string
+		for _, email := range response.Emails {
+			got = append(got, email.ID)
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%s: got %v, want %v", tt.query, got, tt.want)
+		}
+	}
+
+	// Results are part of the email JSON
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v1/emails/signed", nil)
+	api.router.ServeHTTP(w, req)
+	if !strings.Contains(w.Body.String(), `"dkim":[{"domain":"example.com","selector":"s1","bodyHashMatch":true,"result":"pass"}`) {
+		t.Errorf("Expected DKIM results in the email JSON, got %s", w.Body.String())
+	}
+}
+
+func TestAPIGetAllEmailsFilterBySPFAndDMARC(t *testing.T) {
+	api, server, _ := setupTestAPI(t)
+	defer func() {
+		if err := server.Close(); err != nil {
+			t.Errorf("Failed to close server: %v", err)
+		}
+	}()
+
+	emails := []*types.Email{
+		{ID: "aligned", SPF: &types.SPFResult{Domain: "example.com", Result: "pass"}, DMARC: &types.DMARCResult{Domain: "example.com", Policy: "reject", Result: "pass", SPFAligned: true}},
+		{ID: "spoofed", SPF: &types.SPFResult{Domain: "example.net", Result: "softfail"}, DMARC: &types.DMARCResult{Domain: "example.com", Policy: "reject", Result: "fail"}},
+		{ID: "unauthenticated"},
+	}
+	for _, email := range emails {
+		email.Subject, email.Time = email.ID, time.Now()
+		if err := server.SaveEmailToStore(email.ID, false, &types.Envelope{}, email); err != nil {
+			t.Fatalf("Failed to save email: %v", err)
+		}
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{query: "spf=pass", want: []string{"aligned"}},
+		{query: "spf=SOFTFAIL", want: []string{"spoofed"}},
+		{query: "spf=none", want: []string{"unauthenticated"}},
+		{query: "dmarc=fail", want: []string{"spoofed"}},
+		{query: "dmarc=none", want: []string{"unauthenticated"}},
+		{query: "dmarc=pass&spf=fail", want: nil},
+	}
+	gin.SetMode(gin.TestMode)
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/v1/emails?sortBy=subject&sortOrder=asc&"+tt.query, nil)
+		api.router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected status 200, got %d", tt.query, w.Code)
+		}
+		var response struct {
+			Emails []*types.Email `json:"emails"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to parse response: %v", err)
+		}
+		var got []string
+		for _, email := range response.Emails {
+			got = append(got, email.ID)
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%s: got %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestAPIGetAllEmailsFilterBySession(t *testing.T) {
+	api, server, _ := setupTestAPI(t)
+	defer func() {
+		if err := server.Close(); err != nil {
+			t.Errorf("Failed to close server: %v", err)
+		}
+	}()
+
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	envelopes := map[string]*types.Envelope{
+		"implicit": {
+			Host: "app01.example.com", User: "app",
+			TLS:          &types.TLSInfo{Mode: "implicit", Version: "TLS 1.3", CipherSuite: "TLS_AES_128_GCM_SHA256", ServerName: "smtp.example.com"},
+			SessionStart: start, SessionEnd: start.Add(time.Second),
+		},
+		"plaintext": {Host: "legacy.example.org"},
+		"starttls": {
+			Host:         "app02.example.com",
+			TLS:          &types.TLSInfo{Mode: "starttls", Version: "TLS 1.2", CipherSuite: "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"},
+			SessionStart: start,
+		},
+	}
+	for id, envelope := range envelopes {
+		email := &types.Email{ID: id, Subject: id, Time: time.Now()}
+		if err := server.SaveEmailToStore(id, false, envelope, email); err != nil {
+			t.Fatalf("Failed to save email: %v", err)
+		}
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{query: "tls=false", want: []string{"plaintext"}},
+		{query: "tls=true", want: []string{"implicit", "starttls"}},
+		{query: "tlsMode=starttls", want: []string{"starttls"}},
+		{query: "tlsMode=none", want: []string{"plaintext"}},
+		{query: "tlsVersion=TLS1.3", want: []string{"implicit"}},
+		{query: "tlsVersion=TLS%201.2", want: []string{"starttls"}},
+		{query: "cipher=tls_aes_128_gcm_sha256", want: []string{"implicit"}},
+		{query: "sni=smtp.example.com", want: []string{"implicit"}},
+		{query: "helo=example.com", want: []string{"implicit", "starttls"}},
+		{query: "user=app", want: []string{"implicit"}},
+		{query: "user=none&tls=true", want: []string{"starttls"}},
+	}
+	gin.SetMode(gin.TestMode)
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/v1/emails?sortBy=subject&sortOrder=asc&"+tt.query, nil)
+		api.router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected status 200, got %d", tt.query, w.Code)
+		}
+		var response struct {
+			Emails []*types.Email `json:"emails"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to parse response: %v", err)
+		}
+		var got []string
+		for _, email := range response.Emails {
+			got = append(got, email.ID)
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%s: got %v, want %v", tt.query, got, tt.want)
+		}
+	}
+
+	// The connection details are part of the envelope JSON
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v1/emails/implicit", nil)
+	api.router.ServeHTTP(w, req)
+	var email map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &email); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	envelope := email["envelope"].(map[string]interface{})
+	tlsInfo, ok := envelope["tls"].(map[string]interface{})
+	if !ok || tlsInfo["mode"] != "implicit" || tlsInfo["serverName"] != "smtp.example.com" {
+		t.Errorf("Unexpected TLS details: %v", envelope["tls"])
+	}
+	if envelope["sessionStart"] != "2026-03-01T12:00:00Z" || envelope["sessionEnd"] != "2026-03-01T12:00:01Z" {
+		t.Errorf("Unexpected session times: %v, %v", envelope["sessionStart"], envelope["sessionEnd"])
+	}
+}
+
+func TestAPIGetAllEmailsFilterByRejected(t *testing.T) {
+	api, server, _ := setupTestAPI(t)
+	defer func() {
+		if err := server.Close(); err != nil {
+			t.Errorf("Failed to close server: %v", err)
+		}
+	}()
+
+	envelopes := map[string]*types.Envelope{
+		"accepted": {From: "app@example.com"},
+		"oversize": {
+			From:     "big@example.com",
+			Rejected: &types.Rejection{Code: 552, Reason: "Maximum message size exceeded", Limit: 1048576, Captured: 2048},
+		},
+	}
+	for id, envelope := range envelopes {
+		email := &types.Email{ID: id, Subject: id, Time: time.Now()}
+		if err := server.SaveEmailToStore(id, false, envelope, email); err != nil {
+			t.Fatalf("Failed to save email: %v", err)
+		}
+	}
+
+	gin.SetMode(gin.TestMode)
+	for query, want := range map[string]string{
+		"rejected=true":  "oversize",
+		"rejected=false": "accepted",
+		"":               "accepted,oversize",
+	} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/v1/emails?sortBy=subject&sortOrder=asc&"+query, nil)
+		api.router.ServeHTTP(w, req)
+		var response struct {
+			Emails []*types.Email `json:"emails"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to parse response: %v", err)
+		}
+		var got []string
+		for _, email := range response.Emails {
+			got = append(got, email.ID)
+		}
+		if strings.Join(got, ",") != want {
+			t.Errorf("%q: got %v, want %s", query, got, want)
+		}
+		if query == "rejected=true" && len(response.Emails) == 1 {
+			if r := response.Emails[0].Envelope.Rejected; r == nil || r.Code != 552 || r.Captured != 2048 {
+				t.Errorf("Unexpected rejection: %+v", r)
+			}
+		}
+	}
+}
+
+func TestAPIGetAllEmailsFilterByHeader(t *testing.T) {
+	api, server, _ := setupTestAPI(t)
+	defer func() {
+		if err := server.Close(); err != nil {
+			t.Errorf("Failed to close server: %v", err)
+		}
+	}()
+
+	fields := map[string][]types.HeaderField{
+		"tracked": {
+			{Name: "X-Tracking-ID", Value: "order-42", Decoded: "order-42"},
+			{Name: "List-Id", Value: "=?utf-8?q?Caf=C3=A9?= <news.example.com>", Decoded: "Café <news.example.com>"},
+		},
+		"plain": {
+			{Name: "Subject", Value: "plain", Decoded: "plain"},
+		},
+	}
+	for id, list := range fields {
+		email := &types.Email{ID: id, Subject: id, Time: time.Now(), HeaderFields: list}
+		if err := server.SaveEmailToStore(id, false, &types.Envelope{}, email); err != nil {
+			t.Fatalf("Failed to save email: %v", err)
+		}
+	}
+
+	gin.SetMode(gin.TestMode)
+	for query, want := range map[string]string{
+		"header=x-tracking-id":                          "tracked",
+		"header=X-Tracking-ID:ORDER-42":                 "tracked",
+		"header=X-Tracking-ID:order-43":                 "",
+		"header=List-Id:caf%C3%A9&header=X-Tracking-ID": "tracked",
+		"header=List-Id:café&header=X-Other":            "",
+		"header=Subject":                                "plain",
+	} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/v1/emails?sortBy=subject&sortOrder=asc&"+query, nil)
+		api.router.ServeHTTP(w, req)
+		var response struct {
+			Emails []*types.Email `json:"emails"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to parse response: %v", err)
+		}
+		var got []string
+		for _, email := range response.Emails {
+			got = append(got, email.ID)
+		}
+		if strings.Join(got, ",") != want {
+			t.Errorf("%q: got %v, want %s", query, got, want)
+		}
+	}
+}
+
+func TestAPIGetAllEmailsFilterByWarnings(t *testing.T) {
+	api, server, _ := setupTestAPI(t)
+	defer func() {
+		if err := server.Close(); err != nil {
+			t.Errorf("Failed to close server: %v", err)
+		}
+	}()
+
+	warnings := map[string][]types.ParseWarning{
+		"broken": {{Code: "invalid_address", Header: "From", Message: "mail: missing @ in addr-spec"}},
+		"clean":  nil,
+	}
+	for id, list := range warnings {
+		email := &types.Email{ID: id, Subject: id, Time: time.Now(), Warnings: list}
+		if err := server.SaveEmailToStore(id, false, &types.Envelope{}, email); err != nil {
+			t.Fatalf("Failed to save email: %v", err)
+		}
+	}
+
+	gin.SetMode(gin.TestMode)
+	for query, want := range map[string]string{
+		"problems=true":           "broken",
+		"problems=false":          "clean",
+		"warning=invalid_address": "broken",
+		"warning=unknown_charset": "",
+	} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/v1/emails?sortBy=subject&sortOrder=asc&"+query, nil)
+		api.router.ServeHTTP(w, req)
+		var response struct {
+			Emails []*types.Email `json:"emails"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to parse response: %v", err)
+		}
+		var got []string
+		for _, email := range response.Emails {
+			got = append(got, email.ID)
+		}
+		if strings.Join(got, ",") != want {
+			t.Errorf("%q: got %v, want %s", query, got, want)
+		}
+		if query == "problems=true" && len(response.Emails) == 1 {
+			if w := response.Emails[0].Warnings; len(w) != 1 || w[0].Header != "From" {
+				t.Errorf("Expected the warnings in the response, got %+v", w)
+			}
+		}
+	}
+}
+
+func TestAPIGetEmailHTMLInlineImages(t *testing.T) {
+	api, server, tmpDir := setupTestAPI(t)
+	defer func() {
+		if err := server.Close(); err != nil {
+			t.Errorf("Failed to close server: %v", err)
+		}
+	}()
+
+	email := &types.Email{
+		ID:      "cid-id",
+		Subject: "Inline",
+		HTML:    `<p><img src="cid:logo@example.com" alt="logo"></p>`,
+		Time:    time.Now(),
+		Attachments: []*types.Attachment{
+			{ContentType: "image/png", FileName: "logo.png", GeneratedFileName: "logo.png", ContentID: "logo@example.com"},
+		},
+	}
+	if err := os.MkdirAll(filepath.Join(tmpDir, "cid-id"), 0755); err != nil {
+		t.Fatalf("Failed to create attachment directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "cid-id", "logo.png"), []byte("PNG"), 0644); err != nil {
+		t.Fatalf("Failed to create attachment file: %v", err)
+	}
+	if err := server.SaveEmailToStore("cid-id", false, &types.Envelope{}, email); err != nil {
+		t.Fatalf("Failed to save email: %v", err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	for query, want := range map[string]string{
+		"":            `src="/api/v1/emails/cid-id/attachments/logo.png"`,
+		"?embed=true": `src="data:image/png;base64,UE5H"`,
+	} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/v1/emails/cid-id/html"+query, nil)
+		api.router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), want) {
+			t.Errorf("%q: expected %s, got %d %s", query, want, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestAPIEmailsMailboxBulkScope(t *testing.T) {
+	api, server, _ := setupTestAPI(t)
+	defer func() {
+		if err := server.Close(); err != nil {
+			t.Errorf("Failed to close server: %v", err)
+		}
+	}()
+
+	for id, user := range map[string]string{"a1": "alice", "a2": "alice", "b1": "bob", "b2": "bob"} {
+		if err := server.SaveEmailToStore(id, false, &types.Envelope{User: user}, &types.Email{Subject: "For " + user}); err != nil {
+			t.Fatalf("Failed to save email %s: %v", id, err)
+		}
+	}
+
+	gin.SetMode(gin.TestMode)
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		api.router.ServeHTTP(w, req)
+		return w
+	}
+	unread := func(mailbox string) int {
+		count := 0
+		for _, email := range server.GetAllEmailInMailbox(mailbox) {
+			if !email.Read {
+				count++
+			}
+		}
+		return count
+	}
+
+	// Batch routes skip emails outside the mailbox
+	w := serve("PATCH", "/api/v1/emails/batch/read?mailbox=alice", `{"ids": ["a1", "b1"]}`)
+	var result map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if result["success"] != float64(1) || result["failed"] != float64(1) {
+		t.Errorf("Expected only Alice's email to be marked read, got %v", result)
+	}
+	if unread("bob") != 2 {
+		t.Error("Expected Bob's emails to stay unread")
+	}
+	serve("DELETE", "/api/v1/emails/batch?mailbox=alice", `{"ids": ["a1", "b1"]}`)
+	if len(server.GetAllEmailInMailbox("bob")) != 2 {
+		t.Error("Expected Bob's emails to be kept")
+	}
+
+	// Read-all and delete-all only act on the mailbox
+	serve("PATCH", "/email/read-all?mailbox=alice", "")
+	if unread("alice") != 0 || unread("bob") != 2 {
+		t.Errorf("Unexpected unread counts: alice %d, bob %d", unread("alice"), unread("bob"))
+	}
+	if w := serve("DELETE", "/api/v1/emails?mailbox=alice", ""); w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	if got := len(server.GetAllEmail()); got != 2 || len(server.GetAllEmailInMailbox("bob")) != 2 {
+		t.Errorf("Expected only Bob's 2 emails to remain, got %d emails", got)
+	}
+}
@@ -0,0 +1,78 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/soulteary/owlmail/internal/mailserver"
+)
+
+// getGreylist handles GET /api/v1/greylist
+func (api *API) getGreylist(c *gin.Context) {
+	config := api.mailServer.GetGreylistConfig()
+	entries := api.mailServer.GetGreylistEntries()
+	response := greylistConfigResponse(config)
+	response["count"] = len(entries)
+	response["entries"] = entries
+	c.JSON(http.StatusOK, response)
+}
+
+// clearGreylist handles DELETE /api/v1/greylist
+func (api *API) clearGreylist(c *gin.Context) {
+	api.mailServer.ClearGreylist()
+	c.JSON(http.StatusOK, SuccessResponse(SuccessCodeGreylistCleared, "Greylist cleared", nil))
+}
+
+// updateGreylistConfig handles PUT /api/v1/settings/greylist
+func (api *API) updateGreylistConfig(c *gin.Context) {
+	var request struct {
+		Enabled bool   `json:"enabled"`
+		Delay   string `json:"delay"`
+		Expiry  string `json:"expiry"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse(ErrorCodeInvalidRequest, "Invalid request: "+err.Error()))
+		return
+	}
+
+	// Omitted durations keep their current values
+	config := api.mailServer.GetGreylistConfig()
+	config.Enabled = request.Enabled
+	if request.Delay != "" {
+		delay, err := time.ParseDuration(request.Delay)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse(ErrorCodeInvalidGreylistConfig, "Invalid delay: "+err.Error()))
+			return
+		}
+		config.Delay = delay
+	}
+	if request.Expiry != "" {
+		expiry, err := time.ParseDuration(request.Expiry)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse(ErrorCodeInvalidGreylistConfig, "Invalid expiry: "+err.Error()))
+			return
+		}
+		config.Expiry = expiry
+	}
+
+	if err := api.mailServer.SetGreylistConfig(config); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse(ErrorCodeInvalidGreylistConfig, err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    SuccessCodeConfigUpdated,
+		"message": "Greylisting configuration updated",
+		"config":  greylistConfigResponse(config),
+	})
+}
+
+// greylistConfigResponse converts a greylist configuration to its JSON form
+func greylistConfigResponse(config mailserver.GreylistConfig) gin.H {
+	return gin.H{
+		"enabled": config.Enabled,
+		"delay":   config.Delay.String(),
+		"expiry":  config.Expiry.String(),
+	}
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAPIGreylist(t *testing.T) {
+	api, server, _ := setupTestAPI(t)
+	defer func() {
+		if err := server.Close(); err != nil {
+			t.Errorf("Failed to close server: %v", err)
+		}
+	}()
+
+	gin.SetMode(gin.TestMode)
+
+	// Enable greylisting with a custom delay
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("PUT", "/api/v1/settings/greylist", bytes.NewBufferString(`{"enabled":true,"delay":"2m"}`))
+	req.Header.Set("Content-Type", "application/json")
+	api.router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	config := server.GetGreylistConfig()
+	if !config.Enabled || config.Delay != 2*time.Minute || config.Expiry == 0 {
+		t.Errorf("Unexpected greylist config: %+v", config)
+	}
+
+	// Invalid durations are rejected
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("PUT", "/api/v1/settings/greylist", bytes.NewBufferString(`{"enabled":true,"delay":"later"}`))
+	req.Header.Set("Content-Type", "application/json")
+	api.router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", w.Code)
+	}
+
+	// The triplet table is empty until SMTP clients connect
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/v1/greylist", nil)
+	api.router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	var response map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if response["enabled"] != true || response["delay"] != "2m0s" || response["count"] != float64(0) {
+		t.Errorf("Unexpected response: %v", response)
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("DELETE", "/api/v1/greylist", nil)
+	api.router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", w.Code)
+	}
+}
@@ -0,0 +1,15 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// getIngest handles GET /api/v1/ingest
+func (api *API) getIngest(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"config": api.mailServer.GetIngestConfig(),
+		"stats":  api.mailServer.GetIngestStats(),
+	})
+}
@@ -0,0 +1,45 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/soulteary/owlmail/internal/mailserver"
+)
+
+func TestAPIGetIngest(t *testing.T) {
+	api, server, _ := setupTestAPI(t)
+	defer func() {
+		if err := server.Close(); err != nil {
+			t.Errorf("Failed to close server: %v", err)
+		}
+	}()
+
+	gin.SetMode(gin.TestMode)
+	if err := server.SetIngestConfig(mailserver.IngestConfig{Workers: 2, QueueSize: 10}); err != nil {
+		t.Fatalf("SetIngestConfig() error = %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v1/ingest", nil)
+	api.router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	var response struct {
+		Config mailserver.IngestConfig `json:"config"`
+		Stats  mailserver.IngestStats  `json:"stats"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if response.Config.Workers != 2 || response.Config.QueueSize != 10 {
+		t.Errorf("Unexpected config: %+v", response.Config)
+	}
+	if response.Stats != (mailserver.IngestStats{}) {
+		t.Errorf("Expected empty stats, got %+v", response.Stats)
+	}
+}
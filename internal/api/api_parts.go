@@ -0,0 +1,54 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// getEmailParts handles GET /api/v1/emails/:id/parts
+func (api *API) getEmailParts(c *gin.Context) {
+	id := c.Param("id")
+
+	root, err := api.mailServer.GetEmailParts(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse(ErrorCodeEmailNotFound, err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"id":    id,
+		"parts": root,
+	})
+}
+
+// getEmailPart handles GET /api/v1/emails/:id/parts/:path
+// The body is decoded by default; use ?format=raw for the header and body as transmitted.
+func (api *API) getEmailPart(c *gin.Context) {
+	id := c.Param("id")
+	path := c.Param("path")
+
+	root, err := api.mailServer.GetEmailParts(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse(ErrorCodeEmailNotFound, err.Error()))
+		return
+	}
+	part := root.Find(path)
+	if part == nil {
+		c.JSON(http.StatusNotFound, ErrorResponse(ErrorCodePartNotFound, "Part not found"))
+		return
+	}
+
+	if c.Query("format") == "raw" {
+		c.Data(http.StatusOK, "text/plain; charset=utf-8", part.Raw())
+		return
+	}
+
+	if part.FileName != "" {
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", sanitizeFilename(part.FileName)))
+	}
+	// Serve what can be decoded; the problem is listed in the part warnings
+	decoded, _ := part.Decoded()
+	c.Data(http.StatusOK, part.DecodedContentType(), decoded)
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/soulteary/owlmail/internal/mailserver"
+	"github.com/soulteary/owlmail/internal/types"
+)
+
+func TestAPIGetEmailParts(t *testing.T) {
+	api, server, tmpDir := setupTestAPI(t)
+	defer func() {
+		if err := server.Close(); err != nil {
+			t.Errorf("Failed to close server: %v", err)
+		}
+	}()
+
+	content := "Subject: Parts\r\n" +
+		"Content-Type: multipart/mixed; boundary=B\r\n" +
+		"\r\n" +
+		"--B\r\n" +
+		"Content-Type: text/plain; charset=iso-8859-1\r\n" +
+		"Content-Transfer-Encoding: quoted-printable\r\n" +
+		"\r\n" +
+		"Caf=E9\r\n" +
+		"--B\r\n" +
+		"Content-Type: application/octet-stream; name=data.bin\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		"AAEC\r\n" +
+		"--B--\r\n"
+	if err := os.WriteFile(filepath.Join(tmpDir, "parts-id.eml"), []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to create email file: %v", err)
+	}
+	envelope := &types.Envelope{From: "from@example.com", To: []string{"to@example.com"}}
+	if err := server.SaveEmailToStore("parts-id", false, envelope, &types.Email{ID: "parts-id", Subject: "Parts"}); err != nil {
+		t.Fatalf("Failed to save email: %v", err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	get := func(url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", url, nil)
+		api.router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("/api/v1/emails/parts-id/parts")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	var response struct {
+		Parts mailserver.MIMEPart `json:"parts"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	root := response.Parts
+	if root.Path != "0" || root.ContentType != "multipart/mixed" || len(root.Parts) != 2 {
+		t.Fatalf("Unexpected tree: %+v", root)
+	}
+	if text := root.Parts[0]; text.Charset != "iso-8859-1" || text.TransferEncoding != "quoted-printable" || len(text.Headers) != 2 {
+		t.Errorf("Unexpected text part: %+v", text)
+	}
+
+	w = get("/api/v1/emails/parts-id/parts/1")
+	if w.Code != http.StatusOK || w.Body.String() != "Café" || w.Header().Get("Content-Type") != "text/plain; charset=utf-8" {
+		t.Errorf("Unexpected decoded text part: %d %q %s", w.Code, w.Body.String(), w.Header().Get("Content-Type"))
+	}
+	w = get("/api/v1/emails/parts-id/parts/2")
+	if w.Body.String() != "\x00\x01\x02" || !strings.Contains(w.Header().Get("Content-Disposition"), "data.bin") {
+		t.Errorf("Unexpected decoded attachment: %q %s", w.Body.String(), w.Header().Get("Content-Disposition"))
+	}
+	w = get("/api/v1/emails/parts-id/parts/2?format=raw")
+	if !strings.HasPrefix(w.Body.String(), "Content-Type: application/octet-stream; name=data.bin\r\n") || !strings.HasSuffix(w.Body.String(), "AAEC") {
+		t.Errorf("Unexpected raw part %q", w.Body.String())
+	}
+
+	if w = get("/api/v1/emails/parts-id/parts/3"); w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), ErrorCodePartNotFound) {
+		t.Errorf("Expected 404 for an unknown part, got %d %s", w.Code, w.Body.String())
+	}
+	if w = get("/api/v1/emails/missing/parts"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown email, got %d", w.Code)
+	}
+}
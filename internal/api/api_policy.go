@@ -0,0 +1,33 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/soulteary/owlmail/internal/mailserver"
+)
+
+// getAccessPolicy handles GET /api/v1/settings/policy
+func (api *API) getAccessPolicy(c *gin.Context) {
+	c.JSON(http.StatusOK, api.mailServer.GetAccessPolicy())
+}
+
+// updateAccessPolicy handles PUT /api/v1/settings/policy
+func (api *API) updateAccessPolicy(c *gin.Context) {
+	var policy mailserver.AccessPolicy
+	if err := c.ShouldBindJSON(&policy); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse(ErrorCodeInvalidRequest, "Invalid request: "+err.Error()))
+		return
+	}
+
+	if err := api.mailServer.SetAccessPolicy(policy); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse(ErrorCodeInvalidAccessPolicy, err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    SuccessCodeConfigUpdated,
+		"message": "Access policy updated",
+		"policy":  api.mailServer.GetAccessPolicy(),
+	})
+}
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/soulteary/owlmail/internal/mailserver"
+)
+
+func TestAPIAccessPolicy(t *testing.T) {
+	api, server, _ := setupTestAPI(t)
+	defer func() {
+		if err := server.Close(); err != nil {
+			t.Errorf("Failed to close server: %v", err)
+		}
+	}()
+
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("PUT", "/api/v1/settings/policy", bytes.NewBufferString(`{"allowNetworks":["10.0.0.0/8"],"acceptedDomains":["example.com"]}`))
+	req.Header.Set("Content-Type", "application/json")
+	api.router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Invalid networks are rejected and keep the current policy
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("PUT", "/api/v1/settings/policy", bytes.NewBufferString(`{"denyNetworks":["nowhere"]}`))
+	req.Header.Set("Content-Type", "application/json")
+	api.router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", w.Code)
+	}
+	if !bytes.Contains(w.Body.Bytes(), []byte(ErrorCodeInvalidAccessPolicy)) {
+		t.Errorf("Expected %s error code, got %s", ErrorCodeInvalidAccessPolicy, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/v1/settings/policy", nil)
+	api.router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	var policy mailserver.AccessPolicy
+	if err := json.Unmarshal(w.Body.Bytes(), &policy); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if len(policy.AllowNetworks) != 1 || policy.AllowNetworks[0] != "10.0.0.0/8" ||
+		len(policy.AcceptedDomains) != 1 || policy.AcceptedDomains[0] != "example.com" {
+		t.Errorf("Unexpected policy: %+v", policy)
+	}
+}
@@ -0,0 +1,36 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/soulteary/owlmail/internal/mailserver"
+)
+
+// getRateLimits handles GET /api/v1/ratelimits
+func (api *API) getRateLimits(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"config":   api.mailServer.GetRateLimitConfig(),
+		"counters": api.mailServer.GetRateLimitStats(),
+	})
+}
+
+// updateRateLimits handles PUT /api/v1/settings/ratelimits
+func (api *API) updateRateLimits(c *gin.Context) {
+	var config mailserver.RateLimitConfig
+	if err := c.ShouldBindJSON(&config); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse(ErrorCodeInvalidRequest, "Invalid request: "+err.Error()))
+		return
+	}
+
+	if err := api.mailServer.SetRateLimitConfig(config); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse(ErrorCodeInvalidRateLimits, err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    SuccessCodeConfigUpdated,
+		"message": "Rate limits updated",
+		"config":  config,
+	})
+}
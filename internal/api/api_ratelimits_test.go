@@ -0,0 +1,66 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAPIRateLimits(t *testing.T) {
+	api, server, _ := setupTestAPI(t)
+	defer func() {
+		if err := server.Close(); err != nil {
+			t.Errorf("Failed to close server: %v", err)
+		}
+	}()
+
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("PUT", "/api/v1/settings/ratelimits", bytes.NewBufferString(`{"maxConnectionsPerIP":3,"messagesPerMinutePerUser":10}`))
+	req.Header.Set("Content-Type", "application/json")
+	api.router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	config := server.GetRateLimitConfig()
+	if config.MaxConnectionsPerIP != 3 || config.MessagesPerMinutePerUser != 10 || config.MaxConnections != 0 {
+		t.Errorf("Unexpected rate limit config: %+v", config)
+	}
+
+	// Negative limits are rejected
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("PUT", "/api/v1/settings/ratelimits", bytes.NewBufferString(`{"messagesPerMinute":-1}`))
+	req.Header.Set("Content-Type", "application/json")
+	api.router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", w.Code)
+	}
+	if !bytes.Contains(w.Body.Bytes(), []byte(ErrorCodeInvalidRateLimits)) {
+		t.Errorf("Expected %s error code, got %s", ErrorCodeInvalidRateLimits, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/v1/ratelimits", nil)
+	api.router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	var response struct {
+		Config   map[string]float64     `json:"config"`
+		Counters map[string]interface{} `json:"counters"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if response.Config["maxConnectionsPerIP"] != 3 {
+		t.Errorf("Unexpected config: %v", response.Config)
+	}
+	if response.Counters["connections"] != float64(0) || response.Counters["rejectedMessages"] != float64(0) {
+		t.Errorf("Unexpected counters: %v", response.Counters)
+	}
+}
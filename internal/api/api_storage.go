@@ -0,0 +1,33 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/soulteary/owlmail/internal/mailserver"
+)
+
+// getStorageConfig handles GET /api/v1/settings/storage
+func (api *API) getStorageConfig(c *gin.Context) {
+	c.JSON(http.StatusOK, api.mailServer.GetStorageConfig())
+}
+
+// updateStorageConfig handles PUT /api/v1/settings/storage
+func (api *API) updateStorageConfig(c *gin.Context) {
+	var config mailserver.StorageConfig
+	if err := c.ShouldBindJSON(&config); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse(ErrorCodeInvalidRequest, "Invalid request: "+err.Error()))
+		return
+	}
+
+	if err := api.mailServer.SetStorageConfig(config); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse(ErrorCodeInvalidStorageConfig, err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    SuccessCodeConfigUpdated,
+		"message": "Storage mode updated",
+		"config":  config,
+	})
+}
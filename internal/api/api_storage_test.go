@@ -0,0 +1,81 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/soulteary/owlmail/internal/mailserver"
+)
+
+func TestAPIStorageConfig(t *testing.T) {
+	api, server, _ := setupTestAPI(t)
+	defer func() {
+		if err := server.Close(); err != nil {
+			t.Errorf("Failed to close server: %v", err)
+		}
+	}()
+
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("PUT", "/api/v1/settings/storage", bytes.NewBufferString(`{"mode":"sample","sampleEvery":100,"matchFrom":"*@example.com"}`))
+	req.Header.Set("Content-Type", "application/json")
+	api.router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	want := mailserver.StorageConfig{Mode: "sample", SampleEvery: 100, MatchFrom: "*@example.com"}
+	if got := server.GetStorageConfig(); got != want {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("PUT", "/api/v1/settings/storage", bytes.NewBufferString(`{"mode":"tape"}`))
+	req.Header.Set("Content-Type", "application/json")
+	api.router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", w.Code)
+	}
+	if !bytes.Contains(w.Body.Bytes(), []byte(ErrorCodeInvalidStorageConfig)) {
+		t.Errorf("Expected %s error code, got %s", ErrorCodeInvalidStorageConfig, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/v1/settings/storage", nil)
+	api.router.ServeHTTP(w, req)
+	var config mailserver.StorageConfig
+	if err := json.Unmarshal(w.Body.Bytes(), &config); err != nil || config != want {
+		t.Errorf("Expected %+v, got %s", want, w.Body.String())
+	}
+}
+
+func TestAPIEmailStatsReceived(t *testing.T) {
+	api, server, _ := setupTestAPI(t)
+	defer func() {
+		if err := server.Close(); err != nil {
+			t.Errorf("Failed to close server: %v", err)
+		}
+	}()
+
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v1/emails/stats", nil)
+	api.router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	var response struct {
+		Received mailserver.StorageStats `json:"received"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if response.Received.Messages != 0 || response.Received.Senders == nil {
+		t.Errorf("Expected empty received counters, got %+v", response.Received)
+	}
+}
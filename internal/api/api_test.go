@@ -303,3 +303,51 @@ func TestAPIStart(t *testing.T) {
 		t.Error("Expected error when key file is empty")
 	}
 }
+
+func TestAPILocalCA(t *testing.T) {
+	api, server, _ := setupTestAPI(t)
+	defer func() {
+		_ = server.Close()
+	}()
+
+	gin.SetMode(gin.TestMode)
+
+	// Without local CA mode there is no certificate to serve or use
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v1/ca.pem", nil)
+	api.router.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", w.Code)
+	}
+	if _, err := NewAPIWithHTTPS(server, 0, "localhost", "", "", true, "", "").httpsTLSConfig(); err == nil {
+		t.Error("Expected error without certificate files or local CA")
+	}
+
+	caServer, err := mailserver.NewMailServerWithConfig(1025, "localhost", t.TempDir(), nil, nil, &mailserver.TLSConfig{LocalCA: true})
+	if err != nil {
+		t.Fatalf("Failed to create mail server: %v", err)
+	}
+	defer func() {
+		_ = caServer.Close()
+	}()
+
+	// The CA certificate is served without HTTP Basic Auth
+	caAPI := NewAPIWithHTTPS(caServer, 0, "localhost", "user", "pass", true, "", "")
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/v1/ca.pem", nil)
+	caAPI.router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	if w.Body.String() != string(caServer.GetLocalCA().CertPEM()) {
+		t.Error("Expected the local CA certificate")
+	}
+
+	tlsConfig, err := caAPI.httpsTLSConfig()
+	if err != nil {
+		t.Fatalf("httpsTLSConfig failed: %v", err)
+	}
+	if len(tlsConfig.Certificates) != 1 || tlsConfig.Certificates[0].Leaf.VerifyHostname("localhost") != nil {
+		t.Error("Expected a certificate for localhost issued by the local CA")
+	}
+}
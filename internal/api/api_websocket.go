@@ -2,13 +2,35 @@ package api
 
 import (
 	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
 	"github.com/soulteary/owlmail/internal/common"
 )
 
+// wsWriteTimeout bounds each write to a WebSocket client, so that a client
+// that stopped reading cannot hold up the broadcast to the others
+const wsWriteTimeout = 10 * time.Second
+
+// wsClient holds the state of a connected WebSocket client
+type wsClient struct {
+	writeMutex sync.Mutex
+	mailbox    string // Mailbox the viewer is scoped to; empty means all mailboxes
+}
+
+// writeJSON writes a message to the client connection within wsWriteTimeout
+func (client *wsClient) writeJSON(conn *websocket.Conn, message interface{}) error {
+	client.writeMutex.Lock()
+	defer client.writeMutex.Unlock()
+	if err := conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout)); err != nil {
+		return err
+	}
+	return conn.WriteJSON(message)
+}
+
 // handleWebSocket handles WebSocket connections
+// The optional "mailbox" query parameter scopes the connection to a single mailbox
 func (api *API) handleWebSocket(c *gin.Context) {
 	conn, err := api.wsUpgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
@@ -21,12 +43,12 @@ func (api *API) handleWebSocket(c *gin.Context) {
 		}
 	}()
 
-	// Create a write mutex for this connection
-	writeMutex := &sync.Mutex{}
+	// Create client state for this connection
+	client := &wsClient{mailbox: c.Query("mailbox")}
 
 	// Add client
 	api.wsClientsLock.Lock()
-	api.wsClients[conn] = writeMutex
+	api.wsClients[conn] = client
 	api.wsClientsLock.Unlock()
 
 	// Remove client on disconnect
@@ -37,12 +59,10 @@ func (api *API) handleWebSocket(c *gin.Context) {
 	}()
 
 	// Send initial connection message
-	writeMutex.Lock()
-	err = conn.WriteJSON(gin.H{
+	err = client.writeJSON(conn, gin.H{
 		"type":    "connected",
 		"message": "WebSocket connection established",
 	})
-	writeMutex.Unlock()
 	if err != nil {
 		common.Verbose("Failed to send WebSocket connection message: %v", err)
 		return
@@ -60,10 +80,7 @@ func (api *API) handleWebSocket(c *gin.Context) {
 
 		// Handle ping/pong
 		if msgType, ok := msg["type"].(string); ok && msgType == "ping" {
-			writeMutex.Lock()
-			err = conn.WriteJSON(gin.H{"type": "pong"})
-			writeMutex.Unlock()
-			if err != nil {
+			if err := client.writeJSON(conn, gin.H{"type": "pong"}); err != nil {
 				common.Verbose("Failed to send WebSocket pong: %v", err)
 				break
 			}
@@ -73,23 +90,34 @@ func (api *API) handleWebSocket(c *gin.Context) {
 
 // broadcastMessage broadcasts a message to all connected WebSocket clients
 func (api *API) broadcastMessage(message interface{}) {
+	api.broadcast(message, nil)
+}
+
+// broadcastMailboxMessage broadcasts a message to clients that can see the given mailbox
+func (api *API) broadcastMailboxMessage(mailbox string, message interface{}) {
+	api.broadcast(message, func(client *wsClient) bool {
+		return client.mailbox == "" || client.mailbox == mailbox
+	})
+}
+
+// broadcast writes a message to every connected client accepted by include (all clients if nil)
+func (api *API) broadcast(message interface{}, include func(*wsClient) bool) {
 	// Collect failed connections to remove after releasing read lock
 	var failedConns []*websocket.Conn
 
 	api.wsClientsLock.RLock()
-	// Create a snapshot of connections and their mutexes
-	conns := make(map[*websocket.Conn]*sync.Mutex, len(api.wsClients))
-	for conn, writeMutex := range api.wsClients {
-		conns[conn] = writeMutex
+	// Create a snapshot of connections and their client state
+	conns := make(map[*websocket.Conn]*wsClient, len(api.wsClients))
+	for conn, client := range api.wsClients {
+		if include == nil || include(client) {
+			conns[conn] = client
+		}
 	}
 	api.wsClientsLock.RUnlock()
 
 	// Write to each connection using its own mutex
-	for conn, writeMutex := range conns {
-		writeMutex.Lock()
-		err := conn.WriteJSON(message)
-		writeMutex.Unlock()
-		if err != nil {
+	for conn, client := range conns {
+		if err := client.writeJSON(conn, message); err != nil {
 			common.Verbose("WebSocket write error: %v", err)
 			// Collect failed client for removal
 			failedConns = append(failedConns, conn)
@@ -100,11 +128,11 @@ func (api *API) broadcastMessage(message interface{}) {
 	if len(failedConns) > 0 {
 		api.wsClientsLock.Lock()
 		for _, conn := range failedConns {
-			if writeMutex, exists := api.wsClients[conn]; exists {
+			if client, exists := api.wsClients[conn]; exists {
 				// Lock the connection's mutex before closing to ensure no concurrent writes
-				writeMutex.Lock()
+				client.writeMutex.Lock()
 				delete(api.wsClients, conn)
-				writeMutex.Unlock()
+				client.writeMutex.Unlock()
 				if err := conn.Close(); err != nil {
 					common.Verbose("Failed to close WebSocket connection: %v", err)
 				}
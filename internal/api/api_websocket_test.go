@@ -649,3 +649,62 @@ func TestAPIHandleWebSocketInitialMessageError(t *testing.T) {
 		t.Errorf("Expected 0 clients after close, got %d", clientCount)
 	}
 }
+
+func TestAPIBroadcastMailboxMessage(t *testing.T) {
+	api, server, _ := setupTestAPI(t)
+	defer func() {
+		if err := server.Close(); err != nil {
+			t.Errorf("Failed to close server: %v", err)
+		}
+	}()
+
+	srv := httptest.NewServer(api.router)
+	defer srv.Close()
+
+	wsURL := "ws" + srv.URL[4:] + "/api/v1/ws"
+
+	dial := func(url string) *websocket.Conn {
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		var msg map[string]interface{}
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("Failed to read initial message: %v", err)
+		}
+		return conn
+	}
+
+	aliceConn := dial(wsURL + "?mailbox=alice")
+	defer func() {
+		_ = aliceConn.Close()
+	}()
+	allConn := dial(wsURL)
+	defer func() {
+		_ = allConn.Close()
+	}()
+
+	api.broadcastMailboxMessage("bob", gin.H{"type": "new", "mailbox": "bob"})
+	api.broadcastMailboxMessage("alice", gin.H{"type": "new", "mailbox": "alice"})
+
+	// The scoped viewer only receives mail for its own mailbox
+	var msg map[string]interface{}
+	_ = aliceConn.SetReadDeadline(time.Now().Add(1 * time.Second))
+	if err := aliceConn.ReadJSON(&msg); err != nil {
+		t.Fatalf("Scoped client failed to read broadcast: %v", err)
+	}
+	if msg["mailbox"] != "alice" {
+		t.Errorf("Scoped client: expected mailbox 'alice', got %v", msg["mailbox"])
+	}
+
+	// The unscoped viewer receives everything
+	for _, want := range []string{"bob", "alice"} {
+		_ = allConn.SetReadDeadline(time.Now().Add(1 * time.Second))
+		if err := allConn.ReadJSON(&msg); err != nil {
+			t.Fatalf("Unscoped client failed to read broadcast: %v", err)
+		}
+		if msg["mailbox"] != want {
+			t.Errorf("Unscoped client: expected mailbox %q, got %v", want, msg["mailbox"])
+		}
+	}
+}
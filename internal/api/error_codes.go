@@ -10,13 +10,22 @@ const (
 	ErrorCodeNoEmailsToExport   = "NO_EMAILS_TO_EXPORT"
 	ErrorCodeInvalidEmailID     = "INVALID_EMAIL_ID"
 	ErrorCodeNoEmailIDsProvided = "NO_EMAIL_IDS_PROVIDED"
+	ErrorCodePartNotFound       = "PART_NOT_FOUND"
 
 	// Request errors
-	ErrorCodeInvalidRequest      = "INVALID_REQUEST"
-	ErrorCodeInvalidEmailAddress = "INVALID_EMAIL_ADDRESS"
-	ErrorCodeHostRequired        = "HOST_REQUIRED"
-	ErrorCodePortOutOfRange      = "PORT_OUT_OF_RANGE"
-	ErrorCodeInvalidPort         = "INVALID_PORT"
+	ErrorCodeInvalidRequest        = "INVALID_REQUEST"
+	ErrorCodeInvalidEmailAddress   = "INVALID_EMAIL_ADDRESS"
+	ErrorCodeHostRequired          = "HOST_REQUIRED"
+	ErrorCodePortOutOfRange        = "PORT_OUT_OF_RANGE"
+	ErrorCodeInvalidPort           = "INVALID_PORT"
+	ErrorCodeInvalidFaultRule      = "INVALID_FAULT_RULE"
+	ErrorCodeInvalidGreylistConfig = "INVALID_GREYLIST_CONFIG"
+	ErrorCodeInvalidRateLimits     = "INVALID_RATE_LIMITS"
+	ErrorCodeInvalidAccessPolicy   = "INVALID_ACCESS_POLICY"
+	ErrorCodeInvalidStorageConfig  = "INVALID_STORAGE_CONFIG"
+
+	// TLS errors
+	ErrorCodeLocalCADisabled = "LOCAL_CA_DISABLED"
 
 	// Relay errors
 	ErrorCodeRelayFailed = "RELAY_FAILED"
@@ -31,6 +40,7 @@ const (
 	SuccessCodeBatchDeleteCompleted = "BATCH_DELETE_COMPLETED"
 	SuccessCodeBatchReadCompleted   = "BATCH_READ_COMPLETED"
 	SuccessCodeConfigUpdated        = "CONFIG_UPDATED"
+	SuccessCodeGreylistCleared      = "GREYLIST_CLEARED"
 )
 
 // APIResponse represents a standardized API response
@@ -0,0 +1,254 @@
+package mailserver
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-sasl"
+	"github.com/emersion/go-smtp"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Supported SASL mechanisms
+const (
+	authMechPlain   = sasl.Plain
+	authMechLogin   = "LOGIN"
+	authMechCramMD5 = "CRAM-MD5"
+)
+
+// errAuthRequired is returned when a client sends MAIL FROM without authenticating
+var errAuthRequired = &smtp.SMTPError{
+	Code:         530,
+	EnhancedCode: smtp.EnhancedCode{5, 7, 0},
+	Message:      "Authentication required",
+}
+
+// LoadCredentialsFile loads SMTP credentials from an htpasswd-style file.
+// Each non-empty line has the form "username:password", where password is either
+// a bcrypt hash ($2a$, $2b$ or $2y$) or a plaintext password. Lines starting
+// with '#' are ignored. The username DefaultMailbox is reserved, as usernames
+// name mailboxes.
+func LoadCredentialsFile(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open credentials file: %w", err)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	users := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		username, secret, ok := strings.Cut(line, ":")
+		if !ok || username == "" || secret == "" {
+			return nil, fmt.Errorf("invalid credentials entry on line %d", lineNum)
+		}
+		if username == DefaultMailbox {
+			return nil, fmt.Errorf("username %q on line %d is reserved for unauthenticated mail", username, lineNum)
+		}
+		users[username] = secret
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read credentials file: %w", err)
+	}
+	return users, nil
+}
+
+// LoadUsersFile loads the credentials file configured in UsersFile
+func (c *SMTPAuthConfig) LoadUsersFile() error {
+	if c.UsersFile == "" {
+		return nil
+	}
+	users, err := LoadCredentialsFile(c.UsersFile)
+	if err != nil {
+		return err
+	}
+	c.users = users
+	return nil
+}
+
+// UserCount returns the number of configured SMTP users
+func (c *SMTPAuthConfig) UserCount() int {
+	count := len(c.users)
+	if c.Username != "" {
+		if _, exists := c.users[c.Username]; !exists {
+			count++
+		}
+	}
+	return count
+}
+
+// isBcryptHash reports whether secret looks like a bcrypt hash
+func isBcryptHash(secret string) bool {
+	return strings.HasPrefix(secret, "$2a$") || strings.HasPrefix(secret, "$2b$") || strings.HasPrefix(secret, "$2y$")
+}
+
+// Verify checks a username and password against the configured credentials
+func (c *SMTPAuthConfig) Verify(username, password string) bool {
+	if c.Username != "" && subtle.ConstantTimeCompare([]byte(username), []byte(c.Username)) == 1 {
+		return subtle.ConstantTimeCompare([]byte(password), []byte(c.Password)) == 1
+	}
+	secret, ok := c.users[username]
+	if !ok {
+		return false
+	}
+	if isBcryptHash(secret) {
+		return bcrypt.CompareHashAndPassword([]byte(secret), []byte(password)) == nil
+	}
+	return subtle.ConstantTimeCompare([]byte(password), []byte(secret)) == 1
+}
+
+// plaintextPassword returns the plaintext password for a user.
+// CRAM-MD5 needs the shared secret, so users stored as bcrypt hashes cannot use it.
+func (c *SMTPAuthConfig) plaintextPassword(username string) (string, bool) {
+	if c.Username != "" && username == c.Username {
+		return c.Password, true
+	}
+	secret, ok := c.users[username]
+	if !ok || isBcryptHash(secret) {
+		return "", false
+	}
+	return secret, true
+}
+
+// loginServer implements the server side of the LOGIN SASL mechanism
+type loginServer struct {
+	authenticate func(username, password string) error
+	username     string
+	step         int
+}
+
+// Next implements sasl.Server
+func (a *loginServer) Next(response []byte) (challenge []byte, done bool, err error) {
+	switch a.step {
+	case 0:
+		a.step++
+		if response == nil {
+			return []byte("Username:"), false, nil
+		}
+		// Initial response carries the username
+		a.username = string(response)
+		a.step++
+		return []byte("Password:"), false, nil
+	case 1:
+		a.username = string(response)
+		a.step++
+		return []byte("Password:"), false, nil
+	case 2:
+		a.step++
+		return nil, true, a.authenticate(a.username, string(response))
+	default:
+		return nil, true, errors.New("unexpected client response")
+	}
+}
+
+// cramMD5Server implements the server side of the CRAM-MD5 SASL mechanism (RFC 2195)
+type cramMD5Server struct {
+	authenticate func(username, digest string) error
+	challenge    string
+	sent         bool
+}
+
+// Next implements sasl.Server
+func (a *cramMD5Server) Next(response []byte) (challenge []byte, done bool, err error) {
+	if !a.sent {
+		a.sent = true
+		return []byte(a.challenge), false, nil
+	}
+	username, digest, ok := strings.Cut(string(response), " ")
+	if !ok {
+		return nil, true, smtp.ErrAuthFailed
+	}
+	return nil, true, a.authenticate(username, digest)
+}
+
+// cramMD5Digest computes the expected CRAM-MD5 digest for a challenge
+func cramMD5Digest(password, challenge string) string {
+	mac := hmac.New(md5.New, []byte(password))
+	mac.Write([]byte(challenge))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// newCramMD5Challenge builds a unique CRAM-MD5 challenge string
+func newCramMD5Challenge(domain string) string {
+	return fmt.Sprintf("<%s.%d@%s>", makeID(false), time.Now().UnixNano(), domain)
+}
+
+// AuthMechanisms returns the SASL mechanisms advertised in EHLO
+func (s *Session) AuthMechanisms() []string {
+	if !s.mailServer.isAuthEnabled() {
+		return nil
+	}
+	return []string{authMechPlain, authMechLogin, authMechCramMD5}
+}
+
+// Auth returns a SASL server for the requested mechanism
+func (s *Session) Auth(mech string) (sasl.Server, error) {
+	if !s.mailServer.isAuthEnabled() {
+		return nil, smtp.ErrAuthUnsupported
+	}
+	authConfig := s.mailServer.authConfig
+
+	authenticate := func(username, password string) error {
+		if !authConfig.Verify(username, password) {
+			s.logAuthFailure(mech, username)
+			return smtp.ErrAuthFailed
+		}
+		s.setAuthenticated(username)
+		return nil
+	}
+
+	switch mech {
+	case authMechPlain:
+		return sasl.NewPlainServer(func(identity, username, password string) error {
+			if identity != "" && identity != username {
+				return smtp.ErrAuthFailed
+			}
+			return authenticate(username, password)
+		}), nil
+	case authMechLogin:
+		return &loginServer{authenticate: authenticate}, nil
+	case authMechCramMD5:
+		challenge := newCramMD5Challenge(s.mailServer.host)
+		return &cramMD5Server{
+			challenge: challenge,
+			authenticate: func(username, digest string) error {
+				password, ok := authConfig.plaintextPassword(username)
+				if !ok || subtle.ConstantTimeCompare([]byte(cramMD5Digest(password, challenge)), []byte(strings.ToLower(digest))) != 1 {
+					s.logAuthFailure(mech, username)
+					return smtp.ErrAuthFailed
+				}
+				s.setAuthenticated(username)
+				return nil
+			},
+		}, nil
+	default:
+		return nil, smtp.ErrAuthUnknownMechanism
+	}
+}
+
+// setAuthenticated marks the session as authenticated for username
+func (s *Session) setAuthenticated(username string) {
+	s.authenticated = true
+	s.username = username
+}
+
+// isAuthEnabled reports whether SMTP authentication is required
+func (ms *MailServer) isAuthEnabled() bool {
+	return ms.authConfig != nil && ms.authConfig.Enabled
+}
@@ -0,0 +1,147 @@
+package mailserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// authResults are the DKIM, SPF and DMARC results of an email as evaluated
+// on receipt. They are saved next to the .eml file, so that a restored email
+// keeps them whatever the keys and policies of the zone file are by then.
+type authResults struct {
+	DKIM                  []DKIMResult `json:"dkim,omitempty"`
+	SPF                   *SPFResult   `json:"spf,omitempty"`
+	DMARC                 *DMARCResult `json:"dmarc,omitempty"`
+	AuthenticationResults string       `json:"authenticationResults,omitempty"`
+}
+
+// authenticate evaluates SPF for the envelope and DMARC for the From header,
+// then synthesizes the Authentication-Results header of the email. DKIM
+// results must already be set.
+func (ms *MailServer) authenticate(email *Email, envelope *Envelope) {
+	helo := envelope.Host
+	if helo == "unknown" {
+		helo = ""
+	}
+	email.SPF = checkSPF(ms.dnsResolver, net.ParseIP(hostIP(envelope.RemoteAddress)), envelope.From, helo)
+
+	email.DMARC = nil
+	domains := make(map[string]bool)
+	for _, address := range email.From {
+		if at := strings.LastIndex(address.Address, "@"); at >= 0 {
+			domains[strings.ToLower(address.Address[at+1:])] = true
+		}
+	}
+	if len(domains) == 1 {
+		for domain := range domains {
+			email.DMARC = checkDMARC(ms.dnsResolver, domain, email.DKIM, email.SPF)
+		}
+	} else if len(domains) > 1 {
+		email.DMARC = &DMARCResult{Result: DMARCPermError, Reason: "From header has several domains"}
+	}
+
+	authServID := "localhost"
+	if ms.smtpServer != nil && ms.smtpServer.Domain != "" {
+		authServID = ms.smtpServer.Domain
+	}
+	email.AuthenticationResults = authenticationResults(authServID, email.DKIM, email.SPF, email.DMARC)
+}
+
+// authResultsPath returns the path of the authentication results file for an email
+func (ms *MailServer) authResultsPath(id string) string {
+	return filepath.Join(ms.mailDir, id+".auth.json")
+}
+
+// saveAuthResults writes the authentication results of an email next to its .eml file
+func (ms *MailServer) saveAuthResults(id string, email *Email) error {
+	data, err := json.Marshal(authResults{
+		DKIM:                  email.DKIM,
+		SPF:                   email.SPF,
+		DMARC:                 email.DMARC,
+		AuthenticationResults: email.AuthenticationResults,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode authentication results: %w", err)
+	}
+	if err := os.WriteFile(ms.authResultsPath(id), data, 0644); err != nil {
+		return fmt.Errorf("failed to save authentication results: %w", err)
+	}
+	return nil
+}
+
+// loadAuthResults sets the authentication results saved for an email
+func (ms *MailServer) loadAuthResults(id string, email *Email) error {
+	data, err := os.ReadFile(ms.authResultsPath(id))
+	if err != nil {
+		return err
+	}
+	var results authResults
+	if err := json.Unmarshal(data, &results); err != nil {
+		return fmt.Errorf("failed to decode authentication results: %w", err)
+	}
+	email.DKIM = results.DKIM
+	email.SPF = results.SPF
+	email.DMARC = results.DMARC
+	email.AuthenticationResults = results.AuthenticationResults
+	return nil
+}
+
+// authenticationResults formats an Authentication-Results header value (RFC 8601)
+func authenticationResults(authServID string, dkim []DKIMResult, spf *SPFResult, dmarc *DMARCResult) string {
+	results := []string{authServID}
+
+	if len(dkim) == 0 {
+		results = append(results, "dkim=none")
+	}
+	for _, signature := range dkim {
+		result := "dkim=" + signature.Result + authResultsComment(signature.Reason)
+		if signature.Domain != "" {
+			result += " header.d=" + signature.Domain
+		}
+		if signature.Selector != "" {
+			result += " header.s=" + signature.Selector
+		}
+		if signature.Algorithm != "" {
+			result += " header.a=" + signature.Algorithm
+		}
+		results = append(results, result)
+	}
+
+	if spf != nil {
+		result := "spf=" + spf.Result + authResultsComment(spf.Reason)
+		if spf.Identity == "helo" {
+			result += " smtp.helo=" + spf.Domain
+		} else if spf.Sender != "" {
+			result += " smtp.mailfrom=" + spf.Sender
+		}
+		results = append(results, result)
+	}
+
+	if dmarc == nil {
+		results = append(results, "dmarc=none")
+	} else {
+		comment := dmarc.Reason
+		if dmarc.Policy != "" {
+			comment = fmt.Sprintf("p=%s", dmarc.Policy)
+		}
+		result := "dmarc=" + dmarc.Result + authResultsComment(comment)
+		if dmarc.Domain != "" {
+			result += " header.from=" + dmarc.Domain
+		}
+		results = append(results, result)
+	}
+	return strings.Join(results, "; ")
+}
+
+// authResultsComment formats a reason as a header comment, or "" if empty
+func authResultsComment(reason string) string {
+	if reason == "" {
+		return ""
+	}
+	reason = strings.NewReplacer("(", "[", ")", "]", "\\", "").Replace(reason)
+	return " (" + reason + ")"
+}
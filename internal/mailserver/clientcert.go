@@ -0,0 +1,144 @@
+package mailserver
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/soulteary/owlmail/internal/common"
+)
+
+// Client certificate verification modes
+const (
+	ClientAuthRequest = "request" // Verify a client certificate if one is presented
+	ClientAuthRequire = "require" // Fail the TLS handshake without a valid client certificate
+)
+
+// clientAuthType returns the crypto/tls verification mode for the configuration
+func (c *TLSConfig) clientAuthType() (tls.ClientAuthType, error) {
+	switch c.ClientAuth {
+	case "", ClientAuthRequest:
+		return tls.VerifyClientCertIfGiven, nil
+	case ClientAuthRequire:
+		return tls.RequireAndVerifyClientCert, nil
+	default:
+		return tls.NoClientCert, fmt.Errorf("invalid client auth mode %q: must be request or require", c.ClientAuth)
+	}
+}
+
+// configureClientAuth enables client certificate verification on the server
+// TLS configuration and loads the certificate subject mapping
+func (c *TLSConfig) configureClientAuth(config *tls.Config) error {
+	if c.ClientCAFile == "" {
+		if c.ClientAuth != "" || c.ClientCertMapFile != "" {
+			return fmt.Errorf("client certificate authentication requires a client CA file")
+		}
+		return nil
+	}
+
+	authType, err := c.clientAuthType()
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(c.ClientCAFile)
+	if err != nil {
+		return fmt.Errorf("failed to read client CA file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return fmt.Errorf("no certificates found in client CA file %s", c.ClientCAFile)
+	}
+	config.ClientCAs = pool
+	config.ClientAuth = authType
+
+	if c.ClientCertMapFile != "" {
+		identities, err := LoadClientCertMapFile(c.ClientCertMapFile)
+		if err != nil {
+			return err
+		}
+		c.clientCertIdentities = identities
+	}
+	return nil
+}
+
+// LoadClientCertMapFile loads a JSON object mapping client certificate subjects
+// to mailboxes. A key matches either the full subject, such as
+// "CN=billing,O=Example Corp", or its common name alone.
+func LoadClientCertMapFile(filePath string) (map[string]string, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client certificate map file: %w", err)
+	}
+
+	var identities map[string]string
+	if err := json.Unmarshal(data, &identities); err != nil {
+		return nil, fmt.Errorf("failed to parse client certificate map JSON: %w", err)
+	}
+	for subject, identity := range identities {
+		if subject == "" || identity == "" {
+			return nil, fmt.Errorf("client certificate map entries need a subject and a mailbox")
+		}
+		if identity == DefaultMailbox {
+			return nil, fmt.Errorf("mailbox %q of %q is reserved for unauthenticated mail", identity, subject)
+		}
+	}
+	return identities, nil
+}
+
+// clientCertIdentity returns the mailbox mapped to a client certificate subject
+func (ms *MailServer) clientCertIdentity(cert *x509.Certificate) (string, bool) {
+	if ms.tlsConfig == nil {
+		return "", false
+	}
+	if identity, ok := ms.tlsConfig.clientCertIdentities[cert.Subject.String()]; ok {
+		return identity, true
+	}
+	if cert.Subject.CommonName != "" {
+		if identity, ok := ms.tlsConfig.clientCertIdentities[cert.Subject.CommonName]; ok {
+			return identity, true
+		}
+	}
+	return "", false
+}
+
+// verifiedClientCert returns the client certificate of a TLS connection if it
+// was verified against the client CAs
+func verifiedClientCert(state tls.ConnectionState) *x509.Certificate {
+	if len(state.VerifiedChains) == 0 || len(state.PeerCertificates) == 0 {
+		return nil
+	}
+	return state.PeerCertificates[0]
+}
+
+// certFingerprint returns the hex encoded SHA-256 fingerprint of a certificate
+func certFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// checkClientCert records the verified client certificate of the session.
+// A certificate mapped to a mailbox authenticates the session in place of AUTH.
+func (s *Session) checkClientCert() {
+	if s.clientCertSubject != "" || s.conn == nil {
+		return
+	}
+	state, ok := tlsConnectionState(s.conn.Conn())
+	if !ok {
+		return
+	}
+	cert := verifiedClientCert(state)
+	if cert == nil {
+		return
+	}
+	s.clientCertSubject = cert.Subject.String()
+	s.clientCertFingerprint = certFingerprint(cert)
+
+	if identity, ok := s.mailServer.clientCertIdentity(cert); ok && s.username == "" {
+		common.Verbose("Client certificate %q from %s authenticated as %q", s.clientCertSubject, s.remoteAddr(), identity)
+		s.setAuthenticated(identity)
+	}
+}
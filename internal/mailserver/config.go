@@ -5,9 +5,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
-	"time"
 
-	"github.com/emersion/go-smtp"
 	"github.com/soulteary/owlmail/internal/common"
 	"github.com/soulteary/owlmail/internal/outgoing"
 	"github.com/soulteary/owlmail/internal/types"
@@ -40,6 +38,16 @@ func NewMailServerWithFullConfig(port int, host, mailDir string, outgoingConfig
 		mailDir = filepath.Join(os.TempDir(), fmt.Sprintf("owlmail-%d", os.Getpid()))
 	}
 
+	// Load SMTP credentials file if configured
+	if authConfig != nil {
+		if authConfig.Username == DefaultMailbox {
+			return nil, fmt.Errorf("SMTP username %q is reserved for unauthenticated mail", DefaultMailbox)
+		}
+		if err := authConfig.LoadUsersFile(); err != nil {
+			return nil, fmt.Errorf("failed to load SMTP credentials: %w", err)
+		}
+	}
+
 	// Create mail directory
 	if err := os.MkdirAll(mailDir, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create mail directory: %w", err)
@@ -50,11 +58,18 @@ func NewMailServerWithFullConfig(port int, host, mailDir string, outgoingConfig
 		mailDir:      mailDir,
 		port:         port,
 		host:         host,
-		eventChan:    make(chan Event, 100),
+		eventChan:    make(chan struct{}, 1),
 		listeners:    make(map[string][]func(*types.Email)),
 		authConfig:   authConfig,
 		tlsConfig:    tlsConfig,
 		useUUIDForID: useUUIDForID,
+		greylistConfig: GreylistConfig{
+			Delay:  DefaultGreylistDelay,
+			Expiry: DefaultGreylistExpiry,
+		},
+		greylistEntries: make(map[string]*GreylistEntry),
+		rateLimiter:     newRateLimiter(),
+		esmtpExtensions: DefaultESMTPExtensions(),
 	}
 
 	// Setup outgoing mail if config provided
@@ -69,6 +84,9 @@ func NewMailServerWithFullConfig(port int, host, mailDir string, outgoingConfig
 
 	common.Log("owlmail using directory %s", mailDir)
 
+	// Deliver events to listeners, including those of the emails loaded below
+	go ms.dispatchEvents()
+
 	// Load existing emails from directory
 	if err := ms.LoadMailsFromDirectory(); err != nil {
 		common.Error("Failed to load emails from directory: %v", err)
@@ -78,34 +96,33 @@ func NewMailServerWithFullConfig(port int, host, mailDir string, outgoingConfig
 	return ms, nil
 }
 
-// setupSMTPServer configures the SMTP server
+// setupSMTPServer configures the TLS certificate and the default SMTP listeners
 func (ms *MailServer) setupSMTPServer() error {
-	be := &Backend{mailServer: ms}
-	s := smtp.NewServer(be)
-
-	s.Addr = fmt.Sprintf("%s:%d", ms.host, ms.port)
-	s.Domain = "localhost"
-	s.ReadTimeout = 10 * time.Second
-	s.WriteTimeout = 10 * time.Second
-	s.MaxMessageBytes = 1024 * 1024
-	s.MaxRecipients = 50
-
-	// Configure authentication
-	if ms.authConfig != nil && ms.authConfig.Enabled {
-		s.AllowInsecureAuth = true
-		// Note: go-smtp doesn't have EnableAuth, authentication is handled in Session
-	} else {
-		s.AllowInsecureAuth = true
+	// Load or create the local CA
+	if ms.tlsConfig != nil && ms.tlsConfig.LocalCA {
+		ca, err := LoadLocalCA(ms.mailDir, ms.tlsConfig.LocalCAHosts)
+		if err != nil {
+			return err
+		}
+		ms.localCA = ca
 	}
 
-	// Configure TLS for STARTTLS
+	// Configure TLS for STARTTLS and SMTPS
 	if ms.tlsConfig != nil && ms.tlsConfig.Enabled {
 		if ms.tlsConfig.CertFile != "" && ms.tlsConfig.KeyFile != "" {
 			cert, err := tls.LoadX509KeyPair(ms.tlsConfig.CertFile, ms.tlsConfig.KeyFile)
 			if err != nil {
 				return fmt.Errorf("failed to load TLS certificate: %w", err)
 			}
-			s.TLSConfig = &tls.Config{
+			ms.serverTLSConfig = &tls.Config{
+				Certificates: []tls.Certificate{cert},
+			}
+		} else if ms.localCA != nil {
+			cert, err := ms.localCA.ServerCertificate()
+			if err != nil {
+				return err
+			}
+			ms.serverTLSConfig = &tls.Config{
 				Certificates: []tls.Certificate{cert},
 			}
 		} else {
@@ -115,39 +132,16 @@ func (ms *MailServer) setupSMTPServer() error {
 			if err != nil {
 				return fmt.Errorf("failed to generate self-signed certificate: %w", err)
 			}
-			s.TLSConfig = &tls.Config{
+			ms.serverTLSConfig = &tls.Config{
 				Certificates: []tls.Certificate{cert},
 			}
 		}
-	}
-
-	ms.smtpServer = s
 
-	// Setup SMTPS server (direct TLS on 465) if TLS is enabled
-	if ms.tlsConfig != nil && ms.tlsConfig.Enabled {
-		smtps := smtp.NewServer(be)
-		smtps.Addr = fmt.Sprintf("%s:465", ms.host)
-		smtps.Domain = "localhost"
-		smtps.ReadTimeout = 10 * time.Second
-		smtps.WriteTimeout = 10 * time.Second
-		smtps.MaxMessageBytes = 1024 * 1024
-		smtps.MaxRecipients = 50
-
-		// Configure authentication for SMTPS
-		if ms.authConfig != nil && ms.authConfig.Enabled {
-			smtps.AllowInsecureAuth = true
-			// Note: go-smtp doesn't have EnableAuth, authentication is handled in Session
-		} else {
-			smtps.AllowInsecureAuth = true
+		// Verify client certificates if a client CA is configured
+		if err := ms.tlsConfig.configureClientAuth(ms.serverTLSConfig); err != nil {
+			return err
 		}
-
-		// Use same TLS config
-		smtps.TLSConfig = s.TLSConfig
-
-		// Wrap listener with TLS
-		smtps.LMTP = false
-		ms.smtpsServer = smtps
 	}
 
-	return nil
+	return ms.SetListeners(ms.defaultListeners())
 }
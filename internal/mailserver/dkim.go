@@ -0,0 +1,476 @@
+package mailserver
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	_ "crypto/sha1" // rsa-sha1
+	_ "crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/soulteary/owlmail/internal/types"
+)
+
+// DKIMResult is an alias for types.DKIMResult
+type DKIMResult = types.DKIMResult
+
+// DKIM verification results (RFC 8601)
+const (
+	DKIMPass      = "pass"
+	DKIMFail      = "fail"
+	DKIMTempError = "temperror"
+	DKIMPermError = "permerror"
+)
+
+// dkimMinRSABits is the shortest RSA key accepted (RFC 8301)
+const dkimMinRSABits = 1024
+
+// headerField is a raw header field of a message
+type headerField struct {
+	name string // Lower-case field name
+	raw  string // Complete field, folding included, without the final CRLF
+}
+
+// dkimSignature holds the tags of a DKIM-Signature header
+type dkimSignature struct {
+	algorithm   string
+	keyType     string // rsa or ed25519
+	hash        crypto.Hash
+	hashName    string // sha256 or sha1, as listed in key h= tags
+	signature   []byte
+	bodyHash    []byte
+	headerCanon string
+	bodyCanon   string
+	domain      string
+	selector    string
+	identity    string
+	headers     []string
+	length      int64 // Body length limit (l=), -1 when absent
+	expiration  int64 // Unix time (x=), 0 when absent
+}
+
+// verifyDKIM verifies every DKIM-Signature header of a raw message
+func (ms *MailServer) verifyDKIM(raw []byte) []DKIMResult {
+	fields, body := splitMessage(raw)
+	var results []DKIMResult
+	for i, field := range fields {
+		if field.name == "dkim-signature" {
+			results = append(results, verifyDKIMSignature(ms.dnsResolver, fields, i, body))
+		}
+	}
+	return results
+}
+
+// splitMessage splits a message into header fields and body, converting bare
+// LF line endings to CRLF
+func splitMessage(raw []byte) ([]headerField, []byte) {
+	raw = bytes.ReplaceAll(raw, []byte("\r\n"), []byte("\n"))
+	raw = bytes.ReplaceAll(raw, []byte("\n"), []byte("\r\n"))
+
+	var header, body []byte
+	if bytes.HasPrefix(raw, []byte("\r\n")) {
+		body = raw[2:]
+	} else if i := bytes.Index(raw, []byte("\r\n\r\n")); i >= 0 {
+		header, body = raw[:i+2], raw[i+4:]
+	} else {
+		header = raw
+	}
+
+	var fields []headerField
+	for _, line := range strings.SplitAfter(string(header), "\r\n") {
+		if line == "" {
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(fields) > 0 {
+			fields[len(fields)-1].raw += "\r\n" + strings.TrimSuffix(line, "\r\n")
+			continue
+		}
+		line = strings.TrimSuffix(line, "\r\n")
+		name, _, _ := strings.Cut(line, ":")
+		fields = append(fields, headerField{name: strings.ToLower(strings.TrimSpace(name)), raw: line})
+	}
+	return fields, body
+}
+
+// verifyDKIMSignature verifies the DKIM-Signature header fields[index]
+func verifyDKIMSignature(resolver TXTResolver, fields []headerField, index int, body []byte) DKIMResult {
+	field := fields[index]
+	_, value, _ := strings.Cut(field.raw, ":")
+	sig, err := parseDKIMSignature(value)
+	result := DKIMResult{Result: DKIMPermError}
+	if sig != nil {
+		result.Domain = sig.domain
+		result.Selector = sig.selector
+		result.Algorithm = sig.algorithm
+		result.Canonicalization = sig.headerCanon + "/" + sig.bodyCanon
+		result.Identity = sig.identity
+	}
+	if err != nil {
+		result.Reason = err.Error()
+		return result
+	}
+	if sig.expiration > 0 && time.Now().Unix() > sig.expiration {
+		result.Reason = "signature expired"
+		return result
+	}
+
+	// Body hash
+	canonical := canonicalBody(body, sig.bodyCanon)
+	if sig.length >= 0 {
+		if sig.length > int64(len(canonical)) {
+			result.Reason = "body length (l=) exceeds the body"
+			return result
+		}
+		canonical = canonical[:sig.length]
+	}
+	bodyHash := sig.hash.New()
+	bodyHash.Write(canonical)
+	result.BodyHashMatch = bytes.Equal(bodyHash.Sum(nil), sig.bodyHash)
+	if !result.BodyHashMatch {
+		result.Result = DKIMFail
+		result.Reason = "body hash did not verify"
+		return result
+	}
+
+	// Public key
+	key, testing, err := lookupDKIMKey(resolver, sig)
+	result.Testing = testing
+	if err != nil {
+		result.Result = DKIMPermError
+		if !errors.Is(err, ErrRecordNotFound) && !errors.Is(err, errDKIMKey) {
+			result.Result = DKIMTempError
+		}
+		result.Reason = err.Error()
+		return result
+	}
+
+	// Header hash and signature
+	headerHash := sig.hash.New()
+	writeSignedHeaders(headerHash, fields, sig)
+	canonicalSig := canonicalHeader(stripDKIMSignatureValue(field.raw), sig.headerCanon)
+	headerHash.Write([]byte(strings.TrimSuffix(canonicalSig, "\r\n")))
+	if err := verifyDKIMHash(key, sig, headerHash.Sum(nil)); err != nil {
+		result.Result = DKIMFail
+		result.Reason = "signature did not verify"
+		return result
+	}
+
+	result.Result = DKIMPass
+	return result
+}
+
+// parseDKIMSignature parses the value of a DKIM-Signature header. The returned
+// signature holds the tags parsed so far even when an error is returned.
+func parseDKIMSignature(value string) (*dkimSignature, error) {
+	tags, err := parseTagList(value)
+	if err != nil {
+		return nil, err
+	}
+
+	sig := &dkimSignature{
+		algorithm:   tags["a"],
+		domain:      strings.ToLower(tags["d"]),
+		selector:    tags["s"],
+		identity:    tags["i"],
+		headerCanon: "simple",
+		bodyCanon:   "simple",
+		length:      -1,
+	}
+	if c, ok := tags["c"]; ok {
+		header, body, hasBody := strings.Cut(strings.ToLower(c), "/")
+		sig.headerCanon = header
+		if hasBody {
+			sig.bodyCanon = body
+		}
+	}
+
+	for _, tag := range []string{"v", "a", "b", "bh", "d", "h", "s"} {
+		if tags[tag] == "" {
+			return sig, fmt.Errorf("missing %s= tag", tag)
+		}
+	}
+	if tags["v"] != "1" {
+		return sig, fmt.Errorf("unsupported version %q", tags["v"])
+	}
+	switch strings.ToLower(sig.algorithm) {
+	case "rsa-sha256":
+		sig.keyType, sig.hash, sig.hashName = "rsa", crypto.SHA256, "sha256"
+	case "rsa-sha1":
+		sig.keyType, sig.hash, sig.hashName = "rsa", crypto.SHA1, "sha1"
+	case "ed25519-sha256":
+		sig.keyType, sig.hash, sig.hashName = "ed25519", crypto.SHA256, "sha256"
+	default:
+		return sig, fmt.Errorf("unsupported algorithm %q", sig.algorithm)
+	}
+	for _, canon := range []string{sig.headerCanon, sig.bodyCanon} {
+		if canon != "simple" && canon != "relaxed" {
+			return sig, fmt.Errorf("unsupported canonicalization %q", tags["c"])
+		}
+	}
+	if sig.signature, err = base64.StdEncoding.DecodeString(tags["b"]); err != nil {
+		return sig, fmt.Errorf("invalid b= tag: %w", err)
+	}
+	if sig.bodyHash, err = base64.StdEncoding.DecodeString(tags["bh"]); err != nil {
+		return sig, fmt.Errorf("invalid bh= tag: %w", err)
+	}
+
+	for _, name := range strings.Split(tags["h"], ":") {
+		sig.headers = append(sig.headers, strings.ToLower(strings.TrimSpace(name)))
+	}
+	signsFrom := false
+	for _, name := range sig.headers {
+		signsFrom = signsFrom || name == "from"
+	}
+	if !signsFrom {
+		return sig, fmt.Errorf("From header is not signed")
+	}
+
+	if sig.identity == "" {
+		sig.identity = "@" + sig.domain
+	}
+	_, identityDomain, _ := strings.Cut(sig.identity, "@")
+	identityDomain = strings.ToLower(identityDomain)
+	if identityDomain != sig.domain && !strings.HasSuffix(identityDomain, "."+sig.domain) {
+		return sig, fmt.Errorf("identity %q is not in domain %q", sig.identity, sig.domain)
+	}
+	if l, ok := tags["l"]; ok {
+		if sig.length, err = strconv.ParseInt(l, 10, 64); err != nil || sig.length < 0 {
+			return sig, fmt.Errorf("invalid l= tag %q", l)
+		}
+	}
+	if x, ok := tags["x"]; ok {
+		if sig.expiration, err = strconv.ParseInt(x, 10, 64); err != nil {
+			return sig, fmt.Errorf("invalid x= tag %q", x)
+		}
+	}
+	if q, ok := tags["q"]; ok && !strings.Contains(strings.ToLower(q), "dns/txt") {
+		return sig, fmt.Errorf("unsupported query method %q", q)
+	}
+	return sig, nil
+}
+
+// parseTagList parses a DKIM tag=value list, removing whitespace from values
+func parseTagList(list string) (map[string]string, error) {
+	tags := make(map[string]string)
+	for _, spec := range strings.Split(list, ";") {
+		if strings.TrimSpace(spec) == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(spec, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("malformed tag %q", strings.TrimSpace(spec))
+		}
+		if _, exists := tags[name]; exists {
+			return nil, fmt.Errorf("duplicate %s= tag", name)
+		}
+		tags[name] = strings.Join(strings.Fields(value), "")
+	}
+	return tags, nil
+}
+
+// errDKIMKey marks unusable key records, a permanent error
+var errDKIMKey = errors.New("invalid key record")
+
+// lookupDKIMKey fetches and parses the public key of a signature. testing
+// reports whether the key is flagged for testing.
+func lookupDKIMKey(resolver TXTResolver, sig *dkimSignature) (key crypto.PublicKey, testing bool, err error) {
+	if resolver == nil {
+		return nil, false, fmt.Errorf("no key for %s._domainkey.%s: %w", sig.selector, sig.domain, ErrRecordNotFound)
+	}
+	name := sig.selector + "._domainkey." + sig.domain
+	records, err := resolver.LookupTXT(name)
+	if errors.Is(err, ErrRecordNotFound) || (err == nil && len(records) == 0) {
+		return nil, false, fmt.Errorf("no key for %s: %w", name, ErrRecordNotFound)
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("key lookup for %s failed: %w", name, err)
+	}
+
+	// The first usable record wins
+	for _, record := range records {
+		if key, testing, err = parseDKIMKey(record, sig); err == nil {
+			return key, testing, nil
+		}
+	}
+	return nil, testing, err
+}
+
+// parseDKIMKey parses a DKIM key record such as "v=DKIM1; k=rsa; p=MIIB..."
+func parseDKIMKey(record string, sig *dkimSignature) (crypto.PublicKey, bool, error) {
+	tags, err := parseTagList(record)
+	if err != nil {
+		return nil, false, fmt.Errorf("%w: %v", errDKIMKey, err)
+	}
+	flags := strings.Split(tags["t"], ":")
+	testing, strict := false, false
+	for _, flag := range flags {
+		testing = testing || flag == "y"
+		strict = strict || flag == "s"
+	}
+
+	if v, ok := tags["v"]; ok && v != "DKIM1" {
+		return nil, testing, fmt.Errorf("%w: unsupported version %q", errDKIMKey, v)
+	}
+	keyType := tags["k"]
+	if keyType == "" {
+		keyType = "rsa"
+	}
+	if keyType != sig.keyType {
+		return nil, testing, fmt.Errorf("%w: key type %s does not match algorithm %s", errDKIMKey, keyType, sig.algorithm)
+	}
+	if h, ok := tags["h"]; ok && !containsTagValue(h, sig.hashName) {
+		return nil, testing, fmt.Errorf("%w: hash %s not allowed by key", errDKIMKey, sig.hashName)
+	}
+	if s, ok := tags["s"]; ok && !containsTagValue(s, "*") && !containsTagValue(s, "email") {
+		return nil, testing, fmt.Errorf("%w: key is not for email", errDKIMKey)
+	}
+	if strict && !strings.HasSuffix(strings.ToLower(sig.identity), "@"+sig.domain) {
+		return nil, testing, fmt.Errorf("%w: key does not allow subdomain identity %q", errDKIMKey, sig.identity)
+	}
+
+	p, ok := tags["p"]
+	if !ok {
+		return nil, testing, fmt.Errorf("%w: missing p= tag", errDKIMKey)
+	}
+	if p == "" {
+		return nil, testing, fmt.Errorf("%w: key revoked", errDKIMKey)
+	}
+	data, err := base64.StdEncoding.DecodeString(p)
+	if err != nil {
+		return nil, testing, fmt.Errorf("%w: invalid p= tag: %v", errDKIMKey, err)
+	}
+
+	if keyType == "ed25519" {
+		if len(data) != ed25519.PublicKeySize {
+			return nil, testing, fmt.Errorf("%w: invalid ed25519 key length %d", errDKIMKey, len(data))
+		}
+		return ed25519.PublicKey(data), testing, nil
+	}
+	var rsaKey *rsa.PublicKey
+	if parsed, err := x509.ParsePKIXPublicKey(data); err == nil {
+		rsaKey, ok = parsed.(*rsa.PublicKey)
+		if !ok {
+			return nil, testing, fmt.Errorf("%w: not an RSA key", errDKIMKey)
+		}
+	} else if rsaKey, err = x509.ParsePKCS1PublicKey(data); err != nil {
+		return nil, testing, fmt.Errorf("%w: invalid RSA key: %v", errDKIMKey, err)
+	}
+	if rsaKey.N.BitLen() < dkimMinRSABits {
+		return nil, testing, fmt.Errorf("%w: RSA key shorter than %d bits", errDKIMKey, dkimMinRSABits)
+	}
+	return rsaKey, testing, nil
+}
+
+// containsTagValue reports whether a colon-separated tag value lists item
+func containsTagValue(list, item string) bool {
+	for _, v := range strings.Split(list, ":") {
+		if strings.EqualFold(v, item) {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyDKIMHash checks the signature over the header hash
+func verifyDKIMHash(key crypto.PublicKey, sig *dkimSignature, hashed []byte) error {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(k, sig.hash, hashed, sig.signature)
+	case ed25519.PublicKey:
+		if !ed25519.Verify(k, hashed, sig.signature) {
+			return errors.New("ed25519 verification failed")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported key type %T", key)
+	}
+}
+
+// writeSignedHeaders writes the canonical form of the headers listed in h=.
+// Repeated names select instances from the bottom of the header up; names
+// without a remaining instance are skipped.
+func writeSignedHeaders(h hash.Hash, fields []headerField, sig *dkimSignature) {
+	used := make(map[int]bool)
+	for _, name := range sig.headers {
+		for i := len(fields) - 1; i >= 0; i-- {
+			if fields[i].name == name && !used[i] {
+				used[i] = true
+				h.Write([]byte(canonicalHeader(fields[i].raw, sig.headerCanon)))
+				break
+			}
+		}
+	}
+}
+
+// canonicalHeader returns a header field in canonical form, ending with CRLF
+func canonicalHeader(raw, canon string) string {
+	if canon != "relaxed" {
+		return raw + "\r\n"
+	}
+	name, value, _ := strings.Cut(raw, ":")
+	value = strings.NewReplacer("\r\n", "").Replace(value)
+	return strings.ToLower(strings.TrimSpace(name)) + ":" + strings.Join(strings.FieldsFunc(value, isWSP), " ") + "\r\n"
+}
+
+// canonicalBody returns a body in canonical form
+func canonicalBody(body []byte, canon string) []byte {
+	lines := strings.SplitAfter(string(body), "\r\n")
+	var b strings.Builder
+	for _, line := range lines {
+		if canon == "relaxed" {
+			content := strings.Join(strings.FieldsFunc(strings.TrimSuffix(line, "\r\n"), isWSP), " ")
+			if content != "" && (line[0] == ' ' || line[0] == '\t') {
+				content = " " + content
+			}
+			if strings.HasSuffix(line, "\r\n") {
+				content += "\r\n"
+			}
+			line = content
+		}
+		b.WriteString(line)
+	}
+
+	// Drop trailing empty lines and make sure a non-empty body ends with CRLF
+	out := b.String()
+	for strings.HasSuffix(out, "\r\n\r\n") {
+		out = strings.TrimSuffix(out, "\r\n")
+	}
+	if out == "\r\n" && canon == "relaxed" {
+		out = ""
+	}
+	if out != "" && !strings.HasSuffix(out, "\r\n") {
+		out += "\r\n"
+	}
+	if out == "" && canon != "relaxed" {
+		out = "\r\n"
+	}
+	return []byte(out)
+}
+
+// isWSP reports whether r is a space or tab
+func isWSP(r rune) bool {
+	return r == ' ' || r == '\t'
+}
+
+// stripDKIMSignatureValue empties the b= tag of a DKIM-Signature field,
+// keeping every other byte so simple canonicalization still applies
+func stripDKIMSignatureValue(raw string) string {
+	name, value, _ := strings.Cut(raw, ":")
+	specs := strings.Split(value, ";")
+	for i, spec := range specs {
+		tag, _, ok := strings.Cut(spec, "=")
+		if ok && strings.TrimSpace(tag) == "b" {
+			specs[i] = spec[:strings.Index(spec, "=")+1]
+		}
+	}
+	return name + ":" + strings.Join(specs, ";")
+}
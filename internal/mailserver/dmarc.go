@@ -0,0 +1,126 @@
+package mailserver
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/soulteary/owlmail/internal/types"
+	"golang.org/x/net/publicsuffix"
+)
+
+// DMARCResult is an alias for types.DMARCResult
+type DMARCResult = types.DMARCResult
+
+// DMARC results (RFC 7489)
+const (
+	DMARCNone      = "none"
+	DMARCPass      = "pass"
+	DMARCFail      = "fail"
+	DMARCTempError = "temperror"
+	DMARCPermError = "permerror"
+)
+
+// checkDMARC evaluates the DMARC policy of the From header domain against
+// the DKIM and SPF results of the message
+func checkDMARC(resolver TXTResolver, fromDomain string, dkim []DKIMResult, spf *SPFResult) *DMARCResult {
+	fromDomain = strings.ToLower(fromDomain)
+	result := &DMARCResult{Domain: fromDomain, Result: DMARCNone}
+	orgDomain := organizationalDomain(fromDomain)
+
+	// Fall back to the policy of the organizational domain (RFC 7489 section 6.6.3)
+	record, err := lookupDMARCRecord(resolver, fromDomain)
+	usesOrgPolicy := false
+	if err == nil && record == "" && orgDomain != fromDomain {
+		record, err = lookupDMARCRecord(resolver, orgDomain)
+		usesOrgPolicy = true
+	}
+	if err != nil {
+		result.Result, result.Reason = DMARCTempError, err.Error()
+		return result
+	}
+	if record == "" {
+		result.Reason = "no DMARC record for " + fromDomain
+		return result
+	}
+
+	tags, err := parseTagList(record)
+	if err != nil {
+		result.Result, result.Reason = DMARCPermError, "invalid DMARC record: "+err.Error()
+		return result
+	}
+	policy := strings.ToLower(tags["p"])
+	if sp := strings.ToLower(tags["sp"]); usesOrgPolicy && sp != "" {
+		policy = sp
+	}
+	if policy != "none" && policy != "quarantine" && policy != "reject" {
+		result.Result, result.Reason = DMARCPermError, fmt.Sprintf("invalid DMARC policy %q", policy)
+		return result
+	}
+	result.Policy = policy
+
+	strictDKIM := strings.EqualFold(tags["adkim"], "s")
+	for _, signature := range dkim {
+		if signature.Result == DKIMPass && dmarcAligned(signature.Domain, fromDomain, strictDKIM) {
+			result.DKIMAligned = true
+		}
+	}
+	strictSPF := strings.EqualFold(tags["aspf"], "s")
+	if spf != nil && spf.Result == SPFPass && dmarcAligned(spf.Domain, fromDomain, strictSPF) {
+		result.SPFAligned = true
+	}
+
+	if result.DKIMAligned || result.SPFAligned {
+		result.Result = DMARCPass
+	} else {
+		result.Result, result.Reason = DMARCFail, "no aligned DKIM signature or SPF pass"
+	}
+	return result
+}
+
+// lookupDMARCRecord returns the DMARC record published for domain, or "" if
+// there is none or more than one
+func lookupDMARCRecord(resolver TXTResolver, domain string) (string, error) {
+	if resolver == nil {
+		return "", nil
+	}
+	name := "_dmarc." + domain
+	records, err := resolver.LookupTXT(name)
+	if errors.Is(err, ErrRecordNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("DMARC lookup for %s failed: %w", name, err)
+	}
+	var dmarc []string
+	for _, record := range records {
+		version, _, _ := strings.Cut(record, ";")
+		if strings.Join(strings.Fields(version), "") == "v=DMARC1" {
+			dmarc = append(dmarc, record)
+		}
+	}
+	if len(dmarc) != 1 {
+		return "", nil
+	}
+	return dmarc[0], nil
+}
+
+// dmarcAligned reports whether an authenticated domain is aligned with the
+// From domain: identical in strict mode, or sharing the organizational
+// domain in relaxed mode
+func dmarcAligned(domain, fromDomain string, strict bool) bool {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	if strict || domain == fromDomain {
+		return domain == fromDomain
+	}
+	return organizationalDomain(domain) == organizationalDomain(fromDomain)
+}
+
+// organizationalDomain returns the registered domain of name according to
+// the public suffix list, or name itself if it has none
+func organizationalDomain(name string) string {
+	if domain, err := publicsuffix.EffectiveTLDPlusOne(name); err == nil {
+		return domain
+	}
+	return name
+}
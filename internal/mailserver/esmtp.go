@@ -0,0 +1,126 @@
+package mailserver
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/emersion/go-smtp"
+	"github.com/soulteary/owlmail/internal/types"
+)
+
+// RecipientOptions is an alias for types.RecipientOptions
+type RecipientOptions = types.RecipientOptions
+
+// Optional ESMTP extension names
+const (
+	ExtensionSMTPUTF8   = "SMTPUTF8"
+	ExtensionBinaryMIME = "BINARYMIME"
+	ExtensionRequireTLS = "REQUIRETLS"
+	ExtensionDSN        = "DSN"
+)
+
+// ESMTPExtensions selects the optional extensions advertised in the EHLO reply
+type ESMTPExtensions struct {
+	SMTPUTF8   bool // RFC 6531 internationalized addresses
+	BinaryMIME bool // RFC 3030 BODY=BINARYMIME, requires BDAT
+	RequireTLS bool // RFC 8689, only advertised over TLS
+	DSN        bool // RFC 3461 RET, ENVID, NOTIFY and ORCPT parameters
+}
+
+// DefaultESMTPExtensions returns the extensions advertised by default: all of them
+func DefaultESMTPExtensions() ESMTPExtensions {
+	return ESMTPExtensions{SMTPUTF8: true, BinaryMIME: true, RequireTLS: true, DSN: true}
+}
+
+// ParseESMTPExtensions parses a comma-separated list of extension names,
+// e.g. "SMTPUTF8,DSN". "none" disables all of them.
+func ParseESMTPExtensions(spec string) (ESMTPExtensions, error) {
+	var ext ESMTPExtensions
+	for _, name := range strings.Split(spec, ",") {
+		switch strings.ToUpper(strings.TrimSpace(name)) {
+		case "", "NONE":
+		case ExtensionSMTPUTF8:
+			ext.SMTPUTF8 = true
+		case ExtensionBinaryMIME:
+			ext.BinaryMIME = true
+		case ExtensionRequireTLS:
+			ext.RequireTLS = true
+		case ExtensionDSN:
+			ext.DSN = true
+		default:
+			return ESMTPExtensions{}, fmt.Errorf("unknown ESMTP extension %q: must be SMTPUTF8, BINARYMIME, REQUIRETLS or DSN", strings.TrimSpace(name))
+		}
+	}
+	return ext, nil
+}
+
+// Names returns the names of the enabled extensions
+func (e ESMTPExtensions) Names() []string {
+	names := []string{}
+	if e.SMTPUTF8 {
+		names = append(names, ExtensionSMTPUTF8)
+	}
+	if e.BinaryMIME {
+		names = append(names, ExtensionBinaryMIME)
+	}
+	if e.RequireTLS {
+		names = append(names, ExtensionRequireTLS)
+	}
+	if e.DSN {
+		names = append(names, ExtensionDSN)
+	}
+	return names
+}
+
+// apply enables the extensions on an SMTP or LMTP server
+func (e ESMTPExtensions) apply(s *smtp.Server) {
+	s.EnableSMTPUTF8 = e.SMTPUTF8
+	s.EnableBINARYMIME = e.BinaryMIME
+	s.EnableREQUIRETLS = e.RequireTLS
+	s.EnableDSN = e.DSN
+}
+
+// SetESMTPExtensions selects the optional extensions advertised by the SMTP
+// listeners and the LMTP server. It must be called before Listen.
+func (ms *MailServer) SetESMTPExtensions(ext ESMTPExtensions) {
+	ms.esmtpExtensions = ext
+	for _, l := range ms.smtpListeners {
+		ext.apply(l.server)
+	}
+	if ms.lmtpServer != nil {
+		ext.apply(ms.lmtpServer)
+	}
+}
+
+// GetESMTPExtensions returns the optional extensions advertised by the server
+func (ms *MailServer) GetESMTPExtensions() ESMTPExtensions {
+	return ms.esmtpExtensions
+}
+
+// setMailOptions records the ESMTP parameters of MAIL FROM on the envelope
+func setMailOptions(e *Envelope, opts *smtp.MailOptions) {
+	if opts == nil {
+		return
+	}
+	e.Size = opts.Size
+	e.Body = string(opts.Body)
+	e.Return = string(opts.Return)
+	e.EnvelopeID = opts.EnvelopeID
+	e.SMTPUTF8 = opts.UTF8
+	e.RequireTLS = opts.RequireTLS
+}
+
+// newRecipientOptions converts the ESMTP parameters of RCPT TO
+func newRecipientOptions(to string, opts *smtp.RcptOptions) RecipientOptions {
+	rcpt := RecipientOptions{Address: to}
+	if opts == nil {
+		return rcpt
+	}
+	for _, notify := range opts.Notify {
+		rcpt.Notify = append(rcpt.Notify, string(notify))
+	}
+	if opts.OriginalRecipient != "" {
+		rcpt.ORcpt = string(opts.OriginalRecipientType) + ";" + opts.OriginalRecipient
+	}
+	return rcpt
+}
@@ -11,12 +11,64 @@ func (ms *MailServer) On(event string, handler func(*types.Email)) {
 	ms.listeners[event] = append(ms.listeners[event], handler)
 }
 
-// emit sends an event to all listeners
+// emit queues an event for the listeners. It never blocks and never drops
+// an event, however slow the listeners are; events emitted after Close are
+// ignored.
 func (ms *MailServer) emit(event string, email *types.Email) {
-	ms.listenersMutex.RLock()
-	defer ms.listenersMutex.RUnlock()
-	handlers := ms.listeners[event]
-	for _, handler := range handlers {
-		go handler(email)
+	ms.eventsMutex.Lock()
+	defer ms.eventsMutex.Unlock()
+	if ms.eventsClosed {
+		return
+	}
+	id := ""
+	if email != nil {
+		id = email.ID
+	}
+	ms.eventQueue = append(ms.eventQueue, Event{Type: event, Email: email, ID: id})
+	// Wake the dispatcher, unless it is already due to look at the queue
+	select {
+	case ms.eventChan <- struct{}{}:
+	default:
+	}
+}
+
+// dispatchEvents runs the listeners of each queued event, one event at a
+// time and in the order they were emitted, until the queue is closed
+func (ms *MailServer) dispatchEvents() {
+	for range ms.eventChan {
+		ms.dispatchQueued()
+	}
+	ms.dispatchQueued()
+}
+
+// dispatchQueued runs the listeners of the queued events until the queue is empty
+func (ms *MailServer) dispatchQueued() {
+	for {
+		ms.eventsMutex.Lock()
+		events := ms.eventQueue
+		ms.eventQueue = nil
+		ms.eventsMutex.Unlock()
+		if len(events) == 0 {
+			return
+		}
+
+		for _, event := range events {
+			ms.listenersMutex.RLock()
+			handlers := ms.listeners[event.Type]
+			ms.listenersMutex.RUnlock()
+			for _, handler := range handlers {
+				handler(event.Email)
+			}
+		}
+	}
+}
+
+// closeEvents stops accepting events; those already queued are still delivered
+func (ms *MailServer) closeEvents() {
+	ms.eventsMutex.Lock()
+	defer ms.eventsMutex.Unlock()
+	if !ms.eventsClosed {
+		ms.eventsClosed = true
+		close(ms.eventChan)
 	}
 }
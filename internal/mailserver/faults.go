@@ -0,0 +1,193 @@
+package mailserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-smtp"
+	"github.com/soulteary/owlmail/internal/common"
+)
+
+// SMTP stages at which fault rules can be evaluated
+const (
+	FaultStageMail = "mail"
+	FaultStageRcpt = "rcpt"
+	FaultStageData = "data"
+)
+
+// FaultRule describes an SMTP reply injected for matching commands
+type FaultRule struct {
+	Name         string  `json:"name,omitempty"`
+	Stage        string  `json:"stage"`                  // mail, rcpt or data
+	Match        string  `json:"match,omitempty"`        // Address pattern with * wildcards; recipient for rcpt and LMTP data, sender otherwise
+	Probability  float64 `json:"probability,omitempty"`  // Chance (0-1] that a matching command fails; 0 means always
+	MinSizeKB    int64   `json:"minSizeKB,omitempty"`    // Data stage only: fire for messages larger than this size
+	Delay        string  `json:"delay,omitempty"`        // Delay before replying, e.g. "2s"
+	Code         int     `json:"code,omitempty"`         // SMTP reply code; 0 only applies the delay
+	EnhancedCode string  `json:"enhancedCode,omitempty"` // Enhanced status code, e.g. "5.1.1"
+	Message      string  `json:"message,omitempty"`
+}
+
+// Validate checks that the rule is well formed
+func (r *FaultRule) Validate() error {
+	switch r.Stage {
+	case FaultStageMail, FaultStageRcpt, FaultStageData:
+	default:
+		return fmt.Errorf("invalid stage %q: must be mail, rcpt or data", r.Stage)
+	}
+	if r.Code != 0 && (r.Code < 400 || r.Code > 599) {
+		return fmt.Errorf("invalid reply code %d: must be between 400 and 599", r.Code)
+	}
+	if r.Probability < 0 || r.Probability > 1 {
+		return fmt.Errorf("invalid probability %v: must be between 0 and 1", r.Probability)
+	}
+	if r.MinSizeKB < 0 {
+		return fmt.Errorf("invalid minSizeKB %d", r.MinSizeKB)
+	}
+	if r.MinSizeKB > 0 && r.Stage != FaultStageData {
+		return fmt.Errorf("minSizeKB is only supported for the data stage")
+	}
+	if r.Delay != "" {
+		if _, err := time.ParseDuration(r.Delay); err != nil {
+			return fmt.Errorf("invalid delay %q: %w", r.Delay, err)
+		}
+	}
+	if r.EnhancedCode != "" {
+		if _, err := parseEnhancedCode(r.EnhancedCode); err != nil {
+			return err
+		}
+	}
+	if r.Code == 0 && r.Delay == "" {
+		return fmt.Errorf("rule must set a reply code, a delay, or both")
+	}
+	return nil
+}
+
+// matches reports whether the rule applies to a command
+func (r *FaultRule) matches(stage, address string, size int64) bool {
+	if r.Stage != stage {
+		return false
+	}
+	if r.Match != "" {
+		matched, err := path.Match(strings.ToLower(r.Match), strings.ToLower(address))
+		if err != nil || !matched {
+			return false
+		}
+	}
+	if r.MinSizeKB > 0 && size <= r.MinSizeKB*1024 {
+		return false
+	}
+	if r.Probability > 0 && rand.Float64() >= r.Probability {
+		return false
+	}
+	return true
+}
+
+// smtpError builds the SMTP reply for the rule, or nil if it only delays
+func (r *FaultRule) smtpError() error {
+	if r.Code == 0 {
+		return nil
+	}
+	enhancedCode := smtp.EnhancedCodeNotSet
+	if r.EnhancedCode != "" {
+		enhancedCode, _ = parseEnhancedCode(r.EnhancedCode)
+	}
+	message := r.Message
+	if message == "" {
+		message = "Injected failure"
+	}
+	return &smtp.SMTPError{
+		Code:         r.Code,
+		EnhancedCode: enhancedCode,
+		Message:      message,
+	}
+}
+
+// parseEnhancedCode parses an enhanced status code such as "5.1.1"
+func parseEnhancedCode(s string) (smtp.EnhancedCode, error) {
+	parts := strings.Split(s, ".")
+	if len(parts) != 3 {
+		return smtp.EnhancedCode{}, fmt.Errorf("invalid enhanced code %q", s)
+	}
+	var code smtp.EnhancedCode
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 || n > 999 {
+			return smtp.EnhancedCode{}, fmt.Errorf("invalid enhanced code %q", s)
+		}
+		code[i] = n
+	}
+	if code[0] != 2 && code[0] != 4 && code[0] != 5 {
+		return smtp.EnhancedCode{}, fmt.Errorf("invalid enhanced code %q: class must be 2, 4 or 5", s)
+	}
+	return code, nil
+}
+
+// LoadFaultRulesFile loads fault rules from a JSON file containing an array of rules
+func LoadFaultRulesFile(filePath string) ([]FaultRule, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read fault rules file: %w", err)
+	}
+
+	var rules []FaultRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("failed to parse fault rules JSON: %w", err)
+	}
+	return rules, nil
+}
+
+// SetFaultRules replaces the fault injection rules after validating them
+func (ms *MailServer) SetFaultRules(rules []FaultRule) error {
+	for i := range rules {
+		if err := rules[i].Validate(); err != nil {
+			return fmt.Errorf("fault rule %d: %w", i+1, err)
+		}
+	}
+
+	ms.faultsMutex.Lock()
+	defer ms.faultsMutex.Unlock()
+	ms.faultRules = append([]FaultRule{}, rules...)
+	return nil
+}
+
+// GetFaultRules returns a copy of the fault injection rules
+func (ms *MailServer) GetFaultRules() []FaultRule {
+	ms.faultsMutex.RLock()
+	defer ms.faultsMutex.RUnlock()
+	return append([]FaultRule{}, ms.faultRules...)
+}
+
+// injectFault evaluates the fault rules for an SMTP command.
+// The first matching rule wins: its delay is applied and its reply returned.
+func (ms *MailServer) injectFault(stage, address string, size int64) error {
+	ms.faultsMutex.RLock()
+	var rule *FaultRule
+	for i := range ms.faultRules {
+		if ms.faultRules[i].matches(stage, address, size) {
+			matched := ms.faultRules[i]
+			rule = &matched
+			break
+		}
+	}
+	ms.faultsMutex.RUnlock()
+
+	if rule == nil {
+		return nil
+	}
+	if rule.Delay != "" {
+		delay, _ := time.ParseDuration(rule.Delay)
+		time.Sleep(delay)
+	}
+	err := rule.smtpError()
+	if err != nil {
+		common.Verbose("Injected fault %q at %s stage for <%s>: %v", rule.Name, stage, address, err)
+	}
+	return err
+}
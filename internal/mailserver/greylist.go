@@ -0,0 +1,156 @@
+package mailserver
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-smtp"
+	"github.com/soulteary/owlmail/internal/common"
+)
+
+// Greylisting defaults, matching common greylisting MTAs
+const (
+	DefaultGreylistDelay  = 5 * time.Minute
+	DefaultGreylistExpiry = 24 * time.Hour
+)
+
+// GreylistConfig configures the greylisting simulation
+type GreylistConfig struct {
+	Enabled bool
+	Delay   time.Duration // Minimum time before a retry of a new triplet is accepted
+	Expiry  time.Duration // Triplets not seen for this long are forgotten; 0 keeps them forever
+}
+
+// GreylistEntry is a (client IP, sender, recipient) triplet seen by the greylist
+type GreylistEntry struct {
+	ClientIP  string    `json:"clientIP"`
+	Sender    string    `json:"sender"`
+	Recipient string    `json:"recipient"`
+	FirstSeen time.Time `json:"firstSeen"`
+	LastSeen  time.Time `json:"lastSeen"`
+	Attempts  int       `json:"attempts"`
+	Passed    bool      `json:"passed"` // Whether a retry has been accepted
+}
+
+// Validate checks that the configuration is well formed
+func (c *GreylistConfig) Validate() error {
+	if c.Delay < 0 {
+		return fmt.Errorf("invalid greylist delay %s: must not be negative", c.Delay)
+	}
+	if c.Expiry < 0 {
+		return fmt.Errorf("invalid greylist expiry %s: must not be negative", c.Expiry)
+	}
+	if c.Expiry > 0 && c.Expiry < c.Delay {
+		return fmt.Errorf("greylist expiry %s must not be shorter than the delay %s", c.Expiry, c.Delay)
+	}
+	return nil
+}
+
+// SetGreylistConfig updates the greylisting configuration
+func (ms *MailServer) SetGreylistConfig(config GreylistConfig) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+
+	ms.greylistMutex.Lock()
+	defer ms.greylistMutex.Unlock()
+	ms.greylistConfig = config
+	return nil
+}
+
+// GetGreylistConfig returns the greylisting configuration
+func (ms *MailServer) GetGreylistConfig() GreylistConfig {
+	ms.greylistMutex.Lock()
+	defer ms.greylistMutex.Unlock()
+	return ms.greylistConfig
+}
+
+// GetGreylistEntries returns the triplet table ordered by first attempt
+func (ms *MailServer) GetGreylistEntries() []GreylistEntry {
+	ms.greylistMutex.Lock()
+	defer ms.greylistMutex.Unlock()
+
+	ms.expireGreylistEntries(time.Now())
+	entries := make([]GreylistEntry, 0, len(ms.greylistEntries))
+	for _, entry := range ms.greylistEntries {
+		entries = append(entries, *entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].FirstSeen.Before(entries[j].FirstSeen)
+	})
+	return entries
+}
+
+// ClearGreylist forgets all triplets
+func (ms *MailServer) ClearGreylist() {
+	ms.greylistMutex.Lock()
+	defer ms.greylistMutex.Unlock()
+	ms.greylistEntries = make(map[string]*GreylistEntry)
+}
+
+// expireGreylistEntries drops triplets not seen within the expiry window.
+// The caller must hold greylistMutex.
+func (ms *MailServer) expireGreylistEntries(now time.Time) {
+	if ms.greylistConfig.Expiry <= 0 {
+		return
+	}
+	for key, entry := range ms.greylistEntries {
+		if now.Sub(entry.LastSeen) > ms.greylistConfig.Expiry {
+			delete(ms.greylistEntries, key)
+		}
+	}
+}
+
+// checkGreylist records a delivery attempt and returns a temporary failure
+// unless the triplet was first seen at least the configured delay ago
+func (ms *MailServer) checkGreylist(clientIP, sender, recipient string) error {
+	ms.greylistMutex.Lock()
+	defer ms.greylistMutex.Unlock()
+
+	if !ms.greylistConfig.Enabled {
+		return nil
+	}
+
+	now := time.Now()
+	ms.expireGreylistEntries(now)
+	if ms.greylistEntries == nil {
+		ms.greylistEntries = make(map[string]*GreylistEntry)
+	}
+
+	key := strings.ToLower(clientIP + "\x00" + sender + "\x00" + recipient)
+	entry, exists := ms.greylistEntries[key]
+	if !exists {
+		entry = &GreylistEntry{
+			ClientIP:  clientIP,
+			Sender:    sender,
+			Recipient: recipient,
+			FirstSeen: now,
+		}
+		ms.greylistEntries[key] = entry
+	}
+	entry.LastSeen = now
+	entry.Attempts++
+
+	if !entry.Passed && now.Sub(entry.FirstSeen) >= ms.greylistConfig.Delay && exists {
+		entry.Passed = true
+	}
+	if entry.Passed {
+		return nil
+	}
+
+	remaining := ms.greylistConfig.Delay - now.Sub(entry.FirstSeen)
+	common.Verbose("Greylisted <%s> -> <%s> from %s (retry in %s)", sender, recipient, clientIP, remaining.Round(time.Second))
+	return &smtp.SMTPError{
+		Code:         451,
+		EnhancedCode: smtp.EnhancedCode{4, 7, 1},
+		Message:      fmt.Sprintf("Greylisted, please try again in %d seconds", int(remaining.Round(time.Second).Seconds())),
+	}
+}
+
+// clientIP returns the IP address of the connected client, or the full
+// remote address when it is not a TCP connection
+func (s *Session) clientIP() string {
+	return hostIP(s.remoteAddr())
+}
@@ -0,0 +1,91 @@
+package mailserver
+
+import (
+	"bytes"
+	"mime"
+	"strings"
+
+	"github.com/emersion/go-message/charset"
+	"github.com/emersion/go-message/textproto"
+)
+
+// headerDecoder decodes RFC 2047 encoded words in any charset known to go-message
+var headerDecoder = &mime.WordDecoder{CharsetReader: charset.Reader}
+
+// commonHeaders are keyed in Email.Headers with these spellings, whatever
+// their spelling in the message
+var commonHeaders = []string{
+	"From", "To", "Cc", "Bcc", "Subject", "Date", "Message-ID",
+	"Reply-To", "In-Reply-To", "References", "Content-Type",
+	"Content-Transfer-Encoding", "MIME-Version", "X-Mailer",
+	"X-Priority", "Priority", "Importance",
+}
+
+// headerFields lists the fields of a header in order, duplicates included,
+// with the name as spelled in the message. Encoded words that cannot be
+// decoded are reported as warnings about the part.
+func headerFields(h textproto.Header, part string) ([]HeaderField, []ParseWarning) {
+	var warnings []ParseWarning
+	list := make([]HeaderField, 0, h.Len())
+	for fields := h.Fields(); fields.Next(); {
+		name := fields.Key()
+		if raw, err := fields.Raw(); err == nil {
+			if i := bytes.IndexByte(raw, ':'); i > 0 {
+				name = string(bytes.TrimSpace(raw[:i]))
+			}
+		}
+		value := fields.Value()
+		decoded, err := decodeHeader(value)
+		if err != nil {
+			warnings = append(warnings, newHeaderWarning(WarningInvalidEncodedWord, part, name, err))
+		}
+		list = append(list, HeaderField{Name: name, Value: value, Decoded: decoded})
+	}
+	return list, warnings
+}
+
+// decodeHeader decodes the RFC 2047 encoded words of a header value; the value
+// is kept as is if they cannot be decoded
+func decodeHeader(value string) (string, error) {
+	if !strings.Contains(value, "=?") {
+		return value, nil
+	}
+	decoded, err := headerDecoder.DecodeHeader(value)
+	if err != nil {
+		return value, err
+	}
+	return decoded, nil
+}
+
+// headerMap groups header fields by name for Email.Headers: a single value
+// is a string, repeated fields are a list of values in order
+func headerMap(list []HeaderField) map[string]interface{} {
+	keys := make(map[string]string)
+	values := make(map[string][]string)
+	var order []string
+	for _, field := range list {
+		lower := strings.ToLower(field.Name)
+		if _, ok := keys[lower]; !ok {
+			key := field.Name
+			for _, common := range commonHeaders {
+				if strings.EqualFold(common, key) {
+					key = common
+					break
+				}
+			}
+			keys[lower] = key
+			order = append(order, lower)
+		}
+		values[lower] = append(values[lower], field.Value)
+	}
+
+	headers := make(map[string]interface{}, len(order))
+	for _, lower := range order {
+		if len(values[lower]) > 1 {
+			headers[keys[lower]] = values[lower]
+		} else {
+			headers[keys[lower]] = values[lower][0]
+		}
+	}
+	return headers
+}
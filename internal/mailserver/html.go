@@ -0,0 +1,88 @@
+package mailserver
+
+import (
+	"encoding/base64"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// cidReference matches cid: URLs (RFC 2392) in attribute values and CSS url()
+var cidReference = regexp.MustCompile(`(?i)(["'(=]\s*)cid:([^"'\s)>]+)`)
+
+// GetEmailHTML returns the HTML content of an email, with the cid: URLs of
+// inline parts pointing to their attachment download URL
+func (ms *MailServer) GetEmailHTML(id string) (string, error) {
+	email, err := ms.GetEmail(id)
+	if err != nil {
+		return "", err
+	}
+	return rewriteCIDs(email.HTML, email.Attachments, func(attachment *Attachment) string {
+		return "/api/v1/emails/" + url.PathEscape(email.ID) + "/attachments/" + url.PathEscape(attachment.GeneratedFileName)
+	}), nil
+}
+
+// GetEmailHTMLEmbedded returns the HTML content of an email as a
+// self-contained document, with the inline parts referenced by cid: URLs
+// embedded as data URIs
+func (ms *MailServer) GetEmailHTMLEmbedded(id string) (string, error) {
+	email, err := ms.GetEmail(id)
+	if err != nil {
+		return "", err
+	}
+	return rewriteCIDs(email.HTML, email.Attachments, func(attachment *Attachment) string {
+		path := filepath.Join(ms.mailDir, email.ID, attachment.GeneratedFileName)
+		if validatePath(ms.mailDir, path) != nil {
+			return ""
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return ""
+		}
+		return "data:" + attachment.ContentType + ";base64," + base64.StdEncoding.EncodeToString(data)
+	}), nil
+}
+
+// rewriteCIDs replaces the cid: URLs of an HTML body with the URLs given by
+// target for the matching attachments. References without a matching
+// attachment, or for which target returns "", are kept.
+func rewriteCIDs(html string, attachments []*Attachment, target func(*Attachment) string) string {
+	if !strings.Contains(strings.ToLower(html), "cid:") {
+		return html
+	}
+	return cidReference.ReplaceAllStringFunc(html, func(match string) string {
+		groups := cidReference.FindStringSubmatch(match)
+		contentID, err := url.PathUnescape(groups[2])
+		if err != nil {
+			contentID = groups[2]
+		}
+		attachment := findContentID(attachments, contentID)
+		if attachment == nil || attachment.GeneratedFileName == "" {
+			return match
+		}
+		replacement := target(attachment)
+		if replacement == "" {
+			return match
+		}
+		return groups[1] + replacement
+	})
+}
+
+// findContentID returns the attachment with a Content-ID, preferring an exact match
+func findContentID(attachments []*Attachment, contentID string) *Attachment {
+	var folded *Attachment
+	for _, attachment := range attachments {
+		if attachment.ContentID == "" {
+			continue
+		}
+		if attachment.ContentID == contentID {
+			return attachment
+		}
+		if folded == nil && strings.EqualFold(attachment.ContentID, contentID) {
+			folded = attachment
+		}
+	}
+	return folded
+}
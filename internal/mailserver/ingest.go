@@ -0,0 +1,244 @@
+package mailserver
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/emersion/go-smtp"
+	"github.com/soulteary/owlmail/internal/common"
+)
+
+// DefaultIngestQueueSize is the number of spooled messages that may wait for
+// an ingest worker when IngestConfig.QueueSize is not set
+const DefaultIngestQueueSize = 1000
+
+// IngestConfig configures the asynchronous ingest pipeline. With workers,
+// a message is spooled to disk and acknowledged at once, and the workers
+// parse, store and announce it in the background. Without workers, messages
+// are processed within the SMTP transaction.
+type IngestConfig struct {
+	Workers   int `json:"workers"`   // Parse and store workers; 0 processes messages synchronously
+	QueueSize int `json:"queueSize"` // Spooled messages waiting for a worker before clients get 451
+}
+
+// IngestStats holds the counters of the ingest pipeline
+type IngestStats struct {
+	Queued     int   `json:"queued"`     // Spooled messages waiting for a worker
+	InProgress int   `json:"inProgress"` // Messages being parsed and stored
+	MaxQueued  int   `json:"maxQueued"`  // Highest queue depth since startup
+	Processed  int64 `json:"processed"`  // Messages stored since startup
+	Failed     int64 `json:"failed"`     // Spooled messages that could not be parsed
+	Rejected   int64 `json:"rejected"`   // Messages refused with 451 because the queue was full
+}
+
+// errIngestQueueFull is returned for a message when the ingest queue is full
+var errIngestQueueFull = &smtp.SMTPError{
+	Code:         451,
+	EnhancedCode: smtp.EnhancedCode{4, 3, 2},
+	Message:      "Too many messages queued, try again later",
+}
+
+// ingestJob is a spooled message waiting to be parsed and stored. Transcript
+// and session end updates that arrive before it is stored are kept here.
+type ingestJob struct {
+	id         string
+	envelope   *Envelope
+	transcript []TranscriptEntry
+	sessionEnd time.Time
+}
+
+// Validate checks that the configuration is well formed
+func (c *IngestConfig) Validate() error {
+	if c.Workers < 0 || c.QueueSize < 0 {
+		return fmt.Errorf("ingest workers and queue size must not be negative")
+	}
+	return nil
+}
+
+// SetIngestConfig replaces the ingest pipeline. Messages queued for the
+// previous workers are processed before it returns.
+func (ms *MailServer) SetIngestConfig(config IngestConfig) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	ms.stopIngest()
+
+	ms.ingestMutex.Lock()
+	defer ms.ingestMutex.Unlock()
+	ms.ingestConfig = config
+	if config.Workers == 0 {
+		return nil
+	}
+	size := config.QueueSize
+	if size == 0 {
+		size = DefaultIngestQueueSize
+	}
+	queue := make(chan *ingestJob, size)
+	ms.ingestQueue = queue
+	if ms.ingestJobs == nil {
+		ms.ingestJobs = make(map[string]*ingestJob)
+	}
+	for range config.Workers {
+		ms.ingestWG.Add(1)
+		go ms.ingestWorker(queue)
+	}
+	return nil
+}
+
+// GetIngestConfig returns the ingest pipeline configuration
+func (ms *MailServer) GetIngestConfig() IngestConfig {
+	ms.ingestMutex.Lock()
+	defer ms.ingestMutex.Unlock()
+	return ms.ingestConfig
+}
+
+// GetIngestStats returns the current ingest pipeline counters
+func (ms *MailServer) GetIngestStats() IngestStats {
+	ms.ingestMutex.Lock()
+	defer ms.ingestMutex.Unlock()
+	stats := ms.ingestStats
+	stats.Queued = len(ms.ingestQueue)
+	return stats
+}
+
+// stopIngest stops the workers once they have processed the queued messages
+func (ms *MailServer) stopIngest() {
+	ms.ingestMutex.Lock()
+	queue := ms.ingestQueue
+	ms.ingestQueue = nil
+	ms.ingestMutex.Unlock()
+
+	if queue != nil {
+		close(queue)
+		ms.ingestWG.Wait()
+	}
+}
+
+// enqueueIngest hands a spooled message to the ingest workers. It returns
+// false if there are no workers and the message must be processed now.
+func (ms *MailServer) enqueueIngest(id string, s *Session) (bool, error) {
+	ms.ingestMutex.Lock()
+	queue := ms.ingestQueue
+	if queue == nil {
+		ms.ingestMutex.Unlock()
+		return false, nil
+	}
+	job := &ingestJob{id: id, envelope: s.envelope()}
+	ms.ingestJobs[id] = job
+	select {
+	case queue <- job:
+		ms.ingestStats.MaxQueued = max(ms.ingestStats.MaxQueued, len(queue))
+		ms.ingestMutex.Unlock()
+	default:
+		delete(ms.ingestJobs, id)
+		ms.ingestStats.Rejected++
+		ms.ingestMutex.Unlock()
+		if err := os.Remove(filepath.Join(ms.mailDir, id+".eml")); err != nil {
+			common.Verbose("Failed to remove rejected email file: %v", err)
+		}
+		return true, errIngestQueueFull
+	}
+
+	// The envelope and transcript are spooled with the message, so that a
+	// message not yet processed is restored with them after a restart
+	if err := ms.saveEnvelope(id, job.envelope); err != nil {
+		common.Verbose("Error saving envelope: %v", err)
+	}
+	if entries := s.transcript.attach(id); entries != nil {
+		ms.updateTranscript(id, entries)
+	}
+	return true, nil
+}
+
+// ingestWorker parses and stores queued messages until the queue is closed
+func (ms *MailServer) ingestWorker(queue <-chan *ingestJob) {
+	defer ms.ingestWG.Done()
+	for job := range queue {
+		ms.ingestMutex.Lock()
+		ms.ingestStats.InProgress++
+		ms.ingestMutex.Unlock()
+
+		err := ms.ingest(job)
+
+		ms.ingestMutex.Lock()
+		ms.ingestStats.InProgress--
+		if err != nil {
+			ms.ingestStats.Failed++
+		} else {
+			ms.ingestStats.Processed++
+		}
+		ms.ingestMutex.Unlock()
+	}
+}
+
+// ingest parses and stores a spooled message
+func (ms *MailServer) ingest(job *ingestJob) error {
+	emlFile, err := os.Open(filepath.Join(ms.mailDir, job.id+".eml"))
+	if err != nil {
+		ms.dropIngestJob(job.id)
+		common.Error("Failed to open spooled email %s: %v", job.id, err)
+		return err
+	}
+	email, err := ms.parseMessage(job.id, emlFile, true)
+	if closeErr := emlFile.Close(); closeErr != nil {
+		common.Verbose("Failed to close email file: %v", closeErr)
+	}
+	if err != nil {
+		// The message stays spooled and is retried on the next reload
+		ms.dropIngestJob(job.id)
+		common.Error("Failed to parse spooled email %s: %v", job.id, err)
+		return err
+	}
+	ms.authenticate(email, job.envelope)
+	if err := ms.saveAuthResults(job.id, email); err != nil {
+		common.Verbose("Error saving authentication results: %v", err)
+	}
+
+	ms.ingestMutex.Lock()
+	email.Transcript = job.transcript
+	job.envelope.SessionEnd = job.sessionEnd
+	ms.ingestMutex.Unlock()
+
+	if err := ms.SaveEmailToStore(job.id, false, job.envelope, email); err != nil {
+		ms.dropIngestJob(job.id)
+		common.Error("Failed to store spooled email %s: %v", job.id, err)
+		return err
+	}
+
+	// Updates that arrived while the email was being stored are applied to
+	// it; later ones find it in the store
+	ms.ingestMutex.Lock()
+	defer ms.ingestMutex.Unlock()
+	delete(ms.ingestJobs, job.id)
+	ms.storeMutex.Lock()
+	defer ms.storeMutex.Unlock()
+	email.Transcript = job.transcript
+	email.Envelope.SessionEnd = job.sessionEnd
+	return nil
+}
+
+// dropIngestJob forgets a spooled message that could not be stored
+func (ms *MailServer) dropIngestJob(id string) {
+	ms.ingestMutex.Lock()
+	defer ms.ingestMutex.Unlock()
+	delete(ms.ingestJobs, id)
+}
+
+// updateIngestJob records a transcript or session end update for a message
+// that is still queued. The caller must hold ms.ingestMutex. It returns the
+// job, or nil if the message is not queued.
+func (ms *MailServer) updateIngestJob(id string, entries []TranscriptEntry, end time.Time) *ingestJob {
+	job := ms.ingestJobs[id]
+	if job == nil {
+		return nil
+	}
+	if entries != nil {
+		job.transcript = entries
+	}
+	if !end.IsZero() {
+		job.sessionEnd = end
+	}
+	return job
+}
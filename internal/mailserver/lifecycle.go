@@ -1,65 +1,78 @@
 package mailserver
 
 import (
-	"crypto/tls"
 	"net"
 
 	"github.com/soulteary/owlmail/internal/common"
 )
 
-// Listen starts the SMTP server
+// Listen starts the SMTP listeners and blocks until they are closed.
+// Every listener is bound before serving begins, so address errors are returned.
 func (ms *MailServer) Listen() error {
-	// Start SMTPS server (465) if configured
-	if ms.smtpsServer != nil {
-		go func() {
-			common.Log("owlmail SMTPS Server running at %s:465", ms.host)
-			ln, err := net.Listen("tcp", ms.smtpsServer.Addr)
-			if err != nil {
-				common.Error("Failed to start SMTPS server: %v", err)
-				return
-			}
-			tlsListener := tls.NewListener(ln, ms.smtpsServer.TLSConfig)
-			if err := ms.smtpsServer.Serve(tlsListener); err != nil {
-				common.Error("SMTPS server error: %v", err)
+	bound, err := ms.bindListeners()
+	if err != nil {
+		return err
+	}
+
+	// Start LMTP server if configured
+	if ms.lmtpServer != nil {
+		if err := ms.listenLMTP(); err != nil {
+			for _, ln := range bound {
+				_ = ln.Close()
 			}
-		}()
+			return err
+		}
 	}
 
-	common.Log("owlmail SMTP Server running at %s:%d", ms.host, ms.port)
-	if ms.authConfig != nil && ms.authConfig.Enabled {
-		common.Log("SMTP authentication enabled (PLAIN/LOGIN)")
+	for i, l := range ms.smtpListeners {
+		logListener(l.config, bound[i])
+	}
+	if ms.isAuthEnabled() {
+		common.Log("SMTP authentication enabled (PLAIN/LOGIN/CRAM-MD5) for %d user(s)", ms.authConfig.UserCount())
 	}
 	if ms.tlsConfig != nil && ms.tlsConfig.Enabled {
 		common.Log("SMTP TLS/STARTTLS enabled")
 	}
-	return ms.smtpServer.ListenAndServe()
+
+	errs := make(chan error, len(bound))
+	for i, l := range ms.smtpListeners {
+		go func(l *smtpListener, ln net.Listener) {
+			errs <- ms.serve(l.server, ln, l.config.Mode == ListenerModeTLS, l.config.ProxyProtocol)
+		}(l, bound[i])
+	}
+
+	// Wait for every listener to stop and report the first error
+	var serveErr error
+	for range bound {
+		if err := <-errs; err != nil && serveErr == nil {
+			serveErr = err
+		}
+	}
+	return serveErr
 }
 
 // Close stops the SMTP server
 func (ms *MailServer) Close() error {
+	// Stop accepting mail and store the queued messages before shutting down the event loop
+	ms.closeServing()
+	ms.stopIngest()
+
 	if ms.outgoing != nil {
 		ms.outgoing.Close()
 	}
 
-	// Safely close eventChan, handling the case where it's already closed
-	func() {
-		defer func() {
-			if r := recover(); r != nil {
-				// Channel is already closed, which is fine
-				_ = r
-			}
-		}()
-		close(ms.eventChan)
-	}()
+	ms.closeEvents()
 
 	var err error
-	if ms.smtpsServer != nil {
-		if closeErr := ms.smtpsServer.Close(); closeErr != nil {
+	if ms.lmtpServer != nil {
+		if closeErr := ms.lmtpServer.Close(); closeErr != nil {
 			err = closeErr
 		}
 	}
-	if closeErr := ms.smtpServer.Close(); closeErr != nil {
-		err = closeErr
+	for _, l := range ms.smtpListeners {
+		if closeErr := l.server.Close(); closeErr != nil {
+			err = closeErr
+		}
 	}
 	return err
 }
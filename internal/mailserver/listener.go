@@ -0,0 +1,310 @@
+package mailserver
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-smtp"
+	"github.com/soulteary/owlmail/internal/common"
+)
+
+// SMTP listener modes
+const (
+	ListenerModePlain    = "plain"    // STARTTLS is offered when TLS is configured
+	ListenerModeStartTLS = "starttls" // STARTTLS is required before AUTH and MAIL
+	ListenerModeTLS      = "tls"      // Implicit TLS (SMTPS)
+)
+
+// Default per-listener limits
+const (
+	defaultSMTPSPort       = 465
+	defaultMaxMessageBytes = 1024 * 1024
+	defaultMaxRecipients   = 50
+	defaultSMTPTimeout     = 10 * time.Second
+)
+
+// errTLSRequired is returned when a client sends MAIL FROM before STARTTLS on a listener that requires it
+var errTLSRequired = &smtp.SMTPError{
+	Code:         530,
+	EnhancedCode: smtp.EnhancedCode{5, 7, 0},
+	Message:      "Must issue a STARTTLS command first",
+}
+
+// ListenerConfig describes an SMTP listener
+type ListenerConfig struct {
+	Addr            string        `json:"addr"` // host:port, or unix:/path or an absolute path for a unix socket
+	Mode            string        `json:"mode"` // plain, starttls or tls
+	MaxMessageBytes int64         `json:"maxMessageBytes"`
+	MaxRecipients   int           `json:"maxRecipients"`
+	ReadTimeout     time.Duration `json:"readTimeout"`
+	WriteTimeout    time.Duration `json:"writeTimeout"`
+	ProxyProtocol   bool          `json:"proxyProtocol"` // Expect a PROXY protocol v1/v2 header from trusted sources
+}
+
+// smtpListener is a configured listener and the server handling its connections
+type smtpListener struct {
+	config    ListenerConfig // With defaults filled in
+	requested ListenerConfig // As configured
+	server    *smtp.Server
+}
+
+// withDefaults returns a copy of the configuration with unset limits filled
+// in from the SMTP profile, if any
+func (c ListenerConfig) withDefaults(profile *SMTPProfile) ListenerConfig {
+	if c.Mode == "" {
+		c.Mode = ListenerModePlain
+	}
+	if profile != nil {
+		if c.MaxMessageBytes == 0 {
+			c.MaxMessageBytes = profile.MaxMessageBytes
+		}
+		if c.MaxRecipients == 0 {
+			c.MaxRecipients = profile.MaxRecipients
+		}
+		if c.ReadTimeout == 0 {
+			c.ReadTimeout = profile.duration(profile.ReadTimeout)
+		}
+		if c.WriteTimeout == 0 {
+			c.WriteTimeout = profile.duration(profile.WriteTimeout)
+		}
+	}
+	if c.MaxMessageBytes == 0 {
+		c.MaxMessageBytes = defaultMaxMessageBytes
+	}
+	if c.MaxRecipients == 0 {
+		c.MaxRecipients = defaultMaxRecipients
+	}
+	if c.ReadTimeout == 0 {
+		c.ReadTimeout = defaultSMTPTimeout
+	}
+	if c.WriteTimeout == 0 {
+		c.WriteTimeout = defaultSMTPTimeout
+	}
+	return c
+}
+
+// Validate checks that the listener configuration is well formed
+func (c *ListenerConfig) Validate() error {
+	if c.Addr == "" {
+		return fmt.Errorf("listener address is required")
+	}
+	if network, address := parseListenAddr(c.Addr); network != "unix" {
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			return fmt.Errorf("invalid listener address %q: %w", c.Addr, err)
+		}
+	}
+	switch c.Mode {
+	case "", ListenerModePlain, ListenerModeStartTLS, ListenerModeTLS:
+	default:
+		return fmt.Errorf("invalid listener mode %q: must be plain, starttls or tls", c.Mode)
+	}
+	if c.MaxMessageBytes < 0 || c.MaxRecipients < 0 || c.ReadTimeout < 0 || c.WriteTimeout < 0 {
+		return fmt.Errorf("listener %s: limits must not be negative", c.Addr)
+	}
+	return nil
+}
+
+// ParseListenerConfigs parses a comma-separated list of listener URLs.
+// The scheme selects the mode: smtp:// (plain), starttls:// or smtps:// (implicit TLS).
+// The host is a TCP address; a URL without host and with a path, such as
+// smtp:///run/owlmail.sock, is a unix socket. Limits are set with the query
+// parameters maxMessageBytes, maxRecipients, readTimeout and writeTimeout, and
+// proxyProtocol=true expects a PROXY protocol header, e.g.
+// "smtp://0.0.0.0:1025,smtp://[::]:1025,smtps://:1465?maxMessageBytes=10485760".
+func ParseListenerConfigs(spec string) ([]ListenerConfig, error) {
+	var configs []ListenerConfig
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		config, err := parseListenerURL(entry)
+		if err != nil {
+			return nil, err
+		}
+		configs = append(configs, config)
+	}
+	if len(configs) == 0 {
+		return nil, fmt.Errorf("no SMTP listeners configured")
+	}
+	return configs, nil
+}
+
+// parseListenerURL parses a single listener URL
+func parseListenerURL(raw string) (ListenerConfig, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ListenerConfig{}, fmt.Errorf("invalid listener %q: %w", raw, err)
+	}
+
+	var config ListenerConfig
+	switch u.Scheme {
+	case "smtp":
+		config.Mode = ListenerModePlain
+	case "starttls":
+		config.Mode = ListenerModeStartTLS
+	case "smtps":
+		config.Mode = ListenerModeTLS
+	default:
+		return ListenerConfig{}, fmt.Errorf("invalid listener %q: scheme must be smtp, starttls or smtps", raw)
+	}
+
+	switch {
+	case u.Host != "":
+		config.Addr = u.Host
+	case u.Path != "":
+		config.Addr = "unix:" + u.Path
+	default:
+		return ListenerConfig{}, fmt.Errorf("invalid listener %q: missing address", raw)
+	}
+
+	for key, values := range u.Query() {
+		value := values[len(values)-1]
+		switch key {
+		case "maxMessageBytes":
+			config.MaxMessageBytes, err = strconv.ParseInt(value, 10, 64)
+		case "maxRecipients":
+			config.MaxRecipients, err = strconv.Atoi(value)
+		case "readTimeout":
+			config.ReadTimeout, err = time.ParseDuration(value)
+		case "writeTimeout":
+			config.WriteTimeout, err = time.ParseDuration(value)
+		case "proxyProtocol":
+			config.ProxyProtocol, err = strconv.ParseBool(value)
+		default:
+			err = fmt.Errorf("unknown option")
+		}
+		if err != nil {
+			return ListenerConfig{}, fmt.Errorf("invalid listener %q: %s: %w", raw, key, err)
+		}
+	}
+
+	if err := config.Validate(); err != nil {
+		return ListenerConfig{}, err
+	}
+	return config, nil
+}
+
+// defaultListeners returns the listeners used when none are configured:
+// plain SMTP on host:port, plus SMTPS when TLS is enabled
+func (ms *MailServer) defaultListeners() []ListenerConfig {
+	listeners := []ListenerConfig{{
+		Addr: net.JoinHostPort(ms.host, strconv.Itoa(ms.port)),
+		Mode: ListenerModePlain,
+	}}
+	if ms.tlsConfig != nil && ms.tlsConfig.Enabled {
+		port := ms.tlsConfig.SMTPSPort
+		if port == 0 {
+			port = defaultSMTPSPort
+		}
+		listeners = append(listeners, ListenerConfig{
+			Addr: net.JoinHostPort(ms.host, strconv.Itoa(port)),
+			Mode: ListenerModeTLS,
+		})
+	}
+	return listeners
+}
+
+// SetListeners replaces the SMTP listeners. It must be called before Listen.
+func (ms *MailServer) SetListeners(configs []ListenerConfig) error {
+	if len(configs) == 0 {
+		return fmt.Errorf("no SMTP listeners configured")
+	}
+
+	listeners := make([]*smtpListener, 0, len(configs))
+	for _, config := range configs {
+		if err := config.Validate(); err != nil {
+			return err
+		}
+		requested := config
+		config = config.withDefaults(ms.smtpProfile)
+		if config.Mode != ListenerModePlain && ms.serverTLSConfig == nil {
+			return fmt.Errorf("listener %s: mode %s requires TLS to be enabled", config.Addr, config.Mode)
+		}
+		listeners = append(listeners, &smtpListener{
+			config:    config,
+			requested: requested,
+			server:    ms.newListenerServer(config),
+		})
+	}
+
+	ms.smtpListeners = listeners
+	ms.smtpServer = listeners[0].server
+	ms.smtpsServer = nil
+	for _, l := range listeners {
+		if l.config.Mode == ListenerModeTLS {
+			ms.smtpsServer = l.server
+			break
+		}
+	}
+	return nil
+}
+
+// GetListeners returns the SMTP listener configurations
+func (ms *MailServer) GetListeners() []ListenerConfig {
+	configs := make([]ListenerConfig, len(ms.smtpListeners))
+	for i, l := range ms.smtpListeners {
+		configs[i] = l.config
+	}
+	return configs
+}
+
+// newListenerServer creates the SMTP server for a listener
+func (ms *MailServer) newListenerServer(config ListenerConfig) *smtp.Server {
+	s := smtp.NewServer(&Backend{
+		mailServer:  ms,
+		requireTLS:  config.Mode == ListenerModeStartTLS,
+		implicitTLS: config.Mode == ListenerModeTLS,
+	})
+	s.Network, s.Addr = parseListenAddr(config.Addr)
+	s.Domain = ms.GetSMTPProfile().Hostname
+	s.ReadTimeout = config.ReadTimeout
+	s.WriteTimeout = config.WriteTimeout
+	s.MaxMessageBytes = config.MaxMessageBytes
+	s.MaxRecipients = config.MaxRecipients
+	s.TLSConfig = ms.serverTLSConfig
+	ms.esmtpExtensions.apply(s)
+	// Authentication is handled in Session; only listeners requiring STARTTLS refuse AUTH in plaintext
+	s.AllowInsecureAuth = config.Mode != ListenerModeStartTLS
+	return s
+}
+
+// bindListeners opens every SMTP listener. If one fails, those already opened are closed.
+func (ms *MailServer) bindListeners() ([]net.Listener, error) {
+	bound := make([]net.Listener, 0, len(ms.smtpListeners))
+	for _, l := range ms.smtpListeners {
+		ln, err := listen(l.server.Network, l.server.Addr)
+		if err != nil {
+			for _, opened := range bound {
+				_ = opened.Close()
+			}
+			return nil, fmt.Errorf("failed to listen on %s (%s): %w", l.config.Addr, l.config.Mode, err)
+		}
+		bound = append(bound, ln)
+	}
+	return bound, nil
+}
+
+// logListener logs where a listener accepts connections
+func logListener(config ListenerConfig, ln net.Listener) {
+	switch config.Mode {
+	case ListenerModeTLS:
+		common.Log("owlmail SMTPS Server running at %s%s", ln.Addr(), proxySuffix(config))
+	case ListenerModeStartTLS:
+		common.Log("owlmail SMTP Server running at %s (STARTTLS required)%s", ln.Addr(), proxySuffix(config))
+	default:
+		common.Log("owlmail SMTP Server running at %s%s", ln.Addr(), proxySuffix(config))
+	}
+}
+
+// proxySuffix notes in the startup log that a listener expects PROXY protocol headers
+func proxySuffix(config ListenerConfig) string {
+	if config.ProxyProtocol {
+		return " (PROXY protocol)"
+	}
+	return ""
+}
@@ -0,0 +1,127 @@
+package mailserver
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/emersion/go-smtp"
+	"github.com/soulteary/owlmail/internal/common"
+)
+
+// parseListenAddr splits a listen address into network and address.
+// Addresses of the form "unix:/path" or absolute paths are unix sockets;
+// everything else is a TCP host:port. Literal IPv4 and IPv6 hosts use tcp4
+// and tcp6, so that 0.0.0.0 and [::] can be bound on the same port.
+func parseListenAddr(addr string) (network, address string) {
+	if strings.HasPrefix(addr, "unix:") {
+		return "unix", strings.TrimPrefix(addr, "unix:")
+	}
+	if strings.HasPrefix(addr, "/") {
+		return "unix", addr
+	}
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		if ip := net.ParseIP(host); ip != nil {
+			if ip.To4() != nil {
+				return "tcp4", addr
+			}
+			return "tcp6", addr
+		}
+	}
+	return "tcp", addr
+}
+
+// listen opens a listener for network and address, removing a stale unix
+// socket first. Any other file at a socket path is left alone.
+func listen(network, address string) (net.Listener, error) {
+	if network == "unix" {
+		info, err := os.Lstat(address)
+		switch {
+		case err == nil && info.Mode()&os.ModeSocket == 0:
+			return nil, fmt.Errorf("cannot listen on %s: file exists and is not a socket", address)
+		case err == nil:
+			if err := os.Remove(address); err != nil {
+				return nil, fmt.Errorf("failed to remove stale socket %s: %w", address, err)
+			}
+		case !os.IsNotExist(err):
+			return nil, fmt.Errorf("failed to check socket %s: %w", address, err)
+		}
+	}
+	return net.Listen(network, address)
+}
+
+// SetLMTPAddr enables an LMTP listener on a TCP address or unix socket.
+// Only unix socket clients are trusted, see localLMTP. An empty address
+// disables LMTP.
+func (ms *MailServer) SetLMTPAddr(addr string) {
+	ms.lmtpAddr = addr
+	ms.lmtpServer = nil
+	if addr == "" {
+		return
+	}
+
+	s := smtp.NewServer(&Backend{mailServer: ms, lmtp: true})
+	s.Network, s.Addr = parseListenAddr(addr)
+	s.LMTP = true
+	s.Domain = ms.smtpServer.Domain
+	s.ReadTimeout = ms.smtpServer.ReadTimeout
+	s.WriteTimeout = ms.smtpServer.WriteTimeout
+	s.MaxMessageBytes = ms.smtpServer.MaxMessageBytes
+	s.MaxRecipients = ms.smtpServer.MaxRecipients
+	s.AllowInsecureAuth = true
+	ms.esmtpExtensions.apply(s)
+	ms.lmtpServer = s
+}
+
+// localLMTP reports whether conn is an LMTP delivery over a unix socket.
+// Such deliveries come from a trusted local agent and are exempt from the
+// access policy, authentication, rate limits and greylisting; LMTP clients
+// over TCP are treated like SMTP clients.
+func (b *Backend) localLMTP(conn net.Conn) bool {
+	return b.lmtp && conn != nil && conn.LocalAddr().Network() == "unix"
+}
+
+// GetLMTPAddr returns the LMTP listen address, or empty if LMTP is disabled
+func (ms *MailServer) GetLMTPAddr() string {
+	return ms.lmtpAddr
+}
+
+// listenLMTP starts the LMTP server in the background
+func (ms *MailServer) listenLMTP() error {
+	ln, err := listen(ms.lmtpServer.Network, ms.lmtpServer.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to start LMTP server: %w", err)
+	}
+	common.Log("owlmail LMTP Server running at %s", ms.lmtpAddr)
+	go func() {
+		if err := ms.serve(ms.lmtpServer, ln, false, false); err != nil {
+			common.Error("LMTP server error: %v", err)
+		}
+	}()
+	return nil
+}
+
+// LMTPData handles the DATA command over LMTP and reports a status per
+// recipient. The message is stored once, for the recipients that accept it.
+func (s *Session) LMTPData(r io.Reader, status smtp.StatusCollector) error {
+	recipients := s.to
+	s.lmtpRefused = make([]error, len(recipients))
+	defer func() {
+		s.lmtpRefused = nil
+	}()
+
+	_, err := s.deliver(r)
+	if err == nil {
+		s.countRate()
+	}
+	for i, rcpt := range recipients {
+		if s.lmtpRefused[i] != nil {
+			status.SetStatus(rcpt, s.lmtpRefused[i])
+		} else {
+			status.SetStatus(rcpt, err)
+		}
+	}
+	return err
+}
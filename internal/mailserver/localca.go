@@ -0,0 +1,286 @@
+package mailserver
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/soulteary/owlmail/internal/common"
+)
+
+const (
+	// localCADir is the subdirectory of the mail directory holding the local CA
+	localCADir = "ca"
+
+	localCACertFile     = "ca.pem"
+	localCAKeyFile      = "ca-key.pem"
+	localCAServerCert   = "server.pem"
+	localCAServerKey    = "server-key.pem"
+	localCAValidity     = 10 * 365 * 24 * time.Hour
+	localCALeafValidity = 397 * 24 * time.Hour // The longest lifetime browsers accept
+
+	// localCARenewBefore is how long before expiry a server certificate is reissued
+	localCARenewBefore = 30 * 24 * time.Hour
+)
+
+// DefaultLocalCAHosts are the names and addresses of certificates issued by
+// the local CA when none are configured
+var DefaultLocalCAHosts = []string{"localhost", "127.0.0.1", "::1"}
+
+// LocalCA is a certificate authority persisted in the mail directory. It
+// issues the server certificate used by the SMTP listeners and the web API,
+// so clients can trust the CA once and keep verifying certificates.
+type LocalCA struct {
+	dir     string
+	hosts   []string
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+
+	server      *tls.Certificate // Issued server certificate, loaded on first use
+	serverMutex sync.Mutex
+}
+
+// LoadLocalCA loads the local CA from the mail directory, creating it on first
+// use. hosts are the DNS names and IP addresses of the issued server
+// certificate; empty uses DefaultLocalCAHosts.
+func LoadLocalCA(mailDir string, hosts []string) (*LocalCA, error) {
+	if len(hosts) == 0 {
+		hosts = DefaultLocalCAHosts
+	}
+	trimmed := make([]string, len(hosts))
+	for i, host := range hosts {
+		if trimmed[i] = strings.TrimSpace(host); trimmed[i] == "" {
+			return nil, fmt.Errorf("invalid local CA host %q", host)
+		}
+	}
+
+	dir := filepath.Join(mailDir, localCADir)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create local CA directory: %w", err)
+	}
+	ca := &LocalCA{dir: dir, hosts: trimmed}
+
+	certPath := filepath.Join(dir, localCACertFile)
+	keyPath := filepath.Join(dir, localCAKeyFile)
+	pair, err := tls.LoadX509KeyPair(certPath, keyPath)
+	switch {
+	case err == nil:
+		key, ok := pair.PrivateKey.(*ecdsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("unsupported local CA key type %T", pair.PrivateKey)
+		}
+		ca.cert, ca.key = pair.Leaf, key
+		if ca.cert == nil {
+			if ca.cert, err = x509.ParseCertificate(pair.Certificate[0]); err != nil {
+				return nil, fmt.Errorf("failed to parse local CA certificate: %w", err)
+			}
+		}
+	case os.IsNotExist(err):
+		if err := ca.create(certPath, keyPath); err != nil {
+			return nil, err
+		}
+		common.Log("Created local CA %s", certPath)
+	default:
+		return nil, fmt.Errorf("failed to load local CA: %w", err)
+	}
+
+	ca.certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw})
+	return ca, nil
+}
+
+// create generates a new CA key and self-signed certificate
+func (ca *LocalCA) create(certPath, keyPath string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to generate local CA key: %w", err)
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return err
+	}
+
+	hostname, _ := os.Hostname()
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Organization: []string{"OwlMail"},
+			CommonName:   strings.TrimSpace("OwlMail Local CA " + hostname),
+		},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(localCAValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return fmt.Errorf("failed to create local CA certificate: %w", err)
+	}
+	if err := writeKeyPair(certPath, keyPath, der, key); err != nil {
+		return err
+	}
+
+	ca.key = key
+	ca.cert, err = x509.ParseCertificate(der)
+	return err
+}
+
+// CertPEM returns the PEM encoded CA certificate for clients to trust
+func (ca *LocalCA) CertPEM() []byte {
+	return ca.certPEM
+}
+
+// CertFile returns the path of the CA certificate
+func (ca *LocalCA) CertFile() string {
+	return filepath.Join(ca.dir, localCACertFile)
+}
+
+// Hosts returns the DNS names and IP addresses of the server certificate
+func (ca *LocalCA) Hosts() []string {
+	return ca.hosts
+}
+
+// ServerCertificate returns the server certificate issued for the configured
+// hosts. The certificate is persisted next to the CA and reissued when the
+// hosts change or it is about to expire, so clients may also pin it.
+func (ca *LocalCA) ServerCertificate() (tls.Certificate, error) {
+	ca.serverMutex.Lock()
+	defer ca.serverMutex.Unlock()
+
+	if ca.server != nil && ca.isCurrent(ca.server.Leaf) {
+		return *ca.server, nil
+	}
+
+	certPath := filepath.Join(ca.dir, localCAServerCert)
+	keyPath := filepath.Join(ca.dir, localCAServerKey)
+	if pair, err := tls.LoadX509KeyPair(certPath, keyPath); err == nil && ca.isCurrent(pair.Leaf) {
+		ca.server = &pair
+		return pair, nil
+	}
+
+	pair, err := ca.issue(certPath, keyPath)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	common.Log("Issued local CA certificate for %s", strings.Join(ca.hosts, ", "))
+	ca.server = &pair
+	return pair, nil
+}
+
+// isCurrent reports whether a server certificate was issued by this CA for
+// the configured hosts and is not about to expire
+func (ca *LocalCA) isCurrent(cert *x509.Certificate) bool {
+	if cert == nil || cert.CheckSignatureFrom(ca.cert) != nil {
+		return false
+	}
+	if time.Until(cert.NotAfter) < localCARenewBefore {
+		return false
+	}
+	dnsNames, ips := splitHosts(ca.hosts)
+	if len(dnsNames) != len(cert.DNSNames) || len(ips) != len(cert.IPAddresses) {
+		return false
+	}
+	for i, name := range dnsNames {
+		if !strings.EqualFold(name, cert.DNSNames[i]) {
+			return false
+		}
+	}
+	for i, ip := range ips {
+		if !ip.Equal(cert.IPAddresses[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// issue creates and persists a server certificate for the configured hosts
+func (ca *LocalCA) issue(certPath, keyPath string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to generate server key: %w", err)
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	dnsNames, ips := splitHosts(ca.hosts)
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Organization: []string{"OwlMail"},
+			CommonName:   ca.hosts[0],
+		},
+		NotBefore:   now.Add(-time.Hour),
+		NotAfter:    now.Add(localCALeafValidity),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:    dnsNames,
+		IPAddresses: ips,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to issue server certificate: %w", err)
+	}
+	if err := writeKeyPair(certPath, keyPath, der, key); err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.LoadX509KeyPair(certPath, keyPath)
+}
+
+// splitHosts separates IP addresses from DNS names
+func splitHosts(hosts []string) ([]string, []net.IP) {
+	var dnsNames []string
+	var ips []net.IP
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			ips = append(ips, ip)
+		} else {
+			dnsNames = append(dnsNames, host)
+		}
+	}
+	return dnsNames, ips
+}
+
+// randomSerial returns a random 128-bit certificate serial number
+func randomSerial() (*big.Int, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+	return serial, nil
+}
+
+// writeKeyPair writes a certificate and its private key as PEM files. The key
+// is written first, readable by the owner only.
+func writeKeyPair(certPath, keyPath string, der []byte, key *ecdsa.PrivateKey) error {
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("failed to marshal private key: %w", err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := os.WriteFile(keyPath, keyPEM, 0600); err != nil {
+		return fmt.Errorf("failed to write private key: %w", err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := os.WriteFile(certPath, certPEM, 0644); err != nil {
+		return fmt.Errorf("failed to write certificate: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,300 @@
+package mailserver
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-sasl"
+	"github.com/emersion/go-smtp"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// startTestSMTPServer serves the mail server's SMTP server on a random local port
+func startTestSMTPServer(t *testing.T, ms *MailServer) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go func() {
+		_ = ms.serve(ms.smtpServer, ln, false, false)
+	}()
+	t.Cleanup(func() {
+		_ = ms.Close()
+	})
+	return ln.Addr().String()
+}
+
+// cramMD5Client implements the client side of CRAM-MD5 for tests
+type cramMD5Client struct {
+	username string
+	password string
+}
+
+func (a *cramMD5Client) Start() (string, []byte, error) {
+	return authMechCramMD5, nil, nil
+}
+
+func (a *cramMD5Client) Next(challenge []byte) ([]byte, error) {
+	return []byte(a.username + " " + cramMD5Digest(a.password, string(challenge))), nil
+}
+
+func writeCredentialsFile(t *testing.T) string {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret1"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("Failed to hash password: %v", err)
+	}
+	content := "# test users\n" +
+		"alice:" + string(hash) + "\n" +
+		"\n" +
+		"bob:plainpass\n"
+	path := filepath.Join(t.TempDir(), "users.htpasswd")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("Failed to write credentials file: %v", err)
+	}
+	return path
+}
+
+func TestLoadCredentialsFile(t *testing.T) {
+	path := writeCredentialsFile(t)
+	users, err := LoadCredentialsFile(path)
+	if err != nil {
+		t.Fatalf("LoadCredentialsFile failed: %v", err)
+	}
+	if len(users) != 2 {
+		t.Fatalf("Expected 2 users, got %d", len(users))
+	}
+	if users["bob"] != "plainpass" {
+		t.Errorf("Expected bob's password 'plainpass', got %q", users["bob"])
+	}
+
+	// Invalid entry
+	badPath := filepath.Join(t.TempDir(), "bad.htpasswd")
+	if err := os.WriteFile(badPath, []byte("nocolon\n"), 0600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if _, err := LoadCredentialsFile(badPath); err == nil {
+		t.Error("Expected error for invalid entry")
+	}
+
+	// The default mailbox name is reserved for unauthenticated mail
+	reservedPath := filepath.Join(t.TempDir(), "reserved.htpasswd")
+	if err := os.WriteFile(reservedPath, []byte(DefaultMailbox+":secret\n"), 0600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if _, err := LoadCredentialsFile(reservedPath); err == nil {
+		t.Error("Expected error for the reserved default username")
+	}
+
+	// Missing file
+	if _, err := LoadCredentialsFile(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("Expected error for missing file")
+	}
+}
+
+func TestSMTPAuthConfigVerify(t *testing.T) {
+	config := &SMTPAuthConfig{
+		Username:  "admin",
+		Password:  "adminpass",
+		UsersFile: writeCredentialsFile(t),
+		Enabled:   true,
+	}
+	if err := config.LoadUsersFile(); err != nil {
+		t.Fatalf("LoadUsersFile failed: %v", err)
+	}
+
+	tests := []struct {
+		username string
+		password string
+		want     bool
+	}{
+		{"admin", "adminpass", true},
+		{"admin", "wrong", false},
+		{"alice", "secret1", true},
+		{"alice", "wrong", false},
+		{"bob", "plainpass", true},
+		{"bob", "wrong", false},
+		{"nobody", "secret1", false},
+	}
+	for _, tt := range tests {
+		if got := config.Verify(tt.username, tt.password); got != tt.want {
+			t.Errorf("Verify(%q, %q) = %v, want %v", tt.username, tt.password, got, tt.want)
+		}
+	}
+
+	if config.UserCount() != 3 {
+		t.Errorf("Expected 3 users, got %d", config.UserCount())
+	}
+
+	// bcrypt users cannot be used with CRAM-MD5
+	if _, ok := config.plaintextPassword("alice"); ok {
+		t.Error("plaintextPassword should not return bcrypt users")
+	}
+	if password, ok := config.plaintextPassword("bob"); !ok || password != "plainpass" {
+		t.Errorf("plaintextPassword(bob) = %q, %v", password, ok)
+	}
+}
+
+func TestNewMailServerWithInvalidUsersFile(t *testing.T) {
+	authConfig := &SMTPAuthConfig{
+		UsersFile: filepath.Join(t.TempDir(), "missing"),
+		Enabled:   true,
+	}
+	if _, err := NewMailServerWithConfig(1025, "localhost", t.TempDir(), nil, authConfig, nil); err == nil {
+		t.Error("Expected error for missing credentials file")
+	}
+}
+
+func TestSMTPAuthEndToEnd(t *testing.T) {
+	authConfig := &SMTPAuthConfig{
+		Username:  "admin",
+		Password:  "adminpass",
+		UsersFile: writeCredentialsFile(t),
+		Enabled:   true,
+	}
+	server, err := NewMailServerWithConfig(1025, "localhost", t.TempDir(), nil, authConfig, nil)
+	if err != nil {
+		t.Fatalf("Failed to create mail server: %v", err)
+	}
+	addr := startTestSMTPServer(t, server)
+
+	send := func(t *testing.T, auth sasl.Client) error {
+		t.Helper()
+		c, err := smtp.Dial(addr)
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		defer func() {
+			_ = c.Close()
+		}()
+		if auth != nil {
+			if err := c.Auth(auth); err != nil {
+				return err
+			}
+		}
+		return c.Mail("sender@example.com", nil)
+	}
+
+	t.Run("advertises mechanisms", func(t *testing.T) {
+		c, err := smtp.Dial(addr)
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		defer func() {
+			_ = c.Close()
+		}()
+		if err := c.Hello("client"); err != nil {
+			t.Fatalf("Hello failed: %v", err)
+		}
+		for _, mech := range []string{"PLAIN", "LOGIN", "CRAM-MD5"} {
+			if !c.SupportsAuth(mech) {
+				t.Errorf("Expected %s to be advertised", mech)
+			}
+		}
+	})
+
+	t.Run("unauthenticated", func(t *testing.T) {
+		err := send(t, nil)
+		var smtpErr *smtp.SMTPError
+		if !errors.As(err, &smtpErr) || smtpErr.Code != 530 {
+			t.Errorf("Expected 530 error, got %v", err)
+		}
+	})
+
+	t.Run("plain", func(t *testing.T) {
+		if err := send(t, sasl.NewPlainClient("", "alice", "secret1")); err != nil {
+			t.Errorf("PLAIN auth failed: %v", err)
+		}
+	})
+
+	t.Run("plain wrong password", func(t *testing.T) {
+		err := send(t, sasl.NewPlainClient("", "alice", "wrong"))
+		var smtpErr *smtp.SMTPError
+		if !errors.As(err, &smtpErr) || smtpErr.Code != 535 {
+			t.Errorf("Expected 535 error, got %v", err)
+		}
+	})
+
+	t.Run("login", func(t *testing.T) {
+		if err := send(t, sasl.NewLoginClient("admin", "adminpass")); err != nil {
+			t.Errorf("LOGIN auth failed: %v", err)
+		}
+	})
+
+	t.Run("cram-md5", func(t *testing.T) {
+		if err := send(t, &cramMD5Client{username: "bob", password: "plainpass"}); err != nil {
+			t.Errorf("CRAM-MD5 auth failed: %v", err)
+		}
+	})
+
+	t.Run("cram-md5 bcrypt user", func(t *testing.T) {
+		err := send(t, &cramMD5Client{username: "alice", password: "secret1"})
+		if err == nil || !strings.Contains(err.Error(), "535") {
+			t.Errorf("Expected 535 error for bcrypt user, got %v", err)
+		}
+	})
+}
+
+func TestSMTPAuthMailboxIsolation(t *testing.T) {
+	tmpDir := t.TempDir()
+	authConfig := &SMTPAuthConfig{
+		UsersFile: writeCredentialsFile(t),
+		Enabled:   true,
+	}
+	server, err := NewMailServerWithConfig(1025, "localhost", tmpDir, nil, authConfig, nil)
+	if err != nil {
+		t.Fatalf("Failed to create mail server: %v", err)
+	}
+	addr := startTestSMTPServer(t, server)
+
+	for _, user := range []struct{ name, password string }{{"alice", "secret1"}, {"bob", "plainpass"}} {
+		c, err := smtp.Dial(addr)
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		if err := c.Auth(sasl.NewPlainClient("", user.name, user.password)); err != nil {
+			t.Fatalf("Auth failed: %v", err)
+		}
+		msg := "Subject: hello " + user.name + "\r\n\r\nbody\r\n"
+		if err := c.SendMail("sender@example.com", []string{"to@example.com"}, strings.NewReader(msg)); err != nil {
+			t.Fatalf("SendMail failed: %v", err)
+		}
+		_ = c.Quit()
+	}
+
+	alice := server.GetAllEmailInMailbox("alice")
+	if len(alice) != 1 || alice[0].Envelope.User != "alice" || alice[0].Subject != "hello alice" {
+		t.Fatalf("Expected one email in alice's mailbox, got %d", len(alice))
+	}
+	if len(server.GetAllEmailInMailbox("")) != 2 {
+		t.Errorf("Expected 2 emails across all mailboxes")
+	}
+	if mailboxes := server.GetMailboxes(); mailboxes["alice"] != 1 || mailboxes["bob"] != 1 {
+		t.Errorf("Unexpected mailbox counts: %v", mailboxes)
+	}
+
+	// The mailbox survives a reload from disk
+	reloaded, err := NewMailServer(1025, "localhost", tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create mail server: %v", err)
+	}
+	defer func() {
+		_ = reloaded.Close()
+	}()
+	if bob := reloaded.GetAllEmailInMailbox("bob"); len(bob) != 1 || bob[0].Envelope.From != "sender@example.com" {
+		t.Errorf("Expected bob's email to be restored with its envelope, got %d emails", len(bob))
+	}
+
+	// Deleting an email removes its envelope file
+	if err := server.DeleteEmail(alice[0].ID); err != nil {
+		t.Fatalf("DeleteEmail failed: %v", err)
+	}
+	if _, err := os.Stat(server.envelopePath(alice[0].ID)); !os.IsNotExist(err) {
+		t.Errorf("Expected envelope file to be removed, got %v", err)
+	}
+}
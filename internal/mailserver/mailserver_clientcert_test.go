@@ -0,0 +1,211 @@
+package mailserver
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-smtp"
+)
+
+// testClientCA is a CA issuing client certificates for tests
+type testClientCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	file string // PEM file of the CA certificate
+}
+
+func newTestClientCA(t *testing.T) *testClientCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test Client CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create CA certificate: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	file := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatalf("Failed to write CA file: %v", err)
+	}
+	return &testClientCA{cert: cert, key: key, file: file}
+}
+
+// issue creates a client certificate for subject
+func (ca *testClientCA) issue(t *testing.T, subject pkix.Name) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      subject,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("Failed to create client certificate: %v", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// sendWithClientCert delivers a message over STARTTLS presenting cert
+func sendWithClientCert(addr string, cert *tls.Certificate) error {
+	config := &tls.Config{InsecureSkipVerify: true}
+	if cert != nil {
+		config.Certificates = []tls.Certificate{*cert}
+	}
+	c, err := smtp.DialStartTLS(addr, config)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = c.Close()
+	}()
+	return c.SendMail("service@example.com", []string{"rcpt@example.com"}, strings.NewReader("Subject: mtls\r\n\r\nbody\r\n"))
+}
+
+func TestClientCertificateAuthentication(t *testing.T) {
+	ca := newTestClientCA(t)
+	mapFile := filepath.Join(t.TempDir(), "certmap.json")
+	if err := os.WriteFile(mapFile, []byte(`{"CN=billing,O=Example Corp": "billing", "reports": "reports"}`), 0600); err != nil {
+		t.Fatalf("Failed to write map file: %v", err)
+	}
+
+	// Password AUTH is required, a mapped certificate replaces it
+	authConfig := &SMTPAuthConfig{Username: "admin", Password: "secret", Enabled: true}
+	tlsConfig := &TLSConfig{Enabled: true, ClientCAFile: ca.file, ClientCertMapFile: mapFile}
+	server, err := NewMailServerWithConfig(1025, "localhost", t.TempDir(), nil, authConfig, tlsConfig)
+	if err != nil {
+		t.Fatalf("Failed to create mail server: %v", err)
+	}
+	addr := startTestSMTPServer(t, server)
+
+	billing := ca.issue(t, pkix.Name{CommonName: "billing", Organization: []string{"Example Corp"}})
+	if err := sendWithClientCert(addr, &billing); err != nil {
+		t.Fatalf("Delivery with mapped certificate failed: %v", err)
+	}
+	reports := ca.issue(t, pkix.Name{CommonName: "reports", Organization: []string{"Other"}})
+	if err := sendWithClientCert(addr, &reports); err != nil {
+		t.Fatalf("Delivery with certificate mapped by CN failed: %v", err)
+	}
+
+	// A valid but unmapped certificate is recorded but does not authenticate
+	unmapped := ca.issue(t, pkix.Name{CommonName: "unknown"})
+	if err := sendWithClientCert(addr, &unmapped); err == nil {
+		t.Error("Expected unmapped certificate to require AUTH")
+	}
+	// Without a certificate the handshake succeeds in request mode, AUTH is still required
+	if err := sendWithClientCert(addr, nil); err == nil {
+		t.Error("Expected delivery without certificate to require AUTH")
+	}
+
+	billingMail := server.GetAllEmailInMailbox("billing")
+	if len(billingMail) != 1 {
+		t.Fatalf("Expected 1 email in billing mailbox, got %d", len(billingMail))
+	}
+	envelope := billingMail[0].Envelope
+	leaf, _ := x509.ParseCertificate(billing.Certificate[0])
+	if envelope.User != "billing" || envelope.ClientCertSubject != "CN=billing,O=Example Corp" || envelope.ClientCertFingerprint != certFingerprint(leaf) {
+		t.Errorf("Unexpected envelope: %+v", envelope)
+	}
+	if len(envelope.ClientCertFingerprint) != 64 {
+		t.Errorf("Expected SHA-256 hex fingerprint, got %q", envelope.ClientCertFingerprint)
+	}
+	if got := len(server.GetAllEmailInMailbox("reports")); got != 1 {
+		t.Errorf("Expected 1 email in reports mailbox, got %d", got)
+	}
+}
+
+func TestClientCertificateRequired(t *testing.T) {
+	ca := newTestClientCA(t)
+	tlsConfig := &TLSConfig{Enabled: true, ClientCAFile: ca.file, ClientAuth: ClientAuthRequire}
+	server, err := NewMailServerWithConfig(1025, "localhost", t.TempDir(), nil, nil, tlsConfig)
+	if err != nil {
+		t.Fatalf("Failed to create mail server: %v", err)
+	}
+	addr := startTestSMTPServer(t, server)
+
+	if err := sendWithClientCert(addr, nil); err == nil {
+		t.Error("Expected TLS handshake to fail without client certificate")
+	}
+
+	// Certificates from another CA are rejected
+	other := newTestClientCA(t).issue(t, pkix.Name{CommonName: "intruder"})
+	if err := sendWithClientCert(addr, &other); err == nil {
+		t.Error("Expected certificate from an unknown CA to be rejected")
+	}
+
+	cert := ca.issue(t, pkix.Name{CommonName: "service"})
+	if err := sendWithClientCert(addr, &cert); err != nil {
+		t.Fatalf("Delivery with client certificate failed: %v", err)
+	}
+	emails := server.GetAllEmail()
+	if len(emails) != 1 || emails[0].Envelope.ClientCertSubject != "CN=service" || emails[0].Mailbox != DefaultMailbox {
+		t.Fatalf("Unexpected emails: %+v", emails)
+	}
+	transcript, _ := server.GetEmailTranscript(emails[0].ID)
+	found := false
+	for _, entry := range transcript {
+		if strings.Contains(entry.Line, "client certificate CN=service") {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("Expected the client certificate in the TLS handshake event")
+	}
+}
+
+func TestClientCertificateConfigErrors(t *testing.T) {
+	ca := newTestClientCA(t)
+	invalidMap := filepath.Join(t.TempDir(), "map.json")
+	if err := os.WriteFile(invalidMap, []byte(`{"CN=x": ""}`), 0600); err != nil {
+		t.Fatalf("Failed to write map file: %v", err)
+	}
+	notPEM := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(notPEM, []byte("not a certificate"), 0600); err != nil {
+		t.Fatalf("Failed to write CA file: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		config TLSConfig
+	}{
+		{name: "mode without CA", config: TLSConfig{Enabled: true, ClientAuth: ClientAuthRequire}},
+		{name: "invalid mode", config: TLSConfig{Enabled: true, ClientCAFile: ca.file, ClientAuth: "always"}},
+		{name: "missing CA file", config: TLSConfig{Enabled: true, ClientCAFile: "/nonexistent/ca.pem"}},
+		{name: "CA file without certificates", config: TLSConfig{Enabled: true, ClientCAFile: notPEM}},
+		{name: "invalid map", config: TLSConfig{Enabled: true, ClientCAFile: ca.file, ClientCertMapFile: invalidMap}},
+	}
+	for _, tt := range tests {
+		config := tt.config
+		if _, err := NewMailServerWithConfig(1025, "localhost", t.TempDir(), nil, nil, &config); err == nil {
+			t.Errorf("%s: expected error", tt.name)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package mailserver
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-smtp"
+)
+
+// dkimTestMessage is signed with rsa-sha256 and relaxed canonicalization by an
+// independent signer; the matching public key is in dkimTestZone
+const dkimTestMessage = "DKIM-Signature: v=1; a=rsa-sha256; c=relaxed/relaxed;\r\n" +
+	" d=football.example.com; s=test; h=From : To : Subject;\r\n" +
+	" bh=2jUSOH9NhtVGCQWNr9BrIAPreKQjO6Sn7XIkfJVOzv8=;\r\n" +
+	" b=f6zcCfa88N3NN6wbmpGlY4peCGVIh5oseaei3pU0NkSDqPDQECllzzBBnGff\r\n" +
+	" +qx0AfqUP7FPZIc6elVqUdiDbQtw7oCt0Zb222TzgZDB1omWcWhWj7K7uKhAuJPmj+p0rH2y7leEdpGSjY4+41kVdkrugp0Zbq2026B+A3yK7OY=\r\n" +
+	"From: Joe SixPack <joe@football.example.com>\r\n" +
+	"To: Suzie Q <suzie@shopping.example.net>\r\n" +
+	"Subject:   Is dinner\r\n\tready?\r\n" +
+	"\r\n" +
+	"Hi.\r\n\r\nWe lost the game.  Are you hungry yet?\r\n\r\nJoe.\r\n\r\n\r\n"
+
+const dkimTestZone = `$ORIGIN example.com.
+; DKIM keys of the test domains
+test._domainkey.football  IN  TXT  ( "v=DKIM1; k=rsa; "
+                                     "p=MIGfMA0GCSqGSIb3DQEBAQUAA4GNADCBiQKBgQDVTnQYuHaJAuK5lNQcWoHQBrmXvSKYZBo3sIvRVZKRRUgd3SuJEcHBWdqxjhZx"
+                                     "BkZ6T6Lavj9v/xsQ2Lqc1qszZboG4flcRS0Dz7AfFYB578xFoBOI7KxsouBrl1cuFP9enyxomJXv4FKrQ4yzJhrOCYvMwtFQq+SJd0l0JrOQywIDAQAB" )
+revoked._domainkey.football 300 IN TXT "v=DKIM1; p="
+football  IN  A  192.0.2.1
+`
+
+func loadDKIMTestZone(t *testing.T) *ZoneFile {
+	t.Helper()
+	zone, err := ParseZoneFile(strings.NewReader(dkimTestZone))
+	if err != nil {
+		t.Fatalf("ParseZoneFile failed: %v", err)
+	}
+	return zone
+}
+
+// signEd25519 adds an ed25519-sha256 DKIM-Signature header to a message
+func signEd25519(t *testing.T, message string, key ed25519.PrivateKey, tags string) string {
+	t.Helper()
+	fields, body := splitMessage([]byte(message))
+	bh := crypto.SHA256.New()
+	bh.Write(canonicalBody(body, "simple"))
+	header := "DKIM-Signature: v=1; a=ed25519-sha256; d=example.org; s=ed; h=from:subject;" + tags +
+		" bh=" + base64.StdEncoding.EncodeToString(bh.Sum(nil)) + "; b="
+
+	sig, err := parseDKIMSignature(strings.SplitN(header, ":", 2)[1] + "AA==")
+	if err != nil {
+		t.Fatalf("parseDKIMSignature failed: %v", err)
+	}
+	h := crypto.SHA256.New()
+	writeSignedHeaders(h, fields, sig)
+	h.Write([]byte(header))
+	return header + base64.StdEncoding.EncodeToString(ed25519.Sign(key, h.Sum(nil))) + "\r\n" + message
+}
+
+// failingResolver fails every lookup
+type failingResolver struct{}
+
+func (failingResolver) LookupTXT(string) ([]string, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestVerifyDKIMIndependentSignature(t *testing.T) {
+	server, err := NewMailServer(1025, "localhost", t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create mail server: %v", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+	server.SetDNSResolver(loadDKIMTestZone(t))
+
+	tests := []struct {
+		name          string
+		message       string
+		result        string
+		bodyHashMatch bool
+		reason        string
+	}{
+		{name: "valid", message: dkimTestMessage, result: DKIMPass, bodyHashMatch: true},
+		{name: "bare LF line endings", message: strings.ReplaceAll(dkimTestMessage, "\r\n", "\n"), result: DKIMPass, bodyHashMatch: true},
+		{name: "relaxed whitespace changes", message: strings.Replace(dkimTestMessage, "Joe.", "Joe.   ", 1), result: DKIMPass, bodyHashMatch: true},
+		{name: "modified body", message: strings.Replace(dkimTestMessage, "lost", "won", 1), result: DKIMFail, reason: "body hash did not verify"},
+		{name: "modified header", message: strings.Replace(dkimTestMessage, "dinner", "lunch", 1), result: DKIMFail, bodyHashMatch: true, reason: "signature did not verify"},
+		{name: "added From header", message: strings.Replace(dkimTestMessage, "To:", "From: mallory@example.net\r\nTo:", 1), result: DKIMFail, bodyHashMatch: true},
+		{name: "unknown selector", message: strings.Replace(dkimTestMessage, "s=test", "s=other", 1), result: DKIMPermError, bodyHashMatch: true, reason: "no key for other._domainkey.football.example.com"},
+		{name: "revoked key", message: strings.Replace(dkimTestMessage, "s=test", "s=revoked", 1), result: DKIMPermError, bodyHashMatch: true, reason: "key revoked"},
+		{name: "From not signed", message: strings.Replace(dkimTestMessage, "h=From : To", "h=To", 1), result: DKIMPermError, reason: "From header is not signed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results := server.verifyDKIM([]byte(tt.message))
+			if len(results) != 1 {
+				t.Fatalf("Expected 1 result, got %d", len(results))
+			}
+			r := results[0]
+			if r.Result != tt.result || r.BodyHashMatch != tt.bodyHashMatch || !strings.Contains(r.Reason, tt.reason) {
+				t.Errorf("Unexpected result: %+v", r)
+			}
+			if r.Domain != "football.example.com" || r.Algorithm != "rsa-sha256" || r.Canonicalization != "relaxed/relaxed" {
+				t.Errorf("Unexpected signature details: %+v", r)
+			}
+		})
+	}
+
+	// Lookup failures are temporary
+	server.SetDNSResolver(failingResolver{})
+	if results := server.verifyDKIM([]byte(dkimTestMessage)); results[0].Result != DKIMTempError {
+		t.Errorf("Expected temperror, got %+v", results[0])
+	}
+	// Without a resolver no key is found
+	server.SetDNSResolver(nil)
+	if results := server.verifyDKIM([]byte(dkimTestMessage)); results[0].Result != DKIMPermError {
+		t.Errorf("Expected permerror, got %+v", results[0])
+	}
+	// Unsigned messages have no results
+	if results := server.verifyDKIM([]byte("From: a@example.com\r\n\r\nbody\r\n")); len(results) != 0 {
+		t.Errorf("Expected no results, got %+v", results)
+	}
+}
+
+func TestVerifyDKIMEd25519(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	zone, err := ParseZoneFile(strings.NewReader("ed._domainkey.example.org. TXT \"v=DKIM1; k=ed25519; t=y; p=" + base64.StdEncoding.EncodeToString(public) + "\"\n"))
+	if err != nil {
+		t.Fatalf("ParseZoneFile failed: %v", err)
+	}
+	server, err := NewMailServer(1025, "localhost", t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create mail server: %v", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+	server.SetDNSResolver(zone)
+
+	message := "From: a@example.org\r\nSubject: Hello\r\n\r\nSigned part\r\n"
+	signed := signEd25519(t, message, private, " l=13;")
+	results := server.verifyDKIM([]byte(signed))
+	if len(results) != 1 || results[0].Result != DKIMPass || !results[0].Testing || results[0].Canonicalization != "simple/simple" {
+		t.Fatalf("Unexpected results: %+v", results)
+	}
+
+	// Content appended after the signed length still passes
+	results = server.verifyDKIM([]byte(signed + "Unsigned footer\r\n"))
+	if results[0].Result != DKIMPass {
+		t.Errorf("Expected l= to ignore appended content, got %+v", results[0])
+	}
+
+	// Simple canonicalization does not tolerate whitespace changes
+	results = server.verifyDKIM([]byte(strings.Replace(signed, "Subject: Hello", "Subject:  Hello", 1)))
+	if results[0].Result != DKIMFail {
+		t.Errorf("Expected fail, got %+v", results[0])
+	}
+
+	// A key of the wrong type is unusable
+	rsaSigned := strings.Replace(signed, "a=ed25519-sha256", "a=rsa-sha256", 1)
+	if results = server.verifyDKIM([]byte(rsaSigned)); results[0].Result == DKIMPass {
+		t.Errorf("Expected the key type mismatch to fail, got %+v", results[0])
+	}
+}
+
+func TestDKIMResultsOnReceivedEmail(t *testing.T) {
+	server, err := NewMailServer(1025, "localhost", t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create mail server: %v", err)
+	}
+	server.SetDNSResolver(loadDKIMTestZone(t))
+	addr := startTestSMTPServer(t, server)
+
+	c, err := smtp.Dial(addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	if err := c.SendMail("joe@football.example.com", []string{"suzie@shopping.example.net"}, strings.NewReader(dkimTestMessage)); err != nil {
+		t.Fatalf("SendMail failed: %v", err)
+	}
+	_ = c.Quit()
+	emails := server.GetAllEmail()
+	if len(emails) != 1 {
+		t.Fatalf("Expected 1 email, got %d", len(emails))
+	}
+	if len(emails[0].DKIM) != 1 || emails[0].DKIM[0].Result != DKIMPass {
+		t.Errorf("Expected a passing DKIM signature, got %+v", emails[0].DKIM)
+	}
+
+	// Results of receipt are kept when emails are reloaded from disk, even without the keys
+	reloaded, err := NewMailServer(1025, "localhost", server.GetMailDir())
+	if err != nil {
+		t.Fatalf("Failed to reload mail server: %v", err)
+	}
+	defer func() {
+		_ = reloaded.Close()
+	}()
+	if emails := reloaded.GetAllEmail(); len(emails) != 1 || len(emails[0].DKIM) != 1 || emails[0].DKIM[0].Result != DKIMPass {
+		t.Errorf("Expected the passing signature after reload, got %+v", emails)
+	}
+}
+
+func TestParseZoneFile(t *testing.T) {
+	zone, err := ParseZoneFile(strings.NewReader(`$TTL 3600
+$ORIGIN example.com.
+@          IN  TXT  "v=spf1 -all"
+           IN  TXT  "second record"  ; same owner
+mail       IN  MX   10 mx.example.com.
+_dmarc     TXT  v=DMARC1; p=reject
+sel._domainkey.other.org.  TXT  ( "a=\"quoted\"; "
+    "b" )
+`))
+	if err != nil {
+		t.Fatalf("ParseZoneFile failed: %v", err)
+	}
+	tests := []struct {
+		name    string
+		records []string
+	}{
+		{"example.com", []string{"v=spf1 -all", "second record"}},
+		{"EXAMPLE.com.", []string{"v=spf1 -all", "second record"}},
+		{"_dmarc.example.com", []string{"v=DMARC1"}},
+		{"sel._domainkey.other.org", []string{`a="quoted"; b`}},
+	}
+	for _, tt := range tests {
+		records, err := zone.LookupTXT(tt.name)
+		if err != nil || strings.Join(records, "|") != strings.Join(tt.records, "|") {
+			t.Errorf("LookupTXT(%q) = %q, %v; expected %q", tt.name, records, err, tt.records)
+		}
+	}
+	if _, err := zone.LookupTXT("mail.example.com"); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("Expected ErrRecordNotFound for a name without TXT records, got %v", err)
+	}
+	if hosts, err := zone.LookupMX("mail.example.com"); err != nil || strings.Join(hosts, ",") != "mx.example.com" {
+		t.Errorf("LookupMX = %v, %v; expected mx.example.com", hosts, err)
+	}
+	if zone.Len() != 4 {
+		t.Errorf("Expected 4 names, got %d", zone.Len())
+	}
+
+	for _, invalid := range []string{"name TXT ( \"unbalanced\"\n", "name TXT \"unterminated\n"} {
+		if _, err := ParseZoneFile(strings.NewReader(invalid)); err == nil {
+			t.Errorf("Expected error for %q", invalid)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package mailserver
+
+import (
+	"crypto/tls"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-smtp"
+)
+
+func TestParseESMTPExtensions(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    ESMTPExtensions
+		wantErr bool
+	}{
+		{spec: "SMTPUTF8,BINARYMIME,REQUIRETLS,DSN", want: DefaultESMTPExtensions()},
+		{spec: " smtputf8 , dsn", want: ESMTPExtensions{SMTPUTF8: true, DSN: true}},
+		{spec: "none", want: ESMTPExtensions{}},
+		{spec: "SMTPUTF8,PIPELINING", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseESMTPExtensions(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseESMTPExtensions(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseESMTPExtensions(%q) = %+v, want %+v", tt.spec, got, tt.want)
+		}
+	}
+
+	if names := DefaultESMTPExtensions().Names(); strings.Join(names, ",") != "SMTPUTF8,BINARYMIME,REQUIRETLS,DSN" {
+		t.Errorf("Unexpected extension names: %v", names)
+	}
+}
+
+func TestESMTPParametersOnEnvelope(t *testing.T) {
+	server, err := NewMailServerWithConfig(1025, "localhost", t.TempDir(), nil, nil, &TLSConfig{Enabled: true})
+	if err != nil {
+		t.Fatalf("Failed to create mail server: %v", err)
+	}
+	addr := startTestSMTPServer(t, server)
+
+	c, err := smtp.DialStartTLS(addr, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatalf("DialStartTLS failed: %v", err)
+	}
+	defer func() {
+		_ = c.Close()
+	}()
+	for _, ext := range []string{"SMTPUTF8", "BINARYMIME", "REQUIRETLS", "DSN"} {
+		if ok, _ := c.Extension(ext); !ok {
+			t.Errorf("Expected %s to be advertised", ext)
+		}
+	}
+
+	body := "Subject: dsn\r\n\r\nbody\r\n"
+	err = c.Mail("sender@example.com", &smtp.MailOptions{
+		Size:       int64(len(body)),
+		Body:       smtp.Body8BitMIME,
+		Return:     smtp.DSNReturnHeaders,
+		EnvelopeID: "QQ314159",
+		UTF8:       true,
+		RequireTLS: true,
+	})
+	if err != nil {
+		t.Fatalf("Mail failed: %v", err)
+	}
+	if err := c.Rcpt("one@example.com", &smtp.RcptOptions{
+		Notify:                []smtp.DSNNotify{smtp.DSNNotifySuccess, smtp.DSNNotifyFailure},
+		OriginalRecipientType: smtp.DSNAddressTypeRFC822,
+		OriginalRecipient:     "alias@example.com",
+	}); err != nil {
+		t.Fatalf("Rcpt failed: %v", err)
+	}
+	if err := c.Rcpt("二@例子.测试", nil); err != nil {
+		t.Fatalf("Rcpt failed: %v", err)
+	}
+	w, err := c.Data()
+	if err != nil {
+		t.Fatalf("Data failed: %v", err)
+	}
+	_, _ = w.Write([]byte(body))
+	if err := w.Close(); err != nil {
+		t.Fatalf("Data close failed: %v", err)
+	}
+
+	emails := server.GetAllEmail()
+	if len(emails) != 1 {
+		t.Fatalf("Expected 1 email, got %d", len(emails))
+	}
+	envelope := emails[0].Envelope
+	if envelope.Size != int64(len(body)) || envelope.Body != "8BITMIME" || envelope.Return != "HDRS" ||
+		envelope.EnvelopeID != "QQ314159" || !envelope.SMTPUTF8 || !envelope.RequireTLS {
+		t.Errorf("Unexpected MAIL FROM parameters: %+v", envelope)
+	}
+	want := []RecipientOptions{
+		{Address: "one@example.com", Notify: []string{"SUCCESS", "FAILURE"}, ORcpt: "RFC822;alias@example.com"},
+		{Address: "二@例子.测试"},
+	}
+	if !reflect.DeepEqual(envelope.Recipients, want) {
+		t.Errorf("Recipients = %+v, want %+v", envelope.Recipients, want)
+	}
+
+	// The parameters survive a reload from disk
+	reloaded, err := NewMailServer(1025, "localhost", server.mailDir)
+	if err != nil {
+		t.Fatalf("Failed to create mail server: %v", err)
+	}
+	defer func() {
+		_ = reloaded.Close()
+	}()
+	restored := reloaded.GetAllEmail()
+	if len(restored) != 1 || restored[0].Envelope.EnvelopeID != "QQ314159" || !reflect.DeepEqual(restored[0].Envelope.Recipients, want) {
+		t.Errorf("ESMTP parameters not restored: %+v", restored)
+	}
+}
+
+func TestESMTPExtensionsDisabled(t *testing.T) {
+	server, err := NewMailServer(1025, "localhost", t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create mail server: %v", err)
+	}
+	server.SetESMTPExtensions(ESMTPExtensions{DSN: true})
+	if server.smtpServer.EnableSMTPUTF8 || !server.smtpServer.EnableDSN {
+		t.Error("Expected extensions to be applied to the SMTP listener")
+	}
+	server.SetLMTPAddr("127.0.0.1:0")
+	if server.lmtpServer.EnableSMTPUTF8 || !server.lmtpServer.EnableDSN {
+		t.Error("Expected extensions to be applied to the LMTP server")
+	}
+	addr := startTestSMTPServer(t, server)
+
+	c, err := smtp.Dial(addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer func() {
+		_ = c.Close()
+	}()
+	if err := c.Hello("client.example.com"); err != nil {
+		t.Fatalf("Hello failed: %v", err)
+	}
+	for ext, want := range map[string]bool{"SMTPUTF8": false, "BINARYMIME": false, "REQUIRETLS": false, "DSN": true} {
+		if ok, _ := c.Extension(ext); ok != want {
+			t.Errorf("Extension %s advertised = %v, want %v", ext, ok, want)
+		}
+	}
+	if err := c.Mail("sender@example.com", &smtp.MailOptions{UTF8: true}); err == nil {
+		t.Error("Expected SMTPUTF8 to be refused when disabled")
+	}
+}
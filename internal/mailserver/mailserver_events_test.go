@@ -1,6 +1,8 @@
 package mailserver
 
 import (
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 )
@@ -34,3 +36,100 @@ func TestMailServerOn(t *testing.T) {
 		t.Error("Event handler should have been called")
 	}
 }
+
+func TestMailServerEmitOrder(t *testing.T) {
+	server, err := NewMailServer(1025, "localhost", t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create mail server: %v", err)
+	}
+	defer func() {
+		if err := server.Close(); err != nil {
+			t.Errorf("Failed to close server: %v", err)
+		}
+	}()
+
+	fired := make(chan string, 6)
+	server.On("new", func(email *Email) {
+		fired <- "new:" + email.ID
+	})
+	server.On("delete", func(email *Email) {
+		fired <- "delete:" + email.ID
+	})
+	for _, id := range []string{"a", "b", "c"} {
+		server.emit("new", &Email{ID: id})
+		server.emit("delete", &Email{ID: id})
+	}
+
+	var got []string
+	for range 6 {
+		select {
+		case event := <-fired:
+			got = append(got, event)
+		case <-time.After(time.Second):
+			t.Fatalf("Expected 6 events, got %v", got)
+		}
+	}
+	want := []string{"new:a", "delete:a", "new:b", "delete:b", "new:c", "delete:c"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected handlers to run in emit order %v, got %v", want, got)
+	}
+}
+
+func TestMailServerEmitAfterClose(t *testing.T) {
+	server, err := NewMailServer(1025, "localhost", t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create mail server: %v", err)
+	}
+	if err := server.Close(); err != nil {
+		t.Fatalf("Failed to close server: %v", err)
+	}
+	// Events emitted after Close are dropped instead of panicking
+	server.emit("new", &Email{ID: "late"})
+}
+
+func TestMailServerEmitSlowListener(t *testing.T) {
+	server, err := NewMailServer(1025, "localhost", t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create mail server: %v", err)
+	}
+	defer func() {
+		if err := server.Close(); err != nil {
+			t.Errorf("Failed to close server: %v", err)
+		}
+	}()
+
+	// A stalled listener holds up the dispatcher until released
+	const count = 1000
+	release := make(chan struct{})
+	received := make(chan string, count)
+	server.On("new", func(email *Email) {
+		<-release
+		received <- email.ID
+	})
+
+	done := make(chan struct{})
+	go func() {
+		for i := range count {
+			server.emit("new", &Email{ID: strconv.Itoa(i)})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("emit blocked on a slow listener")
+	}
+
+	// Every event is delivered, in order
+	close(release)
+	for i := range count {
+		select {
+		case id := <-received:
+			if id != strconv.Itoa(i) {
+				t.Fatalf("Expected event %d, got %s", i, id)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Event %d was not delivered", i)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package mailserver
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-smtp"
+)
+
+func TestFaultRuleValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    FaultRule
+		wantErr bool
+	}{
+		{"valid rcpt", FaultRule{Stage: FaultStageRcpt, Match: "bounce-*@example.com", Code: 550, EnhancedCode: "5.1.1"}, false},
+		{"valid delay only", FaultRule{Stage: FaultStageMail, Delay: "10ms"}, false},
+		{"valid size", FaultRule{Stage: FaultStageData, MinSizeKB: 10, Code: 552}, false},
+		{"invalid stage", FaultRule{Stage: "helo", Code: 550}, true},
+		{"invalid code", FaultRule{Stage: FaultStageMail, Code: 250}, true},
+		{"invalid probability", FaultRule{Stage: FaultStageMail, Code: 451, Probability: 1.5}, true},
+		{"size on rcpt", FaultRule{Stage: FaultStageRcpt, Code: 552, MinSizeKB: 1}, true},
+		{"invalid delay", FaultRule{Stage: FaultStageMail, Delay: "soon"}, true},
+		{"invalid enhanced code", FaultRule{Stage: FaultStageMail, Code: 550, EnhancedCode: "5.1"}, true},
+		{"no effect", FaultRule{Stage: FaultStageMail}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.rule.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadFaultRulesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "faults.json")
+	content := `[{"name": "bounce", "stage": "rcpt", "match": "bounce-*@example.com", "code": 550}]`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	rules, err := LoadFaultRulesFile(path)
+	if err != nil {
+		t.Fatalf("LoadFaultRulesFile failed: %v", err)
+	}
+	if len(rules) != 1 || rules[0].Name != "bounce" || rules[0].Code != 550 {
+		t.Errorf("Unexpected rules: %+v", rules)
+	}
+
+	if err := os.WriteFile(path, []byte("not json"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if _, err := LoadFaultRulesFile(path); err == nil {
+		t.Error("Expected error for invalid JSON")
+	}
+}
+
+func TestSetFaultRulesRejectsInvalid(t *testing.T) {
+	server, err := NewMailServer(1025, "localhost", t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create mail server: %v", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+
+	if err := server.SetFaultRules([]FaultRule{{Stage: "bogus", Code: 550}}); err == nil {
+		t.Error("Expected error for invalid rule")
+	}
+	if len(server.GetFaultRules()) != 0 {
+		t.Error("Invalid rules should not be applied")
+	}
+}
+
+func TestInjectFault(t *testing.T) {
+	server, err := NewMailServer(1025, "localhost", t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create mail server: %v", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+
+	rules := []FaultRule{
+		{Name: "bounce", Stage: FaultStageRcpt, Match: "bounce-*@example.com", Code: 550, EnhancedCode: "5.1.1", Message: "User unknown"},
+		{Name: "big", Stage: FaultStageData, MinSizeKB: 1, Code: 552},
+		{Name: "never", Stage: FaultStageMail, Code: 451, Probability: 0.0000001},
+		{Name: "slow", Stage: FaultStageMail, Match: "slow@example.com", Delay: "50ms"},
+	}
+	if err := server.SetFaultRules(rules); err != nil {
+		t.Fatalf("SetFaultRules failed: %v", err)
+	}
+
+	var smtpErr *smtp.SMTPError
+	err = server.injectFault(FaultStageRcpt, "Bounce-42@Example.com", 0)
+	if !errors.As(err, &smtpErr) || smtpErr.Code != 550 || smtpErr.EnhancedCode != (smtp.EnhancedCode{5, 1, 1}) || smtpErr.Message != "User unknown" {
+		t.Errorf("Expected 550 5.1.1 User unknown, got %v", err)
+	}
+	if err := server.injectFault(FaultStageRcpt, "user@example.com", 0); err != nil {
+		t.Errorf("Expected no fault for regular recipient, got %v", err)
+	}
+
+	if err := server.injectFault(FaultStageData, "sender@example.com", 1024); err != nil {
+		t.Errorf("Expected no fault at the size limit, got %v", err)
+	}
+	if err := server.injectFault(FaultStageData, "sender@example.com", 1025); !errors.As(err, &smtpErr) || smtpErr.Code != 552 {
+		t.Errorf("Expected 552 for oversize message, got %v", err)
+	}
+
+	start := time.Now()
+	if err := server.injectFault(FaultStageMail, "slow@example.com", 0); err != nil {
+		t.Errorf("Delay-only rule should not fail, got %v", err)
+	}
+	if time.Since(start) < 50*time.Millisecond {
+		t.Error("Expected delay to be applied")
+	}
+}
+
+func TestFaultInjectionEndToEnd(t *testing.T) {
+	server, err := NewMailServer(1025, "localhost", t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create mail server: %v", err)
+	}
+	rules := []FaultRule{
+		{Stage: FaultStageRcpt, Match: "bounce-*@example.com", Code: 550, EnhancedCode: "5.1.1", Message: "User unknown"},
+		{Stage: FaultStageData, MinSizeKB: 1, Code: 552, EnhancedCode: "5.3.4", Message: "Message too big"},
+	}
+	if err := server.SetFaultRules(rules); err != nil {
+		t.Fatalf("SetFaultRules failed: %v", err)
+	}
+	addr := startTestSMTPServer(t, server)
+
+	c, err := smtp.Dial(addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer func() {
+		_ = c.Close()
+	}()
+
+	var smtpErr *smtp.SMTPError
+	err = c.SendMail("sender@example.com", []string{"bounce-1@example.com"}, strings.NewReader("Subject: x\r\n\r\nx\r\n"))
+	if !errors.As(err, &smtpErr) || smtpErr.Code != 550 {
+		t.Fatalf("Expected 550 on RCPT, got %v", err)
+	}
+	if err := c.Reset(); err != nil {
+		t.Fatalf("RSET failed: %v", err)
+	}
+
+	body := "Subject: big\r\n\r\n" + strings.Repeat(strings.Repeat("x", 70)+"\r\n", 30)
+	err = c.SendMail("sender@example.com", []string{"user@example.com"}, strings.NewReader(body))
+	if !errors.As(err, &smtpErr) || smtpErr.Code != 552 {
+		t.Fatalf("Expected 552 on DATA, got %v", err)
+	}
+	if err := c.Reset(); err != nil {
+		t.Fatalf("RSET failed: %v", err)
+	}
+
+	if err := c.SendMail("sender@example.com", []string{"user@example.com"}, strings.NewReader("Subject: ok\r\n\r\nok\r\n")); err != nil {
+		t.Fatalf("Expected small message to be accepted, got %v", err)
+	}
+
+	emails := server.GetAllEmail()
+	if len(emails) != 1 || emails[0].Subject != "ok" {
+		t.Fatalf("Expected only the accepted email to be stored, got %d", len(emails))
+	}
+	files, _ := filepath.Glob(filepath.Join(server.mailDir, "*.eml"))
+	if len(files) != 1 {
+		t.Errorf("Expected rejected message files to be removed, found %d .eml files", len(files))
+	}
+}
@@ -0,0 +1,190 @@
+package mailserver
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-sasl"
+	"github.com/emersion/go-smtp"
+)
+
+func TestGreylistConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  GreylistConfig
+		wantErr bool
+	}{
+		{"defaults", GreylistConfig{Enabled: true, Delay: DefaultGreylistDelay, Expiry: DefaultGreylistExpiry}, false},
+		{"no expiry", GreylistConfig{Enabled: true, Delay: time.Minute}, false},
+		{"negative delay", GreylistConfig{Delay: -time.Second}, true},
+		{"negative expiry", GreylistConfig{Expiry: -time.Second}, true},
+		{"expiry shorter than delay", GreylistConfig{Delay: time.Hour, Expiry: time.Minute}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckGreylist(t *testing.T) {
+	server, err := NewMailServer(1025, "localhost", t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create mail server: %v", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+
+	// Disabled by default
+	if err := server.checkGreylist("192.0.2.1", "a@example.com", "b@example.com"); err != nil {
+		t.Fatalf("Expected greylisting to be disabled by default, got %v", err)
+	}
+	if len(server.GetGreylistEntries()) != 0 {
+		t.Fatal("Disabled greylist should not record triplets")
+	}
+
+	if err := server.SetGreylistConfig(GreylistConfig{Enabled: true, Delay: 50 * time.Millisecond, Expiry: time.Hour}); err != nil {
+		t.Fatalf("SetGreylistConfig failed: %v", err)
+	}
+
+	var smtpErr *smtp.SMTPError
+	err = server.checkGreylist("192.0.2.1", "a@example.com", "b@example.com")
+	if !errors.As(err, &smtpErr) || smtpErr.Code != 451 || smtpErr.EnhancedCode != (smtp.EnhancedCode{4, 7, 1}) {
+		t.Fatalf("Expected 451 4.7.1 for a new triplet, got %v", err)
+	}
+
+	// An early retry is still rejected
+	if err := server.checkGreylist("192.0.2.1", "A@example.com", "b@example.com"); err == nil {
+		t.Fatal("Expected retry before the delay to be rejected")
+	}
+
+	// A different triplet is tracked separately
+	if err := server.checkGreylist("192.0.2.2", "a@example.com", "b@example.com"); err == nil {
+		t.Fatal("Expected new client IP to be greylisted")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if err := server.checkGreylist("192.0.2.1", "a@example.com", "b@example.com"); err != nil {
+		t.Fatalf("Expected retry after the delay to be accepted, got %v", err)
+	}
+
+	entries := server.GetGreylistEntries()
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 triplets, got %d", len(entries))
+	}
+	first := entries[0]
+	if first.ClientIP != "192.0.2.1" || first.Attempts != 3 || !first.Passed {
+		t.Errorf("Unexpected first entry: %+v", first)
+	}
+	if entries[1].Passed {
+		t.Error("Second triplet should not have passed")
+	}
+
+	server.ClearGreylist()
+	if len(server.GetGreylistEntries()) != 0 {
+		t.Error("Expected greylist to be cleared")
+	}
+}
+
+func TestGreylistExpiry(t *testing.T) {
+	server, err := NewMailServer(1025, "localhost", t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create mail server: %v", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+	if err := server.SetGreylistConfig(GreylistConfig{Enabled: true, Delay: 0, Expiry: 30 * time.Millisecond}); err != nil {
+		t.Fatalf("SetGreylistConfig failed: %v", err)
+	}
+
+	if err := server.checkGreylist("192.0.2.1", "a@example.com", "b@example.com"); err == nil {
+		t.Fatal("Expected first attempt to be rejected even with a zero delay")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if err := server.checkGreylist("192.0.2.1", "a@example.com", "b@example.com"); err == nil {
+		t.Fatal("Expected expired triplet to be greylisted again")
+	}
+	if err := server.checkGreylist("192.0.2.1", "a@example.com", "b@example.com"); err != nil {
+		t.Fatalf("Expected immediate retry to pass with a zero delay, got %v", err)
+	}
+}
+
+func TestGreylistEndToEnd(t *testing.T) {
+	server, err := NewMailServer(1025, "localhost", t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create mail server: %v", err)
+	}
+	if err := server.SetGreylistConfig(GreylistConfig{Enabled: true, Delay: 50 * time.Millisecond}); err != nil {
+		t.Fatalf("SetGreylistConfig failed: %v", err)
+	}
+	addr := startTestSMTPServer(t, server)
+
+	send := func() error {
+		c, err := smtp.Dial(addr)
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		defer func() {
+			_ = c.Close()
+		}()
+		return c.SendMail("sender@example.com", []string{"user@example.com"}, strings.NewReader("Subject: retry\r\n\r\nbody\r\n"))
+	}
+
+	var smtpErr *smtp.SMTPError
+	if err := send(); !errors.As(err, &smtpErr) || smtpErr.Code != 451 {
+		t.Fatalf("Expected 451 on first attempt, got %v", err)
+	}
+	if len(server.GetAllEmail()) != 0 {
+		t.Fatal("Greylisted message should not be stored")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if err := send(); err != nil {
+		t.Fatalf("Expected retry to be accepted, got %v", err)
+	}
+	if len(server.GetAllEmail()) != 1 {
+		t.Fatal("Expected retried message to be stored")
+	}
+
+	entries := server.GetGreylistEntries()
+	if len(entries) != 1 || entries[0].ClientIP != "127.0.0.1" || entries[0].Sender != "sender@example.com" {
+		t.Errorf("Unexpected triplet table: %+v", entries)
+	}
+}
+
+func TestGreylistAuthenticated(t *testing.T) {
+	authConfig := &SMTPAuthConfig{Username: "tester", Password: "secret", Enabled: true}
+	server, err := NewMailServerWithConfig(1025, "localhost", t.TempDir(), nil, authConfig, nil)
+	if err != nil {
+		t.Fatalf("Failed to create mail server: %v", err)
+	}
+	if err := server.SetGreylistConfig(GreylistConfig{Enabled: true, Delay: time.Minute}); err != nil {
+		t.Fatalf("SetGreylistConfig failed: %v", err)
+	}
+	addr := startTestSMTPServer(t, server)
+
+	c, err := smtp.Dial(addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer func() {
+		_ = c.Close()
+	}()
+	if err := c.Auth(sasl.NewPlainClient("", "tester", "secret")); err != nil {
+		t.Fatalf("Auth failed: %v", err)
+	}
+
+	// Authenticated submissions are greylisted too
+	err = c.SendMail("sender@example.com", []string{"user@example.com"}, strings.NewReader("Subject: auth\r\n\r\nbody\r\n"))
+	var smtpErr *smtp.SMTPError
+	if !errors.As(err, &smtpErr) || smtpErr.Code != 451 {
+		t.Fatalf("Expected 451 for an authenticated first attempt, got %v", err)
+	}
+}
@@ -0,0 +1,41 @@
+package mailserver
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseHeaderFields(t *testing.T) {
+	raw := "Received: from a.example.com\r\n" +
+		"Received: from b.example.com\r\n" +
+		"\tby mx.example.com\r\n" +
+		"From: from@example.com\r\n" +
+		"Subject: =?utf-8?q?Caf=C3=A9?= menu\r\n" +
+		"X-Tracking-ID: order-42\r\n" +
+		"Message-Id: <1@example.com>\r\n" +
+		"X-Broken: =?unknown-charset?q?abc?=\r\n" +
+		"\r\n" +
+		"Hello\r\n"
+	email := parseTestMessage(t, "headers", raw)
+
+	expected := []HeaderField{
+		{Name: "Received", Value: "from a.example.com", Decoded: "from a.example.com"},
+		{Name: "Received", Value: "from b.example.com by mx.example.com", Decoded: "from b.example.com by mx.example.com"},
+		{Name: "From", Value: "from@example.com", Decoded: "from@example.com"},
+		{Name: "Subject", Value: "=?utf-8?q?Caf=C3=A9?= menu", Decoded: "Café menu"},
+		{Name: "X-Tracking-ID", Value: "order-42", Decoded: "order-42"},
+		{Name: "Message-Id", Value: "<1@example.com>", Decoded: "<1@example.com>"},
+		{Name: "X-Broken", Value: "=?unknown-charset?q?abc?=", Decoded: "=?unknown-charset?q?abc?="},
+	}
+	if !reflect.DeepEqual(email.HeaderFields, expected) {
+		t.Errorf("Unexpected header fields:\n got %+v\nwant %+v", email.HeaderFields, expected)
+	}
+
+	// Well-known headers keep their usual keys; repeated headers are lists
+	if email.Headers["Message-ID"] != "<1@example.com>" || email.Headers["X-Tracking-ID"] != "order-42" {
+		t.Errorf("Unexpected headers: %v", email.Headers)
+	}
+	if received, ok := email.Headers["Received"].([]string); !ok || len(received) != 2 {
+		t.Errorf("Expected both Received headers, got %v", email.Headers["Received"])
+	}
+}
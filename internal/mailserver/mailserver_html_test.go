@@ -0,0 +1,63 @@
+package mailserver
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRewriteCIDs(t *testing.T) {
+	attachments := []*Attachment{
+		{ContentID: "logo@example.com", GeneratedFileName: "logo.png"},
+		{ContentID: "bg part", GeneratedFileName: "bg.gif"},
+		{FileName: "report.pdf", GeneratedFileName: "report.pdf"},
+	}
+	html := `<img src="cid:logo@example.com"><img src='CID:Logo@Example.com'>` +
+		`<td style="background: url(cid:bg%20part)"><img src="cid:missing@example.com">cid:logo@example.com`
+	got := rewriteCIDs(html, attachments, func(attachment *Attachment) string {
+		return "/files/" + attachment.GeneratedFileName
+	})
+	expected := `<img src="/files/logo.png"><img src='/files/logo.png'>` +
+		`<td style="background: url(/files/bg.gif)"><img src="cid:missing@example.com">cid:logo@example.com`
+	if got != expected {
+		t.Errorf("Unexpected rewrite:\n got %s\nwant %s", got, expected)
+	}
+}
+
+func TestGetEmailHTMLInlineImages(t *testing.T) {
+	server, err := NewMailServer(1025, "localhost", t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create mail server: %v", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+	if _, err := server.parseEmail("inline", strings.NewReader(nestedMessage), nil, true, false); err != nil {
+		t.Fatalf("Failed to parse email: %v", err)
+	}
+	image := server.store[0].Attachments[0]
+
+	html, err := server.GetEmailHTML("inline")
+	if err != nil {
+		t.Fatalf("GetEmailHTML failed: %v", err)
+	}
+	if want := `<img src="/api/v1/emails/inline/attachments/` + image.GeneratedFileName + `">`; !strings.Contains(html, want) {
+		t.Errorf("Expected %s in %s", want, html)
+	}
+
+	embedded, err := server.GetEmailHTMLEmbedded("inline")
+	if err != nil {
+		t.Fatalf("GetEmailHTMLEmbedded failed: %v", err)
+	}
+	if want := `<img src="data:image/png;base64,iVBORw0KGgo=">`; !strings.Contains(embedded, want) {
+		t.Errorf("Expected %s in %s", want, embedded)
+	}
+
+	// Inline parts keep their name when the email is reloaded from disk
+	reloaded, err := server.parseMessage("inline", strings.NewReader(nestedMessage), false)
+	if err != nil {
+		t.Fatalf("Failed to parse email: %v", err)
+	}
+	if reloaded.Attachments[0].GeneratedFileName != image.GeneratedFileName {
+		t.Errorf("Expected %s after reload, got %q", image.GeneratedFileName, reloaded.Attachments[0].GeneratedFileName)
+	}
+}
@@ -0,0 +1,236 @@
+package mailserver
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-smtp"
+)
+
+// sendTestMail sends a single message over a new SMTP connection
+func sendTestMail(addr, subject string) error {
+	c, err := smtp.Dial(addr)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = c.Close()
+	}()
+	body := "Subject: " + subject + "\r\n\r\nqueued body\r\n"
+	if err := c.SendMail("sender@example.com", []string{"user@example.com"}, strings.NewReader(body)); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// waitForIngest polls until the ingest stats satisfy ok
+func waitForIngest(t *testing.T, ms *MailServer, ok func(IngestStats) bool) IngestStats {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		stats := ms.GetIngestStats()
+		if ok(stats) {
+			return stats
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for ingest stats, got %+v", stats)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestIngestConfigValidate(t *testing.T) {
+	server, err := NewMailServer(1025, "localhost", t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create mail server: %v", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+
+	for _, config := range []IngestConfig{{Workers: -1}, {Workers: 1, QueueSize: -1}} {
+		if err := server.SetIngestConfig(config); err == nil {
+			t.Errorf("Expected error for %+v", config)
+		}
+	}
+	if err := server.SetIngestConfig(IngestConfig{Workers: 2}); err != nil {
+		t.Fatalf("SetIngestConfig failed: %v", err)
+	}
+	if got := server.GetIngestConfig(); got.Workers != 2 || got.QueueSize != 0 {
+		t.Errorf("Unexpected config: %+v", got)
+	}
+	if err := server.SetIngestConfig(IngestConfig{}); err != nil {
+		t.Fatalf("SetIngestConfig failed: %v", err)
+	}
+}
+
+func TestIngestAsyncDelivery(t *testing.T) {
+	server, err := NewMailServer(1025, "localhost", t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create mail server: %v", err)
+	}
+	if err := server.SetIngestConfig(IngestConfig{Workers: 2, QueueSize: 10}); err != nil {
+		t.Fatalf("SetIngestConfig failed: %v", err)
+	}
+	addr := startTestSMTPServer(t, server)
+
+	if err := sendTestMail(addr, "async"); err != nil {
+		t.Fatalf("SendMail failed: %v", err)
+	}
+	stats := waitForIngest(t, server, func(s IngestStats) bool { return s.Processed == 1 })
+	if stats.Queued != 0 || stats.InProgress != 0 || stats.Failed != 0 || stats.Rejected != 0 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+
+	emails := server.GetAllEmail()
+	if len(emails) != 1 || emails[0].Subject != "async" {
+		t.Fatalf("Expected the queued email to be stored, got %v", emails)
+	}
+	id := emails[0].ID
+
+	// The transcript and session end recorded while queued end up on the email
+	waitForTranscript(t, server, id, "event: connection closed")
+	server.storeMutex.RLock()
+	envelope := *emails[0].Envelope
+	server.storeMutex.RUnlock()
+	if envelope.SessionEnd.IsZero() || envelope.SessionStart.IsZero() || envelope.From != "sender@example.com" {
+		t.Errorf("Unexpected envelope: %+v", envelope)
+	}
+}
+
+func TestIngestQueueFull(t *testing.T) {
+	tmpDir := t.TempDir()
+	server, err := NewMailServer(1025, "localhost", tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create mail server: %v", err)
+	}
+	if err := server.SetIngestConfig(IngestConfig{Workers: 1, QueueSize: 1}); err != nil {
+		t.Fatalf("SetIngestConfig failed: %v", err)
+	}
+	addr := startTestSMTPServer(t, server)
+
+	// Hold the store so the only worker cannot finish the first message
+	server.storeMutex.Lock()
+	locked := true
+	defer func() {
+		if locked {
+			server.storeMutex.Unlock()
+		}
+	}()
+
+	if err := sendTestMail(addr, "first"); err != nil {
+		t.Fatalf("First SendMail failed: %v", err)
+	}
+	waitForIngest(t, server, func(s IngestStats) bool { return s.InProgress == 1 })
+	if err := sendTestMail(addr, "second"); err != nil {
+		t.Fatalf("Second SendMail failed: %v", err)
+	}
+
+	err = sendTestMail(addr, "third")
+	var smtpErr *smtp.SMTPError
+	if !errors.As(err, &smtpErr) || smtpErr.Code != 451 {
+		t.Fatalf("Expected 451 for a full queue, got %v", err)
+	}
+	stats := server.GetIngestStats()
+	if stats.Queued != 1 || stats.InProgress != 1 || stats.MaxQueued != 1 || stats.Rejected != 1 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+
+	server.storeMutex.Unlock()
+	locked = false
+	waitForIngest(t, server, func(s IngestStats) bool { return s.Processed == 2 })
+
+	// The refused message is not kept on disk
+	files, err := filepath.Glob(filepath.Join(tmpDir, "*.eml"))
+	if err != nil || len(files) != 2 {
+		t.Errorf("Expected 2 spooled messages, got %v, %v", files, err)
+	}
+	if emails := server.GetAllEmail(); len(emails) != 2 {
+		t.Errorf("Expected 2 emails, got %d", len(emails))
+	}
+}
+
+func TestIngestCloseDrainsQueue(t *testing.T) {
+	tmpDir := t.TempDir()
+	server, err := NewMailServer(1025, "localhost", tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create mail server: %v", err)
+	}
+	if err := server.SetIngestConfig(IngestConfig{Workers: 1}); err != nil {
+		t.Fatalf("SetIngestConfig failed: %v", err)
+	}
+	addr := startTestSMTPServer(t, server)
+
+	for i := range 5 {
+		if err := sendTestMail(addr, fmt.Sprintf("message %d", i)); err != nil {
+			t.Fatalf("SendMail %d failed: %v", i, err)
+		}
+	}
+	_ = server.Close()
+
+	if emails := server.GetAllEmail(); len(emails) != 5 {
+		t.Errorf("Expected Close to store all 5 queued emails, got %d", len(emails))
+	}
+	if stats := server.GetIngestStats(); stats.Processed != 5 || stats.Queued != 0 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+
+	// Spooled messages are reloaded with their envelope after a restart
+	restarted, err := NewMailServer(1025, "localhost", tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create mail server: %v", err)
+	}
+	defer func() {
+		_ = restarted.Close()
+	}()
+	emails := restarted.GetAllEmail()
+	if len(emails) != 5 {
+		t.Fatalf("Expected 5 reloaded emails, got %d", len(emails))
+	}
+	if emails[0].Envelope == nil || emails[0].Envelope.From != "sender@example.com" {
+		t.Errorf("Expected the spooled envelope, got %+v", emails[0].Envelope)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, emails[0].ID+".eml")); err != nil {
+		t.Errorf("Expected the spooled message to remain: %v", err)
+	}
+}
+
+func TestIngestReloadSkipsQueued(t *testing.T) {
+	tmpDir := t.TempDir()
+	server, err := NewMailServer(1025, "localhost", tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create mail server: %v", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+
+	// A spooled message still waiting for a worker is left to the worker
+	raw := "Subject: queued\r\n\r\nqueued body\r\n"
+	if err := os.WriteFile(filepath.Join(tmpDir, "queued.eml"), []byte(raw), 0644); err != nil {
+		t.Fatalf("Failed to spool message: %v", err)
+	}
+	server.ingestMutex.Lock()
+	server.ingestJobs = map[string]*ingestJob{"queued": {id: "queued", envelope: &Envelope{}}}
+	server.ingestMutex.Unlock()
+
+	if err := server.LoadMailsFromDirectory(); err != nil {
+		t.Fatalf("LoadMailsFromDirectory failed: %v", err)
+	}
+	if emails := server.GetAllEmail(); len(emails) != 0 {
+		t.Errorf("Expected the queued message not to be restored, got %d emails", len(emails))
+	}
+
+	server.dropIngestJob("queued")
+	if err := server.LoadMailsFromDirectory(); err != nil {
+		t.Fatalf("LoadMailsFromDirectory failed: %v", err)
+	}
+	if emails := server.GetAllEmail(); len(emails) != 1 {
+		t.Errorf("Expected the message to be restored once no longer queued, got %d emails", len(emails))
+	}
+}
@@ -0,0 +1,368 @@
+package mailserver
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-smtp"
+)
+
+func TestParseListenerConfigs(t *testing.T) {
+	configs, err := ParseListenerConfigs("smtp://0.0.0.0:1025, smtp://[::]:1025,starttls://:587?maxRecipients=5&readTimeout=30s,smtps://localhost:1465?maxMessageBytes=2048,smtp:///run/owlmail.sock")
+	if err != nil {
+		t.Fatalf("ParseListenerConfigs failed: %v", err)
+	}
+	want := []ListenerConfig{
+		{Addr: "0.0.0.0:1025", Mode: ListenerModePlain},
+		{Addr: "[::]:1025", Mode: ListenerModePlain},
+		{Addr: ":587", Mode: ListenerModeStartTLS, MaxRecipients: 5, ReadTimeout: 30 * time.Second},
+		{Addr: "localhost:1465", Mode: ListenerModeTLS, MaxMessageBytes: 2048},
+		{Addr: "unix:/run/owlmail.sock", Mode: ListenerModePlain},
+	}
+	if len(configs) != len(want) {
+		t.Fatalf("Expected %d listeners, got %d", len(want), len(configs))
+	}
+	for i := range want {
+		if configs[i] != want[i] {
+			t.Errorf("Listener %d = %+v, want %+v", i, configs[i], want[i])
+		}
+	}
+
+	for _, spec := range []string{
+		"",
+		"http://localhost:1025",
+		"smtp://",
+		"smtp://localhost",
+		"smtp://localhost:1025?maxRecipients=many",
+		"smtp://localhost:1025?readTimeout=-1s",
+		"smtp://localhost:1025?bogus=1",
+	} {
+		if _, err := ParseListenerConfigs(spec); err == nil {
+			t.Errorf("Expected error for %q", spec)
+		}
+	}
+}
+
+func TestDefaultListeners(t *testing.T) {
+	server, err := NewMailServerWithConfig(2525, "::1", t.TempDir(), nil, nil, &TLSConfig{Enabled: true, SMTPSPort: 2465})
+	if err != nil {
+		t.Fatalf("Failed to create mail server: %v", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+
+	listeners := server.GetListeners()
+	if len(listeners) != 2 {
+		t.Fatalf("Expected 2 default listeners, got %d", len(listeners))
+	}
+	if listeners[0].Addr != "[::1]:2525" || listeners[0].Mode != ListenerModePlain {
+		t.Errorf("Unexpected SMTP listener: %+v", listeners[0])
+	}
+	if listeners[1].Addr != "[::1]:2465" || listeners[1].Mode != ListenerModeTLS {
+		t.Errorf("Unexpected SMTPS listener: %+v", listeners[1])
+	}
+	if listeners[0].MaxMessageBytes != defaultMaxMessageBytes || listeners[0].ReadTimeout != defaultSMTPTimeout {
+		t.Errorf("Expected default limits, got %+v", listeners[0])
+	}
+	if server.smtpsServer == nil || server.smtpsServer.Addr != "[::1]:2465" {
+		t.Error("Expected SMTPS server on the configured port")
+	}
+}
+
+func TestSetListenersRequiresTLS(t *testing.T) {
+	server, err := NewMailServer(1025, "localhost", t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create mail server: %v", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+
+	for _, mode := range []string{ListenerModeStartTLS, ListenerModeTLS} {
+		if err := server.SetListeners([]ListenerConfig{{Addr: "localhost:0", Mode: mode}}); err == nil {
+			t.Errorf("Expected error for %s listener without TLS", mode)
+		}
+	}
+	if err := server.SetListeners(nil); err == nil {
+		t.Error("Expected error for empty listener list")
+	}
+}
+
+// freePort returns a TCP port that is currently unused on the loopback interface
+func freePort(t *testing.T) int {
+	t.Helper()
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer func() {
+		_ = ln.Close()
+	}()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+// waitForListener dials addr on network until it accepts connections
+func waitForListener(t *testing.T, network, addr string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		conn, err := net.DialTimeout(network, addr, 100*time.Millisecond)
+		if err == nil {
+			_ = conn.Close()
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Listener %s %s did not start: %v", network, addr, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestListenMultipleListeners(t *testing.T) {
+	tmpDir := t.TempDir()
+	server, err := NewMailServerWithConfig(1025, "localhost", tmpDir, nil, nil, &TLSConfig{Enabled: true})
+	if err != nil {
+		t.Fatalf("Failed to create mail server: %v", err)
+	}
+
+	port := strconv.Itoa(freePort(t))
+	starttlsSocket := filepath.Join(tmpDir, "starttls.sock")
+	smtpsSocket := filepath.Join(tmpDir, "smtps.sock")
+	err = server.SetListeners([]ListenerConfig{
+		{Addr: "127.0.0.1:" + port, Mode: ListenerModePlain, MaxRecipients: 1},
+		{Addr: "[::1]:" + port, Mode: ListenerModePlain},
+		{Addr: "unix:" + starttlsSocket, Mode: ListenerModeStartTLS},
+		{Addr: smtpsSocket, Mode: ListenerModeTLS},
+	})
+	if err != nil {
+		t.Fatalf("SetListeners failed: %v", err)
+	}
+
+	listenErr := make(chan error, 1)
+	go func() {
+		listenErr <- server.Listen()
+	}()
+	waitForListener(t, "tcp4", "127.0.0.1:"+port)
+	waitForListener(t, "tcp6", "[::1]:"+port)
+	waitForListener(t, "unix", starttlsSocket)
+	waitForListener(t, "unix", smtpsSocket)
+
+	send := func(c *smtp.Client, to ...string) error {
+		defer func() {
+			_ = c.Close()
+		}()
+		return c.SendMail("sender@example.com", to, strings.NewReader("Subject: hi\r\n\r\nbody\r\n"))
+	}
+
+	// IPv4 and IPv6 share the port; the IPv4 listener has its own recipient limit
+	c, err := smtp.Dial("127.0.0.1:" + port)
+	if err != nil {
+		t.Fatalf("Dial IPv4 failed: %v", err)
+	}
+	var smtpErr *smtp.SMTPError
+	if err := send(c, "a@example.com", "b@example.com"); !errors.As(err, &smtpErr) || smtpErr.Code != 452 {
+		t.Errorf("Expected 452 for too many recipients on IPv4 listener, got %v", err)
+	}
+	c, err = smtp.Dial("[::1]:" + port)
+	if err != nil {
+		t.Fatalf("Dial IPv6 failed: %v", err)
+	}
+	if err := send(c, "a@example.com", "b@example.com"); err != nil {
+		t.Errorf("IPv6 listener failed: %v", err)
+	}
+
+	// STARTTLS is required before MAIL FROM
+	conn, err := net.Dial("unix", starttlsSocket)
+	if err != nil {
+		t.Fatalf("Dial unix failed: %v", err)
+	}
+	c = smtp.NewClient(conn)
+	if err := send(c, "a@example.com"); !errors.As(err, &smtpErr) || smtpErr.Code != 530 {
+		t.Errorf("Expected 530 without STARTTLS, got %v", err)
+	}
+	conn, err = net.Dial("unix", starttlsSocket)
+	if err != nil {
+		t.Fatalf("Dial unix failed: %v", err)
+	}
+	c, err = smtp.NewClientStartTLS(conn, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatalf("StartTLS failed: %v", err)
+	}
+	if err := send(c, "a@example.com"); err != nil {
+		t.Errorf("Expected delivery after STARTTLS, got %v", err)
+	}
+
+	// Implicit TLS over a unix socket
+	conn, err = net.Dial("unix", smtpsSocket)
+	if err != nil {
+		t.Fatalf("Dial unix failed: %v", err)
+	}
+	c = smtp.NewClient(tls.Client(conn, &tls.Config{InsecureSkipVerify: true}))
+	if err := send(c, "a@example.com"); err != nil {
+		t.Errorf("Implicit TLS listener failed: %v", err)
+	}
+
+	if got := len(server.GetAllEmail()); got != 3 {
+		t.Errorf("Expected 3 emails, got %d", got)
+	}
+
+	if err := server.Close(); err != nil {
+		t.Errorf("Close failed: %v", err)
+	}
+	select {
+	case err := <-listenErr:
+		if err != nil {
+			t.Errorf("Listen returned error after Close: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("Listen did not return after Close")
+	}
+}
+
+func TestListenReportsBindErrors(t *testing.T) {
+	occupied, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer func() {
+		_ = occupied.Close()
+	}()
+
+	server, err := NewMailServer(1025, "localhost", t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create mail server: %v", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+	free := strconv.Itoa(freePort(t))
+	err = server.SetListeners([]ListenerConfig{
+		{Addr: "127.0.0.1:" + free},
+		{Addr: occupied.Addr().String()},
+	})
+	if err != nil {
+		t.Fatalf("SetListeners failed: %v", err)
+	}
+
+	if err := server.Listen(); err == nil || !strings.Contains(err.Error(), occupied.Addr().String()) {
+		t.Fatalf("Expected bind error for %s, got %v", occupied.Addr(), err)
+	}
+
+	// The listener bound before the failure was released
+	ln, err := net.Listen("tcp4", "127.0.0.1:"+free)
+	if err != nil {
+		t.Errorf("Expected port %s to be released: %v", free, err)
+	} else {
+		_ = ln.Close()
+	}
+}
+
+func TestEnvelopeRecordsConnectionDetails(t *testing.T) {
+	tmpDir := t.TempDir()
+	server, err := NewMailServerWithConfig(1025, "localhost", tmpDir, nil, nil, &TLSConfig{Enabled: true})
+	if err != nil {
+		t.Fatalf("Failed to create mail server: %v", err)
+	}
+	smtpsSocket := filepath.Join(tmpDir, "smtps.sock")
+	port := strconv.Itoa(freePort(t))
+	if err := server.SetListeners([]ListenerConfig{
+		{Addr: "127.0.0.1:" + port, Mode: ListenerModePlain},
+		{Addr: smtpsSocket, Mode: ListenerModeTLS},
+	}); err != nil {
+		t.Fatalf("SetListeners failed: %v", err)
+	}
+	go func() {
+		_ = server.Listen()
+	}()
+	defer func() {
+		_ = server.Close()
+	}()
+	waitForListener(t, "tcp4", "127.0.0.1:"+port)
+	waitForListener(t, "unix", smtpsSocket)
+
+	send := func(c *smtp.Client, subject string) {
+		t.Helper()
+		if err := c.Hello("client-" + subject + ".example.org"); err != nil {
+			t.Fatalf("EHLO failed: %v", err)
+		}
+		if err := c.SendMail("sender@example.com", []string{"user@example.com"}, strings.NewReader("Subject: "+subject+"\r\n\r\nbody\r\n")); err != nil {
+			t.Fatalf("SendMail failed: %v", err)
+		}
+		// Over TLS, the server may close first and fail the close_notify alert
+		_ = c.Quit()
+	}
+
+	c, err := smtp.Dial("127.0.0.1:" + port)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	send(c, "plaintext")
+
+	conn, err := net.Dial("tcp", "127.0.0.1:"+port)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	c, err = smtp.NewClientStartTLS(conn, &tls.Config{ServerName: "mx.test.local", InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatalf("StartTLS failed: %v", err)
+	}
+	send(c, "starttls")
+
+	conn, err = net.Dial("unix", smtpsSocket)
+	if err != nil {
+		t.Fatalf("Dial unix failed: %v", err)
+	}
+	c = smtp.NewClient(tls.Client(conn, &tls.Config{InsecureSkipVerify: true, MaxVersion: tls.VersionTLS12}))
+	send(c, "implicit")
+
+	// The session end is recorded once the server has closed the connection
+	envelopes := make(map[string]*Envelope)
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		done := true
+		for _, email := range server.GetAllEmail() {
+			envelopes[email.Subject] = email.Envelope
+			done = done && !email.Envelope.SessionEnd.IsZero()
+		}
+		if done && len(envelopes) == 3 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(envelopes) != 3 {
+		t.Fatalf("Expected 3 emails, got %d", len(envelopes))
+	}
+
+	for subject, envelope := range envelopes {
+		if envelope.Host != "client-"+subject+".example.org" {
+			t.Errorf("%s: unexpected HELO name %q", subject, envelope.Host)
+		}
+		if envelope.SessionStart.IsZero() || envelope.SessionEnd.Before(envelope.SessionStart) {
+			t.Errorf("%s: unexpected session times %v - %v", subject, envelope.SessionStart, envelope.SessionEnd)
+		}
+	}
+	if envelopes["plaintext"].TLS != nil {
+		t.Errorf("Expected no TLS details for plaintext, got %+v", envelopes["plaintext"].TLS)
+	}
+	if info := envelopes["starttls"].TLS; info == nil || info.Mode != TLSModeSTARTTLS || info.Version != "TLS 1.3" || info.ServerName != "mx.test.local" || info.CipherSuite == "" {
+		t.Errorf("Unexpected STARTTLS details: %+v", info)
+	}
+	if info := envelopes["implicit"].TLS; info == nil || info.Mode != TLSModeImplicit || info.Version != "TLS 1.2" || info.ServerName != "" {
+		t.Errorf("Unexpected implicit TLS details: %+v", info)
+	}
+
+	// The session end is persisted with the envelope
+	for _, email := range server.GetAllEmail() {
+		saved, err := server.loadEnvelope(email.ID)
+		if err != nil || !saved.SessionEnd.Equal(email.Envelope.SessionEnd) || (saved.TLS == nil) != (email.Envelope.TLS == nil) {
+			t.Errorf("%s: saved envelope %+v, %v does not match %+v", email.Subject, saved, err, email.Envelope)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package mailserver
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-smtp"
+)
+
+func TestParseListenAddr(t *testing.T) {
+	tests := []struct {
+		addr    string
+		network string
+		address string
+	}{
+		{"localhost:2424", "tcp", "localhost:2424"},
+		{"127.0.0.1:2424", "tcp4", "127.0.0.1:2424"},
+		{"[::1]:2424", "tcp6", "[::1]:2424"},
+		{"unix:/tmp/owlmail.sock", "unix", "/tmp/owlmail.sock"},
+		{"/var/run/owlmail.sock", "unix", "/var/run/owlmail.sock"},
+	}
+	for _, tt := range tests {
+		network, address := parseListenAddr(tt.addr)
+		if network != tt.network || address != tt.address {
+			t.Errorf("parseListenAddr(%q) = %q, %q, want %q, %q", tt.addr, network, address, tt.network, tt.address)
+		}
+	}
+}
+
+func TestLMTPUnixSocketDelivery(t *testing.T) {
+	tmpDir := t.TempDir()
+	// Auth is required for SMTP but LMTP deliveries come from a trusted local agent
+	authConfig := &SMTPAuthConfig{Username: "user", Password: "pass", Enabled: true}
+	server, err := NewMailServerWithConfig(1025, "localhost", tmpDir, nil, authConfig, nil)
+	if err != nil {
+		t.Fatalf("Failed to create mail server: %v", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+
+	socketPath := filepath.Join(tmpDir, "lmtp.sock")
+	server.SetLMTPAddr("unix:" + socketPath)
+	if server.GetLMTPAddr() != "unix:"+socketPath {
+		t.Errorf("Unexpected LMTP address: %s", server.GetLMTPAddr())
+	}
+	if err := server.listenLMTP(); err != nil {
+		t.Fatalf("listenLMTP failed: %v", err)
+	}
+
+	conn, err := net.DialTimeout("unix", socketPath, time.Second)
+	if err != nil {
+		t.Fatalf("Failed to dial LMTP socket: %v", err)
+	}
+	c := smtp.NewClientLMTP(conn)
+	defer func() {
+		_ = c.Close()
+	}()
+	if err := c.Hello("mta.example.com"); err != nil {
+		t.Fatalf("LHLO failed: %v", err)
+	}
+	if err := c.Mail("sender@example.com", nil); err != nil {
+		t.Fatalf("MAIL failed: %v", err)
+	}
+	for _, rcpt := range []string{"a@example.com", "b@example.com"} {
+		if err := c.Rcpt(rcpt, nil); err != nil {
+			t.Fatalf("RCPT failed: %v", err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		t.Fatalf("DATA failed: %v", err)
+	}
+	if _, err := w.Write([]byte("Subject: via LMTP\r\n\r\nHello\r\n")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	responses, err := w.CloseWithLMTPResponse()
+	if err != nil {
+		t.Fatalf("DATA close failed: %v", err)
+	}
+	if len(responses) != 2 {
+		t.Fatalf("Expected 2 per-recipient responses, got %d", len(responses))
+	}
+	for rcpt, resp := range responses {
+		if resp.StatusText == "" || !strings.Contains(resp.StatusText, rcpt) {
+			t.Errorf("Unexpected status for %s: %+v", rcpt, resp)
+		}
+	}
+
+	emails := server.GetAllEmail()
+	if len(emails) != 1 {
+		t.Fatalf("Expected 1 stored email, got %d", len(emails))
+	}
+	if emails[0].Subject != "via LMTP" || len(emails[0].Envelope.To) != 2 {
+		t.Errorf("Unexpected email: subject=%q to=%v", emails[0].Subject, emails[0].Envelope.To)
+	}
+}
+
+func TestListenUnixSocketPath(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	// A regular file at the socket path is neither removed nor replaced
+	filePath := filepath.Join(tmpDir, "notes.txt")
+	if err := os.WriteFile(filePath, []byte("keep me"), 0600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if ln, err := listen("unix", filePath); err == nil {
+		_ = ln.Close()
+		t.Fatal("Expected error for a path that is not a socket")
+	}
+	if data, err := os.ReadFile(filePath); err != nil || string(data) != "keep me" {
+		t.Errorf("Expected the file to be kept, got %q (%v)", data, err)
+	}
+
+	// A stale socket left by a previous run is replaced
+	socketPath := filepath.Join(tmpDir, "stale.sock")
+	stale, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatalf("Failed to create socket: %v", err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	_ = stale.Close()
+	ln, err := listen("unix", socketPath)
+	if err != nil {
+		t.Fatalf("Expected the stale socket to be replaced: %v", err)
+	}
+	_ = ln.Close()
+}
+
+func TestSetLMTPAddrDisable(t *testing.T) {
+	server, err := NewMailServer(1025, "localhost", t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create mail server: %v", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+
+	server.SetLMTPAddr("localhost:2424")
+	if server.lmtpServer == nil || !server.lmtpServer.LMTP {
+		t.Fatal("Expected LMTP server to be configured")
+	}
+	server.SetLMTPAddr("")
+	if server.lmtpServer != nil {
+		t.Error("Expected LMTP server to be disabled")
+	}
+}
+
+func TestLMTPTCPClientPolicy(t *testing.T) {
+	// LMTP clients over TCP are not trusted like local ones
+	authConfig := &SMTPAuthConfig{Username: "user", Password: "pass", Enabled: true}
+	server, err := NewMailServerWithConfig(1025, "localhost", t.TempDir(), nil, authConfig, nil)
+	if err != nil {
+		t.Fatalf("Failed to create mail server: %v", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+	server.SetLMTPAddr("127.0.0.1:0")
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go func() {
+		_ = server.serve(server.lmtpServer, ln, false, false)
+	}()
+
+	dial := func() *smtp.Client {
+		conn, err := net.DialTimeout("tcp", ln.Addr().String(), time.Second)
+		if err != nil {
+			t.Fatalf("Failed to dial LMTP: %v", err)
+		}
+		return smtp.NewClientLMTP(conn)
+	}
+
+	c := dial()
+	err = c.Mail("sender@example.com", nil)
+	var smtpErr *smtp.SMTPError
+	if !errors.As(err, &smtpErr) || smtpErr.Code != 530 {
+		t.Errorf("Expected 530 for an unauthenticated TCP LMTP client, got %v", err)
+	}
+	_ = c.Close()
+
+	if err := server.SetAccessPolicy(AccessPolicy{DenyNetworks: []string{"127.0.0.0/8"}}); err != nil {
+		t.Fatalf("SetAccessPolicy failed: %v", err)
+	}
+	c = dial()
+	err = c.Hello("mta.example.com")
+	_ = c.Close()
+	if !errors.As(err, &smtpErr) || smtpErr.Code != 550 {
+		t.Errorf("Expected 550 for a denied TCP LMTP client, got %v", err)
+	}
+}
+
+func TestLMTPPerRecipientStatus(t *testing.T) {
+	tmpDir := t.TempDir()
+	server, err := NewMailServer(1025, "localhost", tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create mail server: %v", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+	if err := server.SetFaultRules([]FaultRule{
+		{Stage: FaultStageData, Match: "full@example.com", Code: 452, EnhancedCode: "4.2.2", Message: "Mailbox full"},
+	}); err != nil {
+		t.Fatalf("SetFaultRules failed: %v", err)
+	}
+	socketPath := filepath.Join(tmpDir, "lmtp.sock")
+	server.SetLMTPAddr("unix:" + socketPath)
+	if err := server.listenLMTP(); err != nil {
+		t.Fatalf("listenLMTP failed: %v", err)
+	}
+
+	conn, err := net.DialTimeout("unix", socketPath, time.Second)
+	if err != nil {
+		t.Fatalf("Failed to dial LMTP socket: %v", err)
+	}
+	c := smtp.NewClientLMTP(conn)
+	defer func() {
+		_ = c.Close()
+	}()
+	if err := c.Hello("mta.example.com"); err != nil {
+		t.Fatalf("LHLO failed: %v", err)
+	}
+	if err := c.Mail("sender@example.com", nil); err != nil {
+		t.Fatalf("MAIL failed: %v", err)
+	}
+	for _, rcpt := range []string{"a@example.com", "full@example.com", "b@example.com"} {
+		if err := c.Rcpt(rcpt, nil); err != nil {
+			t.Fatalf("RCPT failed: %v", err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		t.Fatalf("DATA failed: %v", err)
+	}
+	if _, err := w.Write([]byte("Subject: mixed\r\n\r\nHello\r\n")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	responses, err := w.CloseWithLMTPResponse()
+	var refused smtp.LMTPDataError
+	if !errors.As(err, &refused) || len(refused) != 1 || refused["full@example.com"] == nil || refused["full@example.com"].Code != 452 {
+		t.Errorf("Expected only full@example.com to be refused with 452, got %v", err)
+	}
+	for _, rcpt := range []string{"a@example.com", "b@example.com"} {
+		if responses[rcpt] == nil {
+			t.Errorf("Expected %s to accept the message, got %v", rcpt, responses)
+		}
+	}
+
+	// The message is stored for the recipients that accepted it
+	emails := server.GetAllEmail()
+	if len(emails) != 1 {
+		t.Fatalf("Expected 1 stored email, got %d", len(emails))
+	}
+	if to := strings.Join(emails[0].Envelope.To, ","); to != "a@example.com,b@example.com" {
+		t.Errorf("Expected the accepted recipients in the envelope, got %s", to)
+	}
+}
@@ -0,0 +1,115 @@
+package mailserver
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-smtp"
+)
+
+func TestLoadLocalCA(t *testing.T) {
+	mailDir := t.TempDir()
+	ca, err := LoadLocalCA(mailDir, nil)
+	if err != nil {
+		t.Fatalf("LoadLocalCA failed: %v", err)
+	}
+	if !ca.cert.IsCA {
+		t.Error("Expected a CA certificate")
+	}
+	info, err := os.Stat(filepath.Join(mailDir, localCADir, localCAKeyFile))
+	if err != nil {
+		t.Fatalf("CA key was not persisted: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected CA key mode 0600, got %v", info.Mode().Perm())
+	}
+
+	// The server certificate verifies against the CA for every default host
+	cert, err := ca.ServerCertificate()
+	if err != nil {
+		t.Fatalf("ServerCertificate failed: %v", err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(ca.CertPEM()) {
+		t.Fatal("CertPEM is not a valid PEM certificate")
+	}
+	for _, host := range DefaultLocalCAHosts {
+		if _, err := cert.Leaf.Verify(x509.VerifyOptions{DNSName: host, Roots: roots}); err != nil {
+			t.Errorf("Certificate does not verify for %s: %v", host, err)
+		}
+	}
+
+	// Reloading keeps the CA and the issued certificate
+	reloaded, err := LoadLocalCA(mailDir, []string{"localhost", "127.0.0.1", "::1"})
+	if err != nil {
+		t.Fatalf("Reloading the local CA failed: %v", err)
+	}
+	if string(reloaded.CertPEM()) != string(ca.CertPEM()) {
+		t.Error("Expected the persisted CA to be reused")
+	}
+	reissued, err := reloaded.ServerCertificate()
+	if err != nil {
+		t.Fatalf("ServerCertificate failed: %v", err)
+	}
+	if reissued.Leaf.SerialNumber.Cmp(cert.Leaf.SerialNumber) != 0 {
+		t.Error("Expected the persisted server certificate to be reused")
+	}
+
+	// Changing the hosts issues a new certificate from the same CA
+	renamed, err := LoadLocalCA(mailDir, []string{"mail.test", " 10.0.0.5 "})
+	if err != nil {
+		t.Fatalf("Reloading the local CA failed: %v", err)
+	}
+	renamedCert, err := renamed.ServerCertificate()
+	if err != nil {
+		t.Fatalf("ServerCertificate failed: %v", err)
+	}
+	if renamedCert.Leaf.SerialNumber.Cmp(cert.Leaf.SerialNumber) == 0 {
+		t.Error("Expected a new certificate for new hosts")
+	}
+	for _, host := range []string{"mail.test", "10.0.0.5"} {
+		if _, err := renamedCert.Leaf.Verify(x509.VerifyOptions{DNSName: host, Roots: roots}); err != nil {
+			t.Errorf("Certificate does not verify for %s: %v", host, err)
+		}
+	}
+
+	if _, err := LoadLocalCA(mailDir, []string{"localhost", " "}); err == nil {
+		t.Error("Expected error for an empty host")
+	}
+}
+
+func TestLocalCAStartTLS(t *testing.T) {
+	mailDir := t.TempDir()
+	server, err := NewMailServerWithConfig(1025, "localhost", mailDir, nil, nil, &TLSConfig{Enabled: true, LocalCA: true})
+	if err != nil {
+		t.Fatalf("Failed to create mail server: %v", err)
+	}
+	ca := server.GetLocalCA()
+	if ca == nil {
+		t.Fatal("Expected local CA to be loaded")
+	}
+	addr := startTestSMTPServer(t, server)
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(ca.CertPEM())
+	c, err := smtp.DialStartTLS(addr, &tls.Config{RootCAs: roots, ServerName: "localhost"})
+	if err != nil {
+		t.Fatalf("STARTTLS with certificate verification failed: %v", err)
+	}
+	if err := c.SendMail("sender@example.com", []string{"rcpt@example.com"}, strings.NewReader("Subject: CA\r\n\r\nbody\r\n")); err != nil {
+		t.Errorf("SendMail failed: %v", err)
+	}
+	_ = c.Close()
+
+	// Deleting all email keeps the CA
+	if err := server.DeleteAllEmail(); err != nil {
+		t.Fatalf("DeleteAllEmail failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(mailDir, localCADir, localCAKeyFile)); err != nil {
+		t.Errorf("Local CA was deleted with the email: %v", err)
+	}
+}
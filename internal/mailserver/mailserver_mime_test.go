@@ -0,0 +1,248 @@
+package mailserver
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// nestedMessage is a typical client message: a mixed message holding an
+// alternative of text and related HTML with an inline image, an attachment
+// and a signature appended as a separate text part
+const nestedMessage = "From: from@example.com\r\n" +
+	"To: to@example.com\r\n" +
+	"Subject: Nested\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/mixed; boundary=MIXED\r\n" +
+	"\r\n" +
+	"--MIXED\r\n" +
+	"Content-Type: multipart/alternative; boundary=ALT\r\n" +
+	"\r\n" +
+	"--ALT\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"\r\n" +
+	"Plain body\r\n" +
+	"--ALT\r\n" +
+	"Content-Type: multipart/related; boundary=REL\r\n" +
+	"\r\n" +
+	"--REL\r\n" +
+	"Content-Type: text/html; charset=utf-8\r\n" +
+	"\r\n" +
+	"<p>HTML body <img src=\"cid:logo@example.com\"></p>\r\n" +
+	"--REL\r\n" +
+	"Content-Type: image/png\r\n" +
+	"Content-ID: <logo@example.com>\r\n" +
+	"Content-Disposition: inline\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"iVBORw0KGgo=\r\n" +
+	"--REL--\r\n" +
+	"--ALT--\r\n" +
+	"--MIXED\r\n" +
+	"Content-Type: application/pdf; name=report.pdf\r\n" +
+	"Content-Disposition: attachment\r\n" +
+	"\r\n" +
+	"%PDF-1.4\r\n" +
+	"--MIXED\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"-- signature\r\n" +
+	"--MIXED--\r\n"
+
+// parseTestMessage parses a raw message with attachments saved
+func parseTestMessage(t *testing.T, id, raw string) *Email {
+	t.Helper()
+	tmpDir := t.TempDir()
+	server, err := NewMailServer(1025, "localhost", tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create mail server: %v", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+	email, err := server.parseMessage(id, strings.NewReader(raw), true)
+	if err != nil {
+		t.Fatalf("Failed to parse email: %v", err)
+	}
+	for _, attachment := range email.Attachments {
+		if _, err := os.Stat(filepath.Join(tmpDir, id, attachment.GeneratedFileName)); err != nil {
+			t.Errorf("Attachment %s was not saved: %v", attachment.FileName, err)
+		}
+	}
+	return email
+}
+
+func TestParseNestedMIME(t *testing.T) {
+	email := parseTestMessage(t, "nested", nestedMessage)
+
+	if email.Text != "Plain body\n-- signature" {
+		t.Errorf("Expected the plain alternative and the signature, got %q", email.Text)
+	}
+	if email.HTML != "<p>HTML body <img src=\"cid:logo@example.com\"></p>" {
+		t.Errorf("Expected the HTML of the related part, got %q", email.HTML)
+	}
+	if len(email.Attachments) != 2 {
+		t.Fatalf("Expected the inline image and the attachment, got %d attachments", len(email.Attachments))
+	}
+	image, pdf := email.Attachments[0], email.Attachments[1]
+	if image.ContentID != "logo@example.com" || image.ContentType != "image/png" || image.Size != 8 || image.Path != "1.2.2" {
+		t.Errorf("Unexpected inline image: %+v", image)
+	}
+	if pdf.FileName != "report.pdf" || pdf.ContentType != "application/pdf" {
+		t.Errorf("Unexpected attachment: %+v", pdf)
+	}
+}
+
+func TestParseAlternativeChoice(t *testing.T) {
+	// The last alternative is preferred; the others are kept as attachments
+	raw := "Subject: Alternatives\r\n" +
+		"Content-Type: multipart/alternative; boundary=ALT\r\n" +
+		"\r\n" +
+		"--ALT\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"Plain\r\n" +
+		"--ALT\r\n" +
+		"Content-Type: text/html\r\n" +
+		"\r\n" +
+		"<p>Old</p>\r\n" +
+		"--ALT\r\n" +
+		"Content-Type: text/html\r\n" +
+		"\r\n" +
+		"<p>New</p>\r\n" +
+		"--ALT--\r\n"
+	email := parseTestMessage(t, "alternative", raw)
+
+	if email.Text != "Plain" || email.HTML != "<p>New</p>" {
+		t.Errorf("Unexpected bodies: text %q, html %q", email.Text, email.HTML)
+	}
+	if len(email.Attachments) != 1 || email.Attachments[0].ContentType != "text/html" {
+		t.Errorf("Expected the other HTML alternative as an attachment, got %+v", email.Attachments)
+	}
+}
+
+func TestParseSinglePartAttachment(t *testing.T) {
+	raw := "Subject: Invoice\r\n" +
+		"Content-Type: application/pdf\r\n" +
+		"Content-Disposition: attachment; filename=invoice.pdf\r\n" +
+		"\r\n" +
+		"%PDF-1.4\r\n"
+	email := parseTestMessage(t, "single", raw)
+
+	if email.Text != "" || len(email.Attachments) != 1 || email.Attachments[0].FileName != "invoice.pdf" {
+		t.Errorf("Expected the body as an attachment, got text %q and %+v", email.Text, email.Attachments)
+	}
+}
+
+func TestParseDeepMIME(t *testing.T) {
+	// Nesting beyond the depth limit is kept as an attachment instead of being walked
+	raw := "Subject: Deep\r\n"
+	for i := range maxMIMEDepth + 1 {
+		raw += "Content-Type: multipart/mixed; boundary=B" + strconv.Itoa(i) + "\r\n\r\n--B" + strconv.Itoa(i) + "\r\n"
+	}
+	raw += "Content-Type: text/plain\r\n\r\ndeep\r\n"
+	email := parseTestMessage(t, "deep", raw)
+
+	if email.Text != "" || len(email.Attachments) != 1 || !strings.HasPrefix(email.Attachments[0].ContentType, "multipart/") {
+		t.Errorf("Expected the deepest multipart as an attachment, got text %q and %+v", email.Text, email.Attachments)
+	}
+}
+
+func TestReadMIMETree(t *testing.T) {
+	root, err := readMIMETree([]byte(nestedMessage))
+	if err != nil {
+		t.Fatalf("readMIMETree failed: %v", err)
+	}
+
+	var paths []string
+	var walk func(part *MIMEPart)
+	walk = func(part *MIMEPart) {
+		paths = append(paths, part.Path+"="+part.ContentType)
+		for _, child := range part.Parts {
+			walk(child)
+		}
+	}
+	walk(root)
+	expected := "0=multipart/mixed,1=multipart/alternative,1.1=text/plain,1.2=multipart/related," +
+		"1.2.1=text/html,1.2.2=image/png,2=application/pdf,3=text/plain"
+	if got := strings.Join(paths, ","); got != expected {
+		t.Errorf("Unexpected tree:\n got %s\nwant %s", got, expected)
+	}
+
+	image := root.Find("1.2.2")
+	if image == nil || image.TransferEncoding != "base64" || image.Disposition != "inline" || image.ContentID != "logo@example.com" {
+		t.Fatalf("Unexpected image part: %+v", image)
+	}
+	if image.Size != int64(len("iVBORw0KGgo=")) || len(image.Headers) != 4 || image.Headers[1].Name != "Content-ID" {
+		t.Errorf("Unexpected size or headers: %d %+v", image.Size, image.Headers)
+	}
+	if decoded, err := image.Decoded(); err != nil || string(decoded) != "\x89PNG\r\n\x1a\n" {
+		t.Errorf("Unexpected decoded body %q: %v", decoded, err)
+	}
+	raw := string(image.Raw())
+	if !strings.HasPrefix(raw, "Content-Type: image/png\r\nContent-ID: <logo@example.com>\r\n") || !strings.HasSuffix(raw, "\r\n\r\niVBORw0KGgo=") {
+		t.Errorf("Unexpected raw part %q", raw)
+	}
+	if root.Find("1.2").Charset != "" || root.Find("1.1").Charset != "utf-8" || root.Find("2").FileName != "report.pdf" {
+		t.Error("Unexpected part parameters")
+	}
+	if root.Find("4") != nil || root.Find("1.3") != nil {
+		t.Error("Expected no part for unknown paths")
+	}
+	if ct := root.Find("1.2").DecodedContentType(); ct != "multipart/related; boundary=REL" {
+		t.Errorf("Expected the boundary to be kept, got %q", ct)
+	}
+}
+
+func TestReadMIMETreeSlicesRaw(t *testing.T) {
+	raw := []byte(nestedMessage)
+	root, err := readMIMETree(raw)
+	if err != nil {
+		t.Fatalf("readMIMETree failed: %v", err)
+	}
+	if got := string(root.Find("1.1").body); got != "Plain body" {
+		t.Fatalf("Unexpected text body %q", got)
+	}
+
+	// The bodies are not copies: they change with the raw message
+	for i := range raw {
+		raw[i] = '#'
+	}
+	for _, path := range []string{"0", "1", "1.1", "1.2.1", "1.2.2", "2", "3"} {
+		body := root.Find(path).body
+		if len(body) == 0 || strings.Trim(string(body), "#") != "" {
+			t.Errorf("Expected part %s to be a slice of the raw message, got %q", path, body)
+		}
+	}
+}
+
+func TestReadMIMETreeUnusualLayout(t *testing.T) {
+	// Bare LF line endings, a preamble mentioning the boundary and a part
+	// without header
+	raw := "Content-Type: multipart/mixed; boundary=B\n" +
+		"\n" +
+		"--Bogus line in the preamble\n" +
+		"--B\n" +
+		"\n" +
+		"no header\n" +
+		"--B\n" +
+		"Content-Type: text/html\n" +
+		"\n" +
+		"<p>html</p>\n" +
+		"--B--\n"
+	root, err := readMIMETree([]byte(raw))
+	if err != nil {
+		t.Fatalf("readMIMETree failed: %v", err)
+	}
+	if len(root.Parts) != 2 {
+		t.Fatalf("Expected 2 parts, got %d", len(root.Parts))
+	}
+	if got := string(root.Find("1").body); got != "no header" {
+		t.Errorf("Unexpected body of part 1 %q", got)
+	}
+	if got := string(root.Find("2").body); got != "<p>html</p>" || root.Find("2").ContentType != "text/html" {
+		t.Errorf("Unexpected part 2 %q (%s)", got, root.Find("2").ContentType)
+	}
+}
@@ -0,0 +1,163 @@
+package mailserver
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-smtp"
+)
+
+// oversizeMessage returns a multipart message with an attachment of about size bytes
+func oversizeMessage(size int) string {
+	return "From: big@example.com\r\n" +
+		"To: user@example.com\r\n" +
+		"Subject: Quarterly report\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/mixed; boundary=BOUNDARY\r\n" +
+		"\r\n" +
+		"--BOUNDARY\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"See the attached report.\r\n" +
+		"--BOUNDARY\r\n" +
+		"Content-Type: application/octet-stream; name=report.bin\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		strings.Repeat("QUFBQUFBQUFBQUFBQUFBQUFBQUFBQUFBQUFBQUFBQUFBQUFBQUFBQUFBQUFBQUFB\r\n", size/66) +
+		"--BOUNDARY--\r\n"
+}
+
+func TestOversizeCapture(t *testing.T) {
+	tmpDir := t.TempDir()
+	server, err := NewMailServer(1025, "localhost", tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create mail server: %v", err)
+	}
+	if err := server.SetListeners([]ListenerConfig{{Addr: "127.0.0.1:0", MaxMessageBytes: 4096}}); err != nil {
+		t.Fatalf("SetListeners failed: %v", err)
+	}
+	if err := server.SetOversizeCapture(1); err != nil {
+		t.Fatalf("SetOversizeCapture failed: %v", err)
+	}
+	addr := startTestSMTPServer(t, server)
+
+	c, err := smtp.Dial(addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer func() {
+		_ = c.Close()
+	}()
+	err = c.SendMail("big@example.com", []string{"user@example.com"}, strings.NewReader(oversizeMessage(20000)))
+	var smtpErr *smtp.SMTPError
+	if !errors.As(err, &smtpErr) || smtpErr.Code != 552 {
+		t.Fatalf("Expected 552 for an oversize message, got %v", err)
+	}
+
+	// The session stays usable after the refused message
+	if err := c.SendMail("small@example.com", []string{"user@example.com"}, strings.NewReader("Subject: small\r\n\r\nok\r\n")); err != nil {
+		t.Fatalf("SendMail after oversize message failed: %v", err)
+	}
+
+	emails := server.GetAllEmail()
+	if len(emails) != 2 {
+		t.Fatalf("Expected the rejected record and the small email, got %d emails", len(emails))
+	}
+	rejected := emails[0]
+	if rejected.Subject != "Quarterly report" || rejected.Envelope.From != "big@example.com" {
+		t.Errorf("Expected the header of the oversize message, got subject %q from %q", rejected.Subject, rejected.Envelope.From)
+	}
+	r := rejected.Envelope.Rejected
+	if r == nil || r.Code != 552 || r.Reason != smtp.ErrDataTooLarge.Message || r.Limit != 4096 {
+		t.Fatalf("Unexpected rejection: %+v", r)
+	}
+	raw, err := os.ReadFile(filepath.Join(tmpDir, rejected.ID+".eml"))
+	if err != nil {
+		t.Fatalf("Failed to read captured message: %v", err)
+	}
+	if int64(len(raw)) != r.Captured || len(raw) != headerEnd(raw)+1024 {
+		t.Errorf("Expected the header and 1 KB of body, got %d bytes (rejection %+v)", len(raw), r)
+	}
+	if emails[1].Envelope.Rejected != nil {
+		t.Errorf("Expected the small email not to be rejected, got %+v", emails[1].Envelope.Rejected)
+	}
+
+	// The rejection survives a reload from disk
+	restarted, err := NewMailServer(1025, "localhost", tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create mail server: %v", err)
+	}
+	defer func() {
+		_ = restarted.Close()
+	}()
+	reloaded, err := restarted.GetEmail(rejected.ID)
+	if err != nil || reloaded.Envelope.Rejected == nil || reloaded.Envelope.Rejected.Code != 552 {
+		t.Errorf("Expected the rejection after reload, got %v", err)
+	}
+}
+
+func TestOversizeWithoutCapture(t *testing.T) {
+	tmpDir := t.TempDir()
+	server, err := NewMailServer(1025, "localhost", tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create mail server: %v", err)
+	}
+	if err := server.SetListeners([]ListenerConfig{{Addr: "127.0.0.1:0", MaxMessageBytes: 4096}}); err != nil {
+		t.Fatalf("SetListeners failed: %v", err)
+	}
+	addr := startTestSMTPServer(t, server)
+
+	err = sendTestMailFromErr(addr, oversizeMessage(20000))
+	var smtpErr *smtp.SMTPError
+	if !errors.As(err, &smtpErr) || smtpErr.Code != 552 || smtpErr.EnhancedCode != (smtp.EnhancedCode{5, 3, 4}) {
+		t.Fatalf("Expected 552 5.3.4 for an oversize message, got %v", err)
+	}
+	if emails := server.GetAllEmail(); len(emails) != 0 {
+		t.Errorf("Expected nothing to be stored, got %d emails", len(emails))
+	}
+	if files, _ := filepath.Glob(filepath.Join(tmpDir, "*.eml")); len(files) != 0 {
+		t.Errorf("Expected no files on disk, got %v", files)
+	}
+	if err := server.SetOversizeCapture(-1); err == nil {
+		t.Error("Expected error for a negative capture size")
+	}
+}
+
+// sendTestMailFromErr sends a message over a new SMTP connection and returns the reply error
+func sendTestMailFromErr(addr, body string) error {
+	c, err := smtp.Dial(addr)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = c.Close()
+	}()
+	return c.SendMail("big@example.com", []string{"user@example.com"}, strings.NewReader(body))
+}
+
+func TestOversizeCaptureHeaderEnd(t *testing.T) {
+	capture := newOversizeCapture(64, 4)
+	for _, chunk := range []string{"Subject: a\r", "\n\r\nbody", " continues"} {
+		if n, err := capture.Write([]byte(chunk)); n != len(chunk) || err != nil {
+			t.Fatalf("Write(%q) = %d, %v", chunk, n, err)
+		}
+	}
+	if got := string(capture.buf); got != "Subject: a\r\n\r\nbody" {
+		t.Errorf("Unexpected capture %q", got)
+	}
+	if headerEnd([]byte("Subject: a\n\nbody")) != 12 || headerEnd([]byte("Subject: a\r\n")) != -1 {
+		t.Error("Unexpected header end")
+	}
+
+	// A header longer than its limit is cut, and nothing more is kept
+	capture = newOversizeCapture(16, 4)
+	for _, chunk := range []string{"X-Long: " + strings.Repeat("a", 10), strings.Repeat("b", 100), "\r\n\r\nbody"} {
+		_, _ = capture.Write([]byte(chunk))
+	}
+	if got := string(capture.buf); got != "X-Long: aaaaaaaa" {
+		t.Errorf("Unexpected capture of an overlong header %q", got)
+	}
+}
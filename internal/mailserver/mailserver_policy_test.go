@@ -0,0 +1,207 @@
+package mailserver
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/emersion/go-sasl"
+	"github.com/emersion/go-smtp"
+)
+
+func TestAccessPolicyClientNetworks(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  AccessPolicy
+		allowed bool
+	}{
+		{name: "no policy", policy: AccessPolicy{}, allowed: true},
+		{name: "allowed network", policy: AccessPolicy{AllowNetworks: []string{"127.0.0.0/8"}}, allowed: true},
+		{name: "outside allowed networks", policy: AccessPolicy{AllowNetworks: []string{"10.0.0.0/8"}}, allowed: false},
+		{name: "denied address", policy: AccessPolicy{DenyNetworks: []string{"127.0.0.1"}}, allowed: false},
+		{name: "deny wins over allow", policy: AccessPolicy{AllowNetworks: []string{"127.0.0.0/8"}, DenyNetworks: []string{"127.0.0.1/32"}}, allowed: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, err := NewMailServer(1025, "localhost", t.TempDir())
+			if err != nil {
+				t.Fatalf("Failed to create mail server: %v", err)
+			}
+			if err := server.SetAccessPolicy(tt.policy); err != nil {
+				t.Fatalf("SetAccessPolicy failed: %v", err)
+			}
+			addr := startTestSMTPServer(t, server)
+
+			c, err := smtp.Dial(addr)
+			if err != nil {
+				t.Fatalf("Dial failed: %v", err)
+			}
+			defer func() {
+				_ = c.Close()
+			}()
+			err = c.Hello("client.example.com")
+			if tt.allowed {
+				if err != nil {
+					t.Errorf("Expected client to be accepted, got %v", err)
+				}
+				return
+			}
+			var smtpErr *smtp.SMTPError
+			if !errors.As(err, &smtpErr) || smtpErr.Code != 550 || smtpErr.EnhancedCode != (smtp.EnhancedCode{5, 7, 1}) {
+				t.Errorf("Expected 550 5.7.1, got %v", err)
+			}
+		})
+	}
+}
+
+func TestAccessPolicyUnixPeers(t *testing.T) {
+	server, err := NewMailServer(1025, "localhost", t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create mail server: %v", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+	if err := server.SetAccessPolicy(AccessPolicy{AllowNetworks: []string{"10.0.0.0/8"}}); err != nil {
+		t.Fatalf("SetAccessPolicy failed: %v", err)
+	}
+
+	if err := server.checkClientAccess(&net.UnixAddr{Name: "/run/owlmail.sock", Net: "unix"}); err != nil {
+		t.Errorf("Unix socket peers must be allowed: %v", err)
+	}
+	if err := server.checkClientAccess(&net.TCPAddr{IP: net.ParseIP("10.1.2.3")}); err != nil {
+		t.Errorf("Expected 10.1.2.3 to be allowed: %v", err)
+	}
+	if err := server.checkClientAccess(&net.TCPAddr{IP: net.ParseIP("2001:db8::1")}); err == nil {
+		t.Error("Expected 2001:db8::1 to be rejected")
+	}
+}
+
+func TestAccessPolicyRecipientDomains(t *testing.T) {
+	server, err := NewMailServer(1025, "localhost", t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create mail server: %v", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+	if err := server.SetAccessPolicy(AccessPolicy{AcceptedDomains: []string{"Example.com", "*.test.example.org"}}); err != nil {
+		t.Fatalf("SetAccessPolicy failed: %v", err)
+	}
+
+	tests := []struct {
+		recipient string
+		accepted  bool
+	}{
+		{"user@example.com", true},
+		{"user@EXAMPLE.COM", true},
+		{"user@sub.example.com", false},
+		{"user@a.test.example.org", true},
+		{"user@a.b.test.example.org", true},
+		{"user@test.example.org", false},
+		{"user@example.net", false},
+		{"postmaster", true},
+		{"nobody", false},
+	}
+	for _, tt := range tests {
+		err := server.checkRecipientDomain(tt.recipient)
+		if (err == nil) != tt.accepted {
+			t.Errorf("checkRecipientDomain(%q) = %v, expected accepted=%v", tt.recipient, err, tt.accepted)
+		}
+	}
+
+	policy := server.GetAccessPolicy()
+	if len(policy.AcceptedDomains) != 2 || policy.AcceptedDomains[0] != "example.com" || policy.AcceptedDomains[1] != "*.test.example.org" {
+		t.Errorf("Unexpected accepted domains: %v", policy.AcceptedDomains)
+	}
+}
+
+func TestAccessPolicyRcpt(t *testing.T) {
+	server, err := NewMailServer(1025, "localhost", t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create mail server: %v", err)
+	}
+	if err := server.SetAccessPolicy(AccessPolicy{AcceptedDomains: []string{"example.com"}}); err != nil {
+		t.Fatalf("SetAccessPolicy failed: %v", err)
+	}
+	addr := startTestSMTPServer(t, server)
+
+	c, err := smtp.Dial(addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer func() {
+		_ = c.Close()
+	}()
+	if err := c.Mail("sender@elsewhere.org", nil); err != nil {
+		t.Fatalf("MAIL FROM failed: %v", err)
+	}
+	err = c.Rcpt("user@elsewhere.org", nil)
+	var smtpErr *smtp.SMTPError
+	if !errors.As(err, &smtpErr) || smtpErr.Code != 550 || smtpErr.EnhancedCode != (smtp.EnhancedCode{5, 7, 1}) {
+		t.Errorf("Expected 550 5.7.1 for a foreign domain, got %v", err)
+	}
+	if err := c.Rcpt("user@example.com", nil); err != nil {
+		t.Errorf("Expected accepted domain to pass, got %v", err)
+	}
+}
+
+func TestAccessPolicyRcptAuthenticated(t *testing.T) {
+	authConfig := &SMTPAuthConfig{Username: "tester", Password: "secret", Enabled: true}
+	server, err := NewMailServerWithConfig(1025, "localhost", t.TempDir(), nil, authConfig, nil)
+	if err != nil {
+		t.Fatalf("Failed to create mail server: %v", err)
+	}
+	if err := server.SetAccessPolicy(AccessPolicy{AcceptedDomains: []string{"example.com"}}); err != nil {
+		t.Fatalf("SetAccessPolicy failed: %v", err)
+	}
+	addr := startTestSMTPServer(t, server)
+
+	c, err := smtp.Dial(addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer func() {
+		_ = c.Close()
+	}()
+	if err := c.Auth(sasl.NewPlainClient("", "tester", "secret")); err != nil {
+		t.Fatalf("Auth failed: %v", err)
+	}
+	if err := c.Mail("tester@example.com", nil); err != nil {
+		t.Fatalf("MAIL FROM failed: %v", err)
+	}
+
+	// Authenticated sessions may not relay to other domains either
+	err = c.Rcpt("user@elsewhere.org", nil)
+	var smtpErr *smtp.SMTPError
+	if !errors.As(err, &smtpErr) || smtpErr.Code != 550 {
+		t.Errorf("Expected 550 for a foreign domain, got %v", err)
+	}
+	if err := c.Rcpt("user@example.com", nil); err != nil {
+		t.Errorf("Expected accepted domain to pass, got %v", err)
+	}
+}
+
+func TestSetAccessPolicyInvalid(t *testing.T) {
+	server, err := NewMailServer(1025, "localhost", t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create mail server: %v", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+
+	policies := []AccessPolicy{
+		{AllowNetworks: []string{"not-a-network"}},
+		{DenyNetworks: []string{"10.0.0.0/33"}},
+		{AcceptedDomains: []string{"*"}},
+		{AcceptedDomains: []string{"user@example.com"}},
+		{AcceptedDomains: []string{"a.*.example.com"}},
+	}
+	for _, policy := range policies {
+		if err := server.SetAccessPolicy(policy); err == nil {
+			t.Errorf("Expected error for %+v", policy)
+		}
+	}
+}
@@ -0,0 +1,440 @@
+package mailserver
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-smtp"
+)
+
+// readReply reads a possibly multi-line reply and returns its lines
+func readReply(t *testing.T, conn *textproto.Conn) []string {
+	t.Helper()
+	var lines []string
+	for {
+		line, err := conn.ReadLine()
+		if err != nil {
+			t.Fatalf("Failed to read reply: %v", err)
+		}
+		lines = append(lines, line)
+		if len(line) < 4 || line[3] != '-' {
+			return lines
+		}
+	}
+}
+
+// command sends a command and returns its reply
+func command(t *testing.T, conn *textproto.Conn, line string) []string {
+	t.Helper()
+	if err := conn.PrintfLine("%s", line); err != nil {
+		t.Fatalf("Failed to send %q: %v", line, err)
+	}
+	return readReply(t, conn)
+}
+
+func TestSMTPProfilePostfixConversation(t *testing.T) {
+	server, err := NewMailServer(1025, "localhost", t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create mail server: %v", err)
+	}
+	profile, err := GetBuiltinSMTPProfile(ProfilePostfix)
+	if err != nil {
+		t.Fatalf("GetBuiltinSMTPProfile failed: %v", err)
+	}
+	if err := server.SetSMTPProfile(profile); err != nil {
+		t.Fatalf("SetSMTPProfile failed: %v", err)
+	}
+	addr := startTestSMTPServer(t, server)
+
+	raw, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	_ = raw.SetDeadline(time.Now().Add(5 * time.Second))
+	conn := textproto.NewConn(raw)
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	if greeting := readReply(t, conn); greeting[0] != "220 mail.example.com ESMTP Postfix" {
+		t.Errorf("Unexpected greeting %q", greeting)
+	}
+	ehlo := command(t, conn, "EHLO client.example.org")
+	if ehlo[0] != "250-mail.example.com" {
+		t.Errorf("Unexpected EHLO first line %q", ehlo[0])
+	}
+	reply := strings.Join(ehlo, "\n")
+	for _, expected := range []string{"250-SIZE 10240000", "250-SMTPUTF8", "250-DSN", "250 VRFY"} {
+		if !strings.Contains(reply, expected) {
+			t.Errorf("Expected %q in EHLO reply:\n%s", expected, reply)
+		}
+	}
+	for _, unexpected := range []string{"LIMITS", "BINARYMIME"} {
+		if strings.Contains(reply, unexpected) {
+			t.Errorf("Did not expect %q in EHLO reply:\n%s", unexpected, reply)
+		}
+	}
+
+	steps := []struct {
+		command string
+		reply   string
+	}{
+		{"MAIL FROM:<sender@example.com>", "250 2.1.0 Ok"},
+		{"RCPT TO:<user@example.com>", "250 2.1.5 Ok"},
+		{"DATA", "354 End data with <CR><LF>.<CR><LF>"},
+		{"Subject: Profile\r\n\r\nHello\r\n.", "250 2.0.0 Ok: queued as "},
+		{"NOOP", "250 2.0.0 Ok"},
+		{"VRFY user", "252 2.5.0 Cannot VRFY user"},
+		{"RSET", "250 2.0.0 Ok"},
+		{"QUIT", "221 2.0.0 Bye"},
+	}
+	for _, step := range steps {
+		if reply := command(t, conn, step.command); !strings.HasPrefix(reply[0], step.reply) {
+			t.Errorf("%s: got %q, expected %q", strings.SplitN(step.command, "\r\n", 2)[0], reply[0], step.reply)
+		}
+	}
+
+	emails := server.GetAllEmail()
+	if len(emails) != 1 {
+		t.Fatalf("Expected 1 email, got %d", len(emails))
+	}
+	// The transcript records the replies as sent
+	var transcript []string
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		entries, _ := server.GetEmailTranscript(emails[0].ID)
+		transcript = transcript[:0]
+		for _, entry := range entries {
+			transcript = append(transcript, entry.Line)
+		}
+		if strings.Contains(strings.Join(transcript, "\n"), "221 2.0.0 Bye") {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	log := strings.Join(transcript, "\n")
+	for _, expected := range []string{"220 mail.example.com ESMTP Postfix", "250-mail.example.com", "250 VRFY", "250 2.1.0 Ok", "250 2.0.0 Ok: queued as " + emails[0].ID} {
+		if !strings.Contains(log, expected) {
+			t.Errorf("Expected %q in transcript:\n%s", expected, log)
+		}
+	}
+	if strings.Contains(log, "Roger") {
+		t.Errorf("Transcript contains the default wording:\n%s", log)
+	}
+}
+
+// postfixTransaction delivers a message with the postfix profile's replies
+// expected and quits
+func postfixTransaction(t *testing.T, conn *textproto.Conn) {
+	t.Helper()
+	ehlo := command(t, conn, "EHLO client.example.org")
+	if ehlo[0] != "250-mail.example.com" || ehlo[len(ehlo)-1] != "250 VRFY" {
+		t.Errorf("Unexpected EHLO reply %q", ehlo)
+	}
+	if strings.Contains(strings.Join(ehlo, "\n"), "STARTTLS") {
+		t.Errorf("STARTTLS advertised on an encrypted connection: %q", ehlo)
+	}
+	steps := []struct {
+		command string
+		reply   string
+	}{
+		{"MAIL FROM:<sender@example.com>", "250 2.1.0 Ok"},
+		{"RCPT TO:<user@example.com>", "250 2.1.5 Ok"},
+		{"DATA", "354 End data with <CR><LF>.<CR><LF>"},
+		{"Subject: TLS\r\n\r\nHello\r\n.", "250 2.0.0 Ok: queued as "},
+		{"QUIT", "221 2.0.0 Bye"},
+	}
+	for _, step := range steps {
+		if reply := command(t, conn, step.command); !strings.HasPrefix(reply[0], step.reply) {
+			t.Errorf("%s: got %q, expected %q", strings.SplitN(step.command, "\r\n", 2)[0], reply[0], step.reply)
+		}
+	}
+}
+
+// waitForEmail waits for a single stored email whose transcript is complete
+func waitForEmail(t *testing.T, server *MailServer) *Email {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if emails := server.GetAllEmail(); len(emails) == 1 {
+			waitForTranscript(t, server, emails[0].ID, "event: connection closed")
+			return emails[0]
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the email")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSMTPProfileSTARTTLS(t *testing.T) {
+	server, err := NewMailServerWithConfig(1025, "localhost", t.TempDir(), nil, nil, &TLSConfig{Enabled: true})
+	if err != nil {
+		t.Fatalf("Failed to create mail server: %v", err)
+	}
+	profile, _ := GetBuiltinSMTPProfile(ProfilePostfix)
+	if err := server.SetSMTPProfile(profile); err != nil {
+		t.Fatalf("SetSMTPProfile failed: %v", err)
+	}
+	addr := startTestSMTPServer(t, server)
+
+	raw, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer func() {
+		_ = raw.Close()
+	}()
+	_ = raw.SetDeadline(time.Now().Add(5 * time.Second))
+	conn := textproto.NewConn(raw)
+	if greeting := readReply(t, conn); greeting[0] != "220 mail.example.com ESMTP Postfix" {
+		t.Errorf("Unexpected greeting %q", greeting)
+	}
+	if ehlo := command(t, conn, "EHLO client.example.org"); !strings.Contains(strings.Join(ehlo, "\n"), "250-STARTTLS") {
+		t.Fatalf("Expected STARTTLS to be advertised: %q", ehlo)
+	}
+	if reply := command(t, conn, "STARTTLS"); reply[0] != "220 2.0.0 Ready to start TLS" {
+		t.Fatalf("Unexpected STARTTLS reply %q", reply)
+	}
+
+	// Replies over TLS keep the profile's wording
+	postfixTransaction(t, textproto.NewConn(tls.Client(raw, &tls.Config{InsecureSkipVerify: true})))
+
+	email := waitForEmail(t, server)
+	if email.Envelope.TLS == nil || email.Envelope.TLS.Mode != TLSModeSTARTTLS {
+		t.Errorf("Expected STARTTLS on the envelope, got %+v", email.Envelope.TLS)
+	}
+	entries, _ := server.GetEmailTranscript(email.ID)
+	log := strings.Join(transcriptLines(entries), "\n")
+	for _, expected := range []string{"event: TLS handshake completed", "server: 250 2.1.0 Ok", "server: 221 2.0.0 Bye"} {
+		if !strings.Contains(log, expected) {
+			t.Errorf("Expected %q in transcript:\n%s", expected, log)
+		}
+	}
+	if strings.Contains(log, "Roger") {
+		t.Errorf("Transcript contains the default wording:\n%s", log)
+	}
+}
+
+func TestSMTPProfileLimits(t *testing.T) {
+	server, err := NewMailServer(1025, "localhost", t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create mail server: %v", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+	if err := server.SetListeners([]ListenerConfig{
+		{Addr: "127.0.0.1:0"},
+		{Addr: "127.0.0.1:0", MaxRecipients: 5},
+	}); err != nil {
+		t.Fatalf("SetListeners failed: %v", err)
+	}
+	profile, _ := GetBuiltinSMTPProfile(ProfileGmail)
+	if err := server.SetSMTPProfile(profile); err != nil {
+		t.Fatalf("SetSMTPProfile failed: %v", err)
+	}
+
+	listeners := server.GetListeners()
+	if listeners[0].MaxMessageBytes != 157286400 || listeners[0].MaxRecipients != 100 || listeners[0].ReadTimeout != 5*time.Minute {
+		t.Errorf("Expected the profile limits, got %+v", listeners[0])
+	}
+	if listeners[1].MaxRecipients != 5 {
+		t.Errorf("Expected the listener's own limit to be kept, got %d", listeners[1].MaxRecipients)
+	}
+	if names := server.GetESMTPExtensions().Names(); strings.Join(names, ",") != ExtensionSMTPUTF8 {
+		t.Errorf("Expected only SMTPUTF8, got %v", names)
+	}
+	if server.smtpServer.Domain != "mx.google.com" {
+		t.Errorf("Expected the profile hostname, got %q", server.smtpServer.Domain)
+	}
+}
+
+func TestSMTPProfileClientCompatibility(t *testing.T) {
+	for _, name := range SMTPProfileNames() {
+		t.Run(name, func(t *testing.T) {
+			server, err := NewMailServer(1025, "localhost", t.TempDir())
+			if err != nil {
+				t.Fatalf("Failed to create mail server: %v", err)
+			}
+			profile, _ := GetBuiltinSMTPProfile(name)
+			if err := server.SetSMTPProfile(profile); err != nil {
+				t.Fatalf("SetSMTPProfile failed: %v", err)
+			}
+			addr := startTestSMTPServer(t, server)
+
+			c, err := smtp.Dial(addr)
+			if err != nil {
+				t.Fatalf("Dial failed: %v", err)
+			}
+			if err := c.SendMail("sender@example.com", []string{"user@example.com"}, strings.NewReader("Subject: Hi\r\n\r\nHello\r\n")); err != nil {
+				t.Fatalf("SendMail failed: %v", err)
+			}
+			if err := c.Quit(); err != nil {
+				t.Errorf("Quit failed: %v", err)
+			}
+			if emails := server.GetAllEmail(); len(emails) != 1 {
+				t.Errorf("Expected 1 email, got %d", len(emails))
+			}
+		})
+	}
+}
+
+func TestSMTPProfileImplicitTLS(t *testing.T) {
+	server, err := NewMailServerWithConfig(1025, "localhost", t.TempDir(), nil, nil, &TLSConfig{Enabled: true})
+	if err != nil {
+		t.Fatalf("Failed to create mail server: %v", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+	socket := filepath.Join(t.TempDir(), "smtps.sock")
+	if err := server.SetListeners([]ListenerConfig{{Addr: socket, Mode: ListenerModeTLS}}); err != nil {
+		t.Fatalf("SetListeners failed: %v", err)
+	}
+	profile, _ := GetBuiltinSMTPProfile(ProfilePostfix)
+	if err := server.SetSMTPProfile(profile); err != nil {
+		t.Fatalf("SetSMTPProfile failed: %v", err)
+	}
+	go func() {
+		_ = server.Listen()
+	}()
+	waitForListener(t, "unix", socket)
+
+	raw, err := net.Dial("unix", socket)
+	if err != nil {
+		t.Fatalf("Dial unix failed: %v", err)
+	}
+	defer func() {
+		_ = raw.Close()
+	}()
+	_ = raw.SetDeadline(time.Now().Add(5 * time.Second))
+	conn := textproto.NewConn(tls.Client(raw, &tls.Config{InsecureSkipVerify: true}))
+	if greeting := readReply(t, conn); greeting[0] != "220 mail.example.com ESMTP Postfix" {
+		t.Errorf("Unexpected greeting %q", greeting)
+	}
+	postfixTransaction(t, conn)
+
+	email := waitForEmail(t, server)
+	if email.Envelope.TLS == nil || email.Envelope.TLS.Mode != TLSModeImplicit {
+		t.Errorf("Expected implicit TLS on the envelope, got %+v", email.Envelope.TLS)
+	}
+}
+
+func TestGoSMTPReplyWording(t *testing.T) {
+	// Profiles reword go-smtp's replies by matching their text, so a change
+	// in the go-smtp version must not go unnoticed
+	authConfig := &SMTPAuthConfig{Username: "admin", Password: "topsecret", Enabled: true}
+	server, err := NewMailServerWithConfig(1025, "localhost", t.TempDir(), nil, authConfig, &TLSConfig{Enabled: true})
+	if err != nil {
+		t.Fatalf("Failed to create mail server: %v", err)
+	}
+	addr := startTestSMTPServer(t, server)
+
+	raw, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer func() {
+		_ = raw.Close()
+	}()
+	_ = raw.SetDeadline(time.Now().Add(5 * time.Second))
+	conn := textproto.NewConn(raw)
+	readReply(t, conn)
+
+	credentials := base64.StdEncoding.EncodeToString([]byte("\x00admin\x00topsecret"))
+	steps := []struct {
+		command string
+		reply   string
+	}{
+		{"EHLO client.example.org", "250-" + goSMTPEHLOPrefix + "client.example.org"},
+		{"HELO client.example.org", "250 " + goSMTPHELOPrefix + "client.example.org"},
+		{"AUTH PLAIN " + credentials, "235 " + goSMTPAuthReply},
+		{"MAIL FROM:<sender@example.com>", "250 " + goSMTPMailPrefix + "sender@example.com>"},
+		{"RCPT TO:<user@example.com>", "250 " + goSMTPRcptPrefix + "user@example.com" + goSMTPRcptSuffix},
+		{"VRFY user", "252 " + goSMTPVrfyPrefix},
+		{"NOOP", "250 " + goSMTPNoopReply},
+		{"RSET", "250 " + goSMTPRsetReply},
+		{"MAIL FROM:<sender@example.com>", "250 " + goSMTPMailPrefix},
+		{"RCPT TO:<user@example.com>", "250 " + goSMTPRcptPrefix},
+		{"DATA", "354 " + goSMTPDataPrefix},
+		{"Subject: Wording\r\n\r\nHello\r\n.", "250 "},
+		{"STARTTLS", "220 " + goSMTPStartTLSReply},
+	}
+	for _, step := range steps {
+		if reply := command(t, conn, step.command); !strings.HasPrefix(reply[0], step.reply) {
+			t.Errorf("%s: got %q, expected %q", strings.SplitN(step.command, "\r\n", 2)[0], reply[0], step.reply)
+		}
+	}
+
+	// QUIT is answered on a connection of its own, as STARTTLS began a handshake
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	_ = c.SetDeadline(time.Now().Add(5 * time.Second))
+	quit := textproto.NewConn(c)
+	defer func() {
+		_ = quit.Close()
+	}()
+	readReply(t, quit)
+	if reply := command(t, quit, "QUIT"); reply[0] != "221 "+goSMTPQuitReply {
+		t.Errorf("QUIT: got %q", reply[0])
+	}
+}
+
+func TestLoadSMTPProfileFile(t *testing.T) {
+	dir := t.TempDir()
+	write := func(content string) string {
+		path := filepath.Join(dir, "profile.json")
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write profile: %v", err)
+		}
+		return path
+	}
+
+	profile, err := LoadSMTPProfileFile(write(`{
+		"name": "legacy-relay",
+		"base": "postfix",
+		"hostname": "relay.example.net",
+		"maxRecipients": 10,
+		"replies": {"mail": "2.1.0 Sender <{address}> ok"}
+	}`))
+	if err != nil {
+		t.Fatalf("LoadSMTPProfileFile failed: %v", err)
+	}
+	if profile.Name != "legacy-relay" || profile.Base != ProfilePostfix || profile.Hostname != "relay.example.net" {
+		t.Errorf("Unexpected profile: %+v", profile)
+	}
+	if profile.MaxRecipients != 10 || profile.MaxMessageBytes != 10240000 || profile.Banner != "{hostname} ESMTP Postfix" {
+		t.Errorf("Expected unset fields from the base profile: %+v", profile)
+	}
+	if profile.Replies[ReplyMail] != "2.1.0 Sender <{address}> ok" || profile.Replies[ReplyRcpt] != "2.1.5 Ok" {
+		t.Errorf("Unexpected replies: %v", profile.Replies)
+	}
+	// Built-in profiles are not modified
+	if postfix, _ := GetBuiltinSMTPProfile(ProfilePostfix); postfix.Replies[ReplyMail] != "2.1.0 Ok" {
+		t.Errorf("Built-in profile was modified: %v", postfix.Replies)
+	}
+
+	for _, invalid := range []string{
+		`{"base": "sendmail"}`,
+		`{"replies": {"greeting": "hi"}}`,
+		`{"readTimeout": "soon"}`,
+		`{"extensions": ["PIPELINING"]}`,
+		`{"banner": "two\nlines"}`,
+		`{"extraCapabilities": ["ETRN"]}`,
+		`not json`,
+	} {
+		if _, err := LoadSMTPProfileFile(write(invalid)); err == nil {
+			t.Errorf("Expected error for %s", invalid)
+		}
+	}
+}
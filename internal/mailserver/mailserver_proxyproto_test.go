@@ -0,0 +1,257 @@
+package mailserver
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-smtp"
+)
+
+// proxyV2Header builds a PROXY protocol v2 header for a TCP over IPv4 connection
+func proxyV2Header(command byte, src, dst string, srcPort, dstPort uint16) []byte {
+	header := append([]byte{}, proxyV2Signature...)
+	header = append(header, 0x20|command, 0x11, 0, 12)
+	header = append(header, net.ParseIP(src).To4()...)
+	header = append(header, net.ParseIP(dst).To4()...)
+	header = binary.BigEndian.AppendUint16(header, srcPort)
+	return binary.BigEndian.AppendUint16(header, dstPort)
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		remote  string // Empty keeps the connection's own address
+		wantErr bool
+	}{
+		{name: "v1 TCP4", header: "PROXY TCP4 203.0.113.7 192.0.2.1 51234 25\r\n", remote: "203.0.113.7:51234"},
+		{name: "v1 TCP6", header: "PROXY TCP6 2001:db8::7 2001:db8::1 51234 25\r\n", remote: "[2001:db8::7]:51234"},
+		{name: "v1 UNKNOWN", header: "PROXY UNKNOWN\r\n"},
+		{name: "v2 PROXY", header: string(proxyV2Header(0x1, "198.51.100.9", "192.0.2.1", 40000, 25)), remote: "198.51.100.9:40000"},
+		{name: "v2 LOCAL", header: string(proxyV2Header(0x0, "198.51.100.9", "192.0.2.1", 40000, 25))},
+		{name: "no header", header: "EHLO client.example.com\r\n", wantErr: true},
+		{name: "v1 bad address", header: "PROXY TCP4 2001:db8::7 192.0.2.1 51234 25\r\n", wantErr: true},
+		{name: "v1 bad port", header: "PROXY TCP4 203.0.113.7 192.0.2.1 99999 25\r\n", wantErr: true},
+		{name: "v1 too long", header: "PROXY TCP4 " + strings.Repeat("1", 120) + "\r\n", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, client := net.Pipe()
+			defer func() {
+				_ = server.Close()
+				_ = client.Close()
+			}()
+			go func() {
+				_, _ = io.WriteString(client, tt.header+"EHLO client.example.com\r\n")
+			}()
+			_ = server.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+			pc, err := readProxyHeader(server)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("Expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readProxyHeader failed: %v", err)
+			}
+			if tt.remote == "" {
+				if pc.RemoteAddr() != server.RemoteAddr() {
+					t.Errorf("Expected connection address, got %v", pc.RemoteAddr())
+				}
+			} else if pc.RemoteAddr().String() != tt.remote {
+				t.Errorf("RemoteAddr = %v, want %s", pc.RemoteAddr(), tt.remote)
+			}
+
+			// The bytes following the header reach the SMTP server
+			line, err := bufio.NewReader(pc).ReadString('\n')
+			if err != nil || line != "EHLO client.example.com\r\n" {
+				t.Errorf("Expected EHLO after header, got %q (%v)", line, err)
+			}
+		})
+	}
+}
+
+func TestReadProxyHeaderAlone(t *testing.T) {
+	// The client sends the header, then waits for the greeting
+	for _, header := range []string{
+		"PROXY UNKNOWN\r\n",
+		"PROXY TCP4 203.0.113.7 192.0.2.1 51234 25\r\n",
+		string(proxyV2Header(0x0, "198.51.100.9", "192.0.2.1", 40000, 25)),
+	} {
+		server, client := net.Pipe()
+		go func() {
+			_, _ = io.WriteString(client, header)
+		}()
+		_ = server.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if _, err := readProxyHeader(server); err != nil {
+			t.Errorf("readProxyHeader(%q) failed: %v", header, err)
+		}
+		_ = server.Close()
+		_ = client.Close()
+	}
+}
+
+func TestSetProxyProtocolTrusted(t *testing.T) {
+	server, err := NewMailServer(1025, "localhost", t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create mail server: %v", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+
+	if server.isProxyTrusted(&net.TCPAddr{IP: net.ParseIP("192.0.2.1")}) {
+		t.Error("Expected no source to be trusted by default")
+	}
+	if err := server.SetProxyProtocolTrusted([]string{"10.0.0.0/8", " 192.0.2.1", "2001:db8::/32"}); err != nil {
+		t.Fatalf("SetProxyProtocolTrusted failed: %v", err)
+	}
+	if got := strings.Join(server.GetProxyProtocolTrusted(), ","); got != "10.0.0.0/8,192.0.2.1/32,2001:db8::/32" {
+		t.Errorf("Unexpected trusted networks: %s", got)
+	}
+	for addr, want := range map[string]bool{
+		"10.1.2.3":    true,
+		"192.0.2.1":   true,
+		"192.0.2.2":   false,
+		"2001:db8::1": true,
+		"127.0.0.1":   false,
+	} {
+		if got := server.isProxyTrusted(&net.TCPAddr{IP: net.ParseIP(addr)}); got != want {
+			t.Errorf("isProxyTrusted(%s) = %v, want %v", addr, got, want)
+		}
+	}
+	if server.isProxyTrusted(&net.UnixAddr{Name: "/run/owlmail.sock", Net: "unix"}) {
+		t.Error("Expected unix socket peers not to be trusted")
+	}
+
+	if err := server.SetProxyProtocolTrusted([]string{"10.0.0.0/33"}); err == nil {
+		t.Error("Expected error for invalid CIDR")
+	}
+	if err := server.SetProxyProtocolTrusted([]string{"proxy.example.com"}); err == nil {
+		t.Error("Expected error for host name")
+	}
+}
+
+// startProxySMTPServer serves ms.smtpServer with PROXY protocol enabled
+func startProxySMTPServer(t *testing.T, ms *MailServer) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go func() {
+		_ = ms.serve(ms.smtpServer, ln, false, true)
+	}()
+	t.Cleanup(func() {
+		_ = ms.Close()
+	})
+	return ln.Addr().String()
+}
+
+func TestProxyProtocolClientAddress(t *testing.T) {
+	server, err := NewMailServer(1025, "localhost", t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create mail server: %v", err)
+	}
+	if err := server.SetGreylistConfig(GreylistConfig{Enabled: true, Delay: time.Hour}); err != nil {
+		t.Fatalf("SetGreylistConfig failed: %v", err)
+	}
+	if err := server.SetProxyProtocolTrusted([]string{"127.0.0.1"}); err != nil {
+		t.Fatalf("SetProxyProtocolTrusted failed: %v", err)
+	}
+	addr := startProxySMTPServer(t, server)
+
+	send := func(header string) error {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		if _, err := io.WriteString(conn, header); err != nil {
+			t.Fatalf("Failed to send PROXY header: %v", err)
+		}
+		c := smtp.NewClient(conn)
+		defer func() {
+			_ = c.Close()
+		}()
+		return c.SendMail("sender@example.com", []string{"rcpt@example.com"}, strings.NewReader("Subject: hi\r\n\r\nbody\r\n"))
+	}
+
+	// Greylisting sees the client announced by the proxy
+	if err := send("PROXY TCP4 203.0.113.7 192.0.2.1 51234 25\r\n"); err == nil {
+		t.Fatal("Expected first attempt to be greylisted")
+	}
+	entries := server.GetGreylistEntries()
+	if len(entries) != 1 || entries[0].ClientIP != "203.0.113.7" {
+		t.Fatalf("Expected greylist entry for 203.0.113.7, got %+v", entries)
+	}
+
+	if err := server.SetGreylistConfig(GreylistConfig{}); err != nil {
+		t.Fatalf("SetGreylistConfig failed: %v", err)
+	}
+	if err := send(string(proxyV2Header(0x1, "198.51.100.9", "192.0.2.1", 40000, 25))); err != nil {
+		t.Fatalf("SendMail failed: %v", err)
+	}
+	emails := server.GetAllEmail()
+	if len(emails) != 1 {
+		t.Fatalf("Expected 1 email, got %d", len(emails))
+	}
+	if got := emails[0].Envelope.RemoteAddress; got != "198.51.100.9:40000" {
+		t.Errorf("Envelope remote address = %s, want 198.51.100.9:40000", got)
+	}
+	transcript, err := server.GetEmailTranscript(emails[0].ID)
+	if err != nil || len(transcript) == 0 {
+		t.Fatalf("GetEmailTranscript failed: %v", err)
+	}
+	if !strings.Contains(transcript[0].Line, "connection from 198.51.100.9:40000") || !strings.Contains(transcript[0].Line, "via proxy 127.0.0.1:") {
+		t.Errorf("Unexpected transcript start: %q", transcript[0].Line)
+	}
+
+	// Connections without a header are dropped
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	_, _ = io.WriteString(conn, "EHLO client.example.com\r\n")
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if n, err := conn.Read(make([]byte, 512)); err == nil {
+		t.Errorf("Expected connection without PROXY header to be closed, read %d bytes", n)
+	}
+}
+
+func TestProxyProtocolUntrustedSource(t *testing.T) {
+	server, err := NewMailServer(1025, "localhost", t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create mail server: %v", err)
+	}
+	if err := server.SetProxyProtocolTrusted([]string{"10.0.0.0/8"}); err != nil {
+		t.Fatalf("SetProxyProtocolTrusted failed: %v", err)
+	}
+	addr := startProxySMTPServer(t, server)
+
+	// Untrusted sources are served directly and keep their own address
+	c, err := smtp.Dial(addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer func() {
+		_ = c.Close()
+	}()
+	if err := c.SendMail("sender@example.com", []string{"rcpt@example.com"}, strings.NewReader("Subject: hi\r\n\r\nbody\r\n")); err != nil {
+		t.Fatalf("SendMail failed: %v", err)
+	}
+	emails := server.GetAllEmail()
+	if len(emails) != 1 || !strings.HasPrefix(emails[0].Envelope.RemoteAddress, "127.0.0.1:") {
+		t.Fatalf("Expected direct connection from 127.0.0.1, got %+v", emails)
+	}
+}
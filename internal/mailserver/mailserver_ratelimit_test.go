@@ -0,0 +1,261 @@
+package mailserver
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-sasl"
+	"github.com/emersion/go-smtp"
+)
+
+// readGreeting reads the first reply line sent on a new connection
+func readGreeting(t *testing.T, addr string) string {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatalf("Failed to read greeting: %v", err)
+	}
+	return strings.TrimSpace(line)
+}
+
+func TestRateLimitConnectionsPerIP(t *testing.T) {
+	server, err := NewMailServer(1025, "localhost", t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create mail server: %v", err)
+	}
+	if err := server.SetRateLimitConfig(RateLimitConfig{MaxConnectionsPerIP: 1}); err != nil {
+		t.Fatalf("SetRateLimitConfig failed: %v", err)
+	}
+	addr := startTestSMTPServer(t, server)
+
+	c, err := smtp.Dial(addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	if greeting := readGreeting(t, addr); greeting != "421 4.7.0 Too many connections from 127.0.0.1, try again later" {
+		t.Errorf("Unexpected reply to second connection: %q", greeting)
+	}
+
+	stats := server.GetRateLimitStats()
+	if stats.Connections != 1 || stats.ConnectionsPerIP["127.0.0.1"] != 1 || stats.RejectedConnections != 1 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+
+	// Closing the first connection frees its slot
+	if err := c.Quit(); err != nil {
+		t.Fatalf("Quit failed: %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for server.GetRateLimitStats().Connections != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Connection slot was not released")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if greeting := readGreeting(t, addr); !strings.HasPrefix(greeting, "220 ") {
+		t.Errorf("Expected greeting after slot was freed, got %q", greeting)
+	}
+}
+
+func TestRateLimitMaxConnections(t *testing.T) {
+	server, err := NewMailServer(1025, "localhost", t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create mail server: %v", err)
+	}
+	if err := server.SetRateLimitConfig(RateLimitConfig{MaxConnections: 2}); err != nil {
+		t.Fatalf("SetRateLimitConfig failed: %v", err)
+	}
+	addr := startTestSMTPServer(t, server)
+
+	for i := 0; i < 2; i++ {
+		c, err := smtp.Dial(addr)
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		defer func() {
+			_ = c.Close()
+		}()
+	}
+	if greeting := readGreeting(t, addr); !strings.HasPrefix(greeting, "421 4.3.2 ") {
+		t.Errorf("Expected 421 when the server is full, got %q", greeting)
+	}
+}
+
+// sendRateLimited sends count messages over one connection and returns the
+// SMTP error of the first rejected one
+func sendRateLimited(t *testing.T, c *smtp.Client, count int) *smtp.SMTPError {
+	t.Helper()
+	for i := 0; i < count; i++ {
+		err := c.SendMail("sender@example.com", []string{"rcpt@example.com"}, strings.NewReader("Subject: rate\r\n\r\nbody\r\n"))
+		var smtpErr *smtp.SMTPError
+		if errors.As(err, &smtpErr) {
+			return smtpErr
+		}
+		if err != nil {
+			t.Fatalf("SendMail failed: %v", err)
+		}
+	}
+	return nil
+}
+
+func TestRateLimitMessages(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  RateLimitConfig
+		auth    bool
+		message string
+	}{
+		{name: "per IP", config: RateLimitConfig{MessagesPerMinutePerIP: 2}, message: "Too many messages from 127.0.0.1, try again later"},
+		{name: "per user", config: RateLimitConfig{MessagesPerMinutePerUser: 2}, auth: true, message: "Too many messages from user admin, try again later"},
+		{name: "global", config: RateLimitConfig{MessagesPerMinute: 2}, message: "Too many messages, try again later"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var authConfig *SMTPAuthConfig
+			if tt.auth {
+				authConfig = &SMTPAuthConfig{Username: "admin", Password: "secret", Enabled: true}
+			}
+			server, err := NewMailServerWithConfig(1025, "localhost", t.TempDir(), nil, authConfig, nil)
+			if err != nil {
+				t.Fatalf("Failed to create mail server: %v", err)
+			}
+			if err := server.SetRateLimitConfig(tt.config); err != nil {
+				t.Fatalf("SetRateLimitConfig failed: %v", err)
+			}
+			addr := startTestSMTPServer(t, server)
+
+			c, err := smtp.Dial(addr)
+			if err != nil {
+				t.Fatalf("Dial failed: %v", err)
+			}
+			defer func() {
+				_ = c.Close()
+			}()
+			if tt.auth {
+				if err := c.Auth(sasl.NewPlainClient("", "admin", "secret")); err != nil {
+					t.Fatalf("Auth failed: %v", err)
+				}
+			}
+
+			smtpErr := sendRateLimited(t, c, 3)
+			if smtpErr == nil {
+				t.Fatal("Expected the third message to be rate limited")
+			}
+			if smtpErr.Code != 451 || smtpErr.Message != tt.message {
+				t.Errorf("Unexpected reply: %d %s", smtpErr.Code, smtpErr.Message)
+			}
+			if got := len(server.GetAllEmail()); got != 2 {
+				t.Errorf("Expected 2 delivered emails, got %d", got)
+			}
+
+			stats := server.GetRateLimitStats()
+			if stats.Messages != 2 || stats.MessagesPerIP["127.0.0.1"] != 2 || stats.RejectedMessages != 1 {
+				t.Errorf("Unexpected stats: %+v", stats)
+			}
+			if tt.auth && stats.MessagesPerUser["admin"] != 2 {
+				t.Errorf("Expected 2 messages for admin, got %+v", stats.MessagesPerUser)
+			}
+		})
+	}
+}
+
+func TestRateLimitCountsAcceptedMessages(t *testing.T) {
+	server, err := NewMailServer(1025, "localhost", t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create mail server: %v", err)
+	}
+	if err := server.SetRateLimitConfig(RateLimitConfig{MessagesPerMinutePerIP: 1}); err != nil {
+		t.Fatalf("SetRateLimitConfig failed: %v", err)
+	}
+	if err := server.SetFaultRules([]FaultRule{{Stage: FaultStageData, Match: "refused@example.com", Code: 554}}); err != nil {
+		t.Fatalf("SetFaultRules failed: %v", err)
+	}
+	addr := startTestSMTPServer(t, server)
+
+	c, err := smtp.Dial(addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer func() {
+		_ = c.Close()
+	}()
+
+	// Aborted and refused transactions do not count
+	if err := c.Mail("aborted@example.com", nil); err != nil {
+		t.Fatalf("MAIL FROM failed: %v", err)
+	}
+	if err := c.Reset(); err != nil {
+		t.Fatalf("RSET failed: %v", err)
+	}
+	err = c.SendMail("refused@example.com", []string{"rcpt@example.com"}, strings.NewReader("Subject: refused\r\n\r\nbody\r\n"))
+	if err == nil {
+		t.Fatal("Expected the message to be refused")
+	}
+	if stats := server.GetRateLimitStats(); stats.Messages != 0 {
+		t.Fatalf("Expected no counted messages, got %+v", stats)
+	}
+
+	if smtpErr := sendRateLimited(t, c, 2); smtpErr == nil || smtpErr.Code != 451 {
+		t.Fatalf("Expected the second accepted message to be rate limited, got %v", smtpErr)
+	}
+	if stats := server.GetRateLimitStats(); stats.Messages != 1 || stats.RejectedMessages != 1 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
+
+func TestRateLimitWindow(t *testing.T) {
+	server, err := NewMailServer(1025, "localhost", t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create mail server: %v", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+	if err := server.SetRateLimitConfig(RateLimitConfig{MessagesPerMinutePerIP: 1}); err != nil {
+		t.Fatalf("SetRateLimitConfig failed: %v", err)
+	}
+
+	if err := server.checkMessageRate("192.0.2.1", ""); err != nil {
+		t.Fatalf("First message rejected: %v", err)
+	}
+	server.countMessageRate("192.0.2.1", "")
+	if err := server.checkMessageRate("192.0.2.1", ""); err == nil {
+		t.Fatal("Expected second message to be rejected")
+	}
+	if err := server.checkMessageRate("192.0.2.2", ""); err != nil {
+		t.Errorf("Other IPs must not be limited: %v", err)
+	}
+
+	// Messages older than a minute no longer count
+	server.rateLimitMutex.Lock()
+	old := time.Now().Add(-2 * rateLimitWindow)
+	server.rateLimiter.messages = []time.Time{old, old}
+	server.rateLimiter.messagesPerIP["192.0.2.1"] = []time.Time{old}
+	server.rateLimiter.messagesPerIP["192.0.2.2"] = []time.Time{old}
+	server.rateLimitMutex.Unlock()
+	if err := server.checkMessageRate("192.0.2.1", ""); err != nil {
+		t.Errorf("Expected message to be accepted after the window: %v", err)
+	}
+	server.countMessageRate("192.0.2.1", "")
+	stats := server.GetRateLimitStats()
+	if stats.Messages != 1 || len(stats.MessagesPerIP) != 1 {
+		t.Errorf("Expected expired messages to be dropped, got %+v", stats)
+	}
+
+	if err := server.SetRateLimitConfig(RateLimitConfig{MaxConnections: -1}); err == nil {
+		t.Error("Expected error for negative limit")
+	}
+}
@@ -102,9 +102,15 @@ func TestSessionMail(t *testing.T) {
 	}
 	session.authenticated = false
 	err = session.Mail("from@example.com", nil)
-	// Should still succeed (we just log a warning)
-	if err != nil {
-		t.Errorf("Mail should still succeed with warning, got error: %v", err)
+	// Should be rejected with 530 when not authenticated
+	if err != errAuthRequired {
+		t.Errorf("Mail should be rejected with errAuthRequired, got: %v", err)
+	}
+
+	// Should succeed once authenticated
+	session.authenticated = true
+	if err := session.Mail("from@example.com", nil); err != nil {
+		t.Errorf("Mail should succeed after authentication, got error: %v", err)
 	}
 }
 
@@ -135,8 +141,8 @@ func TestSessionMailWithConnNilConn(t *testing.T) {
 	}
 
 	err = session.Mail("from@example.com", nil)
-	if err != nil {
-		t.Errorf("Mail should succeed, got error: %v", err)
+	if err != errAuthRequired {
+		t.Errorf("Mail should be rejected with errAuthRequired, got: %v", err)
 	}
 }
 
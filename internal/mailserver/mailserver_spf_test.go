@@ -0,0 +1,246 @@
+package mailserver
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-smtp"
+)
+
+// spfTestZone publishes the SPF and DMARC policies used by the tests
+const spfTestZone = `$ORIGIN example.com.
+@                TXT  "v=spf1 mx ip4:192.0.2.0/24 include:_spf.esp.example.net -all"
+@                MX   10 mail
+mail             A    198.51.100.25
+mail             TXT  "v=spf1 a -all"
+_dmarc           TXT  "v=DMARC1; p=reject; sp=quarantine; adkim=s"
+
+$ORIGIN example.org.
+soft             TXT  "v=spf1 ~all"
+redirect         TXT  "v=spf1 redirect=example.com"
+macro            TXT  "v=spf1 exists:%{i}._spf.%{d} -all"
+203.0.113.7._spf.macro  A  127.0.0.2
+twice            TXT  "v=spf1 -all"
+twice            TXT  "v=spf1 +all"
+loop             TXT  "v=spf1 include:loop.example.org"
+bad              TXT  "v=spf1 foo -all"
+_dmarc.bad       TXT  "v=DMARC1; p=maybe"
+local            TXT  "v=spf1 ip4:127.0.0.1 -all"
+_dmarc.local     TXT  "v=DMARC1; p=none"
+
+_spf.esp.example.net.  TXT  "v=spf1 ip6:2001:db8::/32 ~all"
+`
+
+func loadSPFTestZone(t *testing.T) *ZoneFile {
+	t.Helper()
+	zone, err := ParseZoneFile(strings.NewReader(spfTestZone))
+	if err != nil {
+		t.Fatalf("ParseZoneFile failed: %v", err)
+	}
+	return zone
+}
+
+func TestCheckSPF(t *testing.T) {
+	zone := loadSPFTestZone(t)
+	tests := []struct {
+		name     string
+		ip       string
+		mailFrom string
+		helo     string
+		result   string
+		reason   string
+	}{
+		{"ip4 mechanism", "192.0.2.10", "user@example.com", "", SPFPass, "ip4:192.0.2.0/24"},
+		{"mx mechanism", "198.51.100.25", "user@example.com", "", SPFPass, "matched mx"},
+		{"include", "2001:db8::1", "user@example.com", "", SPFPass, "include:_spf.esp.example.net"},
+		{"no match", "203.0.113.9", "user@EXAMPLE.com", "", SPFFail, "matched -all"},
+		{"softfail", "203.0.113.9", "user@soft.example.org", "", SPFSoftFail, "~all"},
+		{"redirect pass", "192.0.2.1", "user@redirect.example.org", "", SPFPass, "ip4:192.0.2.0/24"},
+		{"redirect fail", "203.0.113.9", "user@redirect.example.org", "", SPFFail, "-all"},
+		{"macro exists", "203.0.113.7", "user@macro.example.org", "", SPFPass, "exists:"},
+		{"macro no match", "203.0.113.8", "user@macro.example.org", "", SPFFail, "-all"},
+		{"null sender uses helo", "198.51.100.25", "", "mail.example.com", SPFPass, "matched a"},
+		{"no record", "192.0.2.1", "user@nosuch.example.org", "", SPFNone, "no SPF record"},
+		{"multiple records", "192.0.2.1", "user@twice.example.org", "", SPFPermError, "multiple SPF records"},
+		{"lookup limit", "192.0.2.1", "user@loop.example.org", "", SPFPermError, "too many DNS lookups"},
+		{"unknown mechanism", "192.0.2.1", "user@bad.example.org", "", SPFPermError, "unknown mechanism"},
+		{"invalid domain", "192.0.2.1", "user@localhost", "", SPFNone, "no valid sender domain"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := checkSPF(zone, net.ParseIP(tt.ip), tt.mailFrom, tt.helo)
+			if result.Result != tt.result || !strings.Contains(result.Reason, tt.reason) {
+				t.Errorf("checkSPF = %s (%s), expected %s (%s)", result.Result, result.Reason, tt.result, tt.reason)
+			}
+		})
+	}
+
+	result := checkSPF(zone, net.ParseIP("198.51.100.25"), "", "mail.example.com")
+	if result.Identity != "helo" || result.Sender != "postmaster@mail.example.com" || result.Domain != "mail.example.com" {
+		t.Errorf("Unexpected HELO identity: %+v", result)
+	}
+	if result := checkSPF(failingResolver{}, net.ParseIP("192.0.2.1"), "user@example.com", ""); result.Result != SPFTempError {
+		t.Errorf("Expected temperror for failed lookups, got %+v", result)
+	}
+	if result := checkSPF(zone, nil, "user@example.com", ""); result.Result != SPFNone {
+		t.Errorf("Expected none without a client address, got %+v", result)
+	}
+}
+
+func TestSPFMacroExpansion(t *testing.T) {
+	// Examples of RFC 7208 section 7.4
+	check := &spfCheck{ip: net.ParseIP("192.0.2.3"), sender: "strong-bad@email.example.com"}
+	tests := map[string]string{
+		"%{s}":                     "strong-bad@email.example.com",
+		"%{o}":                     "email.example.com",
+		"%{d4}":                    "email.example.com",
+		"%{d2}":                    "example.com",
+		"%{d1}":                    "com",
+		"%{dr}":                    "com.example.email",
+		"%{d2r}":                   "example.email",
+		"%{l}":                     "strong-bad",
+		"%{l-}":                    "strong.bad",
+		"%{lr-}":                   "bad.strong",
+		"%{l1r-}":                  "strong",
+		"%{ir}.%{v}._spf.%{d2}":    "3.2.0.192.in-addr._spf.example.com",
+		"%{lr-}.lp._spf.%{d2}":     "bad.strong.lp._spf.example.com",
+		"%{d2}.trusted-domains.%%": "example.com.trusted-domains.%",
+	}
+	for spec, expected := range tests {
+		if expanded, err := check.expand(spec, "email.example.com"); err != nil || expanded != expected {
+			t.Errorf("expand(%q) = %q, %v; expected %q", spec, expanded, err, expected)
+		}
+	}
+
+	check.ip = net.ParseIP("2001:db8::cb01")
+	expected := "1.0.b.c.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6._spf.example.com"
+	if expanded, err := check.expand("%{ir}.%{v}._spf.%{d2}", "email.example.com"); err != nil || expanded != expected {
+		t.Errorf("IPv6 expansion = %q, %v; expected %q", expanded, err, expected)
+	}
+
+	for _, invalid := range []string{"%{x}", "%{d0}", "%{}", "%", "%a"} {
+		if _, err := check.expand(invalid, "email.example.com"); err == nil {
+			t.Errorf("Expected error for %q", invalid)
+		}
+	}
+}
+
+func TestCheckDMARC(t *testing.T) {
+	zone := loadSPFTestZone(t)
+	passDKIM := func(domain string) []DKIMResult {
+		return []DKIMResult{{Domain: domain, Result: DKIMPass}}
+	}
+	passSPF := func(domain string) *SPFResult {
+		return &SPFResult{Domain: domain, Result: SPFPass}
+	}
+	tests := []struct {
+		name        string
+		from        string
+		dkim        []DKIMResult
+		spf         *SPFResult
+		result      string
+		policy      string
+		dkimAligned bool
+		spfAligned  bool
+	}{
+		{"aligned DKIM", "example.com", passDKIM("example.com"), nil, DMARCPass, "reject", true, false},
+		{"strict DKIM alignment", "example.com", passDKIM("mail.example.com"), nil, DMARCFail, "reject", false, false},
+		{"relaxed SPF alignment", "example.com", passDKIM("mail.example.com"), passSPF("bounce.example.com"), DMARCPass, "reject", false, true},
+		{"unaligned SPF", "example.com", nil, passSPF("example.net"), DMARCFail, "reject", false, false},
+		{"failed DKIM", "example.com", []DKIMResult{{Domain: "example.com", Result: DKIMFail}}, nil, DMARCFail, "reject", false, false},
+		{"subdomain policy", "news.example.com", nil, nil, DMARCFail, "quarantine", false, false},
+		{"no record", "example.net", passDKIM("example.net"), nil, DMARCNone, "", false, false},
+		{"invalid policy", "bad.example.org", nil, nil, DMARCPermError, "", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := checkDMARC(zone, tt.from, tt.dkim, tt.spf)
+			if result.Result != tt.result || result.Policy != tt.policy || result.DKIMAligned != tt.dkimAligned || result.SPFAligned != tt.spfAligned {
+				t.Errorf("Unexpected result: %+v", result)
+			}
+		})
+	}
+
+	if result := checkDMARC(failingResolver{}, "example.com", nil, nil); result.Result != DMARCTempError {
+		t.Errorf("Expected temperror for failed lookups, got %+v", result)
+	}
+}
+
+func TestAuthenticationResultsOnReceivedEmail(t *testing.T) {
+	server, err := NewMailServer(1025, "localhost", t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create mail server: %v", err)
+	}
+	server.SetDNSResolver(loadSPFTestZone(t))
+	addr := startTestSMTPServer(t, server)
+
+	c, err := smtp.Dial(addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	message := "From: App <app@local.example.org>\r\nTo: user@example.com\r\nSubject: Aligned\r\n\r\nHello\r\n"
+	if err := c.SendMail("bounces@local.example.org", []string{"user@example.com"}, strings.NewReader(message)); err != nil {
+		t.Fatalf("SendMail failed: %v", err)
+	}
+	_ = c.Quit()
+
+	emails := server.GetAllEmail()
+	if len(emails) != 1 {
+		t.Fatalf("Expected 1 email, got %d", len(emails))
+	}
+	email := emails[0]
+	if email.SPF == nil || email.SPF.Result != SPFPass || email.SPF.ClientIP != "127.0.0.1" {
+		t.Errorf("Expected SPF pass for 127.0.0.1, got %+v", email.SPF)
+	}
+	if email.DMARC == nil || email.DMARC.Result != DMARCPass || !email.DMARC.SPFAligned || email.DMARC.Policy != "none" {
+		t.Errorf("Expected an SPF-aligned DMARC pass, got %+v", email.DMARC)
+	}
+	expected := "localhost; dkim=none; spf=pass (local.example.org matched ip4:127.0.0.1) smtp.mailfrom=bounces@local.example.org; dmarc=pass (p=none) header.from=local.example.org"
+	if email.AuthenticationResults != expected {
+		t.Errorf("Authentication-Results = %q, expected %q", email.AuthenticationResults, expected)
+	}
+}
+
+func TestAuthenticationResultsSurviveRestart(t *testing.T) {
+	tmpDir := t.TempDir()
+	server, err := NewMailServer(1025, "localhost", tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create mail server: %v", err)
+	}
+	server.SetDNSResolver(loadSPFTestZone(t))
+	addr := startTestSMTPServer(t, server)
+
+	c, err := smtp.Dial(addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	message := "From: App <app@local.example.org>\r\nTo: user@example.com\r\nSubject: Aligned\r\n\r\nHello\r\n"
+	if err := c.SendMail("bounces@local.example.org", []string{"user@example.com"}, strings.NewReader(message)); err != nil {
+		t.Fatalf("SendMail failed: %v", err)
+	}
+	_ = c.Quit()
+	received := server.GetAllEmail()
+	if len(received) != 1 {
+		t.Fatalf("Expected 1 email, got %d", len(received))
+	}
+
+	// Emails are restored before any zone file is set, with the results they were received with
+	restarted, err := NewMailServer(1025, "localhost", tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create mail server: %v", err)
+	}
+	defer func() {
+		_ = restarted.Close()
+	}()
+	restored := restarted.GetAllEmail()
+	if len(restored) != 1 {
+		t.Fatalf("Expected 1 restored email, got %d", len(restored))
+	}
+	if restored[0].SPF == nil || restored[0].SPF.Result != SPFPass || restored[0].DMARC == nil || restored[0].DMARC.Result != DMARCPass {
+		t.Errorf("Expected the SPF and DMARC results of receipt, got %+v and %+v", restored[0].SPF, restored[0].DMARC)
+	}
+	if restored[0].AuthenticationResults != received[0].AuthenticationResults {
+		t.Errorf("Authentication-Results = %q, expected %q", restored[0].AuthenticationResults, received[0].AuthenticationResults)
+	}
+}
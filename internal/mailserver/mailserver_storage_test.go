@@ -0,0 +1,148 @@
+package mailserver
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-smtp"
+)
+
+// sendTestMailFrom sends a message with the given envelope over a new SMTP connection
+func sendTestMailFrom(t *testing.T, addr, from, to, body string) {
+	t.Helper()
+	c, err := smtp.Dial(addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer func() {
+		_ = c.Close()
+	}()
+	if err := c.SendMail(from, []string{to}, strings.NewReader(body)); err != nil {
+		t.Fatalf("SendMail from %s to %s failed: %v", from, to, err)
+	}
+	if err := c.Quit(); err != nil {
+		t.Fatalf("Quit failed: %v", err)
+	}
+}
+
+func TestStorageConfigValidate(t *testing.T) {
+	for _, config := range []StorageConfig{
+		{Mode: "none"},
+		{Mode: StorageModeSample, SampleEvery: -1},
+		{Mode: StorageModeSample, MatchFrom: "[a-"},
+	} {
+		if err := config.Validate(); err == nil {
+			t.Errorf("Expected error for %+v", config)
+		}
+	}
+	for _, config := range []StorageConfig{
+		{},
+		{Mode: StorageModeBlackhole},
+		{Mode: StorageModeSample, SampleEvery: 100, MatchFrom: "*@example.com", MatchTo: "qa+*@example.com"},
+	} {
+		if err := config.Validate(); err != nil {
+			t.Errorf("Unexpected error for %+v: %v", config, err)
+		}
+	}
+}
+
+func TestStorageBlackhole(t *testing.T) {
+	tmpDir := t.TempDir()
+	server, err := NewMailServer(1025, "localhost", tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create mail server: %v", err)
+	}
+	if err := server.SetStorageConfig(StorageConfig{Mode: StorageModeBlackhole}); err != nil {
+		t.Fatalf("SetStorageConfig failed: %v", err)
+	}
+	addr := startTestSMTPServer(t, server)
+
+	body := "Subject: load\r\n\r\nhello\r\n"
+	for range 3 {
+		sendTestMailFrom(t, addr, "App@Example.com", "user@example.com", body)
+	}
+	sendTestMailFrom(t, addr, "", "user@example.com", body)
+
+	if emails := server.GetAllEmail(); len(emails) != 0 {
+		t.Errorf("Expected no stored emails, got %d", len(emails))
+	}
+	if files, _ := filepath.Glob(filepath.Join(tmpDir, "*.eml")); len(files) != 0 {
+		t.Errorf("Expected no files on disk, got %v", files)
+	}
+
+	stats := server.GetStorageStats()
+	size := int64(len(body))
+	if stats.Messages != 4 || stats.Bytes != 4*size || stats.Stored != 0 || stats.Discarded != 4 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+	if got := stats.Senders["app@example.com"]; got != (SenderStats{Messages: 3, Bytes: 3 * size}) {
+		t.Errorf("Unexpected sender totals: %+v", stats.Senders)
+	}
+	if got := stats.Senders["<>"]; got.Messages != 1 {
+		t.Errorf("Expected the null sender to be counted, got %+v", stats.Senders)
+	}
+	if received, ok := server.GetEmailStats()["received"].(StorageStats); !ok || received.Messages != 4 {
+		t.Errorf("Expected the counters in the email stats, got %v", server.GetEmailStats()["received"])
+	}
+}
+
+func TestStorageSenderLimit(t *testing.T) {
+	server, err := NewMailServer(1025, "localhost", t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create mail server: %v", err)
+	}
+	for i := range maxTrackedSenders + 2 {
+		server.countMessage(fmt.Sprintf("sender%d@example.com", i), 10, false)
+	}
+	server.countMessage("sender0@example.com", 10, false)
+
+	stats := server.GetStorageStats()
+	if len(stats.Senders) != maxTrackedSenders+1 {
+		t.Errorf("Expected %d sender entries, got %d", maxTrackedSenders+1, len(stats.Senders))
+	}
+	if got := stats.Senders["sender0@example.com"]; got.Messages != 2 {
+		t.Errorf("Expected tracked senders to keep counting, got %+v", got)
+	}
+	if got := stats.Senders[otherSenders]; got != (SenderStats{Messages: 2, Bytes: 20}) {
+		t.Errorf("Unexpected totals of other senders: %+v", got)
+	}
+	if stats.Messages != maxTrackedSenders+3 {
+		t.Errorf("Expected every message to be counted, got %d", stats.Messages)
+	}
+}
+
+func TestStorageSample(t *testing.T) {
+	server, err := NewMailServer(1025, "localhost", t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create mail server: %v", err)
+	}
+	config := StorageConfig{Mode: StorageModeSample, SampleEvery: 2, MatchTo: "*@keep.example.com"}
+	if err := server.SetStorageConfig(config); err != nil {
+		t.Fatalf("SetStorageConfig failed: %v", err)
+	}
+	addr := startTestSMTPServer(t, server)
+
+	// Every other matching message is stored, starting with the first
+	for i, to := range []string{
+		"a@keep.example.com",
+		"b@drop.example.com",
+		"c@keep.example.com",
+		"d@keep.example.com",
+	} {
+		sendTestMailFrom(t, addr, "sender@example.com", to, "Subject: "+string(rune('a'+i))+"\r\n\r\nhello\r\n")
+	}
+
+	var subjects []string
+	for _, email := range server.GetAllEmail() {
+		subjects = append(subjects, email.Subject)
+	}
+	if strings.Join(subjects, ",") != "a,d" {
+		t.Errorf("Expected messages a and d to be stored, got %v", subjects)
+	}
+	stats := server.GetStorageStats()
+	if stats.Messages != 4 || stats.Stored != 2 || stats.Discarded != 2 || stats.Senders["sender@example.com"].Messages != 4 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
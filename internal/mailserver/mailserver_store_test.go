@@ -3,6 +3,7 @@ package mailserver
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -1574,3 +1575,51 @@ func TestLoadMailsFromDirectoryWithSubdirectory(t *testing.T) {
 		t.Error("Email should be loaded from directory")
 	}
 }
+
+// TestParseEmailWithTruncatedMultipart tests parseEmail with a multipart that has no closing boundary
+func TestParseEmailWithTruncatedMultipart(t *testing.T) {
+	tmpDir := t.TempDir()
+	server, err := NewMailServer(1025, "localhost", tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create mail server: %v", err)
+	}
+	defer func() {
+		if err := server.Close(); err != nil {
+			t.Errorf("Failed to close server: %v", err)
+		}
+	}()
+
+	// Cut in the middle of the last part, as when the start of an oversize message is kept
+	emailContent := "From: from@example.com\r\n" +
+		"To: to@example.com\r\n" +
+		"Subject: Truncated\r\n" +
+		"Content-Type: multipart/mixed; boundary=\"boundary123\"\r\n" +
+		"\r\n" +
+		"--boundary123\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"Email body\r\n" +
+		"--boundary123\r\n" +
+		"Content-Type: application/pdf\r\n" +
+		"Content-Disposition: attachment; filename=\"test.pdf\"\r\n" +
+		"\r\n" +
+		"PDF cont"
+
+	done := make(chan *Email, 1)
+	go func() {
+		email, err := server.parseEmail("truncated", strings.NewReader(emailContent), nil, false, false)
+		if err != nil {
+			t.Errorf("Failed to parse email: %v", err)
+		}
+		done <- email
+	}()
+
+	select {
+	case email := <-done:
+		if email != nil && email.Text != "Email body" {
+			t.Errorf("Expected the text before the truncated part, got %q", email.Text)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Parsing a truncated multipart did not return")
+	}
+}
@@ -0,0 +1,204 @@
+package mailserver
+
+import (
+	"crypto/tls"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-sasl"
+	"github.com/emersion/go-smtp"
+	"github.com/soulteary/owlmail/internal/types"
+)
+
+// transcriptLines returns the transcript as "direction: line" strings
+func transcriptLines(entries []TranscriptEntry) []string {
+	lines := make([]string, len(entries))
+	for i, entry := range entries {
+		lines[i] = entry.Direction + ": " + entry.Line
+	}
+	return lines
+}
+
+// waitForTranscript polls until the transcript of an email contains want
+func waitForTranscript(t *testing.T, ms *MailServer, id, want string) []TranscriptEntry {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		entries, err := ms.GetEmailTranscript(id)
+		if err != nil {
+			t.Fatalf("GetEmailTranscript failed: %v", err)
+		}
+		for _, line := range transcriptLines(entries) {
+			if line == want {
+				return entries
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Transcript does not contain %q:\n%s", want, strings.Join(transcriptLines(entries), "\n"))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTranscriptEndToEnd(t *testing.T) {
+	tmpDir := t.TempDir()
+	authConfig := &SMTPAuthConfig{Username: "admin", Password: "topsecret", Enabled: true}
+	server, err := NewMailServerWithConfig(1025, "localhost", tmpDir, nil, authConfig, nil)
+	if err != nil {
+		t.Fatalf("Failed to create mail server: %v", err)
+	}
+	addr := startTestSMTPServer(t, server)
+
+	c, err := smtp.Dial(addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	if err := c.Hello("client.example.com"); err != nil {
+		t.Fatalf("Hello failed: %v", err)
+	}
+	if err := c.Auth(sasl.NewPlainClient("", "admin", "topsecret")); err != nil {
+		t.Fatalf("Auth failed: %v", err)
+	}
+	body := "Subject: transcript\r\n\r\nthe body stays private\r\n"
+	if err := c.SendMail("sender@example.com", []string{"user@example.com"}, strings.NewReader(body)); err != nil {
+		t.Fatalf("SendMail failed: %v", err)
+	}
+
+	emails := server.GetAllEmail()
+	if len(emails) != 1 {
+		t.Fatalf("Expected 1 email, got %d", len(emails))
+	}
+	id := emails[0].ID
+
+	// The reply to DATA is added as soon as it is sent
+	waitForTranscript(t, server, id, "server: 250 2.0.0 OK: queued")
+
+	if err := c.Quit(); err != nil {
+		t.Fatalf("Quit failed: %v", err)
+	}
+	entries := waitForTranscript(t, server, id, "event: connection closed")
+	lines := transcriptLines(entries)
+	all := strings.Join(lines, "\n")
+
+	for _, want := range []string{
+		"client: EHLO client.example.com",
+		"client: AUTH PLAIN " + maskedSecret,
+		"server: 235 2.0.0 Authentication succeeded",
+		"client: MAIL FROM:<sender@example.com> BODY=8BITMIME",
+		"client: RCPT TO:<user@example.com>",
+		"client: DATA",
+		"event: message data (" + strconv.Itoa(len(body)) + " bytes)",
+		"client: .",
+		"client: QUIT",
+	} {
+		if !strings.Contains(all, want) {
+			t.Errorf("Transcript missing %q:\n%s", want, all)
+		}
+	}
+	if !strings.HasPrefix(lines[0], "event: connection from 127.0.0.1:") {
+		t.Errorf("Expected connection event first, got %q", lines[0])
+	}
+	if !strings.HasPrefix(lines[1], "server: 220 ") {
+		t.Errorf("Expected greeting, got %q", lines[1])
+	}
+	if strings.Contains(all, "topsecret") || strings.Contains(all, "private") {
+		t.Errorf("Transcript leaks secrets or message data:\n%s", all)
+	}
+
+	// Final replies carry the time taken by the command
+	for _, entry := range entries {
+		if entry.Direction == types.TranscriptServer && strings.HasPrefix(entry.Line, "250 2.0.0 OK") && entry.DurationMs <= 0 {
+			t.Errorf("Expected duration on DATA reply, got %v", entry.DurationMs)
+		}
+	}
+
+	// The transcript is restored from disk
+	reloaded, err := NewMailServer(1025, "localhost", tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create mail server: %v", err)
+	}
+	defer func() {
+		_ = reloaded.Close()
+	}()
+	restored, err := reloaded.GetEmailTranscript(id)
+	if err != nil || len(restored) != len(entries) {
+		t.Errorf("Expected %d restored entries, got %d (%v)", len(entries), len(restored), err)
+	}
+}
+
+func TestTranscriptSTARTTLS(t *testing.T) {
+	server, err := NewMailServerWithConfig(1025, "localhost", t.TempDir(), nil, nil, &TLSConfig{Enabled: true})
+	if err != nil {
+		t.Fatalf("Failed to create mail server: %v", err)
+	}
+	addr := startTestSMTPServer(t, server)
+
+	c, err := smtp.DialStartTLS(addr, &tls.Config{InsecureSkipVerify: true, ServerName: "mx.example.com"})
+	if err != nil {
+		t.Fatalf("DialStartTLS failed: %v", err)
+	}
+	if err := c.SendMail("sender@example.com", []string{"user@example.com"}, strings.NewReader("Subject: tls\r\n\r\nbody\r\n")); err != nil {
+		t.Fatalf("SendMail failed: %v", err)
+	}
+	_ = c.Quit()
+
+	emails := server.GetAllEmail()
+	if len(emails) != 1 {
+		t.Fatalf("Expected 1 email, got %d", len(emails))
+	}
+	entries := waitForTranscript(t, server, emails[0].ID, "event: connection closed")
+	all := strings.Join(transcriptLines(entries), "\n")
+
+	starttls := strings.Index(all, "client: STARTTLS")
+	handshake := strings.Index(all, "event: TLS handshake completed: TLS 1.3")
+	mail := strings.Index(all, "client: MAIL FROM:<sender@example.com>")
+	if starttls < 0 || handshake < starttls || mail < handshake {
+		t.Errorf("Expected STARTTLS, handshake and plaintext MAIL in order:\n%s", all)
+	}
+	if !strings.Contains(all, "SNI mx.example.com") {
+		t.Errorf("Expected SNI in handshake event:\n%s", all)
+	}
+}
+
+func TestTranscriptBDATAndAuthContinuation(t *testing.T) {
+	tr := &transcript{mailServer: &MailServer{}}
+	server := func(s string) {
+		tr.markWrite()
+		_, _ = tr.Write([]byte(s))
+	}
+	client := func(s string) {
+		tr.markRead()
+		_, _ = tr.Write([]byte(s))
+	}
+
+	server("220 localhost ESMTP\r\n")
+	client("AUTH LOGIN\r\n")
+	server("334 VXNlcm5hbWU6\r\n")
+	client("YWxpY2U=\r\n")
+	server("334 UGFzc3dvcmQ6\r\n")
+	client("c2VjcmV0\r\n")
+	server("235 2.0.0 Authentication succeeded\r\n")
+	// A chunk followed by a pipelined command in the same read
+	client("BDAT 5 LAST\r\nhelloRSET\r\n")
+	server("250 2.0.0 OK\r\n")
+
+	want := []string{
+		"server: 220 localhost ESMTP",
+		"client: AUTH LOGIN",
+		"server: 334 VXNlcm5hbWU6",
+		"client: " + maskedSecret,
+		"server: 334 UGFzc3dvcmQ6",
+		"client: " + maskedSecret,
+		"server: 235 2.0.0 Authentication succeeded",
+		"client: BDAT 5 LAST",
+		"event: message data (5 bytes)",
+		"client: RSET",
+		"server: 250 2.0.0 OK",
+	}
+	got := transcriptLines(tr.entries)
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Unexpected transcript:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
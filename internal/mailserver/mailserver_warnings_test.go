@@ -0,0 +1,62 @@
+package mailserver
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseWarnings(t *testing.T) {
+	raw := "From: not an address\r\n" +
+		"To: user@example.com\r\n" +
+		"Date: yesterday\r\n" +
+		"Subject: =?x-unknown?q?abc?=\r\n" +
+		"Content-Type: multipart/mixed; boundary=B\r\n" +
+		"\r\n" +
+		"--B\r\n" +
+		"Content-Type: text/plain; charset=x-unknown\r\n" +
+		"\r\n" +
+		"Hello\r\n" +
+		"--B\r\n" +
+		"Content-Type: application/pdf\r\n" +
+		"Content-Disposition: attachment; filename=\"unterminated\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		"not base64!\r\n" +
+		"--B\r\n" +
+		"Content-Type: text/html\r\n" +
+		"\r\n" +
+		"<p>cut"
+	email := parseTestMessage(t, "warnings", raw)
+
+	var got []string
+	for _, warning := range email.Warnings {
+		got = append(got, warning.Code+"@"+warning.Part+"/"+warning.Header)
+		if warning.Message == "" {
+			t.Errorf("Expected a message for %+v", warning)
+		}
+	}
+	expected := []string{
+		"invalid_encoded_word@0/Subject",
+		"truncated_multipart@0/",
+		"invalid_disposition@2/Content-Disposition",
+		"invalid_date@/Date",
+		"invalid_address@/From",
+		"unknown_charset@1/",
+		"undecodable_body@2/",
+	}
+	if strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Errorf("Unexpected warnings:\n got %v\nwant %v", got, expected)
+	}
+
+	// What could be read is kept
+	if email.Text != "Hello" || email.HTML != "<p>cut" || len(email.To) != 1 || len(email.Attachments) != 1 {
+		t.Errorf("Expected the readable content to be kept, got text %q, html %q, to %v, %d attachments",
+			email.Text, email.HTML, email.To, len(email.Attachments))
+	}
+}
+
+func TestParseWithoutWarnings(t *testing.T) {
+	if email := parseTestMessage(t, "clean", nestedMessage); len(email.Warnings) != 0 {
+		t.Errorf("Expected no warnings, got %+v", email.Warnings)
+	}
+}
@@ -0,0 +1,372 @@
+package mailserver
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/emersion/go-message"
+	"github.com/emersion/go-message/textproto"
+	"github.com/soulteary/owlmail/internal/common"
+)
+
+// maxMIMEDepth is the deepest multipart nesting that is walked; deeper
+// multipart entities are kept as attachments
+const maxMIMEDepth = 32
+
+// MIMEPart is a node of the MIME tree of an email. The root has path "0", its
+// parts "1", "2"..., and nested parts "1.1", "1.2"... as in IMAP.
+type MIMEPart struct {
+	Path             string         `json:"path"`
+	ContentType      string         `json:"contentType"`
+	Charset          string         `json:"charset,omitempty"`
+	TransferEncoding string         `json:"transferEncoding,omitempty"`
+	Disposition      string         `json:"disposition,omitempty"`
+	FileName         string         `json:"fileName,omitempty"`
+	ContentID        string         `json:"contentId,omitempty"`
+	Size             int64          `json:"size"` // Size of the body as transmitted, before decoding
+	Headers          []HeaderField  `json:"headers"`
+	Parts            []*MIMEPart    `json:"parts,omitempty"`
+	Warnings         []ParseWarning `json:"warnings,omitempty"` // Problems found in this part, not in its parts
+
+	header message.Header
+	body   []byte // Body as transmitted, a slice of the raw message when possible
+}
+
+// readMIMETree parses a raw message into its MIME tree. The bodies of the
+// parts are slices of raw, which must not be modified afterwards.
+func readMIMETree(raw []byte) (*MIMEPart, error) {
+	r := bytes.NewReader(raw)
+	br := bufio.NewReader(r)
+	header, err := textproto.ReadHeader(br)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+	offset := len(raw) - r.Len() - br.Buffered()
+	return newMIMEPart("0", header, raw[offset:len(raw):len(raw)], 0), nil
+}
+
+// partWriter receives the body of a part, which is expected to continue
+// src from start. As long as it does, the body is kept as a slice of src;
+// otherwise it is copied.
+type partWriter struct {
+	src        []byte
+	start, end int
+	copied     []byte // The body, once it has been found not to continue src
+}
+
+// Write implements io.Writer
+func (w *partWriter) Write(p []byte) (int, error) {
+	if w.copied == nil {
+		if w.end+len(p) <= len(w.src) && bytes.Equal(p, w.src[w.end:w.end+len(p)]) {
+			w.end += len(p)
+			return len(p), nil
+		}
+		w.copied = append(make([]byte, 0, w.end-w.start+len(p)), w.src[w.start:w.end]...)
+	}
+	w.copied = append(w.copied, p...)
+	return len(p), nil
+}
+
+// body returns the body written so far
+func (w *partWriter) body() []byte {
+	if w.copied != nil {
+		return w.copied
+	}
+	return w.src[w.start:w.end:w.end]
+}
+
+// nextPartStart returns where the body of the next part of a multipart body
+// is expected to start: after the next delimiter line from cursor and the
+// header of the part. A wrong guess only costs a copy, see partWriter.
+func nextPartStart(body []byte, cursor int, boundary string) int {
+	delimiter := []byte("--" + boundary)
+	for i := cursor; i < len(body); {
+		j := bytes.Index(body[i:], delimiter)
+		if j < 0 {
+			break
+		}
+		j += i
+		if j > 0 && body[j-1] != '\n' {
+			i = j + len(delimiter)
+			continue
+		}
+		nl := bytes.IndexByte(body[j:], '\n')
+		if nl < 0 {
+			break
+		}
+		header := j + nl + 1
+		switch rest := body[header:]; {
+		case bytes.HasPrefix(rest, []byte("\r\n")):
+			return header + 2
+		case bytes.HasPrefix(rest, []byte("\n")):
+			return header + 1
+		default:
+			if end := headerEnd(rest); end >= 0 {
+				return header + end
+			}
+		}
+		break
+	}
+	return cursor
+}
+
+// newMIMEPart describes an entity and parses its parts if it is a multipart
+func newMIMEPart(path string, h textproto.Header, body []byte, depth int) *MIMEPart {
+	header := message.Header{Header: h}
+	part := &MIMEPart{
+		Path:             path,
+		TransferEncoding: strings.ToLower(header.Get("Content-Transfer-Encoding")),
+		ContentID:        strings.Trim(header.Get("Content-ID"), "<>"),
+		Size:             int64(len(body)),
+		header:           header,
+		body:             body,
+	}
+	part.Headers, part.Warnings = headerFields(h, path)
+
+	mediaType, typeParams, err := header.ContentType()
+	if err != nil || mediaType == "" {
+		if err != nil {
+			part.Warnings = append(part.Warnings, newHeaderWarning(WarningInvalidContentType, path, "Content-Type", err))
+		}
+		mediaType = "text/plain"
+	}
+	part.ContentType = mediaType
+	part.Charset = typeParams["charset"]
+	var dispositionParams map[string]string
+	if header.Has("Content-Disposition") {
+		part.Disposition, dispositionParams, err = header.ContentDisposition()
+		if err != nil {
+			part.Warnings = append(part.Warnings, newHeaderWarning(WarningInvalidDisposition, path, "Content-Disposition", err))
+		}
+	}
+	part.FileName = dispositionParams["filename"]
+	if part.FileName == "" {
+		part.FileName = typeParams["name"]
+	}
+
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		return part
+	}
+	boundary := typeParams["boundary"]
+	if boundary == "" {
+		part.Warnings = append(part.Warnings, newWarning(WarningMissingBoundary, path, "%s has no boundary", mediaType))
+		return part
+	}
+	if depth >= maxMIMEDepth {
+		part.Warnings = append(part.Warnings, newWarning(WarningTooDeep, path, "multiparts nested more than %d levels deep are not parsed", maxMIMEDepth))
+		return part
+	}
+	part.Parts = make([]*MIMEPart, 0)
+	mr := textproto.NewMultipartReader(bytes.NewReader(body), boundary)
+	cursor := 0
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// The reader cannot resync after a malformed part
+			part.Warnings = append(part.Warnings, newWarning(WarningMalformedMultipart, path, "%v", err))
+			break
+		}
+		w := &partWriter{src: body}
+		w.start = nextPartStart(body, cursor, boundary)
+		w.end = w.start
+		_, err = io.Copy(w, p)
+		partBody := w.body()
+		if w.copied == nil {
+			cursor = w.end
+		}
+		childPath := strconv.Itoa(len(part.Parts) + 1)
+		if path != "0" {
+			childPath = path + "." + childPath
+		}
+		part.Parts = append(part.Parts, newMIMEPart(childPath, p.Header, partBody, depth+1))
+		if err != nil {
+			// The last part is kept up to the end of the message
+			part.Warnings = append(part.Warnings, newWarning(WarningTruncatedMultipart, path, "no closing boundary after part %s", childPath))
+			break
+		}
+	}
+	return part
+}
+
+// isMultipart reports whether the parts of the part were parsed
+func (p *MIMEPart) isMultipart() bool {
+	return p.Parts != nil
+}
+
+// Find returns the part or the descendant with the given path, or nil
+func (p *MIMEPart) Find(path string) *MIMEPart {
+	if path == p.Path {
+		return p
+	}
+	for _, child := range p.Parts {
+		if path == child.Path || strings.HasPrefix(path, child.Path+".") {
+			return child.Find(path)
+		}
+	}
+	return nil
+}
+
+// Raw returns the header and body of the part as transmitted
+func (p *MIMEPart) Raw() []byte {
+	var buf bytes.Buffer
+	if err := textproto.WriteHeader(&buf, p.header.Header); err != nil {
+		common.Verbose("Error writing part header: %v", err)
+	}
+	buf.Write(p.body)
+	return buf.Bytes()
+}
+
+// Decoded returns the body of the part with its transfer encoding removed,
+// and text converted to UTF-8. The body of a multipart is returned as is.
+// On error, what could be decoded is returned with the error.
+func (p *MIMEPart) Decoded() ([]byte, error) {
+	entity, err := message.New(p.header, bytes.NewReader(p.body))
+	if entity == nil {
+		return p.body, err
+	}
+	decoded, readErr := io.ReadAll(entity.Body)
+	if readErr != nil {
+		err = readErr
+	}
+	return decoded, err
+}
+
+// DecodedContentType returns the content type of the decoded body
+func (p *MIMEPart) DecodedContentType() string {
+	if strings.HasPrefix(p.ContentType, "text/") && p.Charset != "" {
+		return p.ContentType + "; charset=utf-8"
+	}
+	if strings.HasPrefix(p.ContentType, "multipart/") {
+		// Keep the boundary, the body of a multipart is not decoded
+		return p.header.Get("Content-Type")
+	}
+	return p.ContentType
+}
+
+// GetEmailParts returns the MIME tree of an email
+func (ms *MailServer) GetEmailParts(id string) (*MIMEPart, error) {
+	if _, err := ms.GetEmail(id); err != nil {
+		return nil, err
+	}
+	raw, err := ms.GetRawEmailContent(id)
+	if err != nil {
+		return nil, err
+	}
+	return readMIMETree(raw)
+}
+
+// mimeContent is what a MIME subtree contributes to an email: the text and
+// HTML bodies to display, and every other leaf
+type mimeContent struct {
+	text  []*MIMEPart
+	html  []*MIMEPart
+	other []*MIMEPart
+}
+
+// add appends the content of a child part
+func (c *mimeContent) add(child *mimeContent) {
+	c.text = append(c.text, child.text...)
+	c.html = append(c.html, child.html...)
+	c.other = append(c.other, child.other...)
+}
+
+// walkMIME collects the content of a part and all its descendants
+func walkMIME(part *MIMEPart) *mimeContent {
+	if part.isMultipart() {
+		children := make([]*mimeContent, 0, len(part.Parts))
+		for _, child := range part.Parts {
+			children = append(children, walkMIME(child))
+		}
+		if part.ContentType == "multipart/alternative" {
+			return chooseAlternative(children)
+		}
+		content := &mimeContent{}
+		for _, child := range children {
+			content.add(child)
+		}
+		return content
+	}
+
+	content := &mimeContent{}
+	switch {
+	case part.Disposition == "attachment":
+		content.other = append(content.other, part)
+	case part.ContentType == "text/plain":
+		content.text = append(content.text, part)
+	case part.ContentType == "text/html":
+		content.html = append(content.html, part)
+	default:
+		content.other = append(content.other, part)
+	}
+	return content
+}
+
+// chooseAlternative merges the alternatives of a multipart/alternative
+// entity. Alternatives are in increasing order of preference, so the text and
+// HTML bodies come from the last alternative that has them. The bodies of
+// the other alternatives are kept as attachments, so that no part is lost.
+func chooseAlternative(alternatives []*mimeContent) *mimeContent {
+	textChoice, htmlChoice := -1, -1
+	for i, alternative := range alternatives {
+		if len(alternative.text) > 0 {
+			textChoice = i
+		}
+		if len(alternative.html) > 0 {
+			htmlChoice = i
+		}
+	}
+
+	content := &mimeContent{}
+	for i, alternative := range alternatives {
+		if i == textChoice {
+			content.text = append(content.text, alternative.text...)
+		} else {
+			content.other = append(content.other, alternative.text...)
+		}
+		if i == htmlChoice {
+			content.html = append(content.html, alternative.html...)
+		} else {
+			content.other = append(content.other, alternative.html...)
+		}
+		content.other = append(content.other, alternative.other...)
+	}
+	return content
+}
+
+// joinBodies concatenates the decoded bodies of consecutive inline parts,
+// with a warning for each part that cannot be fully decoded
+func joinBodies(parts []*MIMEPart) (string, []ParseWarning) {
+	bodies := make([]string, 0, len(parts))
+	var warnings []ParseWarning
+	for _, part := range parts {
+		decoded, err := part.Decoded()
+		if err != nil {
+			warnings = append(warnings, decodeWarning(part.Path, err))
+		}
+		if body := strings.TrimSpace(string(decoded)); body != "" {
+			bodies = append(bodies, body)
+		}
+	}
+	return strings.Join(bodies, "\n"), warnings
+}
+
+// attachment describes a part that is not displayed as a body
+func (p *MIMEPart) attachment() *Attachment {
+	filename := p.FileName
+	if filename == "" {
+		filename = p.ContentType
+	}
+	return &Attachment{
+		ContentType: p.ContentType,
+		FileName:    filename,
+		ContentID:   p.ContentID,
+		Path:        p.Path,
+	}
+}
@@ -0,0 +1,117 @@
+package mailserver
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/emersion/go-smtp"
+	"github.com/soulteary/owlmail/internal/common"
+)
+
+// SetOversizeCapture keeps the header and the first kb kilobytes of the body
+// of messages refused for exceeding the size limit of their listener; of a
+// header longer than kb kilobytes, only its start is kept. They are stored as emails whose envelope is marked as rejected. 0 disables it.
+// BDAT chunks and SIZE parameters over the limit are refused before any data
+// is received, so nothing is kept for them.
+func (ms *MailServer) SetOversizeCapture(kb int) error {
+	if kb < 0 {
+		return fmt.Errorf("oversize capture must not be negative")
+	}
+	ms.oversizeCaptureKB = kb
+	return nil
+}
+
+// GetOversizeCapture returns the body kilobytes kept of oversize messages
+func (ms *MailServer) GetOversizeCapture() int {
+	return ms.oversizeCaptureKB
+}
+
+// oversizeCapture keeps the header of a message and the start of its body
+type oversizeCapture struct {
+	buf         []byte
+	headerLimit int
+	bodyLimit   int
+	bodyStart   int  // Offset of the body, -1 until the end of the header is seen
+	full        bool // Nothing more is kept
+}
+
+// newOversizeCapture creates a capture keeping up to headerLimit bytes of the
+// header and bodyLimit bytes of the body
+func newOversizeCapture(headerLimit, bodyLimit int) *oversizeCapture {
+	return &oversizeCapture{headerLimit: headerLimit, bodyLimit: bodyLimit, bodyStart: -1}
+}
+
+// Write implements io.Writer. It never fails, so that it can be used with io.TeeReader.
+func (c *oversizeCapture) Write(p []byte) (int, error) {
+	if c.full {
+		return len(p), nil
+	}
+	if c.bodyStart >= 0 {
+		c.buf = append(c.buf, p[:min(len(p), c.bodyStart+c.bodyLimit-len(c.buf))]...)
+		c.full = len(c.buf) >= c.bodyStart+c.bodyLimit
+		return len(p), nil
+	}
+
+	// Look for the end of the header in the new bytes only, with enough of
+	// the previous ones to find a blank line split across writes
+	from := max(len(c.buf)-3, 0)
+	c.buf = append(c.buf, p[:min(len(p), c.headerLimit+c.bodyLimit-len(c.buf))]...)
+	if end := headerEnd(c.buf[from:]); end >= 0 && from+end <= c.headerLimit {
+		c.bodyStart = from + end
+		c.buf = c.buf[:min(len(c.buf), c.bodyStart+c.bodyLimit)]
+		c.full = len(c.buf) >= c.bodyStart+c.bodyLimit
+	} else if len(c.buf) >= c.headerLimit {
+		// Only the start of an overlong header is kept
+		c.buf = c.buf[:c.headerLimit]
+		c.full = true
+	}
+	return len(p), nil
+}
+
+// headerEnd returns the offset of the body of a raw message, or -1 if the
+// blank line ending the header has not been seen
+func headerEnd(raw []byte) int {
+	end := -1
+	if i := bytes.Index(raw, []byte("\r\n\r\n")); i >= 0 {
+		end = i + 4
+	}
+	if i := bytes.Index(raw, []byte("\n\n")); i >= 0 && (end < 0 || i+2 < end) {
+		end = i + 2
+	}
+	return end
+}
+
+// storeOversize stores the captured start of a message that exceeded the
+// size limit, marked as rejected with the reply sent to the client
+func (s *Session) storeOversize(id string, capture *oversizeCapture, reply *smtp.SMTPError) {
+	emlPath := filepath.Join(s.mailServer.mailDir, id+".eml")
+	if err := os.WriteFile(emlPath, capture.buf, 0644); err != nil {
+		common.Error("Failed to save oversize email %s: %v", id, err)
+		return
+	}
+
+	var limit int64
+	if s.conn != nil {
+		limit = s.conn.Server().MaxMessageBytes
+	}
+	s.rejected = &Rejection{
+		Code:     reply.Code,
+		Reason:   reply.Message,
+		Limit:    limit,
+		Captured: int64(len(capture.buf)),
+	}
+	defer func() {
+		s.rejected = nil
+	}()
+
+	if _, err := s.mailServer.parseEmail(id, bytes.NewReader(capture.buf), s, true, false); err != nil {
+		common.Error("Failed to store oversize email %s: %v", id, err)
+		if removeErr := os.Remove(emlPath); removeErr != nil {
+			common.Verbose("Failed to remove oversize email file: %v", removeErr)
+		}
+		return
+	}
+	common.Log("Kept %d bytes of oversize email %s from <%s>", len(capture.buf), id, s.from)
+}
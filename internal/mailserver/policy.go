@@ -0,0 +1,160 @@
+package mailserver
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/emersion/go-smtp"
+	"github.com/soulteary/owlmail/internal/common"
+)
+
+// AccessPolicy restricts which clients may connect and which recipient domains
+// are accepted, like the relay restrictions of an MX. Empty lists allow
+// everything. LMTP deliveries are not restricted.
+type AccessPolicy struct {
+	AllowNetworks   []string `json:"allowNetworks"`   // Client CIDRs allowed to connect; empty allows all
+	DenyNetworks    []string `json:"denyNetworks"`    // Client CIDRs refused, even when allowed above
+	AcceptedDomains []string `json:"acceptedDomains"` // Recipient domains accepted; "*.example.com" matches subdomains
+}
+
+// accessPolicy is the parsed form of an AccessPolicy
+type accessPolicy struct {
+	config  AccessPolicy
+	allow   []*net.IPNet
+	deny    []*net.IPNet
+	domains map[string]bool // Lower-case domains; wildcards are stored as ".example.com"
+}
+
+var errClientRejected = &smtp.SMTPError{
+	Code:         550,
+	EnhancedCode: smtp.EnhancedCode{5, 7, 1},
+	Message:      "Client host rejected: Access denied",
+}
+
+// SetAccessPolicy validates and applies the client and recipient access policy
+func (ms *MailServer) SetAccessPolicy(policy AccessPolicy) error {
+	parsed := &accessPolicy{domains: make(map[string]bool)}
+	var err error
+	if parsed.allow, err = parseCIDRs(policy.AllowNetworks); err != nil {
+		return err
+	}
+	if parsed.deny, err = parseCIDRs(policy.DenyNetworks); err != nil {
+		return err
+	}
+
+	domains := make([]string, 0, len(policy.AcceptedDomains))
+	for _, domain := range policy.AcceptedDomains {
+		domain = strings.ToLower(strings.TrimSpace(domain))
+		key := strings.TrimPrefix(domain, "*")
+		if key == "" || key == "." || strings.Contains(key, "@") || strings.Contains(strings.TrimPrefix(key, "."), "*") {
+			return fmt.Errorf("invalid accepted domain %q", domain)
+		}
+		parsed.domains[key] = true
+		domains = append(domains, domain)
+	}
+	parsed.config = AccessPolicy{
+		AllowNetworks:   networkStrings(parsed.allow),
+		DenyNetworks:    networkStrings(parsed.deny),
+		AcceptedDomains: domains,
+	}
+
+	ms.accessMutex.Lock()
+	defer ms.accessMutex.Unlock()
+	ms.accessPolicy = parsed
+	return nil
+}
+
+// GetAccessPolicy returns the client and recipient access policy
+func (ms *MailServer) GetAccessPolicy() AccessPolicy {
+	ms.accessMutex.RLock()
+	defer ms.accessMutex.RUnlock()
+	if ms.accessPolicy == nil {
+		return AccessPolicy{AllowNetworks: []string{}, DenyNetworks: []string{}, AcceptedDomains: []string{}}
+	}
+	return ms.accessPolicy.config
+}
+
+// parseCIDRs parses a list of CIDRs or bare IP addresses
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		network, err := parseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// networkStrings formats networks in CIDR notation
+func networkStrings(networks []*net.IPNet) []string {
+	cidrs := make([]string, len(networks))
+	for i, network := range networks {
+		cidrs[i] = network.String()
+	}
+	return cidrs
+}
+
+// checkClientAccess rejects clients outside the allowed networks or inside
+// the denied ones. Unix socket peers are local and always allowed.
+func (ms *MailServer) checkClientAccess(addr net.Addr) error {
+	ms.accessMutex.RLock()
+	defer ms.accessMutex.RUnlock()
+
+	policy := ms.accessPolicy
+	if policy == nil {
+		return nil
+	}
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return nil
+	}
+	if containsIP(policy.deny, tcpAddr.IP) || (len(policy.allow) > 0 && !containsIP(policy.allow, tcpAddr.IP)) {
+		common.Verbose("Rejected client %s by access policy", tcpAddr.IP)
+		return errClientRejected
+	}
+	return nil
+}
+
+// checkRecipientDomain rejects recipients whose domain is not accepted.
+// The postmaster mailbox without a domain is always accepted (RFC 5321).
+func (ms *MailServer) checkRecipientDomain(recipient string) error {
+	ms.accessMutex.RLock()
+	defer ms.accessMutex.RUnlock()
+
+	policy := ms.accessPolicy
+	if policy == nil || len(policy.domains) == 0 || strings.EqualFold(recipient, "postmaster") {
+		return nil
+	}
+	at := strings.LastIndex(recipient, "@")
+	if at >= 0 {
+		domain := strings.ToLower(strings.TrimSuffix(recipient[at+1:], "."))
+		if policy.domains[domain] {
+			return nil
+		}
+		for i := strings.Index(domain, "."); i >= 0; i = strings.Index(domain, ".") {
+			domain = domain[i+1:]
+			if policy.domains["."+domain] {
+				return nil
+			}
+		}
+	}
+	common.Verbose("Rejected recipient <%s>: domain not accepted", recipient)
+	return &smtp.SMTPError{
+		Code:         550,
+		EnhancedCode: smtp.EnhancedCode{5, 7, 1},
+		Message:      fmt.Sprintf("<%s>: Relay access denied", recipient),
+	}
+}
+
+// containsIP reports whether ip is in any of the networks
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
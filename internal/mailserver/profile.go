@@ -0,0 +1,537 @@
+package mailserver
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-smtp"
+)
+
+// Built-in SMTP profile names
+const (
+	ProfileOwlMail  = "owlmail"
+	ProfilePostfix  = "postfix"
+	ProfileExchange = "exchange"
+	ProfileGmail    = "gmail"
+)
+
+// Reply keys of SMTPProfile.Replies
+const (
+	ReplyEHLO     = "ehlo"     // First line of the EHLO reply
+	ReplyHELO     = "helo"     // HELO reply
+	ReplyMail     = "mail"     // MAIL FROM accepted
+	ReplyRcpt     = "rcpt"     // RCPT TO accepted
+	ReplyData     = "data"     // 354 reply to DATA
+	ReplyQueued   = "queued"   // Message accepted after DATA or the last BDAT chunk
+	ReplyQuit     = "quit"     // 221 reply to QUIT
+	ReplyRset     = "rset"     // RSET reply
+	ReplyNoop     = "noop"     // NOOP reply
+	ReplyVrfy     = "vrfy"     // 252 reply to VRFY
+	ReplyStartTLS = "starttls" // 220 reply to STARTTLS
+	ReplyAuth     = "auth"     // 235 reply to a successful AUTH
+)
+
+// replyKeys lists the valid keys of SMTPProfile.Replies
+var replyKeys = []string{
+	ReplyEHLO, ReplyHELO, ReplyMail, ReplyRcpt, ReplyData, ReplyQueued,
+	ReplyQuit, ReplyRset, ReplyNoop, ReplyVrfy, ReplyStartTLS, ReplyAuth,
+}
+
+// SMTPProfile is the personality of the SMTP server: the name it gives, what
+// it advertises, its limits and the wording of its replies. Built-in profiles
+// mimic common MTAs; a custom profile starts from one of them.
+//
+// Banner and replies are the text following the reply code, optionally
+// starting with an enhanced status code, and may use the placeholders
+// {hostname}, {client} (the EHLO/HELO name), {ip}, {address} (MAIL and RCPT
+// replies only), {id} (the email ID, queued reply only), {session} (a random
+// per-connection token) and {date}.
+type SMTPProfile struct {
+	Name               string            `json:"name"`
+	Base               string            `json:"base,omitempty"`               // Built-in profile a custom profile starts from
+	Hostname           string            `json:"hostname,omitempty"`           // Name in the greeting and EHLO reply
+	Banner             string            `json:"banner,omitempty"`             // Greeting after "220 "
+	Extensions         []string          `json:"extensions,omitempty"`         // Optional ESMTP extensions; empty keeps the server's
+	HiddenCapabilities []string          `json:"hiddenCapabilities,omitempty"` // EHLO keywords not advertised, e.g. LIMITS
+	ExtraCapabilities  []string          `json:"extraCapabilities,omitempty"`  // EHLO lines advertised in addition, see extraCapabilities
+	MaxMessageBytes    int64             `json:"maxMessageBytes,omitempty"`
+	MaxRecipients      int               `json:"maxRecipients,omitempty"`
+	ReadTimeout        string            `json:"readTimeout,omitempty"` // e.g. "5m"
+	WriteTimeout       string            `json:"writeTimeout,omitempty"`
+	Replies            map[string]string `json:"replies,omitempty"`
+}
+
+// extraCapabilities are the EHLO keywords a profile may advertise in
+// addition: commands that go-smtp answers without advertising them
+var extraCapabilities = []string{"VRFY"}
+
+// Replies that go-smtp words itself and profiles reword. They are matched on
+// what go-smtp writes, so TestGoSMTPReplyWording pins them to the go-smtp
+// version in go.mod.
+const (
+	goSMTPEHLOPrefix    = "Hello "                             // First line of the EHLO reply
+	goSMTPHELOPrefix    = "2.0.0 Hello "                       // HELO reply
+	goSMTPMailPrefix    = "2.0.0 Roger, accepting mail from <" // MAIL reply, followed by the address and ">"
+	goSMTPRcptPrefix    = "2.0.0 I'll make sure <"             // RCPT reply, followed by the address and goSMTPRcptSuffix
+	goSMTPRcptSuffix    = "> gets this"
+	goSMTPDataPrefix    = "Go ahead."
+	goSMTPQuitReply     = "2.0.0 Bye"
+	goSMTPRsetReply     = "2.0.0 Session reset"
+	goSMTPNoopReply     = "2.0.0 I have successfully done nothing"
+	goSMTPVrfyPrefix    = "2.5.0 Cannot VRFY user"
+	goSMTPAuthReply     = "2.0.0 Authentication succeeded"
+	goSMTPStartTLSReply = "2.0.0 Ready to start TLS"
+)
+
+// builtinProfiles are the profiles selectable by name
+var builtinProfiles = map[string]SMTPProfile{
+	ProfileOwlMail: {
+		Name:            ProfileOwlMail,
+		Hostname:        "localhost",
+		Extensions:      DefaultESMTPExtensions().Names(),
+		MaxMessageBytes: defaultMaxMessageBytes,
+		MaxRecipients:   defaultMaxRecipients,
+		ReadTimeout:     defaultSMTPTimeout.String(),
+		WriteTimeout:    defaultSMTPTimeout.String(),
+	},
+	ProfilePostfix: {
+		Name:               ProfilePostfix,
+		Hostname:           "mail.example.com",
+		Banner:             "{hostname} ESMTP Postfix",
+		Extensions:         []string{ExtensionSMTPUTF8, ExtensionDSN},
+		HiddenCapabilities: []string{"LIMITS"},
+		ExtraCapabilities:  []string{"VRFY"},
+		MaxMessageBytes:    10240000,
+		MaxRecipients:      1000,
+		ReadTimeout:        "5m",
+		WriteTimeout:       "5m",
+		Replies: map[string]string{
+			ReplyEHLO:     "{hostname}",
+			ReplyHELO:     "{hostname}",
+			ReplyMail:     "2.1.0 Ok",
+			ReplyRcpt:     "2.1.5 Ok",
+			ReplyData:     "End data with <CR><LF>.<CR><LF>",
+			ReplyQueued:   "2.0.0 Ok: queued as {id}",
+			ReplyQuit:     "2.0.0 Bye",
+			ReplyRset:     "2.0.0 Ok",
+			ReplyNoop:     "2.0.0 Ok",
+			ReplyStartTLS: "2.0.0 Ready to start TLS",
+			ReplyAuth:     "2.7.0 Authentication successful",
+		},
+	},
+	ProfileExchange: {
+		Name:               ProfileExchange,
+		Hostname:           "EXCH01.corp.example.com",
+		Banner:             "{hostname} Microsoft ESMTP MAIL Service ready at {date}",
+		Extensions:         []string{ExtensionSMTPUTF8, ExtensionBinaryMIME, ExtensionDSN},
+		HiddenCapabilities: []string{"LIMITS"},
+		MaxMessageBytes:    36700160,
+		MaxRecipients:      200,
+		ReadTimeout:        "5m",
+		WriteTimeout:       "5m",
+		Replies: map[string]string{
+			ReplyEHLO:     "{hostname} Hello [{ip}]",
+			ReplyHELO:     "{hostname} Hello [{ip}]",
+			ReplyMail:     "2.1.0 Sender OK",
+			ReplyRcpt:     "2.1.5 Recipient OK",
+			ReplyData:     "Start mail input; end with <CRLF>.<CRLF>",
+			ReplyQueued:   "2.6.0 <{id}@{hostname}> Queued mail for delivery",
+			ReplyQuit:     "2.0.0 Service closing transmission channel",
+			ReplyRset:     "2.0.0 Resetting",
+			ReplyNoop:     "2.0.0 OK",
+			ReplyVrfy:     "2.1.5 Cannot VRFY user, but will take message for this user",
+			ReplyStartTLS: "2.0.0 SMTP server ready",
+			ReplyAuth:     "2.7.0 Authentication successful",
+		},
+	},
+	ProfileGmail: {
+		Name:               ProfileGmail,
+		Hostname:           "mx.google.com",
+		Banner:             "{hostname} ESMTP {session} - gsmtp",
+		Extensions:         []string{ExtensionSMTPUTF8},
+		HiddenCapabilities: []string{"LIMITS"},
+		MaxMessageBytes:    157286400,
+		MaxRecipients:      100,
+		ReadTimeout:        "5m",
+		WriteTimeout:       "5m",
+		Replies: map[string]string{
+			ReplyEHLO:     "{hostname} at your service, [{ip}]",
+			ReplyHELO:     "{hostname} at your service",
+			ReplyMail:     "2.1.0 OK {session} - gsmtp",
+			ReplyRcpt:     "2.1.5 OK {session} - gsmtp",
+			ReplyData:     "Go ahead {session} - gsmtp",
+			ReplyQueued:   "2.0.0 OK {id} {session} - gsmtp",
+			ReplyQuit:     "2.0.0 closing connection {session} - gsmtp",
+			ReplyRset:     "2.0.0 OK {session} - gsmtp",
+			ReplyNoop:     "2.0.0 OK {session} - gsmtp",
+			ReplyVrfy:     "2.1.5 Send some mail, I'll try my best {session} - gsmtp",
+			ReplyStartTLS: "2.0.0 Ready to start TLS",
+			ReplyAuth:     "2.7.0 Accepted",
+		},
+	},
+}
+
+// SMTPProfileNames returns the names of the built-in profiles
+func SMTPProfileNames() []string {
+	names := make([]string, 0, len(builtinProfiles))
+	for name := range builtinProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GetBuiltinSMTPProfile returns a copy of a built-in profile
+func GetBuiltinSMTPProfile(name string) (*SMTPProfile, error) {
+	profile, ok := builtinProfiles[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown SMTP profile %q: must be one of %s", name, strings.Join(SMTPProfileNames(), ", "))
+	}
+	return profile.clone(), nil
+}
+
+// LoadSMTPProfileFile loads a custom profile from a JSON file. Fields that
+// are not set are taken from the profile named by base, owlmail by default.
+func LoadSMTPProfileFile(filePath string) (*SMTPProfile, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SMTP profile file: %w", err)
+	}
+	var custom SMTPProfile
+	if err := json.Unmarshal(data, &custom); err != nil {
+		return nil, fmt.Errorf("failed to parse SMTP profile JSON: %w", err)
+	}
+	return custom.resolve()
+}
+
+// resolve fills the unset fields of a custom profile from its base
+func (p *SMTPProfile) resolve() (*SMTPProfile, error) {
+	baseName := p.Base
+	if baseName == "" {
+		baseName = ProfileOwlMail
+	}
+	profile, err := GetBuiltinSMTPProfile(baseName)
+	if err != nil {
+		return nil, err
+	}
+	profile.Base = profile.Name
+	profile.Name = p.Name
+	if profile.Name == "" {
+		profile.Name = "custom"
+	}
+	if p.Hostname != "" {
+		profile.Hostname = p.Hostname
+	}
+	if p.Banner != "" {
+		profile.Banner = p.Banner
+	}
+	if p.Extensions != nil {
+		profile.Extensions = p.Extensions
+	}
+	if p.HiddenCapabilities != nil {
+		profile.HiddenCapabilities = p.HiddenCapabilities
+	}
+	if p.ExtraCapabilities != nil {
+		profile.ExtraCapabilities = p.ExtraCapabilities
+	}
+	if p.MaxMessageBytes != 0 {
+		profile.MaxMessageBytes = p.MaxMessageBytes
+	}
+	if p.MaxRecipients != 0 {
+		profile.MaxRecipients = p.MaxRecipients
+	}
+	if p.ReadTimeout != "" {
+		profile.ReadTimeout = p.ReadTimeout
+	}
+	if p.WriteTimeout != "" {
+		profile.WriteTimeout = p.WriteTimeout
+	}
+	if profile.Replies == nil {
+		profile.Replies = make(map[string]string)
+	}
+	for key, reply := range p.Replies {
+		profile.Replies[key] = reply
+	}
+	return profile, profile.Validate()
+}
+
+// clone returns a deep copy of the profile
+func (p *SMTPProfile) clone() *SMTPProfile {
+	c := *p
+	c.Extensions = append([]string(nil), p.Extensions...)
+	c.HiddenCapabilities = append([]string(nil), p.HiddenCapabilities...)
+	c.ExtraCapabilities = append([]string(nil), p.ExtraCapabilities...)
+	if p.Replies != nil {
+		c.Replies = make(map[string]string, len(p.Replies))
+		for key, reply := range p.Replies {
+			c.Replies[key] = reply
+		}
+	}
+	return &c
+}
+
+// Validate checks that the profile is well formed
+func (p *SMTPProfile) Validate() error {
+	if p.Hostname == "" || strings.ContainsAny(p.Hostname, " \r\n") {
+		return fmt.Errorf("invalid hostname %q", p.Hostname)
+	}
+	if _, err := ParseESMTPExtensions(strings.Join(p.Extensions, ",")); err != nil {
+		return err
+	}
+	if p.MaxMessageBytes < 0 || p.MaxRecipients < 0 {
+		return fmt.Errorf("limits must not be negative")
+	}
+	for _, timeout := range []string{p.ReadTimeout, p.WriteTimeout} {
+		if timeout == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(timeout); err != nil || d < 0 {
+			return fmt.Errorf("invalid timeout %q", timeout)
+		}
+	}
+	for key, reply := range p.Replies {
+		if !containsFold(replyKeys, key) {
+			return fmt.Errorf("unknown reply %q: must be one of %s", key, strings.Join(replyKeys, ", "))
+		}
+		if strings.ContainsAny(reply, "\r\n") {
+			return fmt.Errorf("reply %q must be a single line", key)
+		}
+	}
+	if strings.ContainsAny(p.Banner, "\r\n") {
+		return fmt.Errorf("banner must be a single line")
+	}
+	for _, capability := range p.ExtraCapabilities {
+		if !containsFold(extraCapabilities, capability) {
+			return fmt.Errorf("capability %q is not answered by the server: must be one of %s", capability, strings.Join(extraCapabilities, ", "))
+		}
+	}
+	return nil
+}
+
+// containsFold reports whether list contains s, ignoring case
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// rewritesReplies reports whether connections need a profileRelay
+func (p *SMTPProfile) rewritesReplies() bool {
+	if p == nil {
+		return false
+	}
+	for key := range p.Replies {
+		if key != ReplyQueued {
+			return true
+		}
+	}
+	return p.Banner != "" || len(p.HiddenCapabilities) > 0 || len(p.ExtraCapabilities) > 0
+}
+
+// duration parses a validated timeout, 0 when unset
+func (p *SMTPProfile) duration(timeout string) time.Duration {
+	d, _ := time.ParseDuration(timeout)
+	return d
+}
+
+// SetSMTPProfile applies a profile to the SMTP listeners: their hostname,
+// default limits and timeouts, advertised extensions and reply wording.
+// Limits set explicitly on a listener are kept. It must be called before Listen.
+func (ms *MailServer) SetSMTPProfile(profile *SMTPProfile) error {
+	if err := profile.Validate(); err != nil {
+		return fmt.Errorf("invalid SMTP profile %s: %w", profile.Name, err)
+	}
+	previous := ms.smtpProfile
+	ms.smtpProfile = profile.clone()
+
+	// Rebuild the listeners so that their defaults come from the profile
+	configs := make([]ListenerConfig, len(ms.smtpListeners))
+	for i, l := range ms.smtpListeners {
+		configs[i] = l.requested
+	}
+	if len(configs) > 0 {
+		if err := ms.SetListeners(configs); err != nil {
+			ms.smtpProfile = previous
+			return err
+		}
+	}
+	if len(profile.Extensions) > 0 {
+		extensions, _ := ParseESMTPExtensions(strings.Join(profile.Extensions, ","))
+		ms.SetESMTPExtensions(extensions)
+	}
+	if ms.lmtpServer != nil {
+		ms.lmtpServer.Domain = profile.Hostname
+	}
+	return nil
+}
+
+// GetSMTPProfile returns a copy of the SMTP profile in use
+func (ms *MailServer) GetSMTPProfile() *SMTPProfile {
+	if ms.smtpProfile == nil {
+		profile, _ := GetBuiltinSMTPProfile(ProfileOwlMail)
+		return profile
+	}
+	return ms.smtpProfile.clone()
+}
+
+// queuedReply returns the reply to an accepted message, or nil for the
+// default wording
+func (ms *MailServer) queuedReply(id, session string) error {
+	template := ""
+	if ms.smtpProfile != nil {
+		template = ms.smtpProfile.Replies[ReplyQueued]
+	}
+	if template == "" {
+		return nil
+	}
+	text := ms.smtpProfile.expandReply(template, replyVars{id: id, session: session})
+	code, message := splitEnhancedCode(text)
+	return &smtp.SMTPError{Code: 250, EnhancedCode: code, Message: message}
+}
+
+// replyVars are the values of reply placeholders
+type replyVars struct {
+	client  string
+	ip      string
+	address string
+	id      string
+	session string
+}
+
+// expandReply replaces the placeholders of a reply template
+func (p *SMTPProfile) expandReply(template string, vars replyVars) string {
+	return strings.NewReplacer(
+		"{hostname}", p.Hostname,
+		"{client}", vars.client,
+		"{ip}", vars.ip,
+		"{address}", vars.address,
+		"{id}", vars.id,
+		"{session}", vars.session,
+		"{date}", time.Now().Format(time.RFC1123Z),
+	).Replace(template)
+}
+
+// splitEnhancedCode splits a leading enhanced status code off a reply text
+func splitEnhancedCode(text string) (smtp.EnhancedCode, string) {
+	first, rest, _ := strings.Cut(text, " ")
+	if code, err := parseEnhancedCode(first); err == nil {
+		return code, rest
+	}
+	return smtp.NoEnhancedCode, text
+}
+
+// newSessionToken returns a random token identifying a connection in replies
+func newSessionToken() string {
+	b := make([]byte, 6)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// rewrite returns the bytes to send for a reply line written by go-smtp
+func (r *profileRelay) rewrite(line string) []byte {
+	if len(line) < 4 {
+		return []byte(line + "\r\n")
+	}
+	code, final, text := line[:3], line[3] == ' ', line[4:]
+
+	// EHLO replies are collected to drop and add capabilities
+	if r.ehlo != nil {
+		r.ehlo = append(r.ehlo, text)
+		if !final {
+			return nil
+		}
+		return r.ehloReply()
+	}
+	if !r.greeted {
+		r.greeted = true
+		if code == "220" && r.profile.Banner != "" {
+			return r.reply(code, r.profile.Banner, replyVars{})
+		}
+		return []byte(line + "\r\n")
+	}
+
+	switch {
+	case code == "250" && !final && strings.HasPrefix(text, goSMTPEHLOPrefix):
+		r.ehlo = []string{text}
+		return nil
+	case code == "250" && strings.HasPrefix(text, goSMTPHELOPrefix):
+		return r.replace(line, ReplyHELO, replyVars{client: strings.TrimPrefix(text, goSMTPHELOPrefix)})
+	case code == "250" && strings.HasPrefix(text, goSMTPMailPrefix):
+		address := strings.TrimSuffix(strings.TrimPrefix(text, goSMTPMailPrefix), ">")
+		return r.replace(line, ReplyMail, replyVars{address: address})
+	case code == "250" && strings.HasPrefix(text, goSMTPRcptPrefix):
+		address := strings.TrimSuffix(strings.TrimPrefix(text, goSMTPRcptPrefix), goSMTPRcptSuffix)
+		return r.replace(line, ReplyRcpt, replyVars{address: address})
+	case code == "354" && strings.HasPrefix(text, goSMTPDataPrefix):
+		return r.replace(line, ReplyData, replyVars{})
+	case code == "221" && text == goSMTPQuitReply:
+		return r.replace(line, ReplyQuit, replyVars{})
+	case code == "250" && text == goSMTPRsetReply:
+		return r.replace(line, ReplyRset, replyVars{})
+	case code == "250" && text == goSMTPNoopReply:
+		return r.replace(line, ReplyNoop, replyVars{})
+	case code == "252" && strings.HasPrefix(text, goSMTPVrfyPrefix):
+		return r.replace(line, ReplyVrfy, replyVars{})
+	case code == "235" && text == goSMTPAuthReply:
+		return r.replace(line, ReplyAuth, replyVars{})
+	case code == "220" && text == goSMTPStartTLSReply:
+		r.startTLS = true
+		return r.replace(line, ReplyStartTLS, replyVars{})
+	}
+	return []byte(line + "\r\n")
+}
+
+// replace rewrites line with the profile's reply for key, if it has one
+func (r *profileRelay) replace(line, key string, vars replyVars) []byte {
+	template, ok := r.profile.Replies[key]
+	if !ok {
+		return []byte(line + "\r\n")
+	}
+	return r.reply(line[:3], template, vars)
+}
+
+// reply formats a single-line reply
+func (r *profileRelay) reply(code, template string, vars replyVars) []byte {
+	vars.ip = hostIP(r.raw.RemoteAddr().String())
+	vars.session = r.session
+	return []byte(code + " " + r.profile.expandReply(template, vars) + "\r\n")
+}
+
+// ehloReply formats the collected EHLO reply with the profile's first line
+// and capabilities
+func (r *profileRelay) ehloReply() []byte {
+	first, capabilities := r.ehlo[0], r.ehlo[1:]
+	r.ehlo = nil
+	if template, ok := r.profile.Replies[ReplyEHLO]; ok {
+		vars := replyVars{client: strings.TrimPrefix(first, goSMTPEHLOPrefix), ip: hostIP(r.raw.RemoteAddr().String()), session: r.session}
+		first = r.profile.expandReply(template, vars)
+	}
+
+	lines := []string{first}
+	for _, capability := range capabilities {
+		keyword, _, _ := strings.Cut(capability, " ")
+		if !containsFold(r.profile.HiddenCapabilities, keyword) {
+			lines = append(lines, capability)
+		}
+	}
+	lines = append(lines, r.profile.ExtraCapabilities...)
+
+	var b strings.Builder
+	for i, line := range lines {
+		separator := "-"
+		if i == len(lines)-1 {
+			separator = " "
+		}
+		b.WriteString("250" + separator + line + "\r\n")
+	}
+	return []byte(b.String())
+}
@@ -0,0 +1,280 @@
+package mailserver
+
+import (
+	"bytes"
+	"crypto/tls"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-smtp"
+)
+
+// profileRelay rewrites the replies that go-smtp words itself into the
+// wording of an SMTP profile. go-smtp serves one end of a pipe and the relay
+// copies between the other end and the client, rewriting the replies on the
+// way. The relay terminates the client's TLS, whether implicit or after
+// STARTTLS, so that it rewrites plaintext, and mirrors it over the pipe with
+// a loopback TLS session: go-smtp still sees a *tls.Conn and offers what it
+// offers on encrypted connections.
+type profileRelay struct {
+	profile    *SMTPProfile
+	transcript *transcript
+	session    string
+	raw        *transcriptConn // Client connection
+	tlsConfig  *tls.Config     // TLS towards the client, nil without TLS
+	pipe       net.Conn        // Relay end of the pipe
+
+	mu        sync.Mutex
+	client    net.Conn // raw, or the TLS connection over it
+	server    net.Conn // pipe, or the loopback TLS connection over it
+	tlsState  *tls.ConnectionState
+	upgrading chan struct{} // Closed once STARTTLS has been relayed
+	paused    chan struct{} // Closed once commands are no longer relayed for STARTTLS
+
+	pending  []byte   // Incomplete reply line
+	ehlo     []string // Lines of the EHLO reply being collected
+	greeted  bool
+	startTLS bool // The reply being sent accepts STARTTLS
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// relayPipe is go-smtp's end of a profileRelay pipe. It has the addresses
+// of the client connection and tells the transcript when go-smtp writes.
+type relayPipe struct {
+	net.Conn
+	relay *profileRelay
+}
+
+// Read implements net.Conn
+func (p *relayPipe) Read(b []byte) (int, error) {
+	n, err := p.Conn.Read(b)
+	p.relay.transcript.markRead()
+	return n, err
+}
+
+// Write implements net.Conn
+func (p *relayPipe) Write(b []byte) (int, error) {
+	n, err := p.Conn.Write(b)
+	p.relay.transcript.markWrite()
+	return n, err
+}
+
+// LocalAddr implements net.Conn
+func (p *relayPipe) LocalAddr() net.Addr {
+	return p.relay.raw.LocalAddr()
+}
+
+// RemoteAddr implements net.Conn
+func (p *relayPipe) RemoteAddr() net.Addr {
+	return p.relay.raw.RemoteAddr()
+}
+
+// newProfileRelay starts relaying the connection tc for srv, and returns the
+// connection srv must serve. srv's TLS configuration is moved to the relay.
+func newProfileRelay(srv *smtp.Server, tc *transcriptConn, profile *SMTPProfile, session string, implicitTLS bool) net.Conn {
+	serverEnd, relayEnd := net.Pipe()
+	r := &profileRelay{
+		profile:    profile,
+		transcript: tc.transcript,
+		session:    session,
+		raw:        tc,
+		tlsConfig:  srv.TLSConfig,
+		pipe:       relayEnd,
+		client:     tc.Conn,
+		server:     relayEnd,
+		done:       make(chan struct{}),
+	}
+	r.transcript.relayed = true
+
+	var conn net.Conn = &relayPipe{Conn: serverEnd, relay: r}
+	if r.tlsConfig != nil {
+		srv.TLSConfig = &tls.Config{Certificates: r.tlsConfig.Certificates, SessionTicketsDisabled: true}
+		if implicitTLS {
+			conn = tls.Server(conn, srv.TLSConfig)
+		}
+	}
+	go r.run(implicitTLS && r.tlsConfig != nil)
+	return conn
+}
+
+// run relays the connection until either side closes it
+func (r *profileRelay) run(implicitTLS bool) {
+	defer r.close()
+	if implicitTLS {
+		if err := r.handshake(); err != nil {
+			return
+		}
+	}
+	go r.relayCommands()
+	r.relayReplies()
+}
+
+// handshake performs the client's TLS handshake, then the loopback one
+func (r *profileRelay) handshake() error {
+	client := tls.Server(r.raw.Conn, r.tlsConfig)
+	_ = r.raw.SetDeadline(time.Now().Add(defaultSMTPTimeout))
+	if err := client.Handshake(); err != nil {
+		return err
+	}
+	_ = r.raw.SetDeadline(time.Time{})
+
+	server := tls.Client(r.pipe, &tls.Config{InsecureSkipVerify: true})
+	if err := server.Handshake(); err != nil {
+		return err
+	}
+	state := client.ConnectionState()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.client, r.server, r.tlsState = client, server, &state
+	return nil
+}
+
+// conns returns the current client and server connections
+func (r *profileRelay) conns() (net.Conn, net.Conn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.client, r.server
+}
+
+// relayCommands copies client data to go-smtp. It stops reading while
+// STARTTLS is relayed, as the client's next bytes start the TLS handshake.
+func (r *profileRelay) relayCommands() {
+	defer r.close()
+	buf := make([]byte, 4096)
+	for {
+		client, server := r.conns()
+		n, err := client.Read(buf)
+		if n > 0 {
+			if _, err := server.Write(buf[:n]); err != nil {
+				return
+			}
+		}
+		if err == nil {
+			continue
+		}
+
+		r.mu.Lock()
+		upgrading, paused := r.upgrading, r.paused
+		r.mu.Unlock()
+		if upgrading == nil {
+			return
+		}
+		close(paused)
+		select {
+		case <-upgrading:
+		case <-r.done:
+			return
+		}
+	}
+}
+
+// relayReplies copies go-smtp's replies to the client in the profile's wording
+func (r *profileRelay) relayReplies() {
+	buf := make([]byte, 4096)
+	for {
+		_, server := r.conns()
+		n, err := server.Read(buf)
+		r.pending = append(r.pending, buf[:n]...)
+		var out []byte
+		for {
+			i := bytes.IndexByte(r.pending, '\n')
+			if i < 0 {
+				break
+			}
+			line := strings.TrimRight(string(r.pending[:i+1]), "\r\n")
+			r.pending = r.pending[i+1:]
+			out = append(out, r.rewrite(line)...)
+			if r.startTLS {
+				r.startTLS = false
+				if r.upgrade(out) != nil {
+					return
+				}
+				out = nil
+			}
+		}
+		if len(out) > 0 && r.send(out) != nil {
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// send records reply bytes in the transcript and writes them to the client
+func (r *profileRelay) send(out []byte) error {
+	r.transcript.sentReply(out)
+	client, _ := r.conns()
+	_ = client.SetWriteDeadline(time.Now().Add(defaultSMTPTimeout))
+	_, err := client.Write(out)
+	return err
+}
+
+// upgrade sends the reply accepting STARTTLS, then switches both sides of
+// the relay to TLS
+func (r *profileRelay) upgrade(reply []byte) error {
+	if r.tlsConfig == nil {
+		return r.send(reply)
+	}
+
+	// Interrupt the pending read of the next command
+	upgrading, paused := make(chan struct{}), make(chan struct{})
+	r.mu.Lock()
+	r.upgrading, r.paused = upgrading, paused
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		r.upgrading, r.paused = nil, nil
+		r.mu.Unlock()
+		close(upgrading)
+	}()
+	_ = r.raw.SetReadDeadline(time.Now())
+	select {
+	case <-paused:
+	case <-r.done:
+		return net.ErrClosed
+	}
+	_ = r.raw.SetReadDeadline(time.Time{})
+
+	if err := r.send(reply); err != nil {
+		return err
+	}
+	return r.handshake()
+}
+
+// clientTLSState returns the TLS state of the client connection
+func (r *profileRelay) clientTLSState() (tls.ConnectionState, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.tlsState == nil {
+		return tls.ConnectionState{}, false
+	}
+	return *r.tlsState, true
+}
+
+// close closes the client connection and the pipe, which ends go-smtp's session
+func (r *profileRelay) close() {
+	r.closeOnce.Do(func() {
+		close(r.done)
+		_ = r.raw.Close()
+		_ = r.pipe.Close()
+	})
+}
+
+// tlsConnectionState returns the TLS state of the client connection served
+// as conn, which may be a profileRelay's loopback TLS connection
+func tlsConnectionState(conn net.Conn) (tls.ConnectionState, bool) {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return tls.ConnectionState{}, false
+	}
+	if pipe, ok := tlsConn.NetConn().(*relayPipe); ok {
+		return pipe.relay.clientTLSState()
+	}
+	return tlsConn.ConnectionState(), true
+}
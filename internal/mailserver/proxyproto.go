@@ -0,0 +1,288 @@
+package mailserver
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-smtp"
+	"github.com/soulteary/owlmail/internal/common"
+)
+
+const (
+	// proxyV1MaxLength is the longest PROXY protocol v1 header, CRLF included
+	proxyV1MaxLength = 107
+
+	// proxyHeaderTimeout bounds the time a client has to send its PROXY header
+	// when the listener has no read timeout
+	proxyHeaderTimeout = 10 * time.Second
+)
+
+var (
+	// proxyV1Prefix starts every PROXY protocol v1 header
+	proxyV1Prefix = []byte("PROXY ")
+
+	// proxyV2Signature starts every PROXY protocol v2 header
+	proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+// proxyConn is a connection received through a proxy. It reports the
+// addresses announced in the PROXY header instead of the proxy's.
+type proxyConn struct {
+	net.Conn
+	reader *bufio.Reader // Buffered bytes following the header
+	remote net.Addr
+	local  net.Addr
+}
+
+// Read implements net.Conn
+func (c *proxyConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// RemoteAddr implements net.Conn
+func (c *proxyConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// LocalAddr implements net.Conn
+func (c *proxyConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+// SetProxyProtocolTrusted sets the networks allowed to send PROXY protocol
+// headers to listeners with ProxyProtocol enabled. Connections from other
+// sources are served as direct connections. An empty list trusts no source.
+func (ms *MailServer) SetProxyProtocolTrusted(cidrs []string) error {
+	networks, err := parseCIDRs(cidrs)
+	if err != nil {
+		return err
+	}
+
+	ms.proxyMutex.Lock()
+	defer ms.proxyMutex.Unlock()
+	ms.proxyTrusted = networks
+	return nil
+}
+
+// GetProxyProtocolTrusted returns the networks trusted to send PROXY protocol headers
+func (ms *MailServer) GetProxyProtocolTrusted() []string {
+	ms.proxyMutex.RLock()
+	defer ms.proxyMutex.RUnlock()
+	return networkStrings(ms.proxyTrusted)
+}
+
+// parseCIDR parses a CIDR, accepting a bare IP address as a single host
+func parseCIDR(cidr string) (*net.IPNet, error) {
+	cidr = strings.TrimSpace(cidr)
+	if !strings.Contains(cidr, "/") {
+		ip := net.ParseIP(cidr)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid CIDR %q", cidr)
+		}
+		bits := 8 * net.IPv6len
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 8*net.IPv4len
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CIDR %q: %w", cidr, err)
+	}
+	return network, nil
+}
+
+// isProxyTrusted reports whether a peer may send a PROXY protocol header.
+// Only TCP peers within the trusted networks may.
+func (ms *MailServer) isProxyTrusted(addr net.Addr) bool {
+	ms.proxyMutex.RLock()
+	defer ms.proxyMutex.RUnlock()
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	return containsIP(ms.proxyTrusted, tcpAddr.IP)
+}
+
+// acceptProxied reads the PROXY protocol header of a connection from a
+// trusted proxy and serves it with the announced client address
+func (ms *MailServer) acceptProxied(srv *smtp.Server, conn net.Conn, implicitTLS bool) {
+	if !ms.isProxyTrusted(conn.RemoteAddr()) {
+		common.Verbose("Connection from untrusted proxy source %s, serving it directly", conn.RemoteAddr())
+		ms.serveConn(srv, conn, implicitTLS)
+		return
+	}
+
+	// Make sure the header read is interrupted if the mail server closes meanwhile
+	if !ms.trackServing(conn) {
+		_ = conn.Close()
+		return
+	}
+	go func() {
+		timeout := srv.ReadTimeout
+		if timeout <= 0 {
+			timeout = proxyHeaderTimeout
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(timeout))
+		proxied, err := readProxyHeader(conn)
+		_ = conn.SetReadDeadline(time.Time{})
+		ms.untrackServing(conn)
+
+		if err != nil {
+			common.Verbose("Rejected connection from %s: %v", conn.RemoteAddr(), err)
+			_ = conn.Close()
+			return
+		}
+		if proxied.RemoteAddr() != conn.RemoteAddr() {
+			common.Verbose("PROXY protocol: connection from %s via %s", proxied.RemoteAddr(), conn.RemoteAddr())
+		}
+		ms.serveConn(srv, proxied, implicitTLS)
+	}()
+}
+
+// readProxyHeader reads a PROXY protocol v1 or v2 header from conn.
+// LOCAL and UNKNOWN headers, sent by proxies for their own health checks,
+// keep the connection's own addresses.
+func readProxyHeader(conn net.Conn) (*proxyConn, error) {
+	pc := &proxyConn{
+		Conn:   conn,
+		reader: bufio.NewReader(conn),
+		remote: conn.RemoteAddr(),
+		local:  conn.LocalAddr(),
+	}
+
+	// Only peek as far as the header must go: the client sends nothing more
+	// before the greeting
+	prefix, err := pc.reader.Peek(len(proxyV1Prefix))
+	if err != nil {
+		return nil, fmt.Errorf("missing PROXY protocol header: %w", err)
+	}
+	switch {
+	case bytes.Equal(prefix, proxyV1Prefix):
+		err = pc.readV1()
+	case bytes.HasPrefix(proxyV2Signature, prefix):
+		var signature []byte
+		if signature, err = pc.reader.Peek(len(proxyV2Signature)); err != nil {
+			return nil, fmt.Errorf("missing PROXY protocol header: %w", err)
+		}
+		if !bytes.Equal(signature, proxyV2Signature) {
+			return nil, fmt.Errorf("missing PROXY protocol header")
+		}
+		err = pc.readV2()
+	default:
+		err = fmt.Errorf("missing PROXY protocol header")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return pc, nil
+}
+
+// readV1 parses a text header such as "PROXY TCP4 203.0.113.7 192.0.2.1 51234 25\r\n"
+func (c *proxyConn) readV1() error {
+	var line []byte
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= proxyV1MaxLength {
+			return fmt.Errorf("PROXY v1 header too long")
+		}
+		b, err := c.reader.ReadByte()
+		if err != nil {
+			return fmt.Errorf("failed to read PROXY v1 header: %w", err)
+		}
+		line = append(line, b)
+	}
+
+	fields := strings.Split(strings.TrimSuffix(string(line), "\r\n"), " ")
+	if len(fields) < 2 || fields[0] != "PROXY" {
+		return fmt.Errorf("invalid PROXY v1 header %q", line)
+	}
+	switch fields[1] {
+	case "UNKNOWN":
+		return nil
+	case "TCP4", "TCP6":
+	default:
+		return fmt.Errorf("unsupported PROXY v1 protocol %q", fields[1])
+	}
+	if len(fields) != 6 {
+		return fmt.Errorf("invalid PROXY v1 header %q", line)
+	}
+
+	src, err := parseProxyV1Addr(fields[2], fields[4], fields[1] == "TCP4")
+	if err != nil {
+		return err
+	}
+	dst, err := parseProxyV1Addr(fields[3], fields[5], fields[1] == "TCP4")
+	if err != nil {
+		return err
+	}
+	c.remote, c.local = src, dst
+	return nil
+}
+
+// parseProxyV1Addr parses an address and port of a v1 header
+func parseProxyV1Addr(host, port string, ipv4 bool) (*net.TCPAddr, error) {
+	ip := net.ParseIP(host)
+	if ip == nil || (ip.To4() != nil) != ipv4 {
+		return nil, fmt.Errorf("invalid PROXY v1 address %q", host)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid PROXY v1 port %q", port)
+	}
+	return &net.TCPAddr{IP: ip, Port: int(p)}, nil
+}
+
+// readV2 parses a binary header
+func (c *proxyConn) readV2() error {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(c.reader, header); err != nil {
+		return fmt.Errorf("failed to read PROXY v2 header: %w", err)
+	}
+	if version := header[12] >> 4; version != 2 {
+		return fmt.Errorf("unsupported PROXY protocol version %d", version)
+	}
+	command := header[12] & 0x0f
+	family := header[13]
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return fmt.Errorf("failed to read PROXY v2 addresses: %w", err)
+	}
+
+	switch command {
+	case 0x0: // LOCAL
+		return nil
+	case 0x1: // PROXY
+	default:
+		return fmt.Errorf("unsupported PROXY v2 command %d", command)
+	}
+
+	var ipLen int
+	switch family {
+	case 0x11: // TCP over IPv4
+		ipLen = net.IPv4len
+	case 0x21: // TCP over IPv6
+		ipLen = net.IPv6len
+	default:
+		// UNSPEC, UDP and unix sockets carry no client address to use
+		return nil
+	}
+	if len(payload) < 2*ipLen+4 {
+		return fmt.Errorf("PROXY v2 address block too short")
+	}
+	c.remote = &net.TCPAddr{
+		IP:   net.IP(append([]byte{}, payload[:ipLen]...)),
+		Port: int(binary.BigEndian.Uint16(payload[2*ipLen:])),
+	}
+	c.local = &net.TCPAddr{
+		IP:   net.IP(append([]byte{}, payload[ipLen:2*ipLen]...)),
+		Port: int(binary.BigEndian.Uint16(payload[2*ipLen+2:])),
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+package mailserver
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/emersion/go-smtp"
+	"github.com/soulteary/owlmail/internal/common"
+)
+
+// rateLimitWindow is the period message rates are counted over
+const rateLimitWindow = time.Minute
+
+// RateLimitConfig limits SMTP connections and messages. Zero disables a limit.
+// LMTP deliveries are not limited.
+type RateLimitConfig struct {
+	MaxConnections           int `json:"maxConnections"`           // Concurrent connections in total
+	MaxConnectionsPerIP      int `json:"maxConnectionsPerIP"`      // Concurrent connections per client IP
+	MessagesPerMinute        int `json:"messagesPerMinute"`        // Messages per minute in total
+	MessagesPerMinutePerIP   int `json:"messagesPerMinutePerIP"`   // Messages per minute per client IP
+	MessagesPerMinutePerUser int `json:"messagesPerMinutePerUser"` // Messages per minute per authenticated user
+}
+
+// RateLimitStats holds the current rate limiting counters
+type RateLimitStats struct {
+	Connections         int            `json:"connections"`         // Open connections
+	ConnectionsPerIP    map[string]int `json:"connectionsPerIP"`    // Open connections per client IP
+	Messages            int            `json:"messages"`            // Messages in the last minute
+	MessagesPerIP       map[string]int `json:"messagesPerIP"`       // Messages in the last minute per client IP
+	MessagesPerUser     map[string]int `json:"messagesPerUser"`     // Messages in the last minute per authenticated user
+	RejectedConnections int64          `json:"rejectedConnections"` // Connections refused since startup
+	RejectedMessages    int64          `json:"rejectedMessages"`    // Messages refused since startup
+}
+
+// rateLimiter tracks open connections and recent messages
+type rateLimiter struct {
+	connections         map[string]int
+	messages            []time.Time
+	messagesPerIP       map[string][]time.Time
+	messagesPerUser     map[string][]time.Time
+	rejectedConnections int64
+	rejectedMessages    int64
+}
+
+// newRateLimiter creates an empty rate limiter
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{
+		connections:     make(map[string]int),
+		messagesPerIP:   make(map[string][]time.Time),
+		messagesPerUser: make(map[string][]time.Time),
+	}
+}
+
+// Validate checks that the configuration is well formed
+func (c *RateLimitConfig) Validate() error {
+	if c.MaxConnections < 0 || c.MaxConnectionsPerIP < 0 || c.MessagesPerMinute < 0 ||
+		c.MessagesPerMinutePerIP < 0 || c.MessagesPerMinutePerUser < 0 {
+		return fmt.Errorf("rate limits must not be negative")
+	}
+	return nil
+}
+
+// SetRateLimitConfig updates the rate limits
+func (ms *MailServer) SetRateLimitConfig(config RateLimitConfig) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+
+	ms.rateLimitMutex.Lock()
+	defer ms.rateLimitMutex.Unlock()
+	ms.rateLimitConfig = config
+	return nil
+}
+
+// GetRateLimitConfig returns the rate limits
+func (ms *MailServer) GetRateLimitConfig() RateLimitConfig {
+	ms.rateLimitMutex.Lock()
+	defer ms.rateLimitMutex.Unlock()
+	return ms.rateLimitConfig
+}
+
+// GetRateLimitStats returns the current connection and message counters
+func (ms *MailServer) GetRateLimitStats() RateLimitStats {
+	ms.rateLimitMutex.Lock()
+	defer ms.rateLimitMutex.Unlock()
+
+	rl := ms.rateLimiter
+	rl.expire(time.Now())
+	stats := RateLimitStats{
+		ConnectionsPerIP:    make(map[string]int, len(rl.connections)),
+		Messages:            len(rl.messages),
+		MessagesPerIP:       make(map[string]int, len(rl.messagesPerIP)),
+		MessagesPerUser:     make(map[string]int, len(rl.messagesPerUser)),
+		RejectedConnections: rl.rejectedConnections,
+		RejectedMessages:    rl.rejectedMessages,
+	}
+	for ip, count := range rl.connections {
+		stats.Connections += count
+		stats.ConnectionsPerIP[ip] = count
+	}
+	for ip, times := range rl.messagesPerIP {
+		stats.MessagesPerIP[ip] = len(times)
+	}
+	for user, times := range rl.messagesPerUser {
+		stats.MessagesPerUser[user] = len(times)
+	}
+	return stats
+}
+
+// acquireConnection counts a new connection from ip and returns a function
+// releasing it, or a 421 reply if a connection limit is reached
+func (ms *MailServer) acquireConnection(ip string) (func(), error) {
+	ms.rateLimitMutex.Lock()
+	defer ms.rateLimitMutex.Unlock()
+
+	rl := ms.rateLimiter
+	config := ms.rateLimitConfig
+	if config.MaxConnectionsPerIP > 0 && rl.connections[ip] >= config.MaxConnectionsPerIP {
+		rl.rejectedConnections++
+		common.Verbose("Rejected connection from %s: %d connections open", ip, rl.connections[ip])
+		return nil, &smtp.SMTPError{
+			Code:         421,
+			EnhancedCode: smtp.EnhancedCode{4, 7, 0},
+			Message:      fmt.Sprintf("Too many connections from %s, try again later", ip),
+		}
+	}
+	if config.MaxConnections > 0 {
+		total := 0
+		for _, count := range rl.connections {
+			total += count
+		}
+		if total >= config.MaxConnections {
+			rl.rejectedConnections++
+			common.Verbose("Rejected connection from %s: %d connections open in total", ip, total)
+			return nil, &smtp.SMTPError{
+				Code:         421,
+				EnhancedCode: smtp.EnhancedCode{4, 3, 2},
+				Message:      "Too many connections, try again later",
+			}
+		}
+	}
+
+	rl.connections[ip]++
+	released := false
+	return func() {
+		ms.rateLimitMutex.Lock()
+		defer ms.rateLimitMutex.Unlock()
+		if released {
+			return
+		}
+		released = true
+		if rl.connections[ip]--; rl.connections[ip] <= 0 {
+			delete(rl.connections, ip)
+		}
+	}, nil
+}
+
+// checkMessageRate returns a 451 reply if a message rate limit of ip or user
+// is reached. Messages only count once accepted, see countMessageRate.
+func (ms *MailServer) checkMessageRate(ip, user string) error {
+	ms.rateLimitMutex.Lock()
+	defer ms.rateLimitMutex.Unlock()
+
+	rl := ms.rateLimiter
+	config := ms.rateLimitConfig
+	now := time.Now()
+	rl.expire(now)
+
+	var reason string
+	switch {
+	case config.MessagesPerMinutePerIP > 0 && len(rl.messagesPerIP[ip]) >= config.MessagesPerMinutePerIP:
+		reason = fmt.Sprintf("Too many messages from %s", ip)
+	case user != "" && config.MessagesPerMinutePerUser > 0 && len(rl.messagesPerUser[user]) >= config.MessagesPerMinutePerUser:
+		reason = fmt.Sprintf("Too many messages from user %s", user)
+	case config.MessagesPerMinute > 0 && len(rl.messages) >= config.MessagesPerMinute:
+		reason = "Too many messages"
+	}
+	if reason != "" {
+		rl.rejectedMessages++
+		common.Verbose("Rate limited message from %s (user %q): %s", ip, user, reason)
+		return &smtp.SMTPError{
+			Code:         451,
+			EnhancedCode: smtp.EnhancedCode{4, 7, 1},
+			Message:      reason + ", try again later",
+		}
+	}
+	return nil
+}
+
+// countMessageRate counts a message accepted from ip and user towards the
+// message rate limits
+func (ms *MailServer) countMessageRate(ip, user string) {
+	ms.rateLimitMutex.Lock()
+	defer ms.rateLimitMutex.Unlock()
+
+	rl := ms.rateLimiter
+	now := time.Now()
+	rl.messages = append(rl.messages, now)
+	rl.messagesPerIP[ip] = append(rl.messagesPerIP[ip], now)
+	if user != "" {
+		rl.messagesPerUser[user] = append(rl.messagesPerUser[user], now)
+	}
+}
+
+// expire drops messages older than the rate limit window.
+// The caller must hold rateLimitMutex.
+func (rl *rateLimiter) expire(now time.Time) {
+	cutoff := now.Add(-rateLimitWindow)
+	rl.messages = dropBefore(rl.messages, cutoff)
+	for key, times := range rl.messagesPerIP {
+		if rl.messagesPerIP[key] = dropBefore(times, cutoff); len(rl.messagesPerIP[key]) == 0 {
+			delete(rl.messagesPerIP, key)
+		}
+	}
+	for key, times := range rl.messagesPerUser {
+		if rl.messagesPerUser[key] = dropBefore(times, cutoff); len(rl.messagesPerUser[key]) == 0 {
+			delete(rl.messagesPerUser, key)
+		}
+	}
+}
+
+// dropBefore removes the leading times before cutoff from an ordered slice
+func dropBefore(times []time.Time, cutoff time.Time) []time.Time {
+	i := 0
+	for i < len(times) && times[i].Before(cutoff) {
+		i++
+	}
+	return times[i:]
+}
+
+// hostIP returns the IP of a host:port address, or the address itself when
+// it has no port, as for unix sockets
+func hostIP(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
@@ -0,0 +1,270 @@
+package mailserver
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-smtp"
+	"github.com/soulteary/owlmail/internal/common"
+)
+
+// transcriptConn wraps a client connection and tells its transcript when
+// bytes are written to the client, before the TLS layer encrypts them
+type transcriptConn struct {
+	net.Conn
+	transcript *transcript
+	release    func() // Releases the connection's rate limiting slot, if any
+	closeOnce  sync.Once
+	closed     chan struct{}
+}
+
+// Read implements net.Conn
+func (c *transcriptConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.transcript.markRead()
+	return n, err
+}
+
+// Write implements net.Conn
+func (c *transcriptConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.transcript.markWrite()
+	return n, err
+}
+
+// Close implements net.Conn
+func (c *transcriptConn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		c.transcript.finish()
+		if c.release != nil {
+			c.release()
+		}
+		err = c.Conn.Close()
+		close(c.closed)
+	})
+	return err
+}
+
+// connListener is a net.Listener that yields a single, already accepted
+// connection and then blocks until that connection is closed
+type connListener struct {
+	conn     net.Conn
+	closed   <-chan struct{}
+	accepted bool
+}
+
+// Accept implements net.Listener
+func (l *connListener) Accept() (net.Conn, error) {
+	if !l.accepted {
+		l.accepted = true
+		return l.conn, nil
+	}
+	<-l.closed
+	return nil, net.ErrClosed
+}
+
+// Close implements net.Listener
+func (l *connListener) Close() error {
+	return l.conn.Close()
+}
+
+// Addr implements net.Listener
+func (l *connListener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
+
+// serve accepts connections on ln and hands each one to its own copy of srv.
+// go-smtp only offers a server-wide Debug writer, so a server per connection
+// is what lets every conversation be recorded separately.
+// With implicitTLS, connections are wrapped in TLS using srv.TLSConfig (SMTPS).
+// With proxyProtocol, trusted sources must start with a PROXY protocol header.
+func (ms *MailServer) serve(srv *smtp.Server, ln net.Listener, implicitTLS, proxyProtocol bool) error {
+	if !ms.trackServing(ln) {
+		_ = ln.Close()
+		return nil
+	}
+	defer ms.untrackServing(ln)
+
+	var retryDelay time.Duration
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ms.isServingClosed() || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			// Back off on transient errors such as running out of file descriptors
+			if retryDelay == 0 {
+				retryDelay = 5 * time.Millisecond
+			} else {
+				retryDelay = min(2*retryDelay, time.Second)
+			}
+			common.Error("Accept error: %v; retrying in %s", err, retryDelay)
+			time.Sleep(retryDelay)
+			continue
+		}
+		retryDelay = 0
+		if proxyProtocol {
+			ms.acceptProxied(srv, conn, implicitTLS)
+			continue
+		}
+		ms.serveConn(srv, conn, implicitTLS)
+	}
+}
+
+// serveConn serves a single client connection in the background
+func (ms *MailServer) serveConn(srv *smtp.Server, conn net.Conn, implicitTLS bool) {
+	// Connections count against the connection limits, local LMTP ones do not
+	var release func()
+	if b, ok := srv.Backend.(*Backend); ok && !b.localLMTP(conn) {
+		var err error
+		if release, err = ms.acquireConnection(hostIP(conn.RemoteAddr().String())); err != nil {
+			ms.refuseConn(srv, conn, implicitTLS, err)
+			return
+		}
+	}
+
+	t := newTranscript(ms, conn)
+	tc := &transcriptConn{Conn: conn, transcript: t, release: release, closed: make(chan struct{})}
+
+	connSrv := cloneServer(srv, t)
+	var clientConn net.Conn = tc
+	if b, ok := connSrv.Backend.(*Backend); ok && !b.lmtp && ms.smtpProfile.rewritesReplies() {
+		clientConn = newProfileRelay(connSrv, tc, ms.smtpProfile, b.token, implicitTLS)
+	} else if implicitTLS && connSrv.TLSConfig != nil {
+		clientConn = tls.Server(tc, connSrv.TLSConfig)
+	}
+
+	if !ms.trackServing(connSrv) {
+		_ = clientConn.Close()
+		return
+	}
+	go func() {
+		defer ms.untrackServing(connSrv)
+		_ = connSrv.Serve(&connListener{conn: clientConn, closed: tc.closed})
+	}()
+}
+
+// refuseConn sends a reply such as 421 in place of the greeting and closes the connection
+func (ms *MailServer) refuseConn(srv *smtp.Server, conn net.Conn, implicitTLS bool, reply error) {
+	var smtpErr *smtp.SMTPError
+	if !errors.As(reply, &smtpErr) {
+		_ = conn.Close()
+		return
+	}
+	if implicitTLS && srv.TLSConfig != nil {
+		conn = tls.Server(conn, srv.TLSConfig)
+	}
+	go func() {
+		defer func() {
+			_ = conn.Close()
+		}()
+		// Writing over TLS performs the handshake first
+		_ = conn.SetDeadline(time.Now().Add(defaultSMTPTimeout))
+		code := smtpErr.EnhancedCode
+		_, _ = fmt.Fprintf(conn, "%d %d.%d.%d %s\r\n", smtpErr.Code, code[0], code[1], code[2], smtpErr.Message)
+	}()
+}
+
+// cloneServer copies the settings of srv into a new server that records its
+// conversation into t
+func cloneServer(srv *smtp.Server, t *transcript) *smtp.Server {
+	backend := srv.Backend
+	if b, ok := srv.Backend.(*Backend); ok {
+		connBackend := *b
+		connBackend.transcript = t
+		connBackend.token = newSessionToken()
+		backend = &connBackend
+	}
+
+	s := smtp.NewServer(backend)
+	s.Network = srv.Network
+	s.Addr = srv.Addr
+	s.LMTP = srv.LMTP
+	s.Domain = srv.Domain
+	s.MaxRecipients = srv.MaxRecipients
+	s.MaxMessageBytes = srv.MaxMessageBytes
+	s.MaxLineLength = srv.MaxLineLength
+	s.AllowInsecureAuth = srv.AllowInsecureAuth
+	s.ErrorLog = srv.ErrorLog
+	s.ReadTimeout = srv.ReadTimeout
+	s.WriteTimeout = srv.WriteTimeout
+	s.EnableSMTPUTF8 = srv.EnableSMTPUTF8
+	s.EnableREQUIRETLS = srv.EnableREQUIRETLS
+	s.EnableBINARYMIME = srv.EnableBINARYMIME
+	s.EnableDSN = srv.EnableDSN
+	s.EnableRRVS = srv.EnableRRVS
+	s.EnableDELIVERBY = srv.EnableDELIVERBY
+	s.MinimumDeliverByTime = srv.MinimumDeliverByTime
+	s.EnableMTPRIORITY = srv.EnableMTPRIORITY
+	s.MtPriorityProfile = srv.MtPriorityProfile
+	s.Debug = t
+
+	// Record the TLS handshake, whether implicit or via STARTTLS
+	if srv.TLSConfig != nil {
+		s.TLSConfig = srv.TLSConfig.Clone()
+		verifyConnection := s.TLSConfig.VerifyConnection
+		s.TLSConfig.VerifyConnection = func(state tls.ConnectionState) error {
+			if verifyConnection != nil {
+				if err := verifyConnection(state); err != nil {
+					return err
+				}
+			}
+			t.tlsEstablished(state)
+			return nil
+		}
+	}
+	return s
+}
+
+// trackServing registers a listener or per-connection server to be closed
+// with the mail server. It returns false if the mail server is already closed.
+func (ms *MailServer) trackServing(c io.Closer) bool {
+	ms.servingMutex.Lock()
+	defer ms.servingMutex.Unlock()
+	if ms.servingClosed {
+		return false
+	}
+	if ms.serving == nil {
+		ms.serving = make(map[io.Closer]struct{})
+	}
+	ms.serving[c] = struct{}{}
+	ms.servingWG.Add(1)
+	return true
+}
+
+// untrackServing unregisters a listener or per-connection server
+func (ms *MailServer) untrackServing(c io.Closer) {
+	ms.servingMutex.Lock()
+	delete(ms.serving, c)
+	ms.servingMutex.Unlock()
+	ms.servingWG.Done()
+}
+
+// isServingClosed reports whether closeServing has been called
+func (ms *MailServer) isServingClosed() bool {
+	ms.servingMutex.Lock()
+	defer ms.servingMutex.Unlock()
+	return ms.servingClosed
+}
+
+// closeServing closes all listeners and client connections and waits for them to finish
+func (ms *MailServer) closeServing() {
+	ms.servingMutex.Lock()
+	ms.servingClosed = true
+	closers := make([]io.Closer, 0, len(ms.serving))
+	for c := range ms.serving {
+		closers = append(closers, c)
+	}
+	ms.servingMutex.Unlock()
+
+	for _, c := range closers {
+		_ = c.Close()
+	}
+	ms.servingWG.Wait()
+}
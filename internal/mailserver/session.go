@@ -1,8 +1,11 @@
 package mailserver
 
 import (
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"os"
 	"path/filepath"
 
@@ -13,20 +16,36 @@ import (
 
 // Backend implements smtp.Backend
 type Backend struct {
-	mailServer *MailServer
+	mailServer  *MailServer
+	lmtp        bool        // Sessions are LMTP deliveries, see localLMTP
+	requireTLS  bool        // STARTTLS is required before MAIL FROM
+	implicitTLS bool        // Connections are TLS from the start (SMTPS)
+	transcript  *transcript // Conversation of the connection, set per connection by serve
+	token       string      // Random token of the connection for profile replies, set by serve
 }
 
 // NewSession creates a new SMTP session
 func (b *Backend) NewSession(c *smtp.Conn) (smtp.Session, error) {
+	var conn net.Conn
+	if c != nil {
+		conn = c.Conn()
+	}
+	// Local LMTP deliveries are not subject to the access policy
+	local := b.localLMTP(conn)
+	if !local && conn != nil {
+		if err := b.mailServer.checkClientAccess(conn.RemoteAddr()); err != nil {
+			return nil, err
+		}
+	}
 	session := &Session{
 		mailServer:    b.mailServer,
 		conn:          c,
-		authenticated: b.mailServer.authConfig == nil || !b.mailServer.authConfig.Enabled,
-	}
-
-	// If authentication is required, mark as not authenticated
-	if b.mailServer.authConfig != nil && b.mailServer.authConfig.Enabled {
-		session.authenticated = false
+		localLMTP:     local,
+		requireTLS:    b.requireTLS,
+		implicitTLS:   b.implicitTLS,
+		transcript:    b.transcript,
+		token:         b.token,
+		authenticated: local || !b.mailServer.isAuthEnabled(),
 	}
 
 	return session, nil
@@ -36,51 +55,137 @@ func (b *Backend) NewSession(c *smtp.Conn) (smtp.Session, error) {
 type Session struct {
 	mailServer    *MailServer
 	conn          *smtp.Conn
+	localLMTP     bool // LMTP delivery from a trusted local agent
+	requireTLS    bool
+	implicitTLS   bool
+	transcript    *transcript
+	token         string
 	from          string
 	to            []string
+	mailOptions   *smtp.MailOptions
+	rcptOptions   []RecipientOptions
 	authenticated bool
+	username      string     // Authenticated SMTP username
+	rejected      *Rejection // Set while the captured start of a refused message is stored
+	lmtpRefused   []error    // Per-recipient refusals of an LMTP delivery, see dataFault
+
+	clientCertSubject     string // Verified TLS client certificate
+	clientCertFingerprint string
 }
 
 // Mail handles the MAIL FROM command
 func (s *Session) Mail(from string, opts *smtp.MailOptions) error {
-	// Check authentication if required
-	// Note: go-smtp library doesn't provide built-in AUTH support in the way we need
-	// For a full implementation, you would need to intercept AUTH commands at the protocol level
-	// For now, we'll allow all connections but log a warning
-	if s.mailServer.authConfig != nil && s.mailServer.authConfig.Enabled && !s.authenticated {
-		// Get remote address from connection if available
-		if s.conn != nil {
-			if conn := s.conn.Conn(); conn != nil {
-				common.Verbose("Warning: Unauthenticated connection attempt from %s", conn.RemoteAddr())
-			} else {
-				common.Verbose("Warning: Unauthenticated connection attempt")
-			}
-		} else {
-			common.Verbose("Warning: Unauthenticated connection attempt")
+	// Reject plaintext transactions on listeners that require STARTTLS
+	if s.requireTLS && !s.isTLS() {
+		return errTLSRequired
+	}
+	// A client certificate mapped to a mailbox authenticates the session
+	s.checkClientCert()
+	// Reject unauthenticated senders when authentication is required
+	if s.mailServer.isAuthEnabled() && !s.authenticated {
+		common.Verbose("Rejected unauthenticated MAIL FROM <%s> from %s", from, s.remoteAddr())
+		return errAuthRequired
+	}
+	// Local LMTP deliveries are not rate limited. Messages over a limit are
+	// refused here, but only count once accepted, see countRate.
+	if !s.localLMTP {
+		if err := s.mailServer.checkMessageRate(s.clientIP(), s.username); err != nil {
+			return err
 		}
-		// In a production system, you should return an error here
-		// return fmt.Errorf("535 5.7.8 Authentication required")
+	}
+	if err := s.mailServer.injectFault(FaultStageMail, from, 0); err != nil {
+		return err
 	}
 	s.from = from
+	s.mailOptions = opts
 	return nil
 }
 
 // Rcpt handles the RCPT TO command
 func (s *Session) Rcpt(to string, opts *smtp.RcptOptions) error {
+	if err := s.mailServer.injectFault(FaultStageRcpt, to, 0); err != nil {
+		return err
+	}
+	// Local LMTP deliveries are not subject to the domain policy
+	if !s.localLMTP {
+		if err := s.mailServer.checkRecipientDomain(to); err != nil {
+			return err
+		}
+	}
+	// Local LMTP deliveries are not greylisted
+	if !s.localLMTP {
+		if err := s.mailServer.checkGreylist(s.clientIP(), s.from, to); err != nil {
+			return err
+		}
+	}
 	s.to = append(s.to, to)
+	s.rcptOptions = append(s.rcptOptions, newRecipientOptions(to, opts))
 	return nil
 }
 
 // Data handles the DATA command
 func (s *Session) Data(r io.Reader) error {
+	id, err := s.deliver(r)
+	if err != nil {
+		return err
+	}
+	s.countRate()
+	// The SMTP profile may word the reply to an accepted message
+	return s.mailServer.queuedReply(id, s.token)
+}
+
+// deliver saves the raw message to disk, then parses and stores it or
+// queues it for the ingest workers. Messages the storage mode does not keep
+// are only counted. It returns the email ID.
+func (s *Session) deliver(r io.Reader) (string, error) {
 	// Generate unique ID
 	id := makeID(s.mailServer.useUUIDForID)
 
+	if !s.mailServer.shouldStore(s.from, s.to) {
+		return id, s.discard(r)
+	}
+
+	// Keep the start of the message in case it exceeds the size limit
+	var capture *oversizeCapture
+	if kb := s.mailServer.oversizeCaptureKB; kb > 0 {
+		capture = newOversizeCapture(kb*1024, kb*1024)
+		r = io.TeeReader(r, capture)
+	}
+
 	// Save raw email
 	emlPath := filepath.Join(s.mailServer.mailDir, id+".eml")
-	emlFile, err := os.Create(emlPath)
+	size, err := writeEmailFile(emlPath, r)
+	if errors.Is(err, smtp.ErrDataTooLarge) {
+		if capture != nil {
+			s.storeOversize(id, capture, smtp.ErrDataTooLarge)
+		}
+		return "", smtp.ErrDataTooLarge
+	}
+	if err != nil {
+		return "", err
+	}
+
+	// Data stage faults are evaluated once the full message size is known
+	if err := s.dataFault(size); err != nil {
+		if removeErr := os.Remove(emlPath); removeErr != nil {
+			common.Verbose("Failed to remove rejected email file: %v", removeErr)
+		}
+		return "", err
+	}
+
+	// The ingest workers, if any, take it from here
+	queued, err := s.mailServer.enqueueIngest(id, s)
+	if err != nil {
+		return "", err
+	}
+	s.mailServer.countMessage(s.from, size, true)
+	if queued {
+		return id, nil
+	}
+
+	emlFile, err := os.Open(emlPath)
 	if err != nil {
-		return fmt.Errorf("failed to create email file: %w", err)
+		return "", fmt.Errorf("failed to open email file: %w", err)
 	}
 	defer func() {
 		if err := emlFile.Close(); err != nil {
@@ -88,18 +193,162 @@ func (s *Session) Data(r io.Reader) error {
 		}
 	}()
 
-	// Copy email data to file and parse
-	tee := io.TeeReader(r, emlFile)
-
 	// Parse email
-	_, err = s.mailServer.parseEmail(id, tee, s, true, false)
-	return err
+	if _, err := s.mailServer.parseEmail(id, emlFile, s, true, false); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// discard reads a message that is not stored and counts it
+func (s *Session) discard(r io.Reader) error {
+	size, err := io.Copy(io.Discard, r)
+	if errors.Is(err, smtp.ErrDataTooLarge) {
+		return smtp.ErrDataTooLarge
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read email: %w", err)
+	}
+	if err := s.dataFault(size); err != nil {
+		return err
+	}
+	s.mailServer.countMessage(s.from, size, false)
+	return nil
+}
+
+// dataFault evaluates the data stage fault rules for a message of size bytes.
+// An LMTP delivery reports a status per recipient, so there the rules match
+// each recipient instead of the sender: the recipients they refuse are
+// recorded in s.lmtpRefused and dropped from the transaction, and the message
+// is refused only if no recipient is left.
+func (s *Session) dataFault(size int64) error {
+	if s.lmtpRefused == nil {
+		return s.mailServer.injectFault(FaultStageData, s.from, size)
+	}
+	var to []string
+	var rcptOptions []RecipientOptions
+	var err error
+	for i, rcpt := range s.to {
+		if rcptErr := s.mailServer.injectFault(FaultStageData, rcpt, size); rcptErr != nil {
+			s.lmtpRefused[i], err = rcptErr, rcptErr
+			continue
+		}
+		to = append(to, rcpt)
+		rcptOptions = append(rcptOptions, s.rcptOptions[i])
+	}
+	if len(to) == 0 {
+		return err
+	}
+	s.to, s.rcptOptions = to, rcptOptions
+	return nil
+}
+
+// countRate counts an accepted message towards the message rate limits
+func (s *Session) countRate() {
+	if !s.localLMTP {
+		s.mailServer.countMessageRate(s.clientIP(), s.username)
+	}
+}
+
+// writeEmailFile copies a raw message to path and returns its size. The file
+// is synced to disk before the message is acknowledged.
+func writeEmailFile(path string, r io.Reader) (int64, error) {
+	emlFile, err := os.Create(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create email file: %w", err)
+	}
+	size, err := io.Copy(emlFile, r)
+	if err == nil {
+		err = emlFile.Sync()
+	}
+	if closeErr := emlFile.Close(); err == nil && closeErr != nil {
+		err = closeErr
+	}
+	if err != nil {
+		if removeErr := os.Remove(path); removeErr != nil {
+			common.Verbose("Failed to remove incomplete email file: %v", removeErr)
+		}
+		return 0, fmt.Errorf("failed to write email file: %w", err)
+	}
+	return size, nil
 }
 
 // Reset resets the session
 func (s *Session) Reset() {
 	s.from = ""
 	s.to = []string{}
+	s.mailOptions = nil
+	s.rcptOptions = nil
+}
+
+// isTLS reports whether the session's connection is encrypted
+func (s *Session) isTLS() bool {
+	if s.conn == nil {
+		return false
+	}
+	_, ok := tlsConnectionState(s.conn.Conn())
+	return ok
+}
+
+// envelope returns the SMTP envelope of the current transaction
+func (s *Session) envelope() *Envelope {
+	envelope := &Envelope{
+		From:                  s.from,
+		To:                    s.to,
+		Host:                  "unknown",
+		RemoteAddress:         "unknown",
+		User:                  s.username,
+		TLS:                   s.tlsInfo(),
+		SessionStart:          s.transcript.startTime(),
+		ClientCertSubject:     s.clientCertSubject,
+		ClientCertFingerprint: s.clientCertFingerprint,
+		Recipients:            s.rcptOptions,
+		Rejected:              s.rejected,
+	}
+	if s.conn != nil {
+		if conn := s.conn.Conn(); conn != nil {
+			envelope.RemoteAddress = conn.RemoteAddr().String()
+		}
+		envelope.Host = s.conn.Hostname()
+	}
+	setMailOptions(envelope, s.mailOptions)
+	return envelope
+}
+
+// tlsInfo describes the TLS connection of the session, or returns nil for plaintext
+func (s *Session) tlsInfo() *TLSInfo {
+	if s.conn == nil {
+		return nil
+	}
+	state, ok := tlsConnectionState(s.conn.Conn())
+	if !ok {
+		return nil
+	}
+	mode := TLSModeSTARTTLS
+	if s.implicitTLS {
+		mode = TLSModeImplicit
+	}
+	return &TLSInfo{
+		Mode:        mode,
+		Version:     tls.VersionName(state.Version),
+		CipherSuite: tls.CipherSuiteName(state.CipherSuite),
+		ServerName:  state.ServerName,
+	}
+}
+
+// remoteAddr returns the client address of the session, or "unknown"
+func (s *Session) remoteAddr() string {
+	if s.conn != nil {
+		if conn := s.conn.Conn(); conn != nil {
+			return conn.RemoteAddr().String()
+		}
+	}
+	return "unknown"
+}
+
+// logAuthFailure logs a failed authentication attempt
+func (s *Session) logAuthFailure(mech, username string) {
+	common.Log("SMTP authentication failed for user %q (%s) from %s", username, mech, s.remoteAddr())
 }
 
 // Logout closes the session
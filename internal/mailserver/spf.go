@@ -0,0 +1,545 @@
+package mailserver
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/soulteary/owlmail/internal/types"
+)
+
+// SPFResult is an alias for types.SPFResult
+type SPFResult = types.SPFResult
+
+// SPF results (RFC 7208)
+const (
+	SPFNone      = "none"
+	SPFNeutral   = "neutral"
+	SPFPass      = "pass"
+	SPFFail      = "fail"
+	SPFSoftFail  = "softfail"
+	SPFTempError = "temperror"
+	SPFPermError = "permerror"
+)
+
+// SPF processing limits (RFC 7208 section 4.6.4)
+const (
+	spfLookupLimit     = 10
+	spfVoidLookupLimit = 2
+)
+
+// spfError ends an evaluation with a temperror or permerror
+type spfError struct {
+	result string
+	reason string
+}
+
+func (e *spfError) Error() string {
+	return e.reason
+}
+
+// spfDirective is a parsed SPF mechanism with its qualifier
+type spfDirective struct {
+	term       string // As written in the record
+	qualifier  string // Result when the mechanism matches
+	mechanism  string // Lower-case mechanism name
+	domainSpec string // Target domain, possibly with macros
+	network    *net.IPNet
+	cidr4      int // Prefix length applied to IPv4 addresses of a and mx
+	cidr6      int // Prefix length applied to IPv6 addresses of a and mx
+}
+
+// spfCheck holds the state of one SPF evaluation
+type spfCheck struct {
+	resolver TXTResolver
+	ip       net.IP
+	sender   string // local-part@domain
+	helo     string
+	lookups  int // DNS lookups by mechanisms and modifiers
+	voids    int // Lookups that found nothing
+}
+
+// checkSPF evaluates the SPF policy of the envelope sender, or of the HELO
+// name for the null sender
+func checkSPF(resolver TXTResolver, ip net.IP, mailFrom, helo string) *SPFResult {
+	result := &SPFResult{Identity: "mailfrom", Sender: mailFrom}
+	if mailFrom == "" {
+		result.Identity = "helo"
+		result.Sender = "postmaster@" + helo
+	} else if !strings.Contains(mailFrom, "@") {
+		result.Sender = "postmaster@" + mailFrom
+	}
+	result.Domain = strings.ToLower(result.Sender[strings.LastIndex(result.Sender, "@")+1:])
+
+	switch {
+	case ip == nil:
+		result.Result, result.Reason = SPFNone, "client address unknown"
+	case !isSPFDomain(result.Domain):
+		result.Result, result.Reason = SPFNone, "no valid sender domain"
+	default:
+		result.ClientIP = ip.String()
+		check := &spfCheck{resolver: resolver, ip: ip, sender: result.Sender, helo: helo}
+		result.Result, result.Reason = check.checkHost(result.Domain)
+	}
+	return result
+}
+
+// isSPFDomain reports whether name is a fully qualified domain name
+func isSPFDomain(name string) bool {
+	name = strings.TrimSuffix(name, ".")
+	if !strings.Contains(name, ".") || len(name) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 {
+			return false
+		}
+	}
+	return true
+}
+
+// checkHost evaluates the SPF record of domain (RFC 7208 section 4)
+func (c *spfCheck) checkHost(domain string) (result, reason string) {
+	record, err := c.lookupRecord(domain)
+	if err != nil {
+		return err.result, err.reason
+	}
+	if record == "" {
+		return SPFNone, "no SPF record for " + domain
+	}
+
+	// The whole record is parsed before any mechanism is evaluated
+	var directives []*spfDirective
+	redirect := ""
+	for _, term := range strings.Fields(record)[1:] {
+		if name, value, ok := spfModifier(term); ok {
+			if name == "redirect" {
+				if redirect != "" {
+					return SPFPermError, "duplicate redirect modifier in SPF record of " + domain
+				}
+				redirect = value
+			}
+			continue // exp= and unknown modifiers are ignored
+		}
+		directive, err := parseSPFDirective(term)
+		if err != nil {
+			return SPFPermError, fmt.Sprintf("%v in SPF record of %s", err, domain)
+		}
+		directives = append(directives, directive)
+	}
+
+	for _, directive := range directives {
+		matched, err := c.matches(directive, domain)
+		if err != nil {
+			return err.result, err.reason
+		}
+		if matched {
+			return directive.qualifier, fmt.Sprintf("%s matched %s", domain, directive.term)
+		}
+	}
+
+	// redirect only applies when no mechanism matched, and "all" always does
+	if redirect != "" {
+		if err := c.countLookup(); err != nil {
+			return err.result, err.reason
+		}
+		target, err := c.expand(redirect, domain)
+		if err != nil {
+			return err.result, err.reason
+		}
+		result, reason := c.checkHost(target)
+		if result == SPFNone {
+			return SPFPermError, "redirect target " + target + " has no SPF record"
+		}
+		return result, reason
+	}
+	return SPFNeutral, "no mechanism matched in SPF record of " + domain
+}
+
+// lookupRecord returns the SPF record of domain, or "" if it has none
+func (c *spfCheck) lookupRecord(domain string) (string, *spfError) {
+	if c.resolver == nil {
+		return "", nil
+	}
+	records, err := c.resolver.LookupTXT(domain)
+	if errors.Is(err, ErrRecordNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", &spfError{SPFTempError, fmt.Sprintf("SPF lookup for %s failed: %v", domain, err)}
+	}
+	var spf []string
+	for _, record := range records {
+		if version, _, _ := strings.Cut(record, " "); strings.EqualFold(version, "v=spf1") {
+			spf = append(spf, record)
+		}
+	}
+	if len(spf) > 1 {
+		return "", &spfError{SPFPermError, "multiple SPF records for " + domain}
+	}
+	if len(spf) == 0 {
+		return "", nil
+	}
+	return spf[0], nil
+}
+
+// spfModifier splits a name=value modifier term. ok is false for mechanisms.
+func spfModifier(term string) (name, value string, ok bool) {
+	name, value, ok = strings.Cut(term, "=")
+	if !ok || name == "" || strings.ContainsAny(name, ":/") {
+		return "", "", false
+	}
+	return strings.ToLower(name), value, true
+}
+
+// parseSPFDirective parses a mechanism with its optional qualifier
+func parseSPFDirective(term string) (*spfDirective, error) {
+	d := &spfDirective{term: term, qualifier: SPFPass, cidr4: 32, cidr6: 128}
+	rest := term
+	switch rest[0] {
+	case '+':
+		rest = rest[1:]
+	case '-':
+		d.qualifier, rest = SPFFail, rest[1:]
+	case '~':
+		d.qualifier, rest = SPFSoftFail, rest[1:]
+	case '?':
+		d.qualifier, rest = SPFNeutral, rest[1:]
+	}
+	end := strings.IndexAny(rest, ":/")
+	if end < 0 {
+		end = len(rest)
+	}
+	d.mechanism, rest = strings.ToLower(rest[:end]), rest[end:]
+	arg, hasArg := strings.CutPrefix(rest, ":")
+	if hasArg && arg == "" {
+		return nil, fmt.Errorf("empty argument of %q", term)
+	}
+
+	switch d.mechanism {
+	case "all":
+		if rest != "" {
+			return nil, fmt.Errorf("invalid mechanism %q", term)
+		}
+	case "include", "exists":
+		if !hasArg {
+			return nil, fmt.Errorf("missing domain in %q", term)
+		}
+		d.domainSpec = arg
+	case "ptr":
+		if rest != "" && !hasArg {
+			return nil, fmt.Errorf("invalid mechanism %q", term)
+		}
+		d.domainSpec = arg
+	case "a", "mx":
+		if !hasArg {
+			arg = rest
+		}
+		spec, cidr, _ := strings.Cut(arg, "/")
+		if !hasArg && spec != "" {
+			return nil, fmt.Errorf("invalid mechanism %q", term)
+		}
+		d.domainSpec = spec
+		if strings.Contains(arg, "/") && !parseDualCIDR(cidr, d) {
+			return nil, fmt.Errorf("invalid prefix length in %q", term)
+		}
+	case "ip4", "ip6":
+		if !hasArg {
+			return nil, fmt.Errorf("missing address in %q", term)
+		}
+		if !strings.Contains(arg, "/") {
+			if d.mechanism == "ip4" {
+				arg += "/32"
+			} else {
+				arg += "/128"
+			}
+		}
+		ip, network, err := net.ParseCIDR(arg)
+		if err != nil || (d.mechanism == "ip4") != (ip.To4() != nil) {
+			return nil, fmt.Errorf("invalid address in %q", term)
+		}
+		d.network = network
+	default:
+		return nil, fmt.Errorf("unknown mechanism %q", term)
+	}
+	return d, nil
+}
+
+// parseDualCIDR parses the prefix lengths of a and mx after their first
+// slash: "24", "24//64" or "/64"
+func parseDualCIDR(cidr string, d *spfDirective) bool {
+	v4, v6, dual := strings.Cut(cidr, "//")
+	if strings.HasPrefix(cidr, "/") {
+		v4, v6, dual = "", cidr[1:], true
+	}
+	if v4 != "" {
+		n, err := strconv.Atoi(v4)
+		if err != nil || n < 0 || n > 32 {
+			return false
+		}
+		d.cidr4 = n
+	} else if !dual {
+		return false
+	}
+	if dual {
+		n, err := strconv.Atoi(v6)
+		if err != nil || n < 0 || n > 128 {
+			return false
+		}
+		d.cidr6 = n
+	}
+	return true
+}
+
+// matches evaluates a mechanism against the client address
+func (c *spfCheck) matches(d *spfDirective, domain string) (bool, *spfError) {
+	switch d.mechanism {
+	case "all":
+		return true, nil
+	case "ip4", "ip6":
+		return d.network.Contains(c.ip), nil
+	}
+
+	if err := c.countLookup(); err != nil {
+		return false, err
+	}
+	target := domain
+	if d.domainSpec != "" {
+		expanded, err := c.expand(d.domainSpec, domain)
+		if err != nil {
+			return false, err
+		}
+		target = expanded
+	}
+
+	switch d.mechanism {
+	case "include":
+		result, reason := c.checkHost(target)
+		switch result {
+		case SPFPass:
+			return true, nil
+		case SPFTempError:
+			return false, &spfError{SPFTempError, reason}
+		case SPFPermError, SPFNone:
+			return false, &spfError{SPFPermError, "include:" + target + ": " + reason}
+		}
+		return false, nil
+	case "a":
+		ips, err := c.lookupIP(target, true)
+		if err != nil {
+			return false, err
+		}
+		return c.containsClient(ips, d), nil
+	case "mx":
+		hosts, err := c.lookupMX(target)
+		if err != nil {
+			return false, err
+		}
+		if len(hosts) > spfLookupLimit {
+			return false, &spfError{SPFPermError, "too many MX records for " + target}
+		}
+		for _, host := range hosts {
+			ips, err := c.lookupIP(host, false)
+			if err != nil {
+				return false, err
+			}
+			if c.containsClient(ips, d) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "exists":
+		ips, err := c.lookupIP(target, true)
+		return len(ips) > 0, err
+	}
+	// ptr is deprecated and needs reverse DNS, which a zone file does not serve
+	return false, nil
+}
+
+// containsClient reports whether any of ips, widened to the prefix lengths
+// of the directive, contains the client address
+func (c *spfCheck) containsClient(ips []net.IP, d *spfDirective) bool {
+	for _, ip := range ips {
+		bits, prefix := 128, d.cidr6
+		if ip.To4() != nil {
+			bits, prefix = 32, d.cidr4
+		}
+		network := &net.IPNet{IP: ip.Mask(net.CIDRMask(prefix, bits)), Mask: net.CIDRMask(prefix, bits)}
+		if (ip.To4() != nil) == (c.ip.To4() != nil) && network.Contains(c.ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// countLookup counts a DNS lookup against the limit of the evaluation
+func (c *spfCheck) countLookup() *spfError {
+	if c.lookups++; c.lookups > spfLookupLimit {
+		return &spfError{SPFPermError, "too many DNS lookups"}
+	}
+	return nil
+}
+
+// countVoid counts a lookup that found nothing against the void lookup limit
+func (c *spfCheck) countVoid() *spfError {
+	if c.voids++; c.voids > spfVoidLookupLimit {
+		return &spfError{SPFPermError, "too many void DNS lookups"}
+	}
+	return nil
+}
+
+// lookupIP returns the addresses of name. void counts a missing name
+// against the void lookup limit.
+func (c *spfCheck) lookupIP(name string, void bool) ([]net.IP, *spfError) {
+	var ips []net.IP
+	err := ErrRecordNotFound
+	if resolver, ok := c.resolver.(HostResolver); ok {
+		ips, err = resolver.LookupIP(name)
+	}
+	if errors.Is(err, ErrRecordNotFound) {
+		if void {
+			return nil, c.countVoid()
+		}
+		return nil, nil
+	}
+	if err != nil {
+		return nil, &spfError{SPFTempError, fmt.Sprintf("address lookup for %s failed: %v", name, err)}
+	}
+	return ips, nil
+}
+
+// lookupMX returns the mail exchangers of name
+func (c *spfCheck) lookupMX(name string) ([]string, *spfError) {
+	var hosts []string
+	err := ErrRecordNotFound
+	if resolver, ok := c.resolver.(HostResolver); ok {
+		hosts, err = resolver.LookupMX(name)
+	}
+	if errors.Is(err, ErrRecordNotFound) {
+		return nil, c.countVoid()
+	}
+	if err != nil {
+		return nil, &spfError{SPFTempError, fmt.Sprintf("MX lookup for %s failed: %v", name, err)}
+	}
+	return hosts, nil
+}
+
+// expand expands the macros of a domain-spec (RFC 7208 section 7)
+func (c *spfCheck) expand(spec, domain string) (string, *spfError) {
+	if !strings.Contains(spec, "%") {
+		return spec, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(spec); i++ {
+		if spec[i] != '%' {
+			b.WriteByte(spec[i])
+			continue
+		}
+		if i++; i == len(spec) {
+			return "", &spfError{SPFPermError, fmt.Sprintf("invalid macro in %q", spec)}
+		}
+		switch spec[i] {
+		case '%':
+			b.WriteByte('%')
+		case '_':
+			b.WriteByte(' ')
+		case '-':
+			b.WriteString("%20")
+		case '{':
+			end := strings.IndexByte(spec[i:], '}')
+			if end < 2 {
+				return "", &spfError{SPFPermError, fmt.Sprintf("invalid macro in %q", spec)}
+			}
+			value, ok := c.macroValue(spec[i+1:i+end], domain)
+			if !ok {
+				return "", &spfError{SPFPermError, fmt.Sprintf("invalid macro in %q", spec)}
+			}
+			b.WriteString(value)
+			i += end
+		default:
+			return "", &spfError{SPFPermError, fmt.Sprintf("invalid macro in %q", spec)}
+		}
+	}
+	return b.String(), nil
+}
+
+// macroValue expands the body of a %{...} macro: a letter, an optional
+// number of parts to keep, an optional "r" to reverse, and delimiters
+func (c *spfCheck) macroValue(macro, domain string) (string, bool) {
+	local, senderDomain, _ := strings.Cut(c.sender, "@")
+	var value string
+	switch macro[0] | 0x20 { // Upper-case letters only URL-escape in exp=
+	case 's':
+		value = c.sender
+	case 'l':
+		value = local
+	case 'o':
+		value = senderDomain
+	case 'd':
+		value = domain
+	case 'h':
+		value = c.helo
+	case 'p':
+		value = "unknown"
+	case 'v':
+		value = "ip6"
+		if c.ip.To4() != nil {
+			value = "in-addr"
+		}
+	case 'i':
+		value = spfMacroIP(c.ip)
+	default:
+		return "", false
+	}
+
+	rest := macro[1:]
+	digits := len(rest) - len(strings.TrimLeft(rest, "0123456789"))
+	keep := 0
+	if digits > 0 {
+		n, err := strconv.Atoi(rest[:digits])
+		if err != nil || n == 0 {
+			return "", false
+		}
+		keep = n
+	}
+	rest = rest[digits:]
+	reverse := false
+	if rest != "" && (rest[0]|0x20) == 'r' {
+		reverse, rest = true, rest[1:]
+	}
+	if strings.Trim(rest, ".-+,/_=") != "" {
+		return "", false
+	}
+	if rest == "" {
+		rest = "."
+	}
+
+	parts := strings.FieldsFunc(value, func(r rune) bool {
+		return strings.ContainsRune(rest, r)
+	})
+	if reverse {
+		for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+			parts[i], parts[j] = parts[j], parts[i]
+		}
+	}
+	if keep > 0 && keep < len(parts) {
+		parts = parts[len(parts)-keep:]
+	}
+	return strings.Join(parts, "."), true
+}
+
+// spfMacroIP formats an address for the %{i} macro: dotted quads for IPv4,
+// dot-separated nibbles for IPv6
+func spfMacroIP(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.String()
+	}
+	ip16 := ip.To16()
+	nibbles := make([]string, 0, 32)
+	for _, b := range ip16 {
+		nibbles = append(nibbles, strconv.FormatInt(int64(b>>4), 16), strconv.FormatInt(int64(b&0xf), 16))
+	}
+	return strings.Join(nibbles, ".")
+}
@@ -0,0 +1,168 @@
+package mailserver
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Storage modes
+const (
+	StorageModeAll       = "all"       // Store every message
+	StorageModeBlackhole = "blackhole" // Accept messages without storing them
+	StorageModeSample    = "sample"    // Store the messages selected by the sampling rules
+)
+
+const (
+	// maxTrackedSenders bounds the per-sender totals kept since startup
+	maxTrackedSenders = 1000
+
+	// otherSenders holds the totals of senders beyond maxTrackedSenders
+	otherSenders = "<other>"
+)
+
+// StorageConfig selects which accepted messages are stored. Messages that
+// are not stored are accepted and counted, but never written to disk.
+type StorageConfig struct {
+	Mode        string `json:"mode"`                  // all, blackhole or sample; empty means all
+	SampleEvery int    `json:"sampleEvery,omitempty"` // Sample mode: store every Nth matching message, starting with the first
+	MatchFrom   string `json:"matchFrom,omitempty"`   // Sample mode: sender pattern with * wildcards
+	MatchTo     string `json:"matchTo,omitempty"`     // Sample mode: recipient pattern with * wildcards; any recipient may match
+}
+
+// StorageStats counts the messages accepted since startup, stored or not
+type StorageStats struct {
+	Messages  int64                  `json:"messages"`  // Messages accepted
+	Bytes     int64                  `json:"bytes"`     // Size of the accepted messages
+	Stored    int64                  `json:"stored"`    // Messages kept by the storage mode
+	Discarded int64                  `json:"discarded"` // Messages accepted but not stored
+	Senders   map[string]SenderStats `json:"senders"`   // Totals per envelope sender; "<>" is the null sender, "<other>" the senders beyond the first 1000
+}
+
+// SenderStats holds the totals of one envelope sender
+type SenderStats struct {
+	Messages int64 `json:"messages"`
+	Bytes    int64 `json:"bytes"`
+}
+
+// Validate checks that the configuration is well formed
+func (c *StorageConfig) Validate() error {
+	switch c.Mode {
+	case "", StorageModeAll, StorageModeBlackhole, StorageModeSample:
+	default:
+		return fmt.Errorf("invalid storage mode %q: must be all, blackhole or sample", c.Mode)
+	}
+	if c.SampleEvery < 0 {
+		return fmt.Errorf("sampleEvery must not be negative")
+	}
+	for _, pattern := range []string{c.MatchFrom, c.MatchTo} {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid address pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// matches reports whether a message envelope passes the sampling filters
+func (c *StorageConfig) matches(from string, to []string) bool {
+	if c.MatchFrom != "" && !matchAddress(c.MatchFrom, from) {
+		return false
+	}
+	if c.MatchTo == "" {
+		return true
+	}
+	for _, rcpt := range to {
+		if matchAddress(c.MatchTo, rcpt) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchAddress matches an address against a pattern with * wildcards, ignoring case
+func matchAddress(pattern, address string) bool {
+	matched, err := path.Match(strings.ToLower(pattern), strings.ToLower(address))
+	return err == nil && matched
+}
+
+// SetStorageConfig updates the storage mode. The sampling counter restarts.
+func (ms *MailServer) SetStorageConfig(config StorageConfig) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+
+	ms.storageMutex.Lock()
+	defer ms.storageMutex.Unlock()
+	ms.storageConfig = config
+	ms.storageSampled = 0
+	return nil
+}
+
+// GetStorageConfig returns the storage mode
+func (ms *MailServer) GetStorageConfig() StorageConfig {
+	ms.storageMutex.Lock()
+	defer ms.storageMutex.Unlock()
+	return ms.storageConfig
+}
+
+// GetStorageStats returns the counters of accepted messages
+func (ms *MailServer) GetStorageStats() StorageStats {
+	ms.storageMutex.Lock()
+	defer ms.storageMutex.Unlock()
+
+	stats := ms.storageStats
+	stats.Senders = make(map[string]SenderStats, len(ms.storageStats.Senders))
+	for sender, totals := range ms.storageStats.Senders {
+		stats.Senders[sender] = totals
+	}
+	return stats
+}
+
+// shouldStore decides whether a message with the given envelope is stored
+func (ms *MailServer) shouldStore(from string, to []string) bool {
+	ms.storageMutex.Lock()
+	defer ms.storageMutex.Unlock()
+
+	config := &ms.storageConfig
+	switch config.Mode {
+	case StorageModeBlackhole:
+		return false
+	case StorageModeSample:
+		if !config.matches(from, to) {
+			return false
+		}
+		ms.storageSampled++
+		return (ms.storageSampled-1)%int64(max(config.SampleEvery, 1)) == 0
+	}
+	return true
+}
+
+// countMessage adds an accepted message to the storage counters
+func (ms *MailServer) countMessage(from string, size int64, stored bool) {
+	ms.storageMutex.Lock()
+	defer ms.storageMutex.Unlock()
+
+	stats := &ms.storageStats
+	stats.Messages++
+	stats.Bytes += size
+	if stored {
+		stats.Stored++
+	} else {
+		stats.Discarded++
+	}
+
+	sender := strings.ToLower(from)
+	if sender == "" {
+		sender = "<>"
+	}
+	if stats.Senders == nil {
+		stats.Senders = make(map[string]SenderStats)
+	}
+	if _, ok := stats.Senders[sender]; !ok && len(stats.Senders) >= maxTrackedSenders {
+		sender = otherSenders
+	}
+	totals := stats.Senders[sender]
+	totals.Messages++
+	totals.Bytes += size
+	stats.Senders[sender] = totals
+}
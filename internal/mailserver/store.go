@@ -1,6 +1,7 @@
 package mailserver
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
@@ -8,7 +9,6 @@ import (
 	"strings"
 	"time"
 
-	"github.com/emersion/go-message"
 	_ "github.com/emersion/go-message/charset"
 	"github.com/emersion/go-message/mail"
 	"github.com/soulteary/owlmail/internal/common"
@@ -26,6 +26,7 @@ func (ms *MailServer) SaveEmailToStore(id string, isRead bool, envelope *Envelop
 	parsedEmail.Read = isRead
 	parsedEmail.Envelope = envelope
 	parsedEmail.Source = emlPath
+	parsedEmail.Mailbox = mailboxForUser(envelope.User)
 
 	// Try to get file size, but don't fail if file doesn't exist
 	stat, err := os.Stat(emlPath)
@@ -74,6 +75,191 @@ func (ms *MailServer) SaveEmailToStore(id string, isRead bool, envelope *Envelop
 	return nil
 }
 
+// mailboxForUser returns the mailbox name for an authenticated SMTP user
+func mailboxForUser(username string) string {
+	if username == "" {
+		return DefaultMailbox
+	}
+	return username
+}
+
+// envelopePath returns the path of the envelope metadata file for an email
+func (ms *MailServer) envelopePath(id string) string {
+	return filepath.Join(ms.mailDir, id+".envelope.json")
+}
+
+// saveEnvelope writes the SMTP envelope of an email next to its .eml file
+func (ms *MailServer) saveEnvelope(id string, envelope *Envelope) error {
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		return fmt.Errorf("failed to encode envelope: %w", err)
+	}
+	if err := os.WriteFile(ms.envelopePath(id), data, 0644); err != nil {
+		return fmt.Errorf("failed to save envelope: %w", err)
+	}
+	return nil
+}
+
+// loadEnvelope reads the SMTP envelope saved for an email
+func (ms *MailServer) loadEnvelope(id string) (*Envelope, error) {
+	data, err := os.ReadFile(ms.envelopePath(id))
+	if err != nil {
+		return nil, err
+	}
+	envelope := &Envelope{}
+	if err := json.Unmarshal(data, envelope); err != nil {
+		return nil, fmt.Errorf("failed to decode envelope: %w", err)
+	}
+	return envelope, nil
+}
+
+// transcriptPath returns the path of the SMTP transcript file for an email
+func (ms *MailServer) transcriptPath(id string) string {
+	return filepath.Join(ms.mailDir, id+".transcript.json")
+}
+
+// saveTranscript writes the SMTP transcript of an email next to its .eml file
+func (ms *MailServer) saveTranscript(id string, entries []TranscriptEntry) error {
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return fmt.Errorf("failed to encode transcript: %w", err)
+	}
+	// The transcript is rewritten as the conversation goes on; replace it
+	// atomically so a concurrent reload never reads a partial file
+	tmp, err := os.CreateTemp(ms.mailDir, id+".transcript-*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to save transcript: %w", err)
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), ms.transcriptPath(id))
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("failed to save transcript: %w", err)
+	}
+	return nil
+}
+
+// loadTranscript reads the SMTP transcript saved for an email
+func (ms *MailServer) loadTranscript(id string) ([]TranscriptEntry, error) {
+	data, err := os.ReadFile(ms.transcriptPath(id))
+	if err != nil {
+		return nil, err
+	}
+	var entries []TranscriptEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to decode transcript: %w", err)
+	}
+	return entries, nil
+}
+
+// updateTranscript replaces the transcript of a stored email.
+// It is saved before the stored email is updated, so a transcript served
+// from memory is always on disk as well.
+func (ms *MailServer) updateTranscript(id string, entries []TranscriptEntry) {
+	// Emails still queued for ingestion get the transcript once stored
+	ms.ingestMutex.Lock()
+	defer ms.ingestMutex.Unlock()
+	queued := ms.updateIngestJob(id, entries, time.Time{}) != nil
+
+	// The email may have been deleted while the connection was open
+	if !queued && !ms.hasEmail(id) {
+		return
+	}
+	if err := ms.saveTranscript(id, entries); err != nil {
+		common.Verbose("Error saving transcript: %v", err)
+	}
+	if queued {
+		return
+	}
+
+	ms.storeMutex.Lock()
+	defer ms.storeMutex.Unlock()
+	for _, email := range ms.store {
+		if email.ID == id {
+			email.Transcript = entries
+			return
+		}
+	}
+}
+
+// updateSessionEnd records the end of the SMTP session an email was received in
+func (ms *MailServer) updateSessionEnd(id string, end time.Time) {
+	// Emails still queued for ingestion get the session end once stored
+	ms.ingestMutex.Lock()
+	if job := ms.updateIngestJob(id, nil, end); job != nil {
+		envelope := *job.envelope
+		ms.ingestMutex.Unlock()
+		envelope.SessionEnd = end
+		if err := ms.saveEnvelope(id, &envelope); err != nil {
+			common.Verbose("Error saving envelope: %v", err)
+		}
+		return
+	}
+	ms.ingestMutex.Unlock()
+
+	ms.storeMutex.Lock()
+	var envelope Envelope
+	found := false
+	for _, email := range ms.store {
+		if email.ID == id && email.Envelope != nil {
+			email.Envelope.SessionEnd = end
+			envelope, found = *email.Envelope, true
+			break
+		}
+	}
+	ms.storeMutex.Unlock()
+
+	// The email may have been deleted while the connection was open
+	if !found {
+		return
+	}
+	if err := ms.saveEnvelope(id, &envelope); err != nil {
+		common.Verbose("Error saving envelope: %v", err)
+	}
+}
+
+// hasEmail reports whether an email is stored
+func (ms *MailServer) hasEmail(id string) bool {
+	ms.storeMutex.RLock()
+	defer ms.storeMutex.RUnlock()
+	for _, email := range ms.store {
+		if email.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// isKnownEmail reports whether an email is stored or waiting for an ingest
+// worker. Workers only forget a job once its email is stored, so checking both
+// under ms.ingestMutex cannot miss an email in between.
+func (ms *MailServer) isKnownEmail(id string) bool {
+	ms.ingestMutex.Lock()
+	defer ms.ingestMutex.Unlock()
+	if _, queued := ms.ingestJobs[id]; queued {
+		return true
+	}
+	return ms.hasEmail(id)
+}
+
+// GetEmailTranscript returns the SMTP conversation that delivered an email
+func (ms *MailServer) GetEmailTranscript(id string) ([]TranscriptEntry, error) {
+	ms.storeMutex.RLock()
+	defer ms.storeMutex.RUnlock()
+
+	for _, email := range ms.store {
+		if email.ID == id {
+			return email.Transcript, nil
+		}
+	}
+	return nil, fmt.Errorf("email was not found")
+}
+
 // saveAttachment saves an attachment to disk
 func (ms *MailServer) saveAttachment(id string, attachment *Attachment, data []byte) error {
 	attachmentDir := filepath.Join(ms.mailDir, id)
@@ -122,8 +308,52 @@ func (ms *MailServer) GetAllEmail() []*Email {
 	return emails
 }
 
+// GetAllEmailInMailbox returns all emails in the given mailbox.
+// An empty mailbox selects emails from every mailbox.
+func (ms *MailServer) GetAllEmailInMailbox(mailbox string) []*Email {
+	if mailbox == "" {
+		return ms.GetAllEmail()
+	}
+
+	ms.storeMutex.RLock()
+	defer ms.storeMutex.RUnlock()
+
+	emails := make([]*Email, 0)
+	for _, email := range ms.store {
+		if email.Mailbox == mailbox {
+			emails = append(emails, email)
+		}
+	}
+	return emails
+}
+
+// GetMailboxes returns the number of emails in each mailbox
+func (ms *MailServer) GetMailboxes() map[string]int {
+	ms.storeMutex.RLock()
+	defer ms.storeMutex.RUnlock()
+
+	mailboxes := make(map[string]int)
+	for _, email := range ms.store {
+		mailboxes[email.Mailbox]++
+	}
+	return mailboxes
+}
+
 // DeleteEmail deletes an email by ID
 func (ms *MailServer) DeleteEmail(id string) error {
+	email, err := ms.removeEmail(id)
+	if err != nil {
+		return err
+	}
+
+	// Emit delete event once the store is unlocked
+	ms.emit("delete", email)
+
+	return nil
+}
+
+// removeEmail deletes the files of an email and removes it from the store
+func (ms *MailServer) removeEmail(id string) (*Email, error) {
 	ms.storeMutex.Lock()
 	defer ms.storeMutex.Unlock()
 
@@ -139,29 +369,40 @@ func (ms *MailServer) DeleteEmail(id string) error {
 	}
 
 	if emailIndex == -1 {
-		return fmt.Errorf("email not found")
+		return nil, fmt.Errorf("email not found")
 	}
 
 	// Validate email ID to prevent path traversal
 	if err := validateEmailID(id); err != nil {
-		return fmt.Errorf("invalid email ID: %w", err)
+		return nil, fmt.Errorf("invalid email ID: %w", err)
 	}
 
 	// Delete raw email file
 	emlPath := filepath.Join(ms.mailDir, id+".eml")
 	// Validate path is within mail directory
 	if err := validatePath(ms.mailDir, emlPath); err != nil {
-		return fmt.Errorf("path validation failed: %w", err)
+		return nil, fmt.Errorf("path validation failed: %w", err)
 	}
 	if err := os.Remove(emlPath); err != nil {
 		common.Verbose("Error deleting email file: %v", err)
 	}
 
+	// Delete envelope metadata, transcript and authentication results
+	if err := os.Remove(ms.envelopePath(id)); err != nil && !os.IsNotExist(err) {
+		common.Verbose("Error deleting envelope file: %v", err)
+	}
+	if err := os.Remove(ms.transcriptPath(id)); err != nil && !os.IsNotExist(err) {
+		common.Verbose("Error deleting transcript file: %v", err)
+	}
+	if err := os.Remove(ms.authResultsPath(id)); err != nil && !os.IsNotExist(err) {
+		common.Verbose("Error deleting authentication results file: %v", err)
+	}
+
 	// Delete attachments directory
 	attachmentDir := filepath.Join(ms.mailDir, id)
 	// Validate path is within mail directory
 	if err := validatePath(ms.mailDir, attachmentDir); err != nil {
-		return fmt.Errorf("path validation failed: %w", err)
+		return nil, fmt.Errorf("path validation failed: %w", err)
 	}
 	if err := os.RemoveAll(attachmentDir); err != nil {
 		common.Verbose("Error deleting attachment directory: %v", err)
@@ -172,10 +413,7 @@ func (ms *MailServer) DeleteEmail(id string) error {
 	// Remove from store
 	ms.store = append(ms.store[:emailIndex], ms.store[emailIndex+1:]...)
 
-	// Emit delete event
-	ms.emit("delete", email)
-
-	return nil
+	return email, nil
 }
 
 // DeleteAllEmail deletes all emails
@@ -185,10 +423,13 @@ func (ms *MailServer) DeleteAllEmail() error {
 	ms.storeMutex.Lock()
 	defer ms.storeMutex.Unlock()
 
-	// Clear mail directory
+	// Clear mail directory, keeping the local CA
 	files, err := os.ReadDir(ms.mailDir)
 	if err == nil {
 		for _, file := range files {
+			if file.IsDir() && file.Name() == localCADir {
+				continue
+			}
 			if err := os.RemoveAll(filepath.Join(ms.mailDir, file.Name())); err != nil {
 				common.Verbose("Failed to remove file: %v", err)
 			}
@@ -199,6 +440,23 @@ func (ms *MailServer) DeleteAllEmail() error {
 	return nil
 }
 
+// DeleteAllEmailInMailbox deletes all emails in the given mailbox.
+// An empty mailbox deletes every email.
+func (ms *MailServer) DeleteAllEmailInMailbox(mailbox string) error {
+	if mailbox == "" {
+		return ms.DeleteAllEmail()
+	}
+
+	common.Log("Deleting all email in mailbox %s", mailbox)
+	for _, email := range ms.GetAllEmailInMailbox(mailbox) {
+		// An email may have been deleted meanwhile
+		if err := ms.DeleteEmail(email.ID); err != nil {
+			common.Verbose("Failed to delete email %s: %v", email.ID, err)
+		}
+	}
+	return nil
+}
+
 // GetRawEmail returns the raw email file path
 func (ms *MailServer) GetRawEmail(id string) (string, error) {
 	// Validate email ID to prevent path traversal
@@ -234,15 +492,6 @@ func (ms *MailServer) GetRawEmailContent(id string) ([]byte, error) {
 	return content, nil
 }
 
-// GetEmailHTML returns the HTML content of an email
-func (ms *MailServer) GetEmailHTML(id string) (string, error) {
-	email, err := ms.GetEmail(id)
-	if err != nil {
-		return "", err
-	}
-	return email.HTML, nil
-}
-
 // GetEmailAttachment returns attachment file path
 func (ms *MailServer) GetEmailAttachment(id, filename string) (string, string, error) {
 	// Validate email ID to prevent path traversal
@@ -285,11 +534,20 @@ func (ms *MailServer) GetEmailAttachment(id, filename string) (string, string, e
 
 // ReadAllEmail marks all emails as read
 func (ms *MailServer) ReadAllEmail() int {
+	return ms.ReadAllEmailInMailbox("")
+}
+
+// ReadAllEmailInMailbox marks all emails in the given mailbox as read and
+// returns how many were unread. An empty mailbox selects every email.
+func (ms *MailServer) ReadAllEmailInMailbox(mailbox string) int {
 	ms.storeMutex.Lock()
 	defer ms.storeMutex.Unlock()
 
 	count := 0
 	for _, email := range ms.store {
+		if mailbox != "" && email.Mailbox != mailbox {
+			continue
+		}
 		if !email.Read {
 			email.Read = true
 			count++
@@ -312,17 +570,29 @@ func (ms *MailServer) ReadEmail(id string) error {
 	return fmt.Errorf("email not found")
 }
 
-// GetEmailStats returns email statistics
+// GetEmailStats returns email statistics, with the counters of all accepted messages under "received"
 func (ms *MailServer) GetEmailStats() map[string]interface{} {
+	stats := ms.GetEmailStatsInMailbox("")
+	stats["received"] = ms.GetStorageStats()
+	return stats
+}
+
+// GetEmailStatsInMailbox returns the statistics of the emails in the given
+// mailbox. An empty mailbox selects emails from every mailbox.
+func (ms *MailServer) GetEmailStatsInMailbox(mailbox string) map[string]interface{} {
 	ms.storeMutex.RLock()
 	defer ms.storeMutex.RUnlock()
 
 	stats := make(map[string]interface{})
-	total := len(ms.store)
+	total := 0
 	unread := 0
 	byDate := make(map[string]int)
 
 	for _, email := range ms.store {
+		if mailbox != "" && email.Mailbox != mailbox {
+			continue
+		}
+		total++
 		if !email.Read {
 			unread++
 		}
@@ -340,9 +610,70 @@ func (ms *MailServer) GetEmailStats() map[string]interface{} {
 	return stats
 }
 
-// parseEmail parses email from given reader
+// parseEmail parses an email from the given reader and stores it
 func (ms *MailServer) parseEmail(id string, r io.Reader, s *Session, saveAttachments, markAsRead bool) (*Email, error) {
-	msg, err := message.Read(r)
+	email, err := ms.parseMessage(id, r, saveAttachments)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create envelope
+	envelope := &Envelope{
+		From:          "",
+		To:            addressListToStrings(email.To),
+		Host:          "unknown",
+		RemoteAddress: "unknown",
+	}
+	if s != nil {
+		envelope = s.envelope()
+
+		// Persist the envelope so it survives a reload from disk
+		if err := ms.saveEnvelope(id, envelope); err != nil {
+			common.Verbose("Error saving envelope: %v", err)
+		}
+
+		// Attach the conversation so far; it is completed when the connection closes
+		if email.Transcript = s.transcript.attach(id); email.Transcript != nil {
+			if err := ms.saveTranscript(id, email.Transcript); err != nil {
+				common.Verbose("Error saving transcript: %v", err)
+			}
+		}
+	} else {
+		if saved, err := ms.loadEnvelope(id); err == nil {
+			envelope = saved
+		}
+		if saved, err := ms.loadTranscript(id); err == nil {
+			email.Transcript = saved
+		}
+	}
+
+	// Evaluate SPF and DMARC now that the envelope is known. Restored
+	// emails keep the results they were received with.
+	if s != nil {
+		ms.authenticate(email, envelope)
+		if err := ms.saveAuthResults(id, email); err != nil {
+			common.Verbose("Error saving authentication results: %v", err)
+		}
+	} else if err := ms.loadAuthResults(id, email); err != nil {
+		ms.authenticate(email, envelope)
+	}
+
+	// Save email to store
+	if err = ms.SaveEmailToStore(id, markAsRead, envelope, email); err != nil {
+		return nil, fmt.Errorf("failed to store email into memory: %w", err)
+	}
+
+	return email, nil
+}
+
+// parseMessage parses the content of a raw message: headers, bodies and attachments
+func (ms *MailServer) parseMessage(id string, r io.Reader, saveAttachments bool) (*Email, error) {
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read email: %w", err)
+	}
+	// The MIME tree is the only parse of the message
+	root, err := readMIMETree(raw)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse email: %w", err)
 	}
@@ -350,35 +681,24 @@ func (ms *MailServer) parseEmail(id string, r io.Reader, s *Session, saveAttachm
 	// Parse email content
 	email := &Email{
 		Attachments: make([]*Attachment, 0),
-		Headers:     make(map[string]interface{}),
 	}
 
-	// Extract headers
+	// Keep every header field in order, and group them by name
+	email.HeaderFields = root.Headers
+	email.Headers = headerMap(root.Headers)
+
 	// Wrap in mail.Header to get decoding support
-	headers := mail.Header{Header: msg.Header}
-
-	// Parse all headers into Headers map
-	// Common headers to parse
-	commonHeaders := []string{
-		"From", "To", "Cc", "Bcc", "Subject", "Date", "Message-ID",
-		"Reply-To", "In-Reply-To", "References", "Content-Type",
-		"Content-Transfer-Encoding", "MIME-Version", "X-Mailer",
-		"X-Priority", "Priority", "Importance",
-	}
-	for _, headerName := range commonHeaders {
-		if headerValue := headers.Get(headerName); headerValue != "" {
-			if headerValues := headers.Values(headerName); len(headerValues) > 1 {
-				email.Headers[headerName] = headerValues
-			} else {
-				email.Headers[headerName] = headerValue
-			}
-		}
-	}
-	// Note: Additional custom headers can be added here if needed
-	// For now, we parse the most common headers listed above
+	headers := mail.Header{Header: root.header}
+
+	// Verify DKIM signatures against the raw message
+	email.DKIM = ms.verifyDKIM(raw)
+
+	// Problems found while reading the MIME tree
+	warnings := partWarnings(root)
 
 	// Parse date from headers
 	if email.Time, err = headers.Date(); err != nil {
+		warnings = append(warnings, ParseWarning{Code: WarningInvalidDate, Header: "Date", Message: err.Error()})
 		email.Time = parseEmailDate(headers.Header)
 	}
 
@@ -387,101 +707,48 @@ func (ms *MailServer) parseEmail(id string, r io.Reader, s *Session, saveAttachm
 		email.Subject = headers.Get("Subject")
 	}
 
-	// Parse addresses
-	// TODO : handle error cases
-	email.From, _ = headers.AddressList("From")
-	email.To, _ = headers.AddressList("To")
-	email.CC, _ = headers.AddressList("Cc")
-	email.BCC, _ = headers.AddressList("Bcc")
-
-	// Parse body
-	mediaType, _, err := headers.ContentType()
-	if err != nil {
-		mediaType = "text/plain"
-	}
-
-	if strings.HasPrefix(mediaType, "multipart/") {
-		mr := msg.MultipartReader()
-		if mr != nil {
-			for {
-				p, err := mr.NextPart()
-				if err == io.EOF {
-					break
-				}
-				if err != nil {
-					common.Verbose("Error reading multipart: %v", err)
-					continue
-				}
-
-				partMediaType, _, _ := p.Header.ContentType()
-				if partMediaType == "" {
-					partMediaType = "text/plain"
-				}
-
-				disposition, params, _ := p.Header.ContentDisposition()
-				contentID := strings.Trim(p.Header.Get("Content-ID"), "<>")
-
-				body, _ := io.ReadAll(p.Body)
-
-				if partMediaType == "text/plain" && disposition != "attachment" {
-					email.Text = strings.TrimSpace(string(body))
-				} else if partMediaType == "text/html" && disposition != "attachment" {
-					email.HTML = strings.TrimSpace(string(body))
-				} else if disposition == "attachment" || contentID != "" {
-					// Handle attachment
-					filename := params["filename"]
-					if filename == "" {
-						filename = partMediaType
-					}
-
-					attachment := &Attachment{
-						ContentType: partMediaType,
-						FileName:    filename,
-						ContentID:   contentID,
-					}
-
-					if saveAttachments {
-						err = ms.saveAttachment(id, attachment, body)
-						if err != nil {
-							common.Verbose("Error saving attachment: %v", err)
-						}
-					}
-					email.Attachments = append(email.Attachments, attachment)
-				}
-			}
-		}
-	} else {
-		// Simple message
-		body, _ := io.ReadAll(msg.Body)
-		if strings.HasPrefix(mediaType, "text/html") {
-			email.HTML = strings.TrimSpace(string(body))
-		} else {
-			email.Text = strings.TrimSpace(string(body))
+	// Parse addresses; a list that cannot be parsed is left empty
+	for _, field := range []struct {
+		name string
+		list *[]*mail.Address
+	}{
+		{"From", &email.From},
+		{"To", &email.To},
+		{"Cc", &email.CC},
+		{"Bcc", &email.BCC},
+	} {
+		if *field.list, err = headers.AddressList(field.name); err != nil {
+			warnings = append(warnings, ParseWarning{Code: WarningInvalidAddress, Header: field.name, Message: err.Error()})
 		}
 	}
 
-	// Create envelope
-	envelope := &Envelope{
-		From:          "",
-		To:            addressListToStrings(email.To),
-		Host:          "unknown",
-		RemoteAddress: "unknown",
-	}
-	if s != nil {
-		if s.conn != nil {
-			if conn := s.conn.Conn(); conn != nil {
-				envelope.RemoteAddress = conn.RemoteAddr().String()
+	// Parse body: walk the whole MIME tree, so that nested parts are kept
+	content := walkMIME(root)
+	var bodyWarnings []ParseWarning
+	email.Text, bodyWarnings = joinBodies(content.text)
+	warnings = append(warnings, bodyWarnings...)
+	email.HTML, bodyWarnings = joinBodies(content.html)
+	warnings = append(warnings, bodyWarnings...)
+	for _, part := range content.other {
+		attachment := part.attachment()
+		data, err := part.Decoded()
+		if err != nil {
+			warnings = append(warnings, decodeWarning(part.Path, err))
+		}
+		if saveAttachments {
+			if err := ms.saveAttachment(id, attachment, data); err != nil {
+				common.Verbose("Error saving attachment: %v", err)
+				warnings = append(warnings, newWarning(WarningAttachmentNotStored, part.Path, "%v", err))
 			}
-			envelope.Host = s.conn.Hostname()
+		} else if attachment.ContentID != "" {
+			// Names derived from the Content-ID are stable, so inline parts
+			// saved when the email was received are served after a reload
+			transformAttachment(attachment)
+			attachment.Size = int64(len(data))
 		}
-		envelope.From = s.from
-		envelope.To = s.to
-	}
-
-	// Save email to store
-	if err = ms.SaveEmailToStore(id, markAsRead, envelope, email); err != nil {
-		return nil, fmt.Errorf("failed to store email into memory: %w", err)
+		email.Attachments = append(email.Attachments, attachment)
 	}
+	email.Warnings = warnings
 
 	return email, nil
 }
@@ -507,18 +774,8 @@ func (ms *MailServer) LoadMailsFromDirectory() error {
 		id := strings.TrimSuffix(file.Name(), ".eml")
 		emlPath := filepath.Join(ms.mailDir, file.Name())
 
-		// Check if email already loaded
-		ms.storeMutex.RLock()
-		alreadyLoaded := false
-		for _, email := range ms.store {
-			if email.ID == id {
-				alreadyLoaded = true
-				break
-			}
-		}
-		ms.storeMutex.RUnlock()
-
-		if alreadyLoaded {
+		// Check if email already loaded, or spooled for the ingest workers
+		if ms.isKnownEmail(id) {
 			continue
 		}
 
@@ -0,0 +1,307 @@
+package mailserver
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/soulteary/owlmail/internal/types"
+)
+
+// TranscriptEntry is an alias for types.TranscriptEntry
+type TranscriptEntry = types.TranscriptEntry
+
+const (
+	// maxTranscriptEntries bounds the memory used by a single conversation
+	maxTranscriptEntries = 2000
+
+	// maskedSecret replaces SASL credentials in transcripts
+	maskedSecret = "****"
+)
+
+// transcript records the SMTP conversation of a single connection.
+// It is installed as the connection's smtp.Server.Debug writer, which receives
+// every byte read from and written to the client after TLS decryption.
+// Debug does not say which direction a write belongs to, so transcriptConn
+// flags writes to the socket: the Debug write that follows one is a reply.
+// Behind a profileRelay, replies are recorded as the relay sends them instead.
+type transcript struct {
+	mailServer *MailServer
+
+	started      time.Time // When the connection was accepted
+	mu           sync.Mutex
+	entries      []TranscriptEntry
+	truncated    bool
+	replyPending bool   // The next Debug write is a server reply
+	relayed      bool   // Replies are recorded by a profileRelay, see sentReply
+	client       []byte // Incomplete client line
+	server       []byte // Incomplete server reply line
+	lastClient   time.Time
+	inAuth       bool  // Client lines are SASL responses
+	inData       bool  // Client lines are message data
+	bdatLeft     int64 // Bytes of the current BDAT chunk still to be read
+	dataBytes    int64
+	emailIDs     []string // Emails delivered over this connection
+	pending      []string // Emails waiting for the reply to their DATA command
+}
+
+// newTranscript starts the transcript of a client connection
+func newTranscript(ms *MailServer, conn net.Conn) *transcript {
+	t := &transcript{mailServer: ms, started: time.Now()}
+	line := fmt.Sprintf("connection from %s to %s", conn.RemoteAddr(), conn.LocalAddr())
+	if pc, ok := conn.(*proxyConn); ok && pc.remote != pc.Conn.RemoteAddr() {
+		line += fmt.Sprintf(" via proxy %s", pc.Conn.RemoteAddr())
+	}
+	t.add(types.TranscriptEvent, line, 0)
+	return t
+}
+
+// add appends an entry. The caller must hold t.mu.
+func (t *transcript) add(direction, line string, duration time.Duration) {
+	if len(t.entries) >= maxTranscriptEntries {
+		if !t.truncated {
+			t.truncated = true
+			t.entries = append(t.entries, TranscriptEntry{Time: time.Now(), Direction: types.TranscriptEvent, Line: "transcript truncated"})
+		}
+		return
+	}
+	t.entries = append(t.entries, TranscriptEntry{
+		Time:       time.Now(),
+		Direction:  direction,
+		Line:       line,
+		DurationMs: float64(duration.Microseconds()) / 1000,
+	})
+}
+
+// snapshot returns a copy of the entries. The caller must hold t.mu.
+func (t *transcript) snapshot() []TranscriptEntry {
+	return append([]TranscriptEntry{}, t.entries...)
+}
+
+// Write implements io.Writer for smtp.Server.Debug
+func (t *transcript) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	if t.replyPending {
+		t.replyPending = false
+		if !t.relayed {
+			t.readServer(p)
+		}
+	} else {
+		t.readClient(p)
+	}
+	flush, entries := t.answered()
+	t.mu.Unlock()
+
+	for _, id := range flush {
+		t.mailServer.updateTranscript(id, entries)
+	}
+	return len(p), nil
+}
+
+// sentReply records reply bytes that a profileRelay sends to the client
+func (t *transcript) sentReply(p []byte) {
+	t.mu.Lock()
+	t.readServer(p)
+	flush, entries := t.answered()
+	t.mu.Unlock()
+
+	for _, id := range flush {
+		t.mailServer.updateTranscript(id, entries)
+	}
+}
+
+// answered returns the emails to update once their DATA command has been
+// answered, with the conversation so far. The caller must hold t.mu.
+func (t *transcript) answered() ([]string, []TranscriptEntry) {
+	if len(t.pending) == 0 || len(t.server) > 0 || t.inData || !t.lastReplyFinal() {
+		return nil, nil
+	}
+	flush := t.pending
+	t.pending = nil
+	return flush, t.snapshot()
+}
+
+// lastReplyFinal reports whether the last entry is the final line of a server reply.
+// The caller must hold t.mu.
+func (t *transcript) lastReplyFinal() bool {
+	if len(t.entries) == 0 {
+		return false
+	}
+	last := t.entries[len(t.entries)-1]
+	return last.Direction == types.TranscriptServer && (len(last.Line) < 4 || last.Line[3] != '-')
+}
+
+// readClient splits client data into lines. The caller must hold t.mu.
+func (t *transcript) readClient(p []byte) {
+	for len(p) > 0 {
+		// BDAT chunks are raw bytes, not lines
+		if t.bdatLeft > 0 {
+			n := min(int64(len(p)), t.bdatLeft)
+			t.bdatLeft -= n
+			t.dataBytes += n
+			p = p[n:]
+			if t.bdatLeft == 0 {
+				t.add(types.TranscriptEvent, fmt.Sprintf("message data (%d bytes)", t.dataBytes), 0)
+				t.dataBytes = 0
+			}
+			continue
+		}
+
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			t.client = append(t.client, p...)
+			return
+		}
+		line := string(append(t.client, p[:i+1]...))
+		t.client = t.client[:0]
+		p = p[i+1:]
+		t.clientLine(line)
+	}
+}
+
+// clientLine records a complete client line. The caller must hold t.mu.
+func (t *transcript) clientLine(raw string) {
+	line := strings.TrimRight(raw, "\r\n")
+	t.lastClient = time.Now()
+
+	if t.inData {
+		if line == "." {
+			t.inData = false
+			t.add(types.TranscriptEvent, fmt.Sprintf("message data (%d bytes)", t.dataBytes), 0)
+			t.add(types.TranscriptClient, line, 0)
+			t.dataBytes = 0
+			return
+		}
+		t.dataBytes += int64(len(raw))
+		return
+	}
+	if t.inAuth {
+		t.add(types.TranscriptClient, maskedSecret, 0)
+		return
+	}
+
+	verb, arg, _ := strings.Cut(line, " ")
+	switch strings.ToUpper(verb) {
+	case "AUTH":
+		t.inAuth = true
+		if mech, initialResponse, _ := strings.Cut(arg, " "); initialResponse != "" {
+			line = verb + " " + mech + " " + maskedSecret
+		}
+	case "BDAT":
+		if fields := strings.Fields(arg); len(fields) > 0 {
+			if size, err := strconv.ParseInt(fields[0], 10, 64); err == nil && size > 0 {
+				t.bdatLeft = size
+			}
+		}
+	}
+	t.add(types.TranscriptClient, line, 0)
+}
+
+// readServer splits server output into reply lines. The caller must hold t.mu.
+func (t *transcript) readServer(p []byte) {
+	t.server = append(t.server, p...)
+	for {
+		i := bytes.IndexByte(t.server, '\n')
+		if i < 0 {
+			return
+		}
+		line := strings.TrimRight(string(t.server[:i+1]), "\r\n")
+		t.server = t.server[i+1:]
+		t.serverLine(line)
+	}
+}
+
+// serverLine records a reply line and tracks the protocol state. The caller must hold t.mu.
+func (t *transcript) serverLine(line string) {
+	final := len(line) < 4 || line[3] != '-'
+	if !final {
+		t.add(types.TranscriptServer, line, 0)
+		return
+	}
+
+	var duration time.Duration
+	if !t.lastClient.IsZero() {
+		duration = time.Since(t.lastClient)
+	}
+	t.add(types.TranscriptServer, line, duration)
+
+	switch {
+	case strings.HasPrefix(line, "354"):
+		t.inData = true
+	case strings.HasPrefix(line, "334"):
+		// SASL challenge, the next client line is a response
+	default:
+		t.inAuth = false
+	}
+}
+
+// markWrite notes that the connection wrote to the client
+func (t *transcript) markWrite() {
+	t.mu.Lock()
+	t.replyPending = true
+	t.mu.Unlock()
+}
+
+// markRead notes that the connection read from the client
+func (t *transcript) markRead() {
+	t.mu.Lock()
+	t.replyPending = false
+	t.mu.Unlock()
+}
+
+// tlsEstablished records a completed TLS handshake
+func (t *transcript) tlsEstablished(state tls.ConnectionState) {
+	line := fmt.Sprintf("TLS handshake completed: %s, %s", tls.VersionName(state.Version), tls.CipherSuiteName(state.CipherSuite))
+	if state.ServerName != "" {
+		line += fmt.Sprintf(", SNI %s", state.ServerName)
+	}
+	if cert := verifiedClientCert(state); cert != nil {
+		line += fmt.Sprintf(", client certificate %s", cert.Subject)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.add(types.TranscriptEvent, line, 0)
+}
+
+// attach links a delivered email to the transcript and returns the conversation so far
+func (t *transcript) attach(id string) []TranscriptEntry {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.emailIDs = append(t.emailIDs, id)
+	t.pending = append(t.pending, id)
+	return t.snapshot()
+}
+
+// finish records the end of the connection and stores the complete
+// conversation with every email delivered over it
+func (t *transcript) finish() {
+	t.mu.Lock()
+	t.add(types.TranscriptEvent, "connection closed", 0)
+	ids := t.emailIDs
+	entries := t.snapshot()
+	t.mu.Unlock()
+
+	end := time.Now()
+	for _, id := range ids {
+		t.mailServer.updateTranscript(id, entries)
+		t.mailServer.updateSessionEnd(id, end)
+	}
+}
+
+// startTime returns when the connection was accepted, or the zero time
+// without a transcript
+func (t *transcript) startTime() time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return t.started
+}
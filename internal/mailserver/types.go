@@ -1,6 +1,9 @@
 package mailserver
 
 import (
+	"crypto/tls"
+	"io"
+	"net"
 	"sync"
 
 	"github.com/emersion/go-smtp"
@@ -11,6 +14,9 @@ const (
 	defaultPort    = 1025
 	defaultHost    = "localhost"
 	defaultMailDir = "owlmail"
+
+	// DefaultMailbox holds mail received from unauthenticated clients
+	DefaultMailbox = "default"
 )
 
 // Email is an alias for types.Email
@@ -19,21 +25,56 @@ type Email = types.Email
 // Attachment is an alias for types.Attachment
 type Attachment = types.Attachment
 
+// HeaderField is an alias for types.HeaderField
+type HeaderField = types.HeaderField
+
+// ParseWarning is an alias for types.ParseWarning
+type ParseWarning = types.ParseWarning
+
 // Envelope is an alias for types.Envelope
 type Envelope = types.Envelope
 
+// TLSInfo is an alias for types.TLSInfo
+type TLSInfo = types.TLSInfo
+
+// Rejection is an alias for types.Rejection
+type Rejection = types.Rejection
+
+// TLS modes of TLSInfo
+const (
+	TLSModeSTARTTLS = "starttls"
+	TLSModeImplicit = "implicit"
+)
+
 // SMTPAuthConfig represents SMTP authentication configuration
 type SMTPAuthConfig struct {
-	Username string
-	Password string
-	Enabled  bool
+	Username  string
+	Password  string
+	UsersFile string // Optional htpasswd-style credentials file (bcrypt or plaintext)
+	Enabled   bool
+
+	users map[string]string // Credentials loaded from UsersFile
 }
 
 // TLSConfig represents TLS configuration for SMTP server
 type TLSConfig struct {
-	CertFile string
-	KeyFile  string
-	Enabled  bool
+	CertFile  string
+	KeyFile   string
+	Enabled   bool
+	SMTPSPort int // Port of the default implicit TLS listener; 0 means 465
+
+	// Client certificate (mutual TLS) authentication
+	ClientCAFile      string // PEM bundle of CAs that issue client certificates
+	ClientAuth        string // request or require; defaults to request when ClientCAFile is set
+	ClientCertMapFile string // JSON object mapping certificate subjects to mailboxes
+
+	clientCertIdentities map[string]string // Loaded from ClientCertMapFile
+
+	// Local CA mode: without CertFile and KeyFile, the certificate is issued by a
+	// CA persisted in the mail directory. The CA also serves the web API when TLS
+	// is not enabled for SMTP.
+	LocalCA      bool
+	LocalCAHosts []string // DNS names and IPs of the issued certificate; defaults to DefaultLocalCAHosts
 }
 
 // MailServer represents the SMTP mail server
@@ -43,9 +84,15 @@ type MailServer struct {
 	mailDir        string
 	port           int
 	host           string
-	smtpServer     *smtp.Server
-	smtpsServer    *smtp.Server // SMTPS server (direct TLS on 465)
-	eventChan      chan Event
+	smtpServer     *smtp.Server // Server of the first SMTP listener
+	smtpsServer    *smtp.Server // Server of the first implicit TLS listener, if any
+	smtpListeners  []*smtpListener
+	lmtpServer     *smtp.Server // Optional LMTP server
+	lmtpAddr       string
+	eventQueue     []Event       // Events waiting for their listeners, see dispatchEvents
+	eventChan      chan struct{} // Wakes the dispatcher when events are queued
+	eventsClosed   bool
+	eventsMutex    sync.Mutex
 	listeners      map[string][]func(*types.Email)
 	listenersMutex sync.RWMutex
 	outgoing       interface {
@@ -58,6 +105,49 @@ type MailServer struct {
 	authConfig   *SMTPAuthConfig
 	tlsConfig    *TLSConfig
 	useUUIDForID bool
+
+	serverTLSConfig *tls.Config // Certificate used by STARTTLS and implicit TLS listeners
+	localCA         *LocalCA    // Set in local CA mode
+	faultRules      []FaultRule
+	faultsMutex     sync.RWMutex
+
+	greylistConfig  GreylistConfig
+	greylistEntries map[string]*GreylistEntry
+	greylistMutex   sync.Mutex
+
+	rateLimitConfig RateLimitConfig
+	rateLimiter     *rateLimiter
+	rateLimitMutex  sync.Mutex
+
+	accessPolicy *accessPolicy // Client networks and recipient domains accepted; nil accepts all
+	accessMutex  sync.RWMutex
+
+	dnsResolver TXTResolver // Source of DKIM keys and SPF/DMARC policies; nil finds no records
+
+	esmtpExtensions ESMTPExtensions // Optional extensions advertised by SMTP and LMTP servers
+	smtpProfile     *SMTPProfile    // Personality of the SMTP listeners; nil is the owlmail profile
+
+	ingestConfig IngestConfig
+	ingestQueue  chan *ingestJob       // Nil processes messages within the SMTP transaction
+	ingestJobs   map[string]*ingestJob // Spooled messages not stored yet, by email ID
+	ingestStats  IngestStats
+	ingestMutex  sync.Mutex
+	ingestWG     sync.WaitGroup
+
+	storageConfig  StorageConfig
+	storageStats   StorageStats
+	storageSampled int64 // Messages that passed the sampling filters since the mode was set
+	storageMutex   sync.Mutex
+
+	oversizeCaptureKB int // Body kilobytes kept of messages over the size limit; 0 keeps none
+
+	proxyTrusted []*net.IPNet // Sources allowed to send PROXY protocol headers; empty trusts none
+	proxyMutex   sync.RWMutex
+
+	serving       map[io.Closer]struct{} // Listeners and per-connection servers
+	servingClosed bool
+	servingMutex  sync.Mutex
+	servingWG     sync.WaitGroup
 }
 
 // GetHost returns the SMTP server host
@@ -85,6 +175,11 @@ func (ms *MailServer) GetTLSConfig() *TLSConfig {
 	return ms.tlsConfig
 }
 
+// GetLocalCA returns the local CA, or nil when local CA mode is disabled
+func (ms *MailServer) GetLocalCA() *LocalCA {
+	return ms.localCA
+}
+
 // Event represents a server event
 type Event struct {
 	Type  string
@@ -199,6 +199,8 @@ func sanitizeHTML(html string) string {
 	p.AllowAttrs("target").OnElements("a")
 	p.AllowElements("link")
 	p.AllowAttrs("rel", "href", "type", "media").OnElements("link")
+	// Keep references to inline parts, they are rewritten when the HTML is served
+	p.AllowURLSchemes("cid")
 	return p.Sanitize(html)
 }
 
@@ -0,0 +1,55 @@
+package mailserver
+
+import (
+	"fmt"
+
+	"github.com/emersion/go-message"
+)
+
+// Parse warning codes
+const (
+	WarningInvalidAddress      = "invalid_address"       // An address header cannot be parsed
+	WarningInvalidDate         = "invalid_date"          // The Date header cannot be parsed
+	WarningInvalidEncodedWord  = "invalid_encoded_word"  // RFC 2047 encoded words of a header cannot be decoded
+	WarningInvalidContentType  = "invalid_content_type"  // Content-Type cannot be parsed; text/plain is assumed
+	WarningInvalidDisposition  = "invalid_disposition"   // Content-Disposition cannot be parsed
+	WarningMissingBoundary     = "missing_boundary"      // A multipart has no boundary; it is kept as a single part
+	WarningMalformedMultipart  = "malformed_multipart"   // A multipart cannot be read past a malformed part
+	WarningTruncatedMultipart  = "truncated_multipart"   // A multipart ends before its closing boundary
+	WarningTooDeep             = "too_deep"              // Multiparts nested too deeply are kept as single parts
+	WarningUnknownCharset      = "unknown_charset"       // A text part cannot be converted to UTF-8
+	WarningUnknownEncoding     = "unknown_encoding"      // A part has an unknown transfer encoding
+	WarningUndecodableBody     = "undecodable_body"      // A part is not valid in its transfer encoding
+	WarningAttachmentNotStored = "attachment_not_stored" // An attachment could not be written to disk
+)
+
+// newWarning creates a parse warning about a MIME part
+func newWarning(code, part, format string, args ...interface{}) ParseWarning {
+	return ParseWarning{Code: code, Part: part, Message: fmt.Sprintf(format, args...)}
+}
+
+// newHeaderWarning creates a parse warning about a header field of a MIME part
+func newHeaderWarning(code, part, header string, err error) ParseWarning {
+	return ParseWarning{Code: code, Part: part, Header: header, Message: err.Error()}
+}
+
+// decodeWarning describes an error decoding the body of a part
+func decodeWarning(part string, err error) ParseWarning {
+	code := WarningUndecodableBody
+	switch {
+	case message.IsUnknownCharset(err):
+		code = WarningUnknownCharset
+	case message.IsUnknownEncoding(err):
+		code = WarningUnknownEncoding
+	}
+	return newWarning(code, part, "%v", err)
+}
+
+// partWarnings lists the warnings of a part and all its descendants
+func partWarnings(part *MIMEPart) []ParseWarning {
+	warnings := append([]ParseWarning(nil), part.Warnings...)
+	for _, child := range part.Parts {
+		warnings = append(warnings, partWarnings(child)...)
+	}
+	return warnings
+}
@@ -0,0 +1,341 @@
+package mailserver
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrRecordNotFound is returned by resolvers when a name has no records
+var ErrRecordNotFound = errors.New("record not found")
+
+// TXTResolver looks up DNS TXT records. Message authentication checks use it
+// to fetch keys and policies, so they can run against a local zone file
+// instead of DNS.
+type TXTResolver interface {
+	// LookupTXT returns the TXT records of a fully qualified name, or
+	// ErrRecordNotFound if there are none
+	LookupTXT(name string) ([]string, error)
+}
+
+// HostResolver is implemented by resolvers that also serve address and mail
+// exchanger records, as needed by the SPF a, mx and exists mechanisms
+type HostResolver interface {
+	// LookupIP returns the A and AAAA records of a name
+	LookupIP(name string) ([]net.IP, error)
+	// LookupMX returns the mail exchangers of a name, most preferred first
+	LookupMX(name string) ([]string, error)
+}
+
+// SetDNSResolver sets the source of DKIM keys and SPF and DMARC policies,
+// such as a ZoneFile. It must be called before Listen.
+func (ms *MailServer) SetDNSResolver(resolver TXTResolver) {
+	ms.dnsResolver = resolver
+}
+
+// GetDNSResolver returns the source of DKIM keys and SPF and DMARC policies
+func (ms *MailServer) GetDNSResolver() TXTResolver {
+	return ms.dnsResolver
+}
+
+// ZoneFile serves the TXT, A, AAAA and MX records of a BIND-style zone file, e.g.
+//
+//	$ORIGIN example.com.
+//	@                IN  TXT  "v=spf1 mx ip4:192.0.2.0/24 -all"
+//	@                IN  MX   10 mail
+//	mail             IN  A    192.0.2.25
+//	mail._domainkey  IN  TXT  ( "v=DKIM1; k=rsa; "
+//	                            "p=MIIBIjANBgkqh..." )
+//
+// Records of other types are ignored. A TXT record without quotes is taken verbatim.
+type ZoneFile struct {
+	txt map[string][]string
+	ips map[string][]net.IP
+	mx  map[string][]zoneMX
+}
+
+// zoneMX is a mail exchanger record
+type zoneMX struct {
+	preference int
+	host       string
+}
+
+// LoadZoneFile reads a zone file from disk
+func LoadZoneFile(filePath string) (*ZoneFile, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open zone file: %w", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	return ParseZoneFile(f)
+}
+
+// ParseZoneFile parses zone file records
+func ParseZoneFile(r io.Reader) (*ZoneFile, error) {
+	zone := &ZoneFile{
+		txt: make(map[string][]string),
+		ips: make(map[string][]net.IP),
+		mx:  make(map[string][]zoneMX),
+	}
+	origin, previous := "", ""
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	lineNumber := 0
+	var entry []string // Tokens of a record spanning parenthesized lines
+	depth, startLine := 0, 0
+	inherit := false // The record continues the previous owner name
+
+	for scanner.Scan() {
+		lineNumber++
+		tokens, delta, err := zoneTokens(scanner.Text())
+		if err != nil {
+			return nil, fmt.Errorf("zone file line %d: %w", lineNumber, err)
+		}
+		if depth == 0 && len(entry) == 0 {
+			startLine = lineNumber
+			// A line starting with whitespace continues the previous owner name
+			inherit = strings.HasPrefix(scanner.Text(), " ") || strings.HasPrefix(scanner.Text(), "\t")
+		}
+		entry = append(entry, tokens...)
+		if depth += delta; depth < 0 {
+			return nil, fmt.Errorf("zone file line %d: unbalanced parentheses", lineNumber)
+		}
+		if depth > 0 || len(entry) == 0 {
+			continue
+		}
+
+		tokens, entry = entry, nil
+		if !inherit && tokens[0] == "$ORIGIN" {
+			if len(tokens) != 2 {
+				return nil, fmt.Errorf("zone file line %d: invalid $ORIGIN", startLine)
+			}
+			origin = strings.TrimSuffix(strings.ToLower(tokens[1]), ".")
+			continue
+		}
+		if !inherit && strings.HasPrefix(tokens[0], "$") {
+			continue // $TTL and $INCLUDE do not affect lookups
+		}
+
+		name := previous
+		if !inherit {
+			name = qualifyZoneName(tokens[0], origin)
+			previous = name
+			tokens = tokens[1:]
+		}
+		if err := zone.addRecord(name, origin, tokens); err != nil {
+			return nil, fmt.Errorf("zone file line %d: %w", startLine, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read zone file: %w", err)
+	}
+	if depth > 0 {
+		return nil, fmt.Errorf("zone file line %d: unbalanced parentheses", startLine)
+	}
+	return zone, nil
+}
+
+// LookupTXT implements TXTResolver
+func (z *ZoneFile) LookupTXT(name string) ([]string, error) {
+	if z != nil {
+		if records := z.txt[strings.TrimSuffix(strings.ToLower(name), ".")]; len(records) > 0 {
+			return records, nil
+		}
+	}
+	return nil, ErrRecordNotFound
+}
+
+// LookupIP implements HostResolver
+func (z *ZoneFile) LookupIP(name string) ([]net.IP, error) {
+	if z != nil {
+		if ips := z.ips[strings.TrimSuffix(strings.ToLower(name), ".")]; len(ips) > 0 {
+			return ips, nil
+		}
+	}
+	return nil, ErrRecordNotFound
+}
+
+// LookupMX implements HostResolver
+func (z *ZoneFile) LookupMX(name string) ([]string, error) {
+	if z != nil {
+		if records := z.mx[strings.TrimSuffix(strings.ToLower(name), ".")]; len(records) > 0 {
+			records = append([]zoneMX(nil), records...)
+			sort.SliceStable(records, func(i, j int) bool {
+				return records[i].preference < records[j].preference
+			})
+			hosts := make([]string, len(records))
+			for i, record := range records {
+				hosts[i] = record.host
+			}
+			return hosts, nil
+		}
+	}
+	return nil, ErrRecordNotFound
+}
+
+// Len returns the number of names with records
+func (z *ZoneFile) Len() int {
+	if z == nil {
+		return 0
+	}
+	names := make(map[string]bool)
+	for name := range z.txt {
+		names[name] = true
+	}
+	for name := range z.ips {
+		names[name] = true
+	}
+	for name := range z.mx {
+		names[name] = true
+	}
+	return len(names)
+}
+
+// addRecord adds a record given the tokens after its name, skipping the
+// optional TTL and class. Records of unsupported types are ignored.
+func (z *ZoneFile) addRecord(name, origin string, tokens []string) error {
+	for len(tokens) > 0 {
+		token := strings.ToUpper(tokens[0])
+		if token == "IN" || (token != "" && strings.Trim(token, "0123456789") == "") {
+			tokens = tokens[1:]
+			continue
+		}
+		break
+	}
+	if len(tokens) == 0 {
+		return nil
+	}
+	recordType, data := strings.ToUpper(tokens[0]), tokens[1:]
+	if recordType != "TXT" && recordType != "A" && recordType != "AAAA" && recordType != "MX" {
+		return nil
+	}
+	if name == "" {
+		return fmt.Errorf("record without a name")
+	}
+
+	switch recordType {
+	case "TXT":
+		z.txt[name] = append(z.txt[name], zoneTXTValue(data))
+	case "A", "AAAA":
+		var ip net.IP
+		if len(data) == 1 {
+			ip = net.ParseIP(data[0])
+		}
+		if ip == nil || (recordType == "A") != (ip.To4() != nil) {
+			return fmt.Errorf("invalid %s record", recordType)
+		}
+		z.ips[name] = append(z.ips[name], ip)
+	case "MX":
+		if len(data) != 2 {
+			return fmt.Errorf("invalid MX record")
+		}
+		preference, err := strconv.Atoi(data[0])
+		if err != nil || preference < 0 || preference > 65535 {
+			return fmt.Errorf("invalid MX preference %q", data[0])
+		}
+		z.mx[name] = append(z.mx[name], zoneMX{preference: preference, host: qualifyZoneName(data[1], origin)})
+	}
+	return nil
+}
+
+// zoneTokens splits a zone file line into tokens, keeping quoted strings
+// (with their quotes) as single tokens and dropping comments and parentheses.
+// delta is the change in parenthesis depth.
+func zoneTokens(line string) (tokens []string, delta int, err error) {
+	var current strings.Builder
+	flush := func() {
+		if current.Len() > 0 {
+			tokens = append(tokens, current.String())
+			current.Reset()
+		}
+	}
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == '"':
+			flush()
+			end := i + 1
+			for end < len(line) && line[end] != '"' {
+				if line[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(line) {
+				return nil, 0, fmt.Errorf("unterminated quoted string")
+			}
+			tokens = append(tokens, line[i:end+1])
+			i = end
+		case c == ';':
+			flush()
+			return tokens, delta, nil
+		case c == '(' || c == ')':
+			flush()
+			if c == '(' {
+				delta++
+			} else {
+				delta--
+			}
+		case c == ' ' || c == '\t':
+			flush()
+		default:
+			current.WriteByte(c)
+		}
+	}
+	flush()
+	return tokens, delta, nil
+}
+
+// qualifyZoneName returns the lower-case absolute form of an owner name
+func qualifyZoneName(name, origin string) string {
+	name = strings.ToLower(name)
+	switch {
+	case name == "@":
+		return origin
+	case strings.HasSuffix(name, "."):
+		return strings.TrimSuffix(name, ".")
+	case origin != "":
+		return name + "." + origin
+	default:
+		return name
+	}
+}
+
+// zoneTXTValue returns the value of a TXT record given its data tokens
+func zoneTXTValue(tokens []string) string {
+	var b strings.Builder
+	quoted := false
+	for _, token := range tokens {
+		if strings.HasPrefix(token, `"`) {
+			quoted = true
+			b.WriteString(unquoteZoneString(token[1 : len(token)-1]))
+		}
+	}
+	if !quoted {
+		return strings.Join(tokens, " ")
+	}
+	return b.String()
+}
+
+// unquoteZoneString resolves backslash escapes in a quoted zone file string
+func unquoteZoneString(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
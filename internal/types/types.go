@@ -24,7 +24,17 @@ type Email struct {
 	Source        string                 `json:"source"`
 	Size          int64                  `json:"size"`
 	SizeHuman     string                 `json:"sizeHuman"`
-	Headers       map[string]interface{} `json:"headers"`
+	Headers       map[string]interface{} `json:"headers"`      // Values by header name; repeated headers are lists
+	HeaderFields  []HeaderField          `json:"headerFields"` // Every header field in order, duplicates included
+	Mailbox       string                 `json:"mailbox"`
+	DKIM          []DKIMResult           `json:"dkim,omitempty"`     // One result per DKIM-Signature header, in header order
+	SPF           *SPFResult             `json:"spf,omitempty"`      // Evaluation of the envelope sender
+	DMARC         *DMARCResult           `json:"dmarc,omitempty"`    // Evaluation of the From header domain
+	Transcript    []TranscriptEntry      `json:"-"`                  // SMTP conversation, served separately
+	Warnings      []ParseWarning         `json:"warnings,omitempty"` // Problems found while parsing the message
+
+	// Synthesized Authentication-Results header (RFC 8601) with the DKIM, SPF and DMARC results
+	AuthenticationResults string `json:"authenticationResults,omitempty"`
 }
 
 // Attachment represents an email attachment
@@ -34,9 +44,74 @@ type Attachment struct {
 	GeneratedFileName string `json:"generatedFileName"`
 	ContentID         string `json:"contentId"`
 	Size              int64  `json:"size"`
+	Path              string `json:"path,omitempty"` // Path of the part in the MIME tree of the email
 	Transformed       bool   `json:"-"`
 }
 
+// HeaderField is a header field of an email or a MIME part
+type HeaderField struct {
+	Name    string `json:"name"`    // Name as spelled in the message
+	Value   string `json:"value"`   // Unfolded value as transmitted
+	Decoded string `json:"decoded"` // Value with RFC 2047 encoded words decoded
+}
+
+// ParseWarning is a problem found while parsing an email. The email is
+// stored with whatever could be read.
+type ParseWarning struct {
+	Code    string `json:"code"`             // e.g. invalid_address, unknown_charset, truncated_multipart, invalid_date
+	Part    string `json:"part,omitempty"`   // Path of the MIME part concerned, "0" for the message itself
+	Header  string `json:"header,omitempty"` // Header field concerned
+	Message string `json:"message"`
+}
+
+// DKIMResult is the verification result of one DKIM-Signature header
+type DKIMResult struct {
+	Domain           string `json:"domain"`                     // Signing domain (d=)
+	Selector         string `json:"selector"`                   // Key selector (s=)
+	Algorithm        string `json:"algorithm,omitempty"`        // a=, e.g. rsa-sha256
+	Canonicalization string `json:"canonicalization,omitempty"` // c=, header/body
+	Identity         string `json:"identity,omitempty"`         // Agent or user identifier (i=)
+	BodyHashMatch    bool   `json:"bodyHashMatch"`              // The body hash (bh=) matches the received body
+	Result           string `json:"result"`                     // pass, fail, temperror or permerror
+	Reason           string `json:"reason,omitempty"`           // Why the signature did not pass
+	Testing          bool   `json:"testing,omitempty"`          // The key is flagged for testing (t=y)
+}
+
+// SPFResult is the SPF evaluation of the envelope sender (RFC 7208)
+type SPFResult struct {
+	Domain   string `json:"domain"`           // Domain whose policy was evaluated
+	Identity string `json:"identity"`         // mailfrom, or helo for the null sender
+	Sender   string `json:"sender,omitempty"` // Checked sender address
+	ClientIP string `json:"clientIp,omitempty"`
+	Result   string `json:"result"`           // none, neutral, pass, fail, softfail, temperror or permerror
+	Reason   string `json:"reason,omitempty"` // Matching mechanism, or why no policy applied
+}
+
+// DMARCResult is the DMARC evaluation of the From header domain (RFC 7489)
+type DMARCResult struct {
+	Domain      string `json:"domain"`           // From header domain
+	Policy      string `json:"policy,omitempty"` // Requested policy: none, quarantine or reject
+	Result      string `json:"result"`           // none, pass, fail, temperror or permerror
+	SPFAligned  bool   `json:"spfAligned"`       // SPF passed for a domain aligned with From
+	DKIMAligned bool   `json:"dkimAligned"`      // A DKIM signature passed for a domain aligned with From
+	Reason      string `json:"reason,omitempty"`
+}
+
+// Transcript entry directions
+const (
+	TranscriptClient = "client"
+	TranscriptServer = "server"
+	TranscriptEvent  = "event"
+)
+
+// TranscriptEntry is one line of a recorded SMTP conversation
+type TranscriptEntry struct {
+	Time       time.Time `json:"time"`
+	Direction  string    `json:"direction"`            // client, server or event
+	Line       string    `json:"line"`                 // Protocol line without CRLF; secrets and message data are masked
+	DurationMs float64   `json:"durationMs,omitempty"` // Final server reply: time since the client's last line
+}
+
 // Envelope represents SMTP envelope information
 type Envelope struct {
 	From          string   `json:"from"`
@@ -44,6 +119,51 @@ type Envelope struct {
 	CC            []string `json:"cc"`
 	BCC           []string `json:"bcc"`
 	CalculatedBCC []string `json:"calculatedBcc"`
-	Host          string   `json:"host"`
+	Host          string   `json:"host"` // EHLO/HELO argument
 	RemoteAddress string   `json:"remoteAddress"`
+	User          string   `json:"user,omitempty"` // Authenticated SMTP username
+
+	// Connection the message was received over
+	TLS          *TLSInfo  `json:"tls,omitempty"` // Nil for plaintext
+	SessionStart time.Time `json:"sessionStart,omitzero"`
+	SessionEnd   time.Time `json:"sessionEnd,omitzero"` // Set when the connection closes
+
+	// Verified TLS client certificate
+	ClientCertSubject     string `json:"clientCertSubject,omitempty"`
+	ClientCertFingerprint string `json:"clientCertFingerprint,omitempty"` // SHA-256 of the DER certificate, hex encoded
+
+	// ESMTP parameters of MAIL FROM and RCPT TO
+	Size       int64              `json:"size,omitempty"`       // Declared SIZE
+	Body       string             `json:"body,omitempty"`       // BODY type: 7BIT, 8BITMIME or BINARYMIME
+	Return     string             `json:"ret,omitempty"`        // DSN RET: FULL or HDRS
+	EnvelopeID string             `json:"envid,omitempty"`      // DSN ENVID
+	SMTPUTF8   bool               `json:"smtputf8,omitempty"`   // SMTPUTF8 was requested
+	RequireTLS bool               `json:"requireTLS,omitempty"` // REQUIRETLS was requested
+	Recipients []RecipientOptions `json:"recipients,omitempty"` // Per-recipient DSN parameters, in RCPT TO order
+
+	// Set when the message was refused and only the start of it was kept
+	Rejected *Rejection `json:"rejected,omitempty"`
+}
+
+// Rejection describes a refused message of which only the start was captured
+type Rejection struct {
+	Code     int    `json:"code"`     // SMTP reply sent to the client, e.g. 552
+	Reason   string `json:"reason"`   // Text of the reply
+	Limit    int64  `json:"limit"`    // Size limit of the listener in bytes
+	Captured int64  `json:"captured"` // Bytes kept: the header and the start of the body
+}
+
+// TLSInfo describes the TLS connection a message was received over
+type TLSInfo struct {
+	Mode        string `json:"mode"`                 // starttls or implicit
+	Version     string `json:"version"`              // e.g. TLS 1.3
+	CipherSuite string `json:"cipherSuite"`          // e.g. TLS_AES_128_GCM_SHA256
+	ServerName  string `json:"serverName,omitempty"` // SNI sent by the client
+}
+
+// RecipientOptions holds the DSN parameters of a RCPT TO command
+type RecipientOptions struct {
+	Address string   `json:"address"`
+	Notify  []string `json:"notify,omitempty"` // NEVER, or any of SUCCESS, FAILURE and DELAY
+	ORcpt   string   `json:"orcpt,omitempty"`  // Original recipient as "type;address"
 }
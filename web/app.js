@@ -911,18 +911,20 @@ function renderEmailDetail() {
             </div>
         </div>
         <div class="email-detail-body">
-            ${email.html ? renderHTML(email.html) : renderText(email.text || '')}
+            ${email.html ? renderHTML(email.id) : renderText(email.text || '')}
         </div>
         ${attachments}
     `;
 }
 
-function renderHTML(html) {
+function renderHTML(emailId) {
     // Create a safe iframe for HTML content
+    // The served HTML has cid: references rewritten so inline images load
     const iframeId = 'email-html-' + Date.now();
+    const url = `${API_BASE}/emails/${encodeURIComponent(emailId)}/html`;
     return `
         <div class="email-detail-html">
-            <iframe id="${iframeId}" srcdoc="${escapeHtml(html)}"></iframe>
+            <iframe id="${iframeId}" src="${escapeHtml(url)}"></iframe>
         </div>
     `;
 }